@@ -0,0 +1,485 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/api/distribution"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// OverflowLabelKey is the label set on the timeseries which label
+	// combinations over the cardinality limit are folded into.
+	OverflowLabelKey = "otel_overflow"
+)
+
+// cardinalityLimiter limits the number of distinct timeseries written for
+// each metric type. Timeseries over the limit are folded into a single
+// overflow timeseries for each metric type and monitored resource.
+type cardinalityLimiter struct {
+	cfg CardinalityLimitConfig
+	obs selfObservability
+
+	mu sync.Mutex
+	// series maps from the project and metric type to the timeseries keys
+	// seen for it, and whether they have been used since the last garbage
+	// collection. Each project has its own budget.
+	series map[cardinalityKey]map[string]bool
+	// cumulativeOverflows maps from the project and key of cumulative
+	// overflow timeseries to the points folded into them.
+	cumulativeOverflows map[cardinalityKey]*cumulativeOverflow
+	// tripped records the metric types of each project which have exceeded
+	// their limit, so we only log it once.
+	tripped map[cardinalityKey]struct{}
+}
+
+// cardinalityKey identifies a metric type, or an overflow timeseries, within
+// a project.
+type cardinalityKey struct {
+	projectID string
+	key       string
+}
+
+// cumulativeOverflow is the state of a cumulative overflow timeseries. Its
+// value is the sum of the last point of each timeseries folded into it, plus
+// the values those timeseries had before they were reset or forgotten, so it
+// never decreases. Its start time is fixed when it is first written.
+type cumulativeOverflow struct {
+	valueType metricpb.MetricDescriptor_ValueType
+	// maxTracked is the maximum number of timeseries whose last point is
+	// kept, or 0 for no limit. Timeseries beyond it are left out of the
+	// total until others are forgotten. Forgetting timeseries to make room
+	// instead would count their values again when they are written again.
+	maxTracked int
+	// full is set when a timeseries was left out because maxTracked was
+	// reached, so we only log it once.
+	full bool
+	// bucketOptions and bucketCount are the buckets of the first
+	// distribution folded into the overflow timeseries. Distributions with
+	// other buckets can't be added to the total, so they are not folded in.
+	bucketOptions *distribution.Distribution_BucketOptions
+	bucketCount   int
+	start         *timestamppb.Timestamp
+	end           *timestamppb.Timestamp
+	// written is the end time of the last point written, since a point
+	// can't be written twice.
+	written *timestamppb.Timestamp
+	// retired is the sum of the last values of timeseries which were reset
+	// or forgotten. It is nil until one is.
+	retired *monitoringpb.TypedValue
+	// last maps from timeseries keys to the last point written for them.
+	last map[string]*overflowPoint
+	// exemplars are the exemplars of the distribution points written since
+	// the overflow timeseries was last written.
+	exemplars []*distribution.Distribution_Exemplar
+	used      bool
+}
+
+// overflowPoint is the last point of a timeseries folded into a cumulative
+// overflow timeseries, without exemplars.
+type overflowPoint struct {
+	point *monitoringpb.Point
+	used  bool
+}
+
+func newCardinalityLimiter(cfg CardinalityLimitConfig, obs selfObservability) *cardinalityLimiter {
+	return &cardinalityLimiter{
+		cfg:                 cfg,
+		obs:                 obs,
+		series:              make(map[cardinalityKey]map[string]bool),
+		cumulativeOverflows: make(map[cardinalityKey]*cumulativeOverflow),
+		tripped:             make(map[cardinalityKey]struct{}),
+	}
+}
+
+// cardinalityGCRunner periodically forgets the timeseries which have not been
+// written recently until shutdown.
+func (me *MetricsExporter) cardinalityGCRunner() {
+	defer me.goroutines.Done()
+	interval := me.cfg.MetricConfig.NormalizationCache.GCInterval
+	if interval <= 0 {
+		interval = defaultNormalizationCacheGCInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for me.cardinalityLimiter.gc(me.shutdownC, ticker.C) {
+	}
+}
+
+// limitFor returns the limit for the metric type, or 0 if it is unlimited.
+func (l *cardinalityLimiter) limitFor(metricType string) int {
+	if limit, ok := l.cfg.Overrides[metricType]; ok {
+		return limit
+	}
+	return l.cfg.Default
+}
+
+// apply returns the timeseries sent to the project with those over the
+// cardinality limit of their metric type folded into overflow timeseries.
+func (l *cardinalityLimiter) apply(ctx context.Context, projectID string, tss []*monitoringpb.TimeSeries) []*monitoringpb.TimeSeries {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]*monitoringpb.TimeSeries, 0, len(tss))
+	overflows := map[string]*monitoringpb.TimeSeries{}
+	// cumulativeOverflows are the cumulative overflow timeseries written,
+	// which are set to their total once all timeseries are folded.
+	cumulativeOverflows := map[*monitoringpb.TimeSeries]*cumulativeOverflow{}
+	overflowPoints := map[string]int{}
+	for _, ts := range tss {
+		metricType := ts.GetMetric().GetType()
+		limit := l.limitFor(metricType)
+		if limit <= 0 {
+			result = append(result, ts)
+			continue
+		}
+		seriesKey := cardinalityKey{projectID: projectID, key: metricType}
+		seen, ok := l.series[seriesKey]
+		if !ok {
+			seen = make(map[string]bool)
+			l.series[seriesKey] = seen
+		}
+		key := timeSeriesKey(ts)
+		if _, ok := seen[key]; ok || len(seen) < limit {
+			seen[key] = true
+			result = append(result, ts)
+			continue
+		}
+
+		overflowPoints[metricType]++
+		overflow := overflowTimeSeries(ts)
+		overflowKey := timeSeriesKey(overflow)
+		var state *cumulativeOverflow
+		if isCumulativeOverflow(ts) {
+			state = l.foldCumulative(cardinalityKey{projectID: projectID, key: overflowKey}, key, ts)
+		}
+		if existing, ok := overflows[overflowKey]; ok {
+			if state == nil {
+				mergeOverflowTimeSeries(existing, overflow)
+			}
+			continue
+		}
+		overflows[overflowKey] = overflow
+		if state != nil {
+			cumulativeOverflows[overflow] = state
+		}
+		result = append(result, overflow)
+	}
+	if len(cumulativeOverflows) > 0 {
+		filtered := result[:0]
+		for _, ts := range result {
+			if state, ok := cumulativeOverflows[ts]; ok {
+				point := state.point()
+				if point == nil {
+					continue
+				}
+				ts.Points = []*monitoringpb.Point{point}
+			}
+			filtered = append(filtered, ts)
+		}
+		result = filtered
+	}
+	for metricType, points := range overflowPoints {
+		trippedKey := cardinalityKey{projectID: projectID, key: metricType}
+		if _, ok := l.tripped[trippedKey]; !ok {
+			l.tripped[trippedKey] = struct{}{}
+			l.obs.log.Warn(
+				"Metric exceeded its cardinality limit. New label combinations will be written to an overflow timeseries.",
+				zap.String("metric_type", metricType),
+				zap.String("project", projectID),
+				zap.Int("limit", l.limitFor(metricType)),
+			)
+		}
+		l.obs.recordCardinalityOverflow(ctx, points, metricType)
+	}
+	return result
+}
+
+// isCumulativeOverflow returns true if the timeseries is folded into its
+// overflow timeseries by keeping a running total.
+func isCumulativeOverflow(ts *monitoringpb.TimeSeries) bool {
+	if ts.MetricKind != metricpb.MetricDescriptor_CUMULATIVE || len(ts.Points) == 0 {
+		return false
+	}
+	switch ts.ValueType {
+	case metricpb.MetricDescriptor_INT64, metricpb.MetricDescriptor_DOUBLE, metricpb.MetricDescriptor_DISTRIBUTION:
+		return true
+	}
+	return false
+}
+
+// foldCumulative records the point of the timeseries with the given key in
+// the state of its cumulative overflow timeseries, and returns the state.
+// Points older than the last point of the timeseries are ignored, so points
+// which are written again, or which are duplicated in a batch, aren't counted
+// twice.
+func (l *cardinalityLimiter) foldCumulative(overflowKey cardinalityKey, key string, ts *monitoringpb.TimeSeries) *cumulativeOverflow {
+	point := proto.Clone(ts.Points[0]).(*monitoringpb.Point)
+	d := point.GetValue().GetDistributionValue()
+	if d != nil {
+		d.Exemplars = nil
+	}
+	state, ok := l.cumulativeOverflows[overflowKey]
+	if !ok {
+		state = &cumulativeOverflow{
+			valueType:  ts.ValueType,
+			maxTracked: l.cfg.MaxOverflowTimeSeries,
+			start:      point.GetInterval().GetStartTime(),
+			last:       make(map[string]*overflowPoint),
+		}
+		if d != nil {
+			state.bucketOptions, state.bucketCount = d.BucketOptions, len(d.BucketCounts)
+		}
+		l.cumulativeOverflows[overflowKey] = state
+	}
+	state.used = true
+	if ts.ValueType != state.valueType {
+		return state
+	}
+	if d != nil && (!proto.Equal(d.BucketOptions, state.bucketOptions) || len(d.BucketCounts) != state.bucketCount) {
+		// Adding the distribution to the total isn't possible, and leaving
+		// it out of some totals would make the total decrease.
+		l.obs.log.Debug("Distribution has different buckets than its overflow timeseries. Leaving it out of the overflow timeseries.",
+			zap.String("metric_type", ts.GetMetric().GetType()))
+		return state
+	}
+	end := point.GetInterval().GetEndTime()
+	last, ok := state.last[key]
+	switch {
+	case ok:
+		lastEnd := last.point.GetInterval().GetEndTime()
+		if !end.AsTime().After(lastEnd.AsTime()) {
+			return state
+		}
+		if isCumulativeReset(last.point, point) {
+			state.retire(last.point.Value)
+		}
+		last.point, last.used = point, true
+	case state.maxTracked > 0 && len(state.last) >= state.maxTracked:
+		if !state.full {
+			state.full = true
+			l.obs.log.Warn("Too many timeseries folded into a cumulative overflow timeseries. New timeseries are left out of its total.",
+				zap.String("metric_type", ts.GetMetric().GetType()),
+				zap.Int("limit", state.maxTracked))
+		}
+		return state
+	default:
+		state.last[key] = &overflowPoint{point: point, used: true}
+	}
+	if state.end == nil || end.AsTime().After(state.end.AsTime()) {
+		state.end = end
+	}
+	if d := ts.Points[0].GetValue().GetDistributionValue(); d != nil {
+		state.exemplars = append(state.exemplars, d.Exemplars...)
+	}
+	return state
+}
+
+// isCumulativeReset returns true if the point starts a new cumulative
+// interval after the last point of the same timeseries.
+func isCumulativeReset(last, point *monitoringpb.Point) bool {
+	if !proto.Equal(last.GetInterval().GetStartTime(), point.GetInterval().GetStartTime()) {
+		return true
+	}
+	lastValue, value := last.GetValue(), point.GetValue()
+	switch lastValue.Value.(type) {
+	case *monitoringpb.TypedValue_Int64Value:
+		return value.GetInt64Value() < lastValue.GetInt64Value()
+	case *monitoringpb.TypedValue_DoubleValue:
+		return value.GetDoubleValue() < lastValue.GetDoubleValue()
+	case *monitoringpb.TypedValue_DistributionValue:
+		return value.GetDistributionValue().GetCount() < lastValue.GetDistributionValue().GetCount()
+	}
+	return false
+}
+
+// retire adds the value to the values of timeseries which no longer
+// contribute their last point.
+func (c *cumulativeOverflow) retire(value *monitoringpb.TypedValue) {
+	if c.retired == nil {
+		c.retired = proto.Clone(value).(*monitoringpb.TypedValue)
+		return
+	}
+	addTypedValue(c.retired, value, c.valueType)
+}
+
+// point returns the point of the cumulative overflow timeseries, or nil if
+// it has no new point. Exemplars are only written once.
+func (c *cumulativeOverflow) point() *monitoringpb.Point {
+	if c.written != nil && !c.end.AsTime().After(c.written.AsTime()) {
+		return nil
+	}
+	var total *monitoringpb.TypedValue
+	if c.retired != nil {
+		total = proto.Clone(c.retired).(*monitoringpb.TypedValue)
+	}
+	keys := make([]string, 0, len(c.last))
+	for key := range c.last {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := c.last[key].point.GetValue()
+		if total == nil {
+			total = proto.Clone(value).(*monitoringpb.TypedValue)
+			continue
+		}
+		// Only values with the same buckets are folded in, so they can
+		// always be added.
+		addTypedValue(total, value, c.valueType)
+	}
+	if total == nil {
+		return nil
+	}
+	if d := total.GetDistributionValue(); d != nil {
+		d.Exemplars = c.exemplars
+	}
+	c.exemplars = nil
+	c.written = c.end
+	return &monitoringpb.Point{
+		Interval: &monitoringpb.TimeInterval{StartTime: c.start, EndTime: c.end},
+		Value:    total,
+	}
+}
+
+// addTypedValue adds value into into, and returns false if they can't be
+// added.
+func addTypedValue(into, value *monitoringpb.TypedValue, valueType metricpb.MetricDescriptor_ValueType) bool {
+	switch valueType {
+	case metricpb.MetricDescriptor_INT64:
+		into.Value = &monitoringpb.TypedValue_Int64Value{
+			Int64Value: into.GetInt64Value() + value.GetInt64Value(),
+		}
+	case metricpb.MetricDescriptor_DOUBLE:
+		into.Value = &monitoringpb.TypedValue_DoubleValue{
+			DoubleValue: into.GetDoubleValue() + value.GetDoubleValue(),
+		}
+	case metricpb.MetricDescriptor_DISTRIBUTION:
+		return mergeDistributions(into.GetDistributionValue(), value.GetDistributionValue())
+	default:
+		return false
+	}
+	return true
+}
+
+// gc forgets timeseries which have not been written since the previous
+// garbage collection after the ticker ticks, freeing up their budget. The
+// last values of forgotten cumulative timeseries stay in the total of their
+// overflow timeseries, unless it isn't written at all.
+func (l *cardinalityLimiter) gc(shutdown <-chan struct{}, tickerCh <-chan time.Time) bool {
+	select {
+	case <-shutdown:
+		return false
+	case <-tickerCh:
+		l.mu.Lock()
+		for seriesKey, seen := range l.series {
+			for key, used := range seen {
+				if used {
+					seen[key] = false
+				} else {
+					delete(seen, key)
+				}
+			}
+			if len(seen) == 0 {
+				delete(l.series, seriesKey)
+			}
+		}
+		for overflowKey, state := range l.cumulativeOverflows {
+			if !state.used {
+				delete(l.cumulativeOverflows, overflowKey)
+				continue
+			}
+			state.used = false
+			for key, last := range state.last {
+				if last.used {
+					last.used = false
+					continue
+				}
+				state.retire(last.point.Value)
+				delete(state.last, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+	return true
+}
+
+// overflowTimeSeries returns a copy of the timeseries with its metric labels
+// replaced by the overflow label.
+func overflowTimeSeries(ts *monitoringpb.TimeSeries) *monitoringpb.TimeSeries {
+	// Make a copy so we don't mutate timeseries which may be shared.
+	overflow := proto.Clone(ts).(*monitoringpb.TimeSeries)
+	overflow.Metric = &metricpb.Metric{
+		Type:   ts.GetMetric().GetType(),
+		Labels: map[string]string{OverflowLabelKey: "true"},
+	}
+	return overflow
+}
+
+// mergeOverflowTimeSeries folds the point of ts into into by keeping the
+// newest point. Cumulative timeseries are folded with foldCumulative instead.
+func mergeOverflowTimeSeries(into, ts *monitoringpb.TimeSeries) {
+	if len(ts.Points) > 0 && endTimeNanos(ts) > endTimeNanos(into) {
+		into.Points = ts.Points
+	}
+}
+
+// mergeDistributions adds b into a, and returns false if the distributions
+// have different buckets and can't be merged.
+func mergeDistributions(a, b *distribution.Distribution) bool {
+	if a == nil || b == nil ||
+		!proto.Equal(a.BucketOptions, b.BucketOptions) ||
+		len(a.BucketCounts) != len(b.BucketCounts) {
+		return false
+	}
+	count := a.Count + b.Count
+	if count > 0 {
+		mean := (a.Mean*float64(a.Count) + b.Mean*float64(b.Count)) / float64(count)
+		// Combine the sums of squared deviation of both distributions around
+		// the new mean.
+		a.SumOfSquaredDeviation += b.SumOfSquaredDeviation +
+			float64(a.Count)*math.Pow(a.Mean-mean, 2) +
+			float64(b.Count)*math.Pow(b.Mean-mean, 2)
+		a.Mean = mean
+	}
+	switch {
+	case b.Count == 0:
+	case a.Count == 0:
+		a.Range = b.Range
+	case a.Range == nil || b.Range == nil:
+		// The range of one of the distributions is unknown.
+		a.Range = nil
+	default:
+		a.Range = &distribution.Distribution_Range{
+			Min: math.Min(a.Range.Min, b.Range.Min),
+			Max: math.Max(a.Range.Max, b.Range.Max),
+		}
+	}
+	a.Count = count
+	for i := range a.BucketCounts {
+		a.BucketCounts[i] += b.BucketCounts[i]
+	}
+	a.Exemplars = append(a.Exemplars, b.Exemplars...)
+	return true
+}
@@ -0,0 +1,326 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/api/distribution"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newCardinalityTestTimeSeries(metricType string, i int, kind metricpb.MetricDescriptor_MetricKind, value *monitoringpb.TypedValue, end time.Time) *monitoringpb.TimeSeries {
+	ts := newBatchTestTimeSeries(metricType, map[string]string{"i": fmt.Sprint(i)}, end)
+	ts.MetricKind = kind
+	ts.Points[0].Interval.StartTime = timestamppb.New(start)
+	ts.Points[0].Value = value
+	switch value.Value.(type) {
+	case *monitoringpb.TypedValue_Int64Value:
+		ts.ValueType = metricpb.MetricDescriptor_INT64
+	case *monitoringpb.TypedValue_DoubleValue:
+		ts.ValueType = metricpb.MetricDescriptor_DOUBLE
+	case *monitoringpb.TypedValue_DistributionValue:
+		ts.ValueType = metricpb.MetricDescriptor_DISTRIBUTION
+	}
+	return ts
+}
+
+func int64Value(v int64) *monitoringpb.TypedValue {
+	return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: v}}
+}
+
+func TestCardinalityLimiter(t *testing.T) {
+	newLimiter := func(t *testing.T, cfg CardinalityLimitConfig) *cardinalityLimiter {
+		return newCardinalityLimiter(cfg, newTestSelfObservability())
+	}
+	end := start.Add(time.Minute)
+
+	t.Run("Cumulative values are added", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 2})
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 5; i++ {
+			tss = append(tss, newCardinalityTestTimeSeries("foo", i, metricpb.MetricDescriptor_CUMULATIVE, int64Value(int64(i)), end))
+		}
+		result := l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 3)
+		assert.Equal(t, tss[:2], result[:2])
+		overflow := result[2]
+		assert.Equal(t, map[string]string{OverflowLabelKey: "true"}, overflow.Metric.Labels)
+		assert.Equal(t, "foo", overflow.Metric.Type)
+		assert.Equal(t, int64(2+3+4), overflow.Points[0].Value.GetInt64Value())
+		// The input timeseries are not modified.
+		assert.Equal(t, int64(2), tss[2].Points[0].Value.GetInt64Value())
+		assert.Equal(t, map[string]string{"i": "2"}, tss[2].Metric.Labels)
+
+		// Timeseries which were already seen are still written.
+		result = l.apply(context.Background(), "myproject", tss[1:2])
+		assert.Equal(t, tss[1:2], result)
+	})
+
+	t.Run("Cumulative overflow is a running total", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		newTimeSeries := func(i int, value int64, start, end time.Time) *monitoringpb.TimeSeries {
+			ts := newCardinalityTestTimeSeries("foo", i, metricpb.MetricDescriptor_CUMULATIVE, int64Value(value), end)
+			ts.Points[0].Interval.StartTime = timestamppb.New(start)
+			return ts
+		}
+		overflowPoint := func(t *testing.T, tss ...*monitoringpb.TimeSeries) *monitoringpb.Point {
+			result := l.apply(context.Background(), "myproject", tss)
+			require.Len(t, result, 2)
+			require.Len(t, result[1].Points, 1)
+			return result[1].Points[0]
+		}
+
+		point := overflowPoint(t,
+			newTimeSeries(0, 100, start, end),
+			newTimeSeries(1, 5, start.Add(time.Second), end),
+			newTimeSeries(2, 7, start, end),
+		)
+		assert.Equal(t, int64(12), point.Value.GetInt64Value())
+		assert.Equal(t, start.Add(time.Second).UTC(), point.Interval.StartTime.AsTime())
+
+		// The last point of each timeseries counts once, even if a batch
+		// has several points of the same timeseries.
+		point = overflowPoint(t,
+			newTimeSeries(0, 101, start, end.Add(time.Minute)),
+			newTimeSeries(1, 6, start.Add(time.Second), end.Add(time.Minute)),
+			newTimeSeries(1, 8, start.Add(time.Second), end.Add(2*time.Minute)),
+		)
+		assert.Equal(t, int64(8+7), point.Value.GetInt64Value())
+		// The start time doesn't change.
+		assert.Equal(t, start.Add(time.Second).UTC(), point.Interval.StartTime.AsTime())
+		assert.Equal(t, end.Add(2*time.Minute).UTC(), point.Interval.EndTime.AsTime())
+
+		// Reset timeseries keep the value they had before the reset, so the
+		// total doesn't decrease.
+		point = overflowPoint(t,
+			newTimeSeries(0, 102, start, end.Add(3*time.Minute)),
+			newTimeSeries(2, 1, end.Add(2*time.Minute), end.Add(3*time.Minute)),
+		)
+		assert.Equal(t, int64(8+7+1), point.Value.GetInt64Value())
+
+		// Points which are written again aren't counted.
+		result := l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{
+			newTimeSeries(2, 1, end.Add(2*time.Minute), end.Add(3*time.Minute)),
+		})
+		assert.Empty(t, result)
+
+		// Forgotten timeseries keep their value in the total.
+		shutdown := make(chan struct{})
+		tickerCh := make(chan time.Time, 1)
+		tickerCh <- time.Now()
+		assert.True(t, l.gc(shutdown, tickerCh))
+		point = overflowPoint(t,
+			newTimeSeries(0, 103, start, end.Add(4*time.Minute)),
+			newTimeSeries(2, 2, end.Add(2*time.Minute), end.Add(4*time.Minute)),
+		)
+		assert.Equal(t, int64(8+7+2), point.Value.GetInt64Value())
+		tickerCh <- time.Now()
+		assert.True(t, l.gc(shutdown, tickerCh))
+		point = overflowPoint(t,
+			newTimeSeries(0, 104, start, end.Add(5*time.Minute)),
+			newTimeSeries(2, 3, end.Add(2*time.Minute), end.Add(5*time.Minute)),
+		)
+		assert.Equal(t, int64(8+7+3), point.Value.GetInt64Value())
+	})
+
+	t.Run("Gauges keep the newest point", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		tss := []*monitoringpb.TimeSeries{
+			newCardinalityTestTimeSeries("foo", 0, metricpb.MetricDescriptor_GAUGE, int64Value(1), end),
+			newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_GAUGE, int64Value(2), end.Add(time.Second)),
+			newCardinalityTestTimeSeries("foo", 2, metricpb.MetricDescriptor_GAUGE, int64Value(3), end),
+		}
+		result := l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 2)
+		assert.Equal(t, int64(2), result[1].Points[0].Value.GetInt64Value())
+	})
+
+	t.Run("Distributions are merged", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		newDistribution := func(count int64, mean float64, buckets []int64) *monitoringpb.TypedValue {
+			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DistributionValue{
+				DistributionValue: &distribution.Distribution{
+					Count:        count,
+					Mean:         mean,
+					BucketCounts: buckets,
+					BucketOptions: &distribution.Distribution_BucketOptions{
+						Options: &distribution.Distribution_BucketOptions_ExplicitBuckets{
+							ExplicitBuckets: &distribution.Distribution_BucketOptions_Explicit{Bounds: []float64{10}},
+						},
+					},
+				},
+			}}
+		}
+		tss := []*monitoringpb.TimeSeries{
+			newCardinalityTestTimeSeries("foo", 0, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(1, 1, []int64{1, 0}), end),
+			newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(1, 2, []int64{1, 0}), end),
+			newCardinalityTestTimeSeries("foo", 2, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(3, 14, []int64{1, 2}), end),
+		}
+		result := l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 2)
+		d := result[1].Points[0].Value.GetDistributionValue()
+		assert.Equal(t, int64(4), d.Count)
+		assert.Equal(t, 11.0, d.Mean)
+		assert.Equal(t, []int64{2, 2}, d.BucketCounts)
+		assert.Equal(t, 1*81.0+3*9.0, d.SumOfSquaredDeviation)
+	})
+
+	t.Run("Distributions with other buckets are left out", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		newDistribution := func(count int64, bounds []float64, buckets []int64) *monitoringpb.TypedValue {
+			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DistributionValue{
+				DistributionValue: &distribution.Distribution{
+					Count:        count,
+					BucketCounts: buckets,
+					BucketOptions: &distribution.Distribution_BucketOptions{
+						Options: &distribution.Distribution_BucketOptions_ExplicitBuckets{
+							ExplicitBuckets: &distribution.Distribution_BucketOptions_Explicit{Bounds: bounds},
+						},
+					},
+				},
+			}}
+		}
+		tss := []*monitoringpb.TimeSeries{
+			newCardinalityTestTimeSeries("foo", 0, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(1, []float64{10}, []int64{1, 0}), end),
+			newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(1, []float64{10}, []int64{1, 0}), end),
+			newCardinalityTestTimeSeries("foo", 2, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(3, []float64{5, 10}, []int64{1, 1, 1}), end),
+		}
+		result := l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 2)
+		assert.Equal(t, int64(1), result[1].Points[0].Value.GetDistributionValue().Count)
+
+		// The total doesn't change when the left out timeseries is written
+		// with the buckets of the overflow timeseries.
+		tss = []*monitoringpb.TimeSeries{
+			newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(2, []float64{10}, []int64{2, 0}), end.Add(time.Minute)),
+			newCardinalityTestTimeSeries("foo", 2, metricpb.MetricDescriptor_CUMULATIVE, newDistribution(4, []float64{5, 10}, []int64{2, 1, 1}), end.Add(time.Minute)),
+		}
+		result = l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 1)
+		assert.Equal(t, int64(2), result[0].Points[0].Value.GetDistributionValue().Count)
+	})
+
+	t.Run("Cumulative overflow keeps at most MaxOverflowTimeSeries", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1, MaxOverflowTimeSeries: 2})
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 5; i++ {
+			tss = append(tss, newCardinalityTestTimeSeries("foo", i, metricpb.MetricDescriptor_CUMULATIVE, int64Value(int64(i)), end))
+		}
+		result := l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 2)
+		assert.Equal(t, int64(1+2), result[1].Points[0].Value.GetInt64Value())
+		assert.Len(t, l.cumulativeOverflows, 1)
+		for _, state := range l.cumulativeOverflows {
+			assert.Len(t, state.last, 2)
+		}
+
+		// Timeseries which were left out stay out, so the total doesn't
+		// jump when they are written again.
+		tss = []*monitoringpb.TimeSeries{
+			newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_CUMULATIVE, int64Value(3), end.Add(time.Minute)),
+			newCardinalityTestTimeSeries("foo", 3, metricpb.MetricDescriptor_CUMULATIVE, int64Value(10), end.Add(time.Minute)),
+		}
+		result = l.apply(context.Background(), "myproject", tss)
+		require.Len(t, result, 1)
+		assert.Equal(t, int64(3+2), result[0].Points[0].Value.GetInt64Value())
+	})
+
+	t.Run("Each project has its own limit", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		ts := newCardinalityTestTimeSeries("foo", 0, metricpb.MetricDescriptor_GAUGE, int64Value(1), end)
+		other := newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_GAUGE, int64Value(2), end)
+		assert.Equal(t, []*monitoringpb.TimeSeries{ts}, l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{ts}))
+		assert.Equal(t, []*monitoringpb.TimeSeries{other}, l.apply(context.Background(), "otherproject", []*monitoringpb.TimeSeries{other}))
+		result := l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{other})
+		require.Len(t, result, 1)
+		assert.Equal(t, map[string]string{OverflowLabelKey: "true"}, result[0].Metric.Labels)
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{
+			Default:   1,
+			Overrides: map[string]int{"unlimited": 0},
+		})
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 3; i++ {
+			tss = append(tss, newCardinalityTestTimeSeries("unlimited", i, metricpb.MetricDescriptor_GAUGE, int64Value(1), end))
+		}
+		assert.Equal(t, tss, l.apply(context.Background(), "myproject", tss))
+	})
+
+	t.Run("Unused timeseries are forgotten", func(t *testing.T) {
+		l := newLimiter(t, CardinalityLimitConfig{Default: 1})
+		first := newCardinalityTestTimeSeries("foo", 0, metricpb.MetricDescriptor_GAUGE, int64Value(1), end)
+		second := newCardinalityTestTimeSeries("foo", 1, metricpb.MetricDescriptor_GAUGE, int64Value(1), end)
+		l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{first})
+
+		shutdown := make(chan struct{})
+		tickerCh := make(chan time.Time, 1)
+		tickerCh <- time.Now()
+		assert.True(t, l.gc(shutdown, tickerCh))
+		// first was used before the first gc, so it still counts.
+		assert.NotEqual(t, second, l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{second})[0])
+
+		tickerCh <- time.Now()
+		assert.True(t, l.gc(shutdown, tickerCh))
+		assert.Equal(t, second, l.apply(context.Background(), "myproject", []*monitoringpb.TimeSeries{second})[0])
+	})
+}
+
+func TestMergeDistributionsRange(t *testing.T) {
+	newDistribution := func(count int64, r *distribution.Distribution_Range) *distribution.Distribution {
+		return &distribution.Distribution{
+			Count:        count,
+			BucketCounts: []int64{count},
+			Range:        r,
+		}
+	}
+	for _, tc := range []struct {
+		desc     string
+		a, b     *distribution.Distribution
+		expected *distribution.Distribution_Range
+	}{
+		{
+			desc:     "both ranges are known",
+			a:        newDistribution(1, &distribution.Distribution_Range{Min: 1, Max: 2}),
+			b:        newDistribution(1, &distribution.Distribution_Range{Min: 0, Max: 1}),
+			expected: &distribution.Distribution_Range{Min: 0, Max: 2},
+		},
+		{
+			desc: "one range is unknown",
+			a:    newDistribution(1, &distribution.Distribution_Range{Min: 1, Max: 2}),
+			b:    newDistribution(1, nil),
+		},
+		{
+			desc:     "empty distribution",
+			a:        newDistribution(0, nil),
+			b:        newDistribution(1, &distribution.Distribution_Range{Min: 0, Max: 1}),
+			expected: &distribution.Distribution_Range{Min: 0, Max: 1},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.True(t, mergeDistributions(tc.a, tc.b))
+			assert.Equal(t, tc.expected, tc.a.Range)
+		})
+	}
+}
@@ -15,17 +15,131 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/metric"
 	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/api"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
 const (
 	DefaultTimeout = 12 * time.Second // Consistent with Cloud Monitoring's timeout
+
+	// Cloud Monitoring accepts at most 200 buckets, which includes the
+	// underflow and overflow buckets.
+	defaultMaxExponentialHistogramBuckets = 198
+
+	defaultNormalizationCacheGCInterval = 20 * time.Minute
+
+	defaultMaxOverflowTimeSeries = 10000
+)
+
+// Values for MetricConfig.DuplicateTimeSeriesPolicy.
+const (
+	// DuplicateTimeSeriesSplit sends duplicate timeseries in sequential
+	// requests, in timestamp order.
+	DuplicateTimeSeriesSplit = "split"
+	// DuplicateTimeSeriesKeepNewest only sends the newest point of duplicate
+	// timeseries.
+	DuplicateTimeSeriesKeepNewest = "keep_newest"
+)
+
+// Values for MetricConfig.CumulativeStartTime.
+const (
+	// CumulativeStartTimeNormalize drops the first point of cumulative
+	// timeseries without a start time, and subtracts it from later points.
+	CumulativeStartTimeNormalize = "normalize"
+	// CumulativeStartTimeProcessStart uses the start time of the process
+	// which produced cumulative points without a start time, if it is known.
+	CumulativeStartTimeProcessStart = "process_start_time"
+)
+
+// Values for MetricConfig.MetricDescriptorConflictStrategy.
+const (
+	// MetricDescriptorConflictSkip logs conflicting metric descriptors, and
+	// leaves the existing descriptor unchanged.
+	MetricDescriptorConflictSkip = "skip"
+	// MetricDescriptorConflictRename writes timeseries which conflict with
+	// the existing descriptor to a new metric type, with the metric kind and
+	// value type appended.
+	MetricDescriptorConflictRename = "rename"
+	// MetricDescriptorConflictRecreate deletes the existing descriptor, and
+	// all of its data, and creates the new one. It requires
+	// MetricConfig.AllowMetricDescriptorDeletion.
+	MetricDescriptorConflictRecreate = "recreate"
+)
+
+// Values for MetricConfig.UnknownUnitPolicy.
+const (
+	// UnknownUnitAnnotation writes units Cloud Monitoring doesn't support as
+	// an annotation, e.g. "Cel" as "{Cel}", which is dimensionless.
+	UnknownUnitAnnotation = "annotation"
+	// UnknownUnitVerbatim writes units Cloud Monitoring doesn't support
+	// unchanged.
+	UnknownUnitVerbatim = "verbatim"
+	// UnknownUnitDrop writes no unit for units Cloud Monitoring doesn't
+	// support.
+	UnknownUnitDrop = "drop"
+)
+
+// Values for LabelLimitsConfig.Policy.
+const (
+	// LabelLimitsTruncate truncates label keys and values which are too long,
+	// replacing their end with a hash, and drops the lowest priority labels
+	// of metrics with too many labels.
+	LabelLimitsTruncate = "truncate"
+	// LabelLimitsDropLabels drops labels with keys or values which are too
+	// long, and the lowest priority labels of metrics with too many labels.
+	LabelLimitsDropLabels = "drop_labels"
+	// LabelLimitsDropPoint drops points with any label over the limits,
+	// including labels which are dropped from metrics with too many labels.
+	LabelLimitsDropPoint = "drop_point"
+)
+
+// Values for RelabelConfig.Action.
+const (
+	// RelabelReplace sets TargetLabel to Replacement, with capture groups
+	// expanded, if Regex matches the joined SourceLabels.
+	RelabelReplace = "replace"
+	// RelabelKeep drops points for which Regex doesn't match the joined
+	// SourceLabels.
+	RelabelKeep = "keep"
+	// RelabelDrop drops points for which Regex matches the joined
+	// SourceLabels.
+	RelabelDrop = "drop"
+	// RelabelHashMod sets TargetLabel to the hash of the joined SourceLabels,
+	// modulo Modulus.
+	RelabelHashMod = "hashmod"
+	// RelabelLabelMap copies the labels with keys matching Regex to the keys
+	// given by Replacement, with capture groups expanded.
+	RelabelLabelMap = "labelmap"
+	// RelabelLabelDrop removes the labels with keys matching Regex.
+	RelabelLabelDrop = "labeldrop"
+)
+
+// Values for ClientConfig.Transport.
+const (
+	// ClientTransportGRPC sends requests to the Google Cloud APIs.
+	ClientTransportGRPC = "grpc"
+	// ClientTransportFile writes requests to files as JSON lines, after
+	// validating them like the Google Cloud APIs would, instead of sending
+	// them. It needs no credentials.
+	ClientTransportFile = "file"
+)
+
+// Values for ProjectRoutingRule.Source.
+const (
+	// ProjectRoutingSourceResource matches the attributes of the resource.
+	ProjectRoutingSourceResource = "resource"
+	// ProjectRoutingSourceRecord matches the attributes of the metric point,
+	// log record or span.
+	ProjectRoutingSourceRecord = "record"
 )
 
 // Config defines configuration for Google Cloud exporter.
@@ -39,6 +153,84 @@ type Config struct {
 	TraceConfig  TraceConfig  `mapstructure:"trace"`
 	LogConfig    LogConfig    `mapstructure:"log"`
 	MetricConfig MetricConfig `mapstructure:"metric"`
+	// ProjectRouting determines the project each time series, log entry and
+	// span is sent to.
+	ProjectRouting ProjectRoutingConfig `mapstructure:"project_routing"`
+	// ResourceMappings determine the monitored resource of metrics and
+	// logs. They are evaluated in order, before the built-in mappings, and
+	// the first whose predicates all match is used. If set, they take
+	// precedence over MetricConfig.MapMonitoredResource.
+	ResourceMappings []ResourceMapping `mapstructure:"resource_mappings"`
+	// MeterProvider is used to report metrics about the exporters
+	// themselves.
+	// Must be set programmatically (no support via declarative config).
+	// Optional. The global MeterProvider is used if unset.
+	MeterProvider metric.MeterProvider
+}
+
+// ProjectRoutingConfig routes telemetry to projects based on its attributes.
+type ProjectRoutingConfig struct {
+	// Rules are evaluated in order, and the first rule which matches
+	// determines the project. Telemetry which matches no rule is sent to the
+	// project in its gcp.project.id resource attribute, if it is set, or
+	// else to DefaultProjectID.
+	Rules []ProjectRoutingRule `mapstructure:"rules"`
+	// DefaultProjectID is the project of telemetry which matches no rule,
+	// and has no gcp.project.id resource attribute. Defaults to ProjectID.
+	DefaultProjectID string `mapstructure:"default_project"`
+	// DropUnmatched drops telemetry which matches no rule, even if it has a
+	// gcp.project.id resource attribute.
+	DropUnmatched bool `mapstructure:"drop_unmatched"`
+}
+
+// ProjectRoutingRule maps the value of an attribute to a project. By default
+// the value is the project ID.
+type ProjectRoutingRule struct {
+	// Attribute is the key of the attribute to match.
+	Attribute string `mapstructure:"attribute"`
+	// Source is where the attribute is looked up. Defaults to
+	// ProjectRoutingSourceResource.
+	Source string `mapstructure:"source"`
+	// Projects maps attribute values to project IDs. A value which isn't in
+	// the table is unknown: the telemetry matches no rule, and later rules
+	// aren't evaluated.
+	Projects map[string]string `mapstructure:"projects"`
+	// Template is the project ID, with ${value} replaced by the attribute
+	// value. It can't be combined with Projects.
+	Template string `mapstructure:"template"`
+}
+
+// ResourceMapping maps resources to a monitored resource type.
+type ResourceMapping struct {
+	// Type is the monitored resource type.
+	Type string `mapstructure:"type"`
+	// Match are predicates on resource attributes, which must all hold for
+	// the mapping to be used. A mapping without predicates matches every
+	// resource.
+	Match []ResourceAttributeMatch `mapstructure:"match"`
+	// Labels maps monitored resource label keys onto the resource attributes
+	// they are populated from. If unset, the labels of the built-in mapping
+	// of Type are used.
+	Labels map[string]ResourceLabelMapping `mapstructure:"labels"`
+}
+
+// ResourceAttributeMatch matches resources with an attribute.
+type ResourceAttributeMatch struct {
+	// Key is the resource attribute key.
+	Key string `mapstructure:"key"`
+	// Values are the values the attribute may have. If empty, the attribute
+	// only needs to be present.
+	Values []string `mapstructure:"values"`
+}
+
+// ResourceLabelMapping determines the value of a monitored resource label.
+type ResourceLabelMapping struct {
+	// Key is the resource attribute the label is populated from.
+	Key string `mapstructure:"key"`
+	// FallbackKeys are tried in order if Key is missing or empty.
+	FallbackKeys []string `mapstructure:"fallback_keys"`
+	// Default is the value of the label if none of the keys have a value.
+	Default string `mapstructure:"default"`
 }
 
 type ClientConfig struct {
@@ -53,6 +245,13 @@ type ClientConfig struct {
 	UseInsecure bool `mapstructure:"use_insecure"`
 	// GRPCPoolSize sets the size of the connection pool in the GCP client
 	GRPCPoolSize int `mapstructure:"grpc_pool_size"`
+	// Transport determines how requests are sent. Defaults to
+	// ClientTransportGRPC.
+	Transport string `mapstructure:"transport"`
+	// FilePath is the file requests are appended to with
+	// ClientTransportFile. If it is a directory, the requests to each API are
+	// appended to a file in it named after the API.
+	FilePath string `mapstructure:"file_path"`
 }
 
 type TraceConfig struct {
@@ -114,10 +313,273 @@ type MetricConfig struct {
 	// It is enabled by default. Since it caches starting points, it may result in
 	// increased memory usage.
 	CumulativeNormalization bool `mapstructure:"cumulative_normalization"`
+	// CumulativeNormalizationSnapshot, if set, periodically saves the points
+	// cached by cumulative normalization to disk, and restores them at
+	// startup, so cumulative timeseries continue across restarts instead of
+	// being reset.
+	CumulativeNormalizationSnapshot *NormalizationSnapshotConfig `mapstructure:"cumulative_normalization_snapshot"`
+	// CumulativeStartTime determines the start time of cumulative points
+	// without one. "normalize" (the default) drops the first point, and
+	// subtracts it from later points. "process_start_time" uses the
+	// process.start_time resource attribute, or the value of the Prometheus
+	// process_start_time_seconds metric from the same resource, as the start
+	// time, so counts from before the first point are kept. The start time
+	// of each resource is remembered, so it also applies to later pushes
+	// without the metric. Points from resources without a known process
+	// start time are normalized.
+	CumulativeStartTime string `mapstructure:"cumulative_start_time"`
+	// DeltaToCumulative accumulates delta sums and histograms into cumulative
+	// points with a stable start time for each timeseries. When disabled, each
+	// delta point is sent as a cumulative point covering only its own interval.
+	// Since it caches running totals, it may result in increased memory usage.
+	DeltaToCumulative bool `mapstructure:"delta_to_cumulative"`
+	// NormalizationCache limits the memory used by the points cached for
+	// CumulativeNormalization and DeltaToCumulative.
+	NormalizationCache NormalizationCacheConfig `mapstructure:"normalization_cache"`
 	// EnableSumOfSquaredDeviation enables calculation of an estimated sum of squared
 	// deviation.  It isn't correct, so we don't send it by default, and don't expose
 	// it to users. For some uses, it is expected, however.
 	EnableSumOfSquaredDeviation bool `mapstructure:"sum_of_squared_deviation"`
+	// DuplicateTimeSeriesPolicy determines how multiple points for the same
+	// timeseries in a single export are handled, since Cloud Monitoring rejects
+	// requests which contain the same timeseries twice. "split" (the default)
+	// sends them in sequential requests, in timestamp order. "keep_newest"
+	// only sends the point with the latest timestamp.
+	DuplicateTimeSeriesPolicy string `mapstructure:"duplicate_timeseries_policy"`
+	// RetryPartialFailures, if true, resends the timeseries of a failed
+	// CreateTimeSeries request which failed with a retryable error
+	// (UNAVAILABLE or DEADLINE_EXCEEDED). Timeseries which were rejected
+	// permanently, or which were written successfully, are not resent.
+	// Timeseries are only resent if the error message or details identify
+	// them.
+	RetryPartialFailures bool `mapstructure:"retry_partial_failures"`
+	// WALConfig, if set, enables a write-ahead log which persists
+	// CreateTimeSeries requests that failed with a retryable error to disk,
+	// and replays them with exponential backoff, including across restarts.
+	WALConfig *WALConfig `mapstructure:"experimental_wal_config"`
+	// CardinalityLimit limits the number of distinct timeseries written for
+	// each metric type. Label combinations seen after the limit is reached
+	// are written to a single timeseries with the otel_overflow="true" label.
+	// For cumulative metrics, its value is the sum of the latest values of
+	// those label combinations, and its start time is fixed when it is
+	// first written.
+	CardinalityLimit CardinalityLimitConfig `mapstructure:"cardinality_limit"`
+	// WarmMetricDescriptorCache, if true, lists the existing metric
+	// descriptors under Prefix in each project before creating any, so
+	// descriptors which already exist aren't created again after a restart,
+	// and conflicts with them are detected.
+	WarmMetricDescriptorCache bool `mapstructure:"warm_metric_descriptor_cache"`
+	// MetricDescriptorConflictStrategy determines what happens when a metric
+	// descriptor has a different metric kind or value type than the existing
+	// descriptor of the same metric type. Conflicts are always logged and
+	// counted. "skip" (the default) leaves the existing descriptor unchanged,
+	// so the conflicting timeseries are rejected. "rename" writes the
+	// conflicting timeseries to a new metric type with the kind and value type
+	// appended, e.g. "workload.googleapis.com/foo_cumulative_double". It
+	// lists the existing descriptors under Prefix in each project before the
+	// first timeseries are written to it, even if WarmMetricDescriptorCache
+	// is false. "recreate" deletes the existing descriptor, including all of
+	// its data, and must be enabled with AllowMetricDescriptorDeletion.
+	MetricDescriptorConflictStrategy string `mapstructure:"metric_descriptor_conflict_strategy"`
+	// AllowMetricDescriptorDeletion acknowledges that the "recreate"
+	// MetricDescriptorConflictStrategy permanently deletes the existing
+	// metric descriptors, and all of their data.
+	AllowMetricDescriptorDeletion bool `mapstructure:"allow_metric_descriptor_deletion"`
+	// LabelLimits enforces Cloud Monitoring's limits on the number of labels
+	// of a metric, and the length of label keys and values, which otherwise
+	// cause the whole CreateTimeSeries request to fail.
+	LabelLimits LabelLimitsConfig `mapstructure:"label_limits"`
+	// MetricRelabelConfigs are Prometheus-style relabeling rules applied, in
+	// order, to the labels of each point before its metric type is
+	// determined. The metric name is available as the "__name__" label, and
+	// can be changed by setting it.
+	MetricRelabelConfigs []RelabelConfig `mapstructure:"metric_relabel_configs"`
+	// MaxExponentialHistogramBuckets is the maximum number of finite buckets
+	// written for exponential histograms. Points with more positive buckets
+	// have their scale reduced, merging adjacent buckets, until they fit.
+	// Each timeseries keeps the lowest scale it has needed. Defaults to 198,
+	// since Cloud Monitoring accepts at most 200 buckets, including the
+	// underflow and overflow buckets. Set to 0 to disable downscaling.
+	MaxExponentialHistogramBuckets int `mapstructure:"max_exponential_histogram_buckets"`
+	// MaxConcurrentRequests is the maximum number of CreateTimeSeries
+	// requests in flight at once, across all projects. Defaults to 10.
+	MaxConcurrentRequests int `mapstructure:"max_concurrent_requests"`
+	// MaxConcurrentRequestsPerProject is the maximum number of
+	// CreateTimeSeries requests in flight at once for each project. Requests
+	// for different projects are always sent concurrently, so a slow or
+	// throttled project doesn't delay the others. Defaults to 1.
+	MaxConcurrentRequestsPerProject int `mapstructure:"max_concurrent_requests_per_project"`
+	// MaxTimeSeriesPerRequest is the maximum number of timeseries in a
+	// CreateTimeSeries request. Defaults to 200, which is the most Cloud
+	// Monitoring accepts.
+	MaxTimeSeriesPerRequest int `mapstructure:"max_timeseries_per_request"`
+	// MaxRequestBytes is the maximum encoded size of a CreateTimeSeries
+	// request. Batches are split before they exceed it, though a single
+	// timeseries which is larger is still sent on its own. Defaults to 10 MB.
+	MaxRequestBytes int `mapstructure:"max_request_bytes"`
+	// UnknownUnitPolicy determines how metric units are written when they
+	// can't be translated from UCUM, as used by OpenTelemetry, to the units
+	// Cloud Monitoring supports: "annotation" (the default), "verbatim" or
+	// "drop".
+	UnknownUnitPolicy string `mapstructure:"unknown_unit_policy"`
+	// Descriptors override the metadata of the metric descriptors created
+	// for metrics. A metric uses the override whose Metric is its name, if
+	// there is one, and otherwise the first override whose Metric is a glob
+	// matching its name. Descriptors which already exist are updated when
+	// their metadata changes.
+	Descriptors []MetricDescriptorConfig `mapstructure:"descriptors"`
+}
+
+// MetricDescriptorConfig overrides the metadata of metric descriptors.
+// Fields which are unset keep the metadata derived from the metric.
+type MetricDescriptorConfig struct {
+	// Metric is the name of the metric, before the prefix is added, or a
+	// glob matching it with the syntax of path.Match, e.g. "http.server.*".
+	Metric string `mapstructure:"metric"`
+	// DisplayName is the name of the metric shown in the Cloud Console.
+	// Summary metrics append the suffix of each of their descriptors to it.
+	DisplayName string `mapstructure:"display_name"`
+	// Description is the description of the metric.
+	Description string `mapstructure:"description"`
+	// Labels maps label keys to their descriptions.
+	Labels map[string]string `mapstructure:"labels"`
+	// LaunchStage is the launch stage of the metric, e.g. "BETA".
+	LaunchStage string `mapstructure:"launch_stage"`
+	// MonitoredResourceTypes restricts the monitored resource types the
+	// metric can be written to.
+	MonitoredResourceTypes []string `mapstructure:"monitored_resource_types"`
+}
+
+// RelabelConfig is a rule for rewriting the name and labels of metric points.
+// It follows Prometheus' relabel_config.
+type RelabelConfig struct {
+	// SourceLabels are the label keys whose values are joined with Separator
+	// and matched against Regex. They are sanitized like TargetLabel.
+	SourceLabels []string `mapstructure:"source_labels"`
+	// Separator joins the values of SourceLabels. Defaults to ";".
+	Separator string `mapstructure:"separator"`
+	// Regex is matched against the joined SourceLabels, or against label keys
+	// for the labelmap and labeldrop actions. It is anchored at both ends.
+	// Defaults to "(.*)".
+	Regex string `mapstructure:"regex"`
+	// Modulus is the modulus of the hash for the hashmod action.
+	Modulus uint64 `mapstructure:"modulus"`
+	// TargetLabel is the label key set by the replace and hashmod actions.
+	// Like the keys of attributes, it is sanitized, e.g. "http.method" is
+	// set as "http_method".
+	TargetLabel string `mapstructure:"target_label"`
+	// Replacement is the value set by the replace action, or the key set by
+	// the labelmap action, which is sanitized like TargetLabel. Capture
+	// groups of Regex are expanded, e.g. "$1". Defaults to "$1" if unset. An
+	// empty replacement removes TargetLabel.
+	Replacement *string `mapstructure:"replacement"`
+	// Action is one of "replace", "keep", "drop", "hashmod", "labelmap" or
+	// "labeldrop". Defaults to "replace".
+	Action string `mapstructure:"action"`
+}
+
+// LabelLimitsConfig defines how metric label limits are enforced.
+type LabelLimitsConfig struct {
+	// Policy is the action taken when a limit is exceeded: "truncate",
+	// "drop_labels" or "drop_point". Defaults to "", which doesn't enforce
+	// limits.
+	Policy string `mapstructure:"policy"`
+	// MaxLabels is the maximum number of labels of a metric, counted over
+	// the labels of all of its points, and including the otel_overflow label
+	// if a cardinality limit is set. Defaults to 30.
+	MaxLabels int `mapstructure:"max_labels"`
+	// MaxKeyLength is the maximum length of a label key, in bytes. Defaults
+	// to 100.
+	MaxKeyLength int `mapstructure:"max_key_length"`
+	// MaxValueLength is the maximum length of a label value, in bytes.
+	// Defaults to 1024.
+	MaxValueLength int `mapstructure:"max_value_length"`
+	// Priority lists the label keys to keep first when labels must be
+	// dropped from a metric with too many labels. Labels which aren't listed
+	// are dropped first, in reverse alphabetical order.
+	Priority []string `mapstructure:"priority"`
+}
+
+// CardinalityLimitConfig defines the number of timeseries allowed for each
+// metric type.
+type CardinalityLimitConfig struct {
+	// Default is the limit for metric types without an override. Defaults to
+	// 0, which means unlimited.
+	Default int `mapstructure:"default"`
+	// Overrides maps from a metric type, e.g.
+	// "workload.googleapis.com/http.server.duration", to its limit. A limit of
+	// 0 means unlimited.
+	Overrides map[string]int `mapstructure:"overrides"`
+	// MaxOverflowTimeSeries is the maximum number of timeseries over the
+	// limit whose last point is kept for each cumulative overflow
+	// timeseries, so their values are added up once. Further timeseries are
+	// left out of the overflow total until others go unused for
+	// NormalizationCache.GCInterval. Zero means no limit. Defaults to 10000.
+	MaxOverflowTimeSeries int `mapstructure:"max_overflow_timeseries"`
+}
+
+// enabled returns true if any metric type is limited.
+func (c CardinalityLimitConfig) enabled() bool {
+	if c.Default > 0 {
+		return true
+	}
+	for _, limit := range c.Overrides {
+		if limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizationSnapshotConfig defines configuration for the cumulative
+// normalization snapshot.
+type NormalizationSnapshotConfig struct {
+	// Path is the path to the file where the snapshot is stored.
+	Path string `mapstructure:"path"`
+	// Interval is how often the snapshot is written. It is also written at
+	// shutdown. Defaults to 1m.
+	Interval time.Duration `mapstructure:"interval"`
+	// TTL is the maximum age of a snapshot which is restored at startup.
+	// Older snapshots are ignored. Defaults to 1h.
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+// NormalizationCacheConfig defines configuration for the caches of points
+// used by cumulative normalization and delta to cumulative accumulation. The
+// limits apply to each cache separately: the normalizer keeps one cache of
+// start points and one of previous points, the accumulator keeps one of
+// running totals, and exponential histograms keep one of the scale of each
+// timeseries.
+type NormalizationCacheConfig struct {
+	// MaxEntries is the maximum number of points in each cache. When it is
+	// exceeded, the least recently used points are evicted, and their
+	// timeseries are treated as new if they are seen again. Zero means no
+	// limit, which is the default.
+	MaxEntries int `mapstructure:"max_entries"`
+	// MaxBytes is the maximum estimated memory used by the points in each
+	// cache, in bytes. When it is exceeded, the least recently used points
+	// are evicted. Zero means no limit, which is the default.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+	// GCInterval is how often unused points are removed. Points which
+	// haven't been used for a full interval are removed. Defaults to 20m.
+	GCInterval time.Duration `mapstructure:"gc_interval"`
+}
+
+// WALConfig defines configuration for the metrics write-ahead log.
+type WALConfig struct {
+	// Directory is the path to the directory where pending requests are stored.
+	Directory string `mapstructure:"directory"`
+	// MaxSize is the maximum total size of the pending requests of all
+	// projects, in bytes. When it is exceeded, the oldest requests of the
+	// projects with the most pending requests are dropped. Defaults to 1 GiB.
+	MaxSize int64 `mapstructure:"max_size"`
+	// MaxAge is the maximum age of points which are replayed. Older points are
+	// dropped. Defaults to 24h, and can't exceed it, since Cloud Monitoring
+	// rejects points older than 25 hours.
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MaxBackoff is the maximum time to wait between retries of a pending
+	// request. Defaults to 5m.
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
 }
 
 // ImpersonateConfig defines configuration for service account impersonation
@@ -153,8 +615,23 @@ func DefaultConfig() Config {
 			InstrumentationLibraryLabels:     true,
 			ServiceResourceLabels:            true,
 			CumulativeNormalization:          true,
-			GetMetricName:                    defaultGetMetricName,
-			MapMonitoredResource:             defaultResourceToMonitoredResource,
+			CumulativeStartTime:              CumulativeStartTimeNormalize,
+			DuplicateTimeSeriesPolicy:        DuplicateTimeSeriesSplit,
+			MetricDescriptorConflictStrategy: MetricDescriptorConflictSkip,
+			UnknownUnitPolicy:                UnknownUnitAnnotation,
+			MaxExponentialHistogramBuckets:   defaultMaxExponentialHistogramBuckets,
+			MaxConcurrentRequests:            10,
+			NormalizationCache: NormalizationCacheConfig{
+				GCInterval: defaultNormalizationCacheGCInterval,
+			},
+			CardinalityLimit: CardinalityLimitConfig{
+				MaxOverflowTimeSeries: defaultMaxOverflowTimeSeries,
+			},
+			MaxConcurrentRequestsPerProject: 1,
+			MaxTimeSeriesPerRequest:         sendBatchSize,
+			MaxRequestBytes:                 defaultMaxRequestSize,
+			GetMetricName:                   defaultGetMetricName,
+			MapMonitoredResource:            defaultResourceToMonitoredResource,
 		},
 	}
 }
@@ -173,5 +650,154 @@ func ValidateConfig(cfg Config) error {
 		}
 		seenReplacements[mapping.Replacement] = struct{}{}
 	}
+	for i, rule := range cfg.ProjectRouting.Rules {
+		if rule.Attribute == "" {
+			return fmt.Errorf("project_routing.rules[%d].attribute is required", i)
+		}
+		switch rule.Source {
+		case "", ProjectRoutingSourceResource, ProjectRoutingSourceRecord:
+		default:
+			return fmt.Errorf("unknown project_routing.rules[%d].source: %q", i, rule.Source)
+		}
+		if len(rule.Projects) > 0 && rule.Template != "" {
+			return fmt.Errorf("project_routing.rules[%d] must not set both projects and template", i)
+		}
+	}
+	for _, c := range []struct {
+		name         string
+		clientConfig ClientConfig
+	}{
+		{"metric", cfg.MetricConfig.ClientConfig},
+		{"log", cfg.LogConfig.ClientConfig},
+		{"trace", cfg.TraceConfig.ClientConfig},
+	} {
+		name, clientConfig := c.name, c.clientConfig
+		switch clientConfig.Transport {
+		case "", ClientTransportGRPC:
+		case ClientTransportFile:
+			if clientConfig.FilePath == "" {
+				return fmt.Errorf("%s.file_path is required with the %s transport", name, ClientTransportFile)
+			}
+			if cfg.ProjectID == "" {
+				return fmt.Errorf("project is required with the %s transport", ClientTransportFile)
+			}
+		default:
+			return fmt.Errorf("unknown %s.transport: %q", name, clientConfig.Transport)
+		}
+	}
+	for i, mapping := range cfg.ResourceMappings {
+		if mapping.Type == "" {
+			return fmt.Errorf("resource_mappings[%d].type is required", i)
+		}
+		for j, match := range mapping.Match {
+			if match.Key == "" {
+				return fmt.Errorf("resource_mappings[%d].match[%d].key is required", i, j)
+			}
+		}
+		for label, labelMapping := range mapping.Labels {
+			if labelMapping.Key == "" && labelMapping.Default == "" {
+				return fmt.Errorf("resource_mappings[%d].labels.%s requires a key or default", i, label)
+			}
+		}
+	}
+	switch cfg.MetricConfig.CumulativeStartTime {
+	case "", CumulativeStartTimeNormalize, CumulativeStartTimeProcessStart:
+	default:
+		return fmt.Errorf("unknown metric.cumulative_start_time: %q", cfg.MetricConfig.CumulativeStartTime)
+	}
+	switch cfg.MetricConfig.DuplicateTimeSeriesPolicy {
+	case "", DuplicateTimeSeriesSplit, DuplicateTimeSeriesKeepNewest:
+	default:
+		return fmt.Errorf("unknown metric.duplicate_timeseries_policy: %q", cfg.MetricConfig.DuplicateTimeSeriesPolicy)
+	}
+	switch cfg.MetricConfig.MetricDescriptorConflictStrategy {
+	case "", MetricDescriptorConflictSkip, MetricDescriptorConflictRename, MetricDescriptorConflictRecreate:
+	default:
+		return fmt.Errorf("unknown metric.metric_descriptor_conflict_strategy: %q", cfg.MetricConfig.MetricDescriptorConflictStrategy)
+	}
+	if cfg.MetricConfig.MetricDescriptorConflictStrategy == MetricDescriptorConflictRecreate && !cfg.MetricConfig.AllowMetricDescriptorDeletion {
+		return errors.New("metric.metric_descriptor_conflict_strategy \"recreate\" requires metric.allow_metric_descriptor_deletion")
+	}
+	switch cfg.MetricConfig.UnknownUnitPolicy {
+	case "", UnknownUnitAnnotation, UnknownUnitVerbatim, UnknownUnitDrop:
+	default:
+		return fmt.Errorf("unknown metric.unknown_unit_policy: %q", cfg.MetricConfig.UnknownUnitPolicy)
+	}
+	if snapshotConfig := cfg.MetricConfig.CumulativeNormalizationSnapshot; snapshotConfig != nil {
+		if !cfg.MetricConfig.CumulativeNormalization {
+			return errors.New("metric.cumulative_normalization_snapshot requires metric.cumulative_normalization")
+		}
+		if snapshotConfig.Path == "" {
+			return errors.New("metric.cumulative_normalization_snapshot.path is required")
+		}
+		if snapshotConfig.Interval < 0 {
+			return errors.New("metric.cumulative_normalization_snapshot.interval must not be negative")
+		}
+		if snapshotConfig.TTL < 0 {
+			return errors.New("metric.cumulative_normalization_snapshot.ttl must not be negative")
+		}
+	}
+	if cfg.MetricConfig.NormalizationCache.MaxEntries < 0 {
+		return errors.New("metric.normalization_cache.max_entries must not be negative")
+	}
+	if cfg.MetricConfig.NormalizationCache.MaxBytes < 0 {
+		return errors.New("metric.normalization_cache.max_bytes must not be negative")
+	}
+	if cfg.MetricConfig.NormalizationCache.GCInterval < 0 {
+		return errors.New("metric.normalization_cache.gc_interval must not be negative")
+	}
+	if walConfig := cfg.MetricConfig.WALConfig; walConfig != nil {
+		if walConfig.Directory == "" {
+			return errors.New("metric.experimental_wal_config.directory is required")
+		}
+		if walConfig.MaxAge > maxWALAge {
+			return fmt.Errorf("metric.experimental_wal_config.max_age must not exceed %v", maxWALAge)
+		}
+	}
+	if cfg.MetricConfig.CardinalityLimit.Default < 0 {
+		return errors.New("metric.cardinality_limit.default must not be negative")
+	}
+	for metricType, limit := range cfg.MetricConfig.CardinalityLimit.Overrides {
+		if limit < 0 {
+			return fmt.Errorf("metric.cardinality_limit.overrides[%q] must not be negative", metricType)
+		}
+	}
+	if cfg.MetricConfig.CardinalityLimit.MaxOverflowTimeSeries < 0 {
+		return errors.New("metric.cardinality_limit.max_overflow_timeseries must not be negative")
+	}
+	switch cfg.MetricConfig.LabelLimits.Policy {
+	case "", LabelLimitsTruncate, LabelLimitsDropLabels, LabelLimitsDropPoint:
+	default:
+		return fmt.Errorf("unknown metric.label_limits.policy: %q", cfg.MetricConfig.LabelLimits.Policy)
+	}
+	if l := cfg.MetricConfig.LabelLimits; l.MaxLabels < 0 || l.MaxKeyLength < 0 || l.MaxValueLength < 0 {
+		return errors.New("metric.label_limits limits must not be negative")
+	}
+	if cfg.MetricConfig.MaxExponentialHistogramBuckets < 0 {
+		return errors.New("metric.max_exponential_histogram_buckets must not be negative")
+	}
+	if cfg.MetricConfig.MaxConcurrentRequests < 0 || cfg.MetricConfig.MaxConcurrentRequestsPerProject < 0 {
+		return errors.New("metric.max_concurrent_requests and metric.max_concurrent_requests_per_project must not be negative")
+	}
+	if n := cfg.MetricConfig.MaxTimeSeriesPerRequest; n < 0 || n > sendBatchSize {
+		return fmt.Errorf("metric.max_timeseries_per_request must be between 0 and %d", sendBatchSize)
+	}
+	if cfg.MetricConfig.MaxRequestBytes < 0 {
+		return errors.New("metric.max_request_bytes must not be negative")
+	}
+	if _, err := newRelabeler(cfg.MetricConfig.MetricRelabelConfigs); err != nil {
+		return err
+	}
+	for i, descriptor := range cfg.MetricConfig.Descriptors {
+		if descriptor.Metric == "" {
+			return fmt.Errorf("metric.descriptors[%d].metric is required", i)
+		}
+		if _, err := path.Match(descriptor.Metric, ""); err != nil {
+			return fmt.Errorf("invalid metric.descriptors[%d].metric %q: %w", i, descriptor.Metric, err)
+		}
+		if _, ok := api.LaunchStage_value[descriptor.LaunchStage]; descriptor.LaunchStage != "" && !ok {
+			return fmt.Errorf("unknown metric.descriptors[%d].launch_stage: %q", i, descriptor.LaunchStage)
+		}
+	}
 	return nil
 }
@@ -14,7 +14,10 @@
 
 package collector
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestValidateConfig(t *testing.T) {
 	for _, tc := range []struct {
@@ -66,6 +69,417 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			desc: "Project routing",
+			input: Config{
+				ProjectRouting: ProjectRoutingConfig{
+					Rules: []ProjectRoutingRule{
+						{
+							Attribute: "k8s.namespace.name",
+							Projects:  map[string]string{"team-a": "project-a"},
+						},
+						{
+							Attribute: "tenant",
+							Source:    ProjectRoutingSourceRecord,
+							Template:  "tenant-${value}",
+						},
+					},
+					DefaultProjectID: "default-project",
+					DropUnmatched:    true,
+				},
+			},
+		},
+		{
+			desc: "Project routing rule without attribute",
+			input: Config{
+				ProjectRouting: ProjectRoutingConfig{
+					Rules: []ProjectRoutingRule{{Template: "tenant-${value}"}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown project routing source",
+			input: Config{
+				ProjectRouting: ProjectRoutingConfig{
+					Rules: []ProjectRoutingRule{{Attribute: "tenant", Source: "scope"}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Project routing rule with projects and template",
+			input: Config{
+				ProjectRouting: ProjectRoutingConfig{
+					Rules: []ProjectRoutingRule{
+						{
+							Attribute: "tenant",
+							Projects:  map[string]string{"a": "project-a"},
+							Template:  "tenant-${value}",
+						},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Resource mappings",
+			input: Config{
+				ResourceMappings: []ResourceMapping{
+					{
+						Type:  "generic_task",
+						Match: []ResourceAttributeMatch{{Key: "service.name", Values: []string{"a", "b"}}},
+						Labels: map[string]ResourceLabelMapping{
+							"job":      {Key: "service.name"},
+							"location": {Key: "cloud.region", FallbackKeys: []string{"cloud.availability_zone"}, Default: "global"},
+						},
+					},
+					{Type: "k8s_container"},
+				},
+			},
+		},
+		{
+			desc: "Resource mapping without type",
+			input: Config{
+				ResourceMappings: []ResourceMapping{{Match: []ResourceAttributeMatch{{Key: "service.name"}}}},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Resource mapping match without key",
+			input: Config{
+				ResourceMappings: []ResourceMapping{{Type: "generic_task", Match: []ResourceAttributeMatch{{Values: []string{"a"}}}}},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Resource mapping label without key or default",
+			input: Config{
+				ResourceMappings: []ResourceMapping{
+					{
+						Type:   "generic_task",
+						Labels: map[string]ResourceLabelMapping{"job": {FallbackKeys: []string{"service.name"}}},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown label limits policy",
+			input: Config{
+				MetricConfig: MetricConfig{
+					LabelLimits: LabelLimitsConfig{Policy: "ignore"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Negative label limit",
+			input: Config{
+				MetricConfig: MetricConfig{
+					LabelLimits: LabelLimitsConfig{Policy: LabelLimitsTruncate, MaxLabels: -1},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Invalid metric relabel config",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MetricRelabelConfigs: []RelabelConfig{{Action: "unknown"}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Negative max exponential histogram buckets",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MaxExponentialHistogramBuckets: -1,
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Negative max concurrent requests",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MaxConcurrentRequestsPerProject: -1,
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Too many timeseries per request",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MaxTimeSeriesPerRequest: 201,
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Negative max request bytes",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MaxRequestBytes: -1,
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown duplicate timeseries policy",
+			input: Config{
+				MetricConfig: MetricConfig{
+					DuplicateTimeSeriesPolicy: "drop",
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown cumulative start time",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CumulativeStartTime: "first_point",
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown metric descriptor conflict strategy",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MetricDescriptorConflictStrategy: "ignore",
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Recreate metric descriptors without allowing deletion",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MetricDescriptorConflictStrategy: MetricDescriptorConflictRecreate,
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Recreate metric descriptors",
+			input: Config{
+				MetricConfig: MetricConfig{
+					MetricDescriptorConflictStrategy: MetricDescriptorConflictRecreate,
+					AllowMetricDescriptorDeletion:    true,
+				},
+			},
+		},
+		{
+			desc: "Normalization snapshot without path",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CumulativeNormalization:         true,
+					CumulativeNormalizationSnapshot: &NormalizationSnapshotConfig{},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Normalization snapshot without cumulative normalization",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CumulativeNormalizationSnapshot: &NormalizationSnapshotConfig{
+						Path: "/tmp/normalization.pb",
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Normalization snapshot with negative TTL",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CumulativeNormalization: true,
+					CumulativeNormalizationSnapshot: &NormalizationSnapshotConfig{
+						Path: "/tmp/normalization.pb",
+						TTL:  -time.Hour,
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Normalization snapshot",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CumulativeNormalization: true,
+					CumulativeNormalizationSnapshot: &NormalizationSnapshotConfig{
+						Path:     "/tmp/normalization.pb",
+						Interval: time.Minute,
+						TTL:      time.Hour,
+					},
+				},
+			},
+		},
+		{
+			desc: "Negative normalization cache max bytes",
+			input: Config{
+				MetricConfig: MetricConfig{
+					NormalizationCache: NormalizationCacheConfig{MaxBytes: -1},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Normalization cache",
+			input: Config{
+				MetricConfig: MetricConfig{
+					NormalizationCache: NormalizationCacheConfig{
+						MaxEntries: 100000,
+						MaxBytes:   1 << 30,
+						GCInterval: 5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			desc: "WAL without directory",
+			input: Config{
+				MetricConfig: MetricConfig{
+					WALConfig: &WALConfig{},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "WAL max age too long",
+			input: Config{
+				MetricConfig: MetricConfig{
+					WALConfig: &WALConfig{
+						Directory: "/tmp/wal",
+						MaxAge:    48 * time.Hour,
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "WAL",
+			input: Config{
+				MetricConfig: MetricConfig{
+					WALConfig: &WALConfig{
+						Directory: "/tmp/wal",
+						MaxAge:    time.Hour,
+					},
+				},
+			},
+		},
+		{
+			desc: "Negative cardinality limit",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CardinalityLimit: CardinalityLimitConfig{
+						Overrides: map[string]int{"workload.googleapis.com/foo": -1},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Negative max overflow timeseries",
+			input: Config{
+				MetricConfig: MetricConfig{
+					CardinalityLimit: CardinalityLimitConfig{MaxOverflowTimeSeries: -1},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown unit policy",
+			input: Config{
+				MetricConfig: MetricConfig{UnknownUnitPolicy: "guess"},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Descriptors",
+			input: Config{
+				MetricConfig: MetricConfig{
+					Descriptors: []MetricDescriptorConfig{
+						{
+							Metric:                 "http.server.*",
+							DisplayName:            "HTTP server",
+							Description:            "HTTP server metrics",
+							Labels:                 map[string]string{"http_method": "The HTTP method"},
+							LaunchStage:            "BETA",
+							MonitoredResourceTypes: []string{"k8s_container"},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Descriptor without metric",
+			input: Config{
+				MetricConfig: MetricConfig{
+					Descriptors: []MetricDescriptorConfig{{DisplayName: "foo"}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Descriptor with invalid glob",
+			input: Config{
+				MetricConfig: MetricConfig{
+					Descriptors: []MetricDescriptorConfig{{Metric: "foo["}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Descriptor with unknown launch stage",
+			input: Config{
+				MetricConfig: MetricConfig{
+					Descriptors: []MetricDescriptorConfig{{Metric: "foo", LaunchStage: "STABLE"}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "Unknown transport",
+			input: Config{
+				LogConfig: LogConfig{
+					ClientConfig: ClientConfig{Transport: "http"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "File transport without path",
+			input: Config{
+				ProjectID: "my-project",
+				TraceConfig: TraceConfig{
+					ClientConfig: ClientConfig{Transport: ClientTransportFile},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "File transport without project",
+			input: Config{
+				MetricConfig: MetricConfig{
+					ClientConfig: ClientConfig{Transport: ClientTransportFile, FilePath: "/tmp/requests.jsonl"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			desc: "File transport",
+			input: Config{
+				ProjectID: "my-project",
+				MetricConfig: MetricConfig{
+					ClientConfig: ClientConfig{Transport: ClientTransportFile, FilePath: "/tmp/requests.jsonl"},
+				},
+			},
+		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			err := ValidateConfig(tc.input)
@@ -0,0 +1,115 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"path"
+
+	"google.golang.org/genproto/googleapis/api"
+	"google.golang.org/genproto/googleapis/api/label"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	"google.golang.org/protobuf/proto"
+)
+
+// descriptorOverrides looks up the configured metadata of metric
+// descriptors by metric name.
+type descriptorOverrides struct {
+	exact map[string]MetricDescriptorConfig
+	globs []MetricDescriptorConfig
+}
+
+// newDescriptorOverrides returns the overrides of the configuration, or nil
+// if there are none. The configuration must have been validated.
+func newDescriptorOverrides(configs []MetricDescriptorConfig) *descriptorOverrides {
+	if len(configs) == 0 {
+		return nil
+	}
+	o := &descriptorOverrides{exact: map[string]MetricDescriptorConfig{}}
+	for _, config := range configs {
+		if !isGlob(config.Metric) {
+			if _, ok := o.exact[config.Metric]; !ok {
+				o.exact[config.Metric] = config
+			}
+			continue
+		}
+		o.globs = append(o.globs, config)
+	}
+	return o
+}
+
+// isGlob returns true if the pattern has any special characters of
+// path.Match.
+func isGlob(pattern string) bool {
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the override of the named metric. Overrides of the exact
+// name take precedence over globs.
+func (o *descriptorOverrides) lookup(name string) (MetricDescriptorConfig, bool) {
+	if o == nil {
+		return MetricDescriptorConfig{}, false
+	}
+	if config, ok := o.exact[name]; ok {
+		return config, true
+	}
+	for _, config := range o.globs {
+		if ok, _ := path.Match(config.Metric, name); ok {
+			return config, true
+		}
+	}
+	return MetricDescriptorConfig{}, false
+}
+
+// apply sets the metadata of the descriptor of the named metric to its
+// override. displayNameSuffix is appended to an overridden display name,
+// for the descriptors of summary metrics.
+func (o *descriptorOverrides) apply(name, displayNameSuffix string, md *metricpb.MetricDescriptor) {
+	config, ok := o.lookup(name)
+	if !ok {
+		return
+	}
+	if config.DisplayName != "" {
+		md.DisplayName = config.DisplayName + displayNameSuffix
+	}
+	if config.Description != "" {
+		md.Description = config.Description
+	}
+	if config.LaunchStage != "" {
+		md.LaunchStage = api.LaunchStage(api.LaunchStage_value[config.LaunchStage])
+	}
+	if len(config.MonitoredResourceTypes) > 0 {
+		md.MonitoredResourceTypes = append([]string(nil), config.MonitoredResourceTypes...)
+	}
+	if len(config.Labels) == 0 {
+		return
+	}
+	// Label descriptors are shared between the descriptors of summary
+	// metrics, so they are copied rather than changed.
+	labels := make([]*label.LabelDescriptor, len(md.Labels))
+	for i, l := range md.Labels {
+		labels[i] = l
+		if description, ok := config.Labels[l.Key]; ok {
+			labels[i] = proto.Clone(l).(*label.LabelDescriptor)
+			labels[i].Description = description
+		}
+	}
+	md.Labels = labels
+}
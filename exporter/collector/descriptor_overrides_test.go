@@ -0,0 +1,115 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"google.golang.org/genproto/googleapis/api"
+)
+
+func TestDescriptorOverridesLookup(t *testing.T) {
+	overrides := newDescriptorOverrides([]MetricDescriptorConfig{
+		{Metric: "http.*", DisplayName: "glob"},
+		{Metric: "http.server.*", DisplayName: "later glob"},
+		{Metric: "http.server.duration", DisplayName: "exact"},
+	})
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{name: "http.server.duration", expected: "exact"},
+		{name: "http.server.active_requests", expected: "glob"},
+		{name: "rpc.server.duration"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, ok := overrides.lookup(tc.name)
+			assert.Equal(t, tc.expected != "", ok)
+			assert.Equal(t, tc.expected, config.DisplayName)
+		})
+	}
+	var nilOverrides *descriptorOverrides
+	_, ok := nilOverrides.lookup("http.server.duration")
+	assert.False(t, ok)
+}
+
+func TestMetricDescriptorOverrides(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	mapper.descriptorOverrides = newDescriptorOverrides([]MetricDescriptorConfig{
+		{
+			Metric:                 "custom.*",
+			DisplayName:            "Custom",
+			Description:            "A custom metric",
+			Labels:                 map[string]string{"foo": "The foo label"},
+			LaunchStage:            "BETA",
+			MonitoredResourceTypes: []string{"k8s_container"},
+		},
+	})
+
+	t.Run("Gauge", func(t *testing.T) {
+		metric := pmetric.NewMetric()
+		metric.SetName("custom.metric")
+		metric.SetDescription("original description")
+		metric.SetDataType(pmetric.MetricDataTypeGauge)
+		point := metric.Gauge().DataPoints().AppendEmpty()
+		point.SetIntVal(1)
+		point.Attributes().InsertString("foo", "bar")
+		point.Attributes().InsertString("baz", "qux")
+
+		mds := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, mds, 1)
+		md := mds[0]
+		assert.Equal(t, "Custom", md.DisplayName)
+		assert.Equal(t, "A custom metric", md.Description)
+		assert.Equal(t, api.LaunchStage_BETA, md.LaunchStage)
+		assert.Equal(t, []string{"k8s_container"}, md.MonitoredResourceTypes)
+		descriptions := map[string]string{}
+		for _, l := range md.Labels {
+			descriptions[l.Key] = l.Description
+		}
+		assert.Equal(t, map[string]string{"foo": "The foo label", "baz": ""}, descriptions)
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		metric := pmetric.NewMetric()
+		metric.SetName("custom.summary")
+		metric.SetDataType(pmetric.MetricDataTypeSummary)
+		metric.Summary().DataPoints().AppendEmpty()
+
+		mds := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, mds, 3)
+		assert.Equal(t, "Custom"+SummarySumSuffix, mds[0].DisplayName)
+		assert.Equal(t, "Custom"+SummaryCountPrefix, mds[1].DisplayName)
+		assert.Equal(t, "Custom", mds[2].DisplayName)
+	})
+
+	t.Run("No override", func(t *testing.T) {
+		metric := pmetric.NewMetric()
+		metric.SetName("other.metric")
+		metric.SetDescription("original description")
+		metric.SetDataType(pmetric.MetricDataTypeGauge)
+		metric.Gauge().DataPoints().AppendEmpty().SetIntVal(1)
+
+		mds := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, mds, 1)
+		assert.Equal(t, "other.metric", mds[0].DisplayName)
+		assert.Equal(t, "original description", mds[0].Description)
+		assert.Equal(t, api.LaunchStage_LAUNCH_STAGE_UNSPECIFIED, mds[0].LaunchStage)
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+const (
+	// minExponentialHistogramScale is the lowest scale allowed by the
+	// OpenTelemetry data model.
+	minExponentialHistogramScale = -10
+)
+
+// exponentialHistogramDownscaler reduces the scale of exponential histogram
+// points, merging adjacent buckets, until their buckets fit in the maximum
+// number of buckets. Only positive buckets are counted, since negative
+// buckets are written to the underflow bucket. It remembers the scale used
+// for each timeseries, so that all of its points are written with the same
+// buckets, unless a point needs a lower scale. Otherwise, cumulative
+// normalization would treat each change of scale as a reset.
+type exponentialHistogramDownscaler struct {
+	maxBuckets int
+
+	// mu makes reading and updating the scale of a timeseries atomic.
+	mu sync.Mutex
+	// scales holds the scale the points of each timeseries are written with.
+	// It has the limits of the normalization caches. Its garbage collection
+	// is run by the exporter.
+	scales *datapointstorage.Cache
+}
+
+func newExponentialHistogramDownscaler(maxBuckets int, options datapointstorage.Options) *exponentialHistogramDownscaler {
+	return &exponentialHistogramDownscaler{
+		maxBuckets: maxBuckets,
+		scales:     datapointstorage.NewCacheWithoutGC(options),
+	}
+}
+
+// downscalerGCRunner forgets the scales of timeseries which have not been
+// written recently until shutdown.
+func (me *MetricsExporter) downscalerGCRunner() {
+	defer me.goroutines.Done()
+	me.mapper.downscaler.scales.RunGC(me.shutdownC)
+}
+
+// downscale returns the point with its scale reduced to the scale of the
+// timeseries, or lower if needed to fit in the maximum number of buckets. The
+// point is not modified.
+func (d *exponentialHistogramDownscaler) downscale(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) pmetric.ExponentialHistogramDataPoint {
+	scale := point.Scale()
+	for scale > minExponentialHistogramScale && datapointstorage.DownscaledBucketCount(point.Positive(), point.Scale()-scale) > d.maxBuckets {
+		scale--
+	}
+
+	d.mu.Lock()
+	if previous, ok := d.scales.GetScale(identifier); ok && previous < scale {
+		scale = previous
+	}
+	d.scales.SetScale(identifier, scale)
+	d.mu.Unlock()
+
+	if scale >= point.Scale() {
+		return point
+	}
+	// Make a copy so we don't mutate underlying data
+	newPoint := pmetric.NewExponentialHistogramDataPoint()
+	point.CopyTo(newPoint)
+	by := point.Scale() - scale
+	newPoint.SetScale(scale)
+	downscaleBuckets(newPoint.Positive(), by)
+	downscaleBuckets(newPoint.Negative(), by)
+	return newPoint
+}
+
+// downscaleBuckets reduces the scale of the buckets by the given amount.
+func downscaleBuckets(buckets pmetric.Buckets, by int32) {
+	if len(buckets.MBucketCounts()) == 0 {
+		return
+	}
+	offset, counts := datapointstorage.DownscaleBuckets(buckets, by)
+	buckets.SetOffset(offset)
+	buckets.SetMBucketCounts(counts)
+}
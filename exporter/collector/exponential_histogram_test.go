@@ -0,0 +1,107 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+func newDownscalerTestPoint(scale, offset int32, counts []uint64) pmetric.ExponentialHistogramDataPoint {
+	point := pmetric.NewExponentialHistogramDataPoint()
+	point.SetScale(scale)
+	point.Positive().SetOffset(offset)
+	point.Positive().SetMBucketCounts(counts)
+	point.Negative().SetOffset(offset)
+	point.Negative().SetMBucketCounts(counts)
+	return point
+}
+
+func TestExponentialHistogramDownscaler(t *testing.T) {
+	newDownscaler := func(t *testing.T, maxBuckets int) *exponentialHistogramDownscaler {
+		return newExponentialHistogramDownscaler(maxBuckets, datapointstorage.Options{})
+	}
+
+	t.Run("Points within the limit are unchanged", func(t *testing.T) {
+		d := newDownscaler(t, 4)
+		point := newDownscalerTestPoint(3, 1, []uint64{1, 2, 3, 4})
+		assert.Equal(t, point, d.downscale(point, testIdentifier("id")))
+	})
+
+	t.Run("Adjacent buckets are merged", func(t *testing.T) {
+		d := newDownscaler(t, 3)
+		point := newDownscalerTestPoint(3, 1, []uint64{1, 2, 3, 4})
+		result := d.downscale(point, testIdentifier("id"))
+		assert.EqualValues(t, 2, result.Scale())
+		for _, buckets := range []pmetric.Buckets{result.Positive(), result.Negative()} {
+			assert.EqualValues(t, 0, buckets.Offset())
+			assert.Equal(t, []uint64{1, 5, 4}, buckets.MBucketCounts())
+		}
+		// The original point is not modified.
+		assert.EqualValues(t, 3, point.Scale())
+		assert.Equal(t, []uint64{1, 2, 3, 4}, point.Positive().MBucketCounts())
+	})
+
+	t.Run("Negative offsets are merged", func(t *testing.T) {
+		d := newDownscaler(t, 2)
+		result := d.downscale(newDownscalerTestPoint(0, -3, []uint64{1, 2, 3}), testIdentifier("id"))
+		assert.EqualValues(t, -1, result.Scale())
+		assert.EqualValues(t, -2, result.Positive().Offset())
+		assert.Equal(t, []uint64{1, 5}, result.Positive().MBucketCounts())
+	})
+
+	t.Run("Sparse buckets over a wide range are merged", func(t *testing.T) {
+		d := newDownscaler(t, 2)
+		counts := make([]uint64, 8)
+		counts[0], counts[7] = 1, 1
+		result := d.downscale(newDownscalerTestPoint(5, 0, counts), testIdentifier("id"))
+		assert.EqualValues(t, 3, result.Scale())
+		assert.Equal(t, []uint64{1, 1}, result.Positive().MBucketCounts())
+	})
+
+	t.Run("Scale is consistent across points of a series", func(t *testing.T) {
+		d := newDownscaler(t, 2)
+		first := d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2, 3, 4}), testIdentifier("id"))
+		assert.EqualValues(t, 2, first.Scale())
+		// This point fits at scale 3, but is written at the scale of the
+		// previous point.
+		second := d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2}), testIdentifier("id"))
+		assert.EqualValues(t, 2, second.Scale())
+		assert.Equal(t, []uint64{3}, second.Positive().MBucketCounts())
+		// Other series are unaffected.
+		other := d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2}), testIdentifier("other"))
+		assert.EqualValues(t, 3, other.Scale())
+	})
+
+	t.Run("Scales are kept for at most MaxEntries series", func(t *testing.T) {
+		d := newExponentialHistogramDownscaler(2, datapointstorage.Options{MaxEntries: 1})
+		d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2, 3, 4}), testIdentifier("id"))
+		d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2}), testIdentifier("other"))
+		assert.Equal(t, 1, d.scales.Size())
+		// The scale of the evicted series is forgotten.
+		result := d.downscale(newDownscalerTestPoint(3, 0, []uint64{1, 2}), testIdentifier("id"))
+		assert.EqualValues(t, 3, result.Scale())
+	})
+
+	t.Run("Scale is not reduced below the minimum", func(t *testing.T) {
+		d := newDownscaler(t, 1)
+		result := d.downscale(newDownscalerTestPoint(minExponentialHistogramScale, -1, []uint64{1, 1}), testIdentifier("id"))
+		assert.EqualValues(t, minExponentialHistogramScale, result.Scale())
+	})
+}
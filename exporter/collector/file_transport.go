@@ -0,0 +1,125 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// fileTransportTarget is dialed by the file transport. The connection is
+// never used, as requests are intercepted before they are sent.
+const fileTransportTarget = "passthrough:///file-transport"
+
+// fileTransportRecord is a line written by the file transport.
+type fileTransportRecord struct {
+	// Method is the full gRPC method, e.g.
+	// /google.monitoring.v3.MetricService/CreateTimeSeries.
+	Method  string          `json:"method"`
+	Request json.RawMessage `json:"request"`
+}
+
+// fileTransport writes the requests made by a Google Cloud API client to
+// files, instead of sending them.
+type fileTransport struct {
+	path string
+	// mu serializes writes, so lines of concurrent requests aren't
+	// interleaved.
+	mu sync.Mutex
+}
+
+func newFileTransport(path string) *fileTransport {
+	return &fileTransport{path: path}
+}
+
+// unaryClientInterceptor validates and writes each request, and returns an
+// empty response. Invalid requests fail with INVALID_ARGUMENT, and aren't
+// written.
+func (t *fileTransport) unaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return fmt.Errorf("file transport: unsupported request type %T", req)
+	}
+	if err := validateRequest(msg); err != nil {
+		return err
+	}
+	return t.write(method, msg)
+}
+
+func (t *fileTransport) write(method string, msg proto.Message) error {
+	request, err := protojson.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("file transport: failed to encode request: %w", err)
+	}
+	line, err := json.Marshal(fileTransportRecord{Method: method, Request: request})
+	if err != nil {
+		return fmt.Errorf("file transport: failed to encode request: %w", err)
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	path, err := t.filePath(method)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("file transport: %w", err)
+	}
+	return f.Close()
+}
+
+// filePath returns the file requests of the method are written to.
+func (t *fileTransport) filePath(method string) (string, error) {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t.path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("file transport: %w", err)
+	}
+	if !info.IsDir() {
+		return t.path, nil
+	}
+	// method is of the form /package.service/method
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	return filepath.Join(t.path, service+".jsonl"), nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func readFileTransportRecords(t *testing.T, path string) []fileTransportRecord {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []fileTransportRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record fileTransportRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestFileTransportMetrics(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	cfg := DefaultConfig()
+	cfg.ProjectID = "my-project"
+	cfg.MetricConfig.ClientConfig.Transport = ClientTransportFile
+	cfg.MetricConfig.ClientConfig.FilePath = path
+	require.NoError(t, ValidateConfig(cfg))
+
+	exporter, err := NewGoogleCloudMetricsExporter(ctx, cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+
+	metrics := pmetric.NewMetrics()
+	metric := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	metric.SetName("my.gauge")
+	metric.SetDataType(pmetric.MetricDataTypeGauge)
+	point := metric.Gauge().DataPoints().AppendEmpty()
+	point.SetTimestamp(pcommon.NewTimestampFromTime(time.Now()))
+	point.SetIntVal(42)
+	require.NoError(t, exporter.PushMetrics(ctx, metrics))
+	require.NoError(t, exporter.Shutdown(ctx))
+
+	var createTimeSeries []*monitoringpb.CreateTimeSeriesRequest
+	for _, record := range readFileTransportRecords(t, path) {
+		if record.Method != "/google.monitoring.v3.MetricService/CreateTimeSeries" {
+			continue
+		}
+		req := &monitoringpb.CreateTimeSeriesRequest{}
+		require.NoError(t, protojson.Unmarshal(record.Request, req))
+		createTimeSeries = append(createTimeSeries, req)
+	}
+	require.Len(t, createTimeSeries, 1)
+	assert.Equal(t, "projects/my-project", createTimeSeries[0].Name)
+	require.Len(t, createTimeSeries[0].TimeSeries, 1)
+	assert.Equal(t, "workload.googleapis.com/my.gauge", createTimeSeries[0].TimeSeries[0].Metric.Type)
+	assert.Equal(t, int64(42), createTimeSeries[0].TimeSeries[0].Points[0].Value.GetInt64Value())
+}
+
+func TestFileTransportLogsDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.ProjectID = "my-project"
+	cfg.LogConfig.DefaultLogName = "my-log"
+	cfg.LogConfig.ClientConfig.Transport = ClientTransportFile
+	cfg.LogConfig.ClientConfig.FilePath = dir
+
+	exporter, err := NewGoogleCloudLogsExporter(ctx, cfg, zap.NewNop())
+	require.NoError(t, err)
+	logs := plog.NewLogs()
+	logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().Body().SetStringVal("hello")
+	require.NoError(t, exporter.PushLogs(ctx, logs))
+	require.NoError(t, exporter.Shutdown(ctx))
+
+	records := readFileTransportRecords(t, filepath.Join(dir, "google.logging.v2.LoggingServiceV2.jsonl"))
+	require.Len(t, records, 1)
+	assert.Equal(t, "/google.logging.v2.LoggingServiceV2/WriteLogEntries", records[0].Method)
+}
+
+func TestFileTransportRejectsInvalidRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	transport := newFileTransport(path)
+	ts := &monitoringpb.TimeSeries{
+		Metric:     &metricpb.Metric{Type: "workload.googleapis.com/my.gauge"},
+		Resource:   &monitoredrespb.MonitoredResource{Type: "generic_node"},
+		MetricKind: metricpb.MetricDescriptor_GAUGE,
+		Points: []*monitoringpb.Point{{
+			Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.Now()},
+		}},
+	}
+	req := &monitoringpb.CreateTimeSeriesRequest{
+		Name:       "projects/my-project",
+		TimeSeries: []*monitoringpb.TimeSeries{ts, ts},
+	}
+	err := transport.unaryClientInterceptor(context.Background(), "/google.monitoring.v3.MetricService/CreateTimeSeries", req, nil, nil, nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestValidateCreateTimeSeriesRequest(t *testing.T) {
+	now := time.Now()
+	point := func(start, end time.Time) []*monitoringpb.Point {
+		interval := &monitoringpb.TimeInterval{EndTime: timestamppb.New(end)}
+		if !start.IsZero() {
+			interval.StartTime = timestamppb.New(start)
+		}
+		return []*monitoringpb.Point{{Interval: interval}}
+	}
+	for _, tc := range []struct {
+		desc        string
+		ts          *monitoringpb.TimeSeries
+		expectedErr bool
+	}{
+		{
+			desc: "gauge",
+			ts:   &monitoringpb.TimeSeries{MetricKind: metricpb.MetricDescriptor_GAUGE, Points: point(time.Time{}, now)},
+		},
+		{
+			desc: "cumulative",
+			ts:   &monitoringpb.TimeSeries{MetricKind: metricpb.MetricDescriptor_CUMULATIVE, Points: point(now.Add(-time.Minute), now)},
+		},
+		{
+			desc:        "cumulative without start time",
+			ts:          &monitoringpb.TimeSeries{MetricKind: metricpb.MetricDescriptor_CUMULATIVE, Points: point(time.Time{}, now)},
+			expectedErr: true,
+		},
+		{
+			desc:        "gauge with start time",
+			ts:          &monitoringpb.TimeSeries{MetricKind: metricpb.MetricDescriptor_GAUGE, Points: point(now.Add(-time.Minute), now)},
+			expectedErr: true,
+		},
+		{
+			desc:        "no points",
+			ts:          &monitoringpb.TimeSeries{MetricKind: metricpb.MetricDescriptor_GAUGE},
+			expectedErr: true,
+		},
+		{
+			desc: "invalid label key",
+			ts: &monitoringpb.TimeSeries{
+				Metric:     &metricpb.Metric{Labels: map[string]string{"my.label": "value"}},
+				MetricKind: metricpb.MetricDescriptor_GAUGE,
+				Points:     point(time.Time{}, now),
+			},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.ts.Metric == nil {
+				tc.ts.Metric = &metricpb.Metric{}
+			}
+			tc.ts.Metric.Type = "workload.googleapis.com/my.metric"
+			tc.ts.Resource = &monitoredrespb.MonitoredResource{Type: "generic_node"}
+			err := validateRequest(&monitoringpb.CreateTimeSeriesRequest{
+				Name:       "projects/my-project",
+				TimeSeries: []*monitoringpb.TimeSeries{tc.ts},
+			})
+			if tc.expectedErr {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ require (
 	go.opencensus.io v0.23.0
 	go.opentelemetry.io/collector/semconv v0.53.0
 	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/metric v0.30.0
 	go.opentelemetry.io/otel/sdk v1.7.0
 	go.opentelemetry.io/otel/trace v1.7.0
 	go.uber.org/atomic v1.9.0
@@ -23,6 +24,7 @@ require (
 	cloud.google.com/go/monitoring v1.4.0
 	cloud.google.com/go/trace v1.2.0
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping v0.32.3
+	github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/unitmapping v0.32.3
 	github.com/benbjohnson/clock v1.3.0 // indirect
 	github.com/google/go-cmp v0.5.7
 	go.opentelemetry.io/collector/pdata v0.53.0
@@ -61,3 +63,5 @@ require (
 replace github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace => ../trace
 
 replace github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping => ../../internal/resourcemapping
+
+replace github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/unitmapping => ../../internal/unitmapping
@@ -30,4 +30,5 @@ replace (
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector => ../../collector
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace => ../../trace
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping => ../../../internal/resourcemapping
+	github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/unitmapping => ../../../internal/unitmapping
 )
@@ -126,12 +126,12 @@ func recordMetrics(ctx context.Context, t *FakeTesting, startTime, endTime time.
 		}
 		func() {
 			metrics := test.LoadOTLPMetricsInput(t, startTime, endTime)
-			testServerExporter := testServer.NewExporter(ctx, t, test.CreateMetricConfig())
-			inMemoryOCExporter, err := integrationtest.NewInMemoryOCViewExporter()
-			require.NoError(t, err)
-			defer inMemoryOCExporter.Shutdown(ctx)
+			inMemoryOTelExporter := integrationtest.NewInMemoryOTelExporter()
+			cfg := test.CreateMetricConfig()
+			inMemoryOTelExporter.Configure(&cfg)
+			testServerExporter := testServer.NewExporter(ctx, t, cfg)
 
-			err = testServerExporter.PushMetrics(ctx, metrics)
+			err := testServerExporter.PushMetrics(ctx, metrics)
 			if !test.ExpectErr {
 				require.NoError(t, err, "failed to export metrics to local test server")
 			} else {
@@ -139,8 +139,7 @@ func recordMetrics(ctx context.Context, t *FakeTesting, startTime, endTime time.
 			}
 			require.NoError(t, testServerExporter.Shutdown(ctx))
 
-			selfObsMetrics, err := inMemoryOCExporter.Proto(ctx)
-			require.NoError(t, err)
+			selfObsMetrics := inMemoryOTelExporter.Proto(ctx)
 			fixture := &integrationtest.MetricExpectFixture{
 				CreateMetricDescriptorRequests:  testServer.CreateMetricDescriptorRequests(),
 				CreateTimeSeriesRequests:        testServer.CreateTimeSeriesRequests(),
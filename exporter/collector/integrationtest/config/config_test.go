@@ -17,6 +17,7 @@ package config
 import (
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,6 +79,21 @@ func TestLoadConfig(t *testing.T) {
 					CreateMetricDescriptorBufferSize: 10,
 					ServiceResourceLabels:            true,
 					CumulativeNormalization:          true,
+					CumulativeStartTime:              collector.CumulativeStartTimeNormalize,
+					DuplicateTimeSeriesPolicy:        collector.DuplicateTimeSeriesSplit,
+					MetricDescriptorConflictStrategy: collector.MetricDescriptorConflictSkip,
+					UnknownUnitPolicy:                collector.UnknownUnitAnnotation,
+					MaxExponentialHistogramBuckets:   198,
+					MaxConcurrentRequests:            10,
+					MaxConcurrentRequestsPerProject:  1,
+					MaxTimeSeriesPerRequest:          200,
+					MaxRequestBytes:                  10000000,
+					NormalizationCache: collector.NormalizationCacheConfig{
+						GCInterval: 20 * time.Minute,
+					},
+					CardinalityLimit: collector.CardinalityLimitConfig{
+						MaxOverflowTimeSeries: 10000,
+					},
 				},
 				LogConfig: collector.LogConfig{
 					ClientConfig: collector.ClientConfig{
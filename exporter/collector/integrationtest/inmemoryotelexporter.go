@@ -0,0 +1,129 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integrationtest
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/api/distribution"
+	"google.golang.org/genproto/googleapis/api/label"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector"
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/metrictest"
+)
+
+const selfObsProjectName = "projects/myproject"
+
+// InMemoryOTelExporter captures the self observability metrics reported to
+// its MeterProvider.
+type InMemoryOTelExporter struct {
+	meterProvider *metrictest.MeterProvider
+}
+
+// NewInMemoryOTelExporter creates a new in memory OTel exporter for testing.
+// Its MeterProvider must be set on the exporter config with Configure.
+func NewInMemoryOTelExporter() *InMemoryOTelExporter {
+	return &InMemoryOTelExporter{meterProvider: metrictest.NewMeterProvider()}
+}
+
+// Configure sets the exporter's MeterProvider to capture the self
+// observability metrics.
+func (i *InMemoryOTelExporter) Configure(cfg *collector.Config) {
+	cfg.MeterProvider = i.meterProvider
+}
+
+// Proto converts the captured self observability metrics into the requests
+// which would be sent to Cloud Monitoring.
+func (i *InMemoryOTelExporter) Proto(ctx context.Context) *SelfObservabilityMetric {
+	req := &monitoringpb.CreateTimeSeriesRequest{Name: selfObsProjectName}
+	selfObs := &SelfObservabilityMetric{}
+	for _, inst := range i.meterProvider.Collect(ctx) {
+		metricType := "workload.googleapis.com/" + inst.Name
+		md := &metricpb.MetricDescriptor{
+			Name:        selfObsProjectName + "/metricDescriptors/" + metricType,
+			Type:        metricType,
+			MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
+			ValueType:   metricpb.MetricDescriptor_DOUBLE,
+			Unit:        string(inst.Unit),
+			Description: inst.Description,
+			DisplayName: inst.Name,
+		}
+		if inst.Kind == metrictest.Gauge || inst.Kind == metrictest.UpDownCounter {
+			md.MetricKind = metricpb.MetricDescriptor_GAUGE
+		}
+		switch {
+		case inst.Kind == metrictest.Histogram:
+			md.ValueType = metricpb.MetricDescriptor_DISTRIBUTION
+		case inst.Int:
+			md.ValueType = metricpb.MetricDescriptor_INT64
+		}
+
+		keys := map[string]struct{}{}
+		for _, point := range inst.Points {
+			labels := map[string]string{}
+			for iter := point.Attributes.Iter(); iter.Next(); {
+				kv := iter.Attribute()
+				labels[string(kv.Key)] = kv.Value.Emit()
+				keys[string(kv.Key)] = struct{}{}
+			}
+			req.TimeSeries = append(req.TimeSeries, &monitoringpb.TimeSeries{
+				Metric:   &metricpb.Metric{Type: metricType, Labels: labels},
+				Resource: &monitoredrespb.MonitoredResource{Type: "global"},
+				Points: []*monitoringpb.Point{{
+					Interval: &monitoringpb.TimeInterval{
+						StartTime: &timestamppb.Timestamp{},
+						EndTime:   &timestamppb.Timestamp{},
+					},
+					Value: selfObsValue(md.ValueType, point),
+				}},
+			})
+		}
+		for key := range keys {
+			md.Labels = append(md.Labels, &label.LabelDescriptor{Key: key})
+		}
+		sort.Slice(md.Labels, func(i, j int) bool {
+			return md.Labels[i].Key < md.Labels[j].Key
+		})
+		selfObs.CreateMetricDescriptorRequests = append(selfObs.CreateMetricDescriptorRequests, &monitoringpb.CreateMetricDescriptorRequest{
+			Name:             selfObsProjectName,
+			MetricDescriptor: md,
+		})
+	}
+	if len(req.TimeSeries) > 0 {
+		selfObs.CreateTimeSeriesRequests = []*monitoringpb.CreateTimeSeriesRequest{req}
+	}
+	return selfObs
+}
+
+func selfObsValue(valueType metricpb.MetricDescriptor_ValueType, point metrictest.Point) *monitoringpb.TypedValue {
+	switch valueType {
+	case metricpb.MetricDescriptor_DISTRIBUTION:
+		return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DistributionValue{
+			DistributionValue: &distribution.Distribution{
+				Count: point.Count,
+				Mean:  point.Value / float64(point.Count),
+			},
+		}}
+	case metricpb.MetricDescriptor_INT64:
+		return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: int64(point.Value)}}
+	default:
+		return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: point.Value}}
+	}
+}
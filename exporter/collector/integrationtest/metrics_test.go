@@ -40,11 +40,11 @@ func TestMetrics(t *testing.T) {
 			require.NoError(t, err)
 			go testServer.Serve()
 			defer testServer.Shutdown()
-			testServerExporter := testServer.NewExporter(ctx, t, test.CreateMetricConfig())
 			// For collecting self observability metrics
-			inMemoryOCExporter, err := NewInMemoryOCViewExporter()
-			require.NoError(t, err)
-			defer inMemoryOCExporter.Shutdown(ctx)
+			inMemoryOTelExporter := NewInMemoryOTelExporter()
+			cfg := test.CreateMetricConfig()
+			inMemoryOTelExporter.Configure(&cfg)
+			testServerExporter := testServer.NewExporter(ctx, t, cfg)
 
 			err = testServerExporter.PushMetrics(ctx, metrics)
 			if !test.ExpectErr {
@@ -69,8 +69,7 @@ func TestMetrics(t *testing.T) {
 				return expectFixture.CreateServiceTimeSeriesRequests[i].Name < expectFixture.CreateServiceTimeSeriesRequests[j].Name
 			})
 
-			selfObsMetrics, err := inMemoryOCExporter.Proto(ctx)
-			require.NoError(t, err)
+			selfObsMetrics := inMemoryOTelExporter.Proto(ctx)
 			fixture := &MetricExpectFixture{
 				CreateTimeSeriesRequests:        testServer.CreateTimeSeriesRequests(),
 				CreateMetricDescriptorRequests:  testServer.CreateMetricDescriptorRequests(),
@@ -42,9 +42,10 @@ var (
 	// the same value every time due to side effects. The values of these metrics get cleared
 	// and are not checked in the fixture. Their labels and types are still checked.
 	selfObsMetricsToNormalize = map[string]struct{}{
-		"custom.googleapis.com/opencensus/grpc.io/client/roundtrip_latency":      {},
-		"custom.googleapis.com/opencensus/grpc.io/client/sent_bytes_per_rpc":     {},
-		"custom.googleapis.com/opencensus/grpc.io/client/received_bytes_per_rpc": {},
+		"workload.googleapis.com/rpc.client.duration":                           {},
+		"workload.googleapis.com/rpc.client.request.size":                       {},
+		"workload.googleapis.com/rpc.client.response.size":                      {},
+		"workload.googleapis.com/googlecloudmonitoring/project_request_latency": {},
 	}
 )
 
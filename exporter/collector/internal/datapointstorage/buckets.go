@@ -0,0 +1,52 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// DownscaledBucketCount returns the number of buckets needed to hold the
+// exponential histogram buckets after reducing their scale by the given
+// amount.
+func DownscaledBucketCount(buckets pmetric.Buckets, by int32) int {
+	n := len(buckets.MBucketCounts())
+	if n == 0 {
+		return 0
+	}
+	first := buckets.Offset() >> by
+	last := (buckets.Offset() + int32(n) - 1) >> by
+	return int(last-first) + 1
+}
+
+// DownscaleBuckets returns the offset and counts of the exponential histogram
+// buckets after reducing their scale by the given amount. Each bucket at the
+// lower scale covers 2^by buckets at the original scale. The counts are
+// always a new slice, and the buckets are not modified. Empty buckets are
+// the same at any scale, so they can also be "downscaled" to a higher one.
+func DownscaleBuckets(buckets pmetric.Buckets, by int32) (int32, []uint64) {
+	counts := buckets.MBucketCounts()
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	// Shifting rounds towards negative infinity, which maps negative
+	// indices to the correct bucket.
+	offset := buckets.Offset() >> by
+	merged := make([]uint64, DownscaledBucketCount(buckets, by))
+	for i, count := range counts {
+		merged[((buckets.Offset()+int32(i))>>by)-offset] += count
+	}
+	return offset, merged
+}
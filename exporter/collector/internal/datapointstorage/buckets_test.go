@@ -0,0 +1,74 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestDownscaleBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		desc           string
+		offset         int32
+		counts         []uint64
+		by             int32
+		expectedOffset int32
+		expectedCounts []uint64
+	}{
+		{
+			desc:           "same scale",
+			offset:         3,
+			counts:         []uint64{1, 2},
+			expectedOffset: 3,
+			expectedCounts: []uint64{1, 2},
+		},
+		{
+			desc:           "merge pairs",
+			offset:         1,
+			counts:         []uint64{1, 2, 3, 4},
+			by:             1,
+			expectedOffset: 0,
+			expectedCounts: []uint64{1, 5, 4},
+		},
+		{
+			desc:           "negative indices",
+			offset:         -3,
+			counts:         []uint64{1, 2, 3},
+			by:             2,
+			expectedOffset: -1,
+			expectedCounts: []uint64{6},
+		},
+		{
+			desc: "empty buckets at a higher scale",
+			by:   -1,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			buckets := pmetric.NewBuckets()
+			buckets.SetOffset(tc.offset)
+			buckets.SetMBucketCounts(tc.counts)
+			assert.Equal(t, len(tc.expectedCounts), DownscaledBucketCount(buckets, tc.by))
+			offset, counts := DownscaleBuckets(buckets, tc.by)
+			assert.Equal(t, tc.expectedOffset, offset)
+			assert.Equal(t, tc.expectedCounts, counts)
+			// The buckets are unchanged.
+			assert.Equal(t, tc.offset, buckets.Offset())
+			assert.Equal(t, tc.counts, buckets.MBucketCounts())
+		})
+	}
+}
@@ -15,140 +15,303 @@
 package datapointstorage
 
 import (
-	"fmt"
-	"strings"
+	"container/list"
 	"sync"
 	"time"
 
-	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
-	"go.uber.org/atomic"
-	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
-const gcInterval = 20 * time.Minute
+const defaultGCInterval = 20 * time.Minute
 
-type Cache struct {
-	numberCache               map[string]usedNumberPoint
-	summaryCache              map[string]usedSummaryPoint
-	histogramCache            map[string]usedHistogramPoint
-	exponentialHistogramCache map[string]usedExponentialHistogramPoint
-	numberLock                sync.RWMutex
-	summaryLock               sync.RWMutex
-	histogramLock             sync.RWMutex
-	exponentialHistogramLock  sync.RWMutex
+// Options configures the size of a Cache, and how often unused points are
+// removed from it.
+type Options struct {
+	// MaxEntries is the maximum number of points in the cache. When it is
+	// exceeded, the least recently used points are evicted. Zero means no
+	// limit.
+	MaxEntries int
+	// MaxBytes is the maximum estimated memory used by points in the cache.
+	// When it is exceeded, the least recently used points are evicted. Zero
+	// means no limit.
+	MaxBytes int64
+	// GCInterval is how often points are checked for use. Points which
+	// haven't been used for a full interval are removed. Defaults to 20m.
+	GCInterval time.Duration
 }
 
-type usedNumberPoint struct {
-	point *pmetric.NumberDataPoint
-	used  *atomic.Bool
+// Stats are the size of a Cache, and counts of its lookups and evictions
+// since it was created.
+type Stats struct {
+	Entries   int
+	Bytes     int64
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Collisions is the number of lookups which found a point of a
+	// different timeseries with the same hash.
+	Collisions int64
 }
 
-type usedSummaryPoint struct {
-	point *pmetric.SummaryDataPoint
-	used  *atomic.Bool
+// Add returns the sum of the stats of two caches.
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		Entries:    s.Entries + other.Entries,
+		Bytes:      s.Bytes + other.Bytes,
+		Hits:       s.Hits + other.Hits,
+		Misses:     s.Misses + other.Misses,
+		Evictions:  s.Evictions + other.Evictions,
+		Collisions: s.Collisions + other.Collisions,
+	}
 }
 
-type usedHistogramPoint struct {
-	point *pmetric.HistogramDataPoint
-	used  *atomic.Bool
+// Cache stores points of all types by identifier. Points of different types
+// share one LRU list, so the limits apply to the cache as a whole.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[entryKey]*list.Element
+	// lru holds *entry, with the most recently used at the front.
+	lru     *list.List
+	bytes   int64
+	options Options
+
+	hits       int64
+	misses     int64
+	evictions  int64
+	collisions int64
+}
+
+type pointType int
+
+const (
+	numberPoint pointType = iota
+	summaryPoint
+	histogramPoint
+	exponentialHistogramPoint
+	scalePoint
+)
+
+type entryKey struct {
+	pointType pointType
+	hash      [2]uint64
 }
 
-type usedExponentialHistogramPoint struct {
-	point *pmetric.ExponentialHistogramDataPoint
-	used  *atomic.Bool
+type entry struct {
+	key entryKey
+	// check distinguishes timeseries with the same hash.
+	check uint64
+	point interface{}
+	size  int64
+	// used is set when the point is read or written, and cleared by gc.
+	used bool
 }
 
 // NewCache instantiates a cache and starts background processes
-func NewCache(shutdown <-chan struct{}) *Cache {
-	c := &Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
-	go func() {
-		ticker := time.NewTicker(gcInterval)
-		for c.gc(shutdown, ticker.C) {
-		}
-	}()
+func NewCache(shutdown <-chan struct{}, options Options) *Cache {
+	c := newCache(options)
+	go c.RunGC(shutdown)
 	return c
 }
 
+// NewCacheWithoutGC instantiates a cache without starting its garbage
+// collection, so the caller can run RunGC in a goroutine it tracks.
+func NewCacheWithoutGC(options Options) *Cache {
+	return newCache(options)
+}
+
+// RunGC removes points which haven't been used for a full GC interval until
+// shutdown.
+func (c *Cache) RunGC(shutdown <-chan struct{}) {
+	ticker := time.NewTicker(c.options.GCInterval)
+	defer ticker.Stop()
+	for c.gc(shutdown, ticker.C) {
+	}
+}
+
+func newCache(options Options) *Cache {
+	if options.GCInterval <= 0 {
+		options.GCInterval = defaultGCInterval
+	}
+	return &Cache{
+		entries: make(map[entryKey]*list.Element),
+		lru:     list.New(),
+		options: options,
+	}
+}
+
+// get returns the point stored for the timeseries, and marks it as most
+// recently used.
+func (c *Cache) get(t pointType, id Identifier) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[entryKey{t, id.hash}]
+	if !found {
+		c.misses++
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if e.check != id.check {
+		// The point belongs to a different timeseries with the same hash.
+		// Treat this timeseries as new, rather than normalizing it against
+		// the other timeseries' point.
+		c.misses++
+		c.collisions++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	e.used = true
+	return e.point, true
+}
+
+// set stores the point for the timeseries, and evicts the least recently
+// used points if the cache is over its limits. If a different timeseries
+// with the same hash has a point stored, it is replaced.
+func (c *Cache) set(t pointType, id Identifier, point interface{}, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := entryKey{t, id.hash}
+	size += entryOverhead
+	if elem, found := c.entries[key]; found {
+		e := elem.Value.(*entry)
+		c.bytes += size - e.size
+		e.check, e.point, e.size, e.used = id.check, point, size, true
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&entry{key: key, check: id.check, point: point, size: size, used: true})
+		c.bytes += size
+	}
+	// Never evict the point we just stored, even if it is over the limit
+	// on its own.
+	for c.lru.Len() > 1 && c.overLimit() {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *Cache) overLimit() bool {
+	return (c.options.MaxEntries > 0 && c.lru.Len() > c.options.MaxEntries) ||
+		(c.options.MaxBytes > 0 && c.bytes > c.options.MaxBytes)
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+}
+
 // GetNumberDataPoint retrieves the point associated with the identifier, and whether
 // or not it was found
-func (c *Cache) GetNumberDataPoint(identifier string) (*pmetric.NumberDataPoint, bool) {
-	c.numberLock.RLock()
-	defer c.numberLock.RUnlock()
-	point, found := c.numberCache[identifier]
-	if found {
-		point.used.Store(true)
+func (c *Cache) GetNumberDataPoint(identifier Identifier) (*pmetric.NumberDataPoint, bool) {
+	point, found := c.get(numberPoint, identifier)
+	if !found {
+		return nil, false
 	}
-	return point.point, found
+	return point.(*pmetric.NumberDataPoint), true
 }
 
 // SetNumberDataPoint assigns the point to the identifier in the cache
-func (c *Cache) SetNumberDataPoint(identifier string, point *pmetric.NumberDataPoint) {
-	c.numberLock.Lock()
-	defer c.numberLock.Unlock()
-	c.numberCache[identifier] = usedNumberPoint{point, atomic.NewBool(true)}
+func (c *Cache) SetNumberDataPoint(identifier Identifier, point *pmetric.NumberDataPoint) {
+	var size int64
+	if point != nil {
+		size = numberDataPointSize(*point)
+	}
+	c.set(numberPoint, identifier, point, size)
 }
 
 // GetSummaryDataPoint retrieves the point associated with the identifier, and whether
 // or not it was found
-func (c *Cache) GetSummaryDataPoint(identifier string) (*pmetric.SummaryDataPoint, bool) {
-	c.summaryLock.RLock()
-	defer c.summaryLock.RUnlock()
-	point, found := c.summaryCache[identifier]
-	if found {
-		point.used.Store(true)
+func (c *Cache) GetSummaryDataPoint(identifier Identifier) (*pmetric.SummaryDataPoint, bool) {
+	point, found := c.get(summaryPoint, identifier)
+	if !found {
+		return nil, false
 	}
-	return point.point, found
+	return point.(*pmetric.SummaryDataPoint), true
 }
 
 // SetSummaryDataPoint assigns the point to the identifier in the cache
-func (c *Cache) SetSummaryDataPoint(identifier string, point *pmetric.SummaryDataPoint) {
-	c.summaryLock.Lock()
-	defer c.summaryLock.Unlock()
-	c.summaryCache[identifier] = usedSummaryPoint{point, atomic.NewBool(true)}
+func (c *Cache) SetSummaryDataPoint(identifier Identifier, point *pmetric.SummaryDataPoint) {
+	var size int64
+	if point != nil {
+		size = summaryDataPointSize(*point)
+	}
+	c.set(summaryPoint, identifier, point, size)
 }
 
 // GetHistogramDataPoint retrieves the point associated with the identifier, and whether
 // or not it was found
-func (c *Cache) GetHistogramDataPoint(identifier string) (*pmetric.HistogramDataPoint, bool) {
-	c.histogramLock.RLock()
-	defer c.histogramLock.RUnlock()
-	point, found := c.histogramCache[identifier]
-	if found {
-		point.used.Store(true)
+func (c *Cache) GetHistogramDataPoint(identifier Identifier) (*pmetric.HistogramDataPoint, bool) {
+	point, found := c.get(histogramPoint, identifier)
+	if !found {
+		return nil, false
 	}
-	return point.point, found
+	return point.(*pmetric.HistogramDataPoint), true
 }
 
 // SetHistogramDataPoint assigns the point to the identifier in the cache
-func (c *Cache) SetHistogramDataPoint(identifier string, point *pmetric.HistogramDataPoint) {
-	c.histogramLock.Lock()
-	defer c.histogramLock.Unlock()
-	c.histogramCache[identifier] = usedHistogramPoint{point, atomic.NewBool(true)}
+func (c *Cache) SetHistogramDataPoint(identifier Identifier, point *pmetric.HistogramDataPoint) {
+	var size int64
+	if point != nil {
+		size = histogramDataPointSize(*point)
+	}
+	c.set(histogramPoint, identifier, point, size)
 }
 
 // GetExponentialHistogramDataPoint retrieves the point associated with the identifier, and whether
 // or not it was found
-func (c *Cache) GetExponentialHistogramDataPoint(identifier string) (*pmetric.ExponentialHistogramDataPoint, bool) {
-	c.exponentialHistogramLock.RLock()
-	defer c.exponentialHistogramLock.RUnlock()
-	point, found := c.exponentialHistogramCache[identifier]
-	if found {
-		point.used.Store(true)
+func (c *Cache) GetExponentialHistogramDataPoint(identifier Identifier) (*pmetric.ExponentialHistogramDataPoint, bool) {
+	point, found := c.get(exponentialHistogramPoint, identifier)
+	if !found {
+		return nil, false
 	}
-	return point.point, found
+	return point.(*pmetric.ExponentialHistogramDataPoint), true
 }
 
 // SetExponentialHistogramDataPoint assigns the point to the identifier in the cache
-func (c *Cache) SetExponentialHistogramDataPoint(identifier string, point *pmetric.ExponentialHistogramDataPoint) {
-	c.exponentialHistogramLock.Lock()
-	defer c.exponentialHistogramLock.Unlock()
-	c.exponentialHistogramCache[identifier] = usedExponentialHistogramPoint{point, atomic.NewBool(true)}
+func (c *Cache) SetExponentialHistogramDataPoint(identifier Identifier, point *pmetric.ExponentialHistogramDataPoint) {
+	var size int64
+	if point != nil {
+		size = exponentialHistogramDataPointSize(*point)
+	}
+	c.set(exponentialHistogramPoint, identifier, point, size)
+}
+
+// GetScale retrieves the exponential histogram scale associated with the
+// identifier, and whether or not it was found
+func (c *Cache) GetScale(identifier Identifier) (int32, bool) {
+	scale, found := c.get(scalePoint, identifier)
+	if !found {
+		return 0, false
+	}
+	return scale.(int32), true
+}
+
+// SetScale assigns the exponential histogram scale to the identifier in the
+// cache
+func (c *Cache) SetScale(identifier Identifier, scale int32) {
+	c.set(scalePoint, identifier, scale, 4)
+}
+
+// Size returns the number of points in the cache.
+func (c *Cache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the size of the cache, and counts of its lookups and
+// evictions.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Entries:    c.lru.Len(),
+		Bytes:      c.bytes,
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+		Collisions: c.collisions,
+	}
 }
 
 // gc garbage collects the cache after the ticker ticks
@@ -157,75 +320,20 @@ func (c *Cache) gc(shutdown <-chan struct{}, tickerCh <-chan time.Time) bool {
 	case <-shutdown:
 		return false
 	case <-tickerCh:
-		// garbage collect the numberCache
-		c.numberLock.Lock()
-		for id, point := range c.numberCache {
-			// for points that have been used, mark them as unused
-			if point.used.Load() {
-				point.used.Store(false)
-			} else {
-				// for points that have not been used, delete points
-				delete(c.numberCache, id)
-			}
-		}
-		c.numberLock.Unlock()
-		// garbage collect the summaryCache
-		c.summaryLock.Lock()
-		for id, point := range c.summaryCache {
+		c.mu.Lock()
+		for elem := c.lru.Front(); elem != nil; {
+			next := elem.Next()
+			e := elem.Value.(*entry)
 			// for points that have been used, mark them as unused
-			if point.used.Load() {
-				point.used.Store(false)
+			if e.used {
+				e.used = false
 			} else {
 				// for points that have not been used, delete points
-				delete(c.summaryCache, id)
+				c.remove(elem)
 			}
+			elem = next
 		}
-		c.summaryLock.Unlock()
-		// garbage collect the histogramCache
-		c.histogramLock.Lock()
-		for id, point := range c.histogramCache {
-			// for points that have been used, mark them as unused
-			if point.used.Load() {
-				point.used.Store(false)
-			} else {
-				// for points that have not been used, delete points
-				delete(c.histogramCache, id)
-			}
-		}
-		c.histogramLock.Unlock()
-		// garbage collect the exponentialHistogramCache
-		c.exponentialHistogramLock.Lock()
-		for id, point := range c.exponentialHistogramCache {
-			// for points that have been used, mark them as unused
-			if point.used.Load() {
-				point.used.Store(false)
-			} else {
-				// for points that have not been used, delete points
-				delete(c.exponentialHistogramCache, id)
-			}
-		}
-		c.exponentialHistogramLock.Unlock()
+		c.mu.Unlock()
 	}
 	return true
 }
-
-// Identifier returns the unique string identifier for a metric
-func Identifier(resource *monitoredrespb.MonitoredResource, extraLabels map[string]string, metric pmetric.Metric, attributes pcommon.Map) string {
-	var b strings.Builder
-
-	// Resource identifiers
-	if resource != nil {
-		fmt.Fprintf(&b, "%v", resource.GetLabels())
-	}
-
-	// Instrumentation library labels and additional resource labels
-	fmt.Fprintf(&b, " - %v", extraLabels)
-
-	// Metric identifiers
-	fmt.Fprintf(&b, " - %s -", metric.Name())
-	attributes.Sort().Range(func(k string, v pcommon.Value) bool {
-		fmt.Fprintf(&b, " %s=%s", k, v.AsString())
-		return true
-	})
-	return b.String()
-}
@@ -20,40 +20,41 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
-	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
 func TestSetAndGet(t *testing.T) {
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
-	c.SetNumberDataPoint("foo", nil)
-	point, found := c.GetNumberDataPoint("foo")
+	c := newCache(Options{})
+	c.SetNumberDataPoint(testIdentifier("foo"), nil)
+	point, found := c.GetNumberDataPoint(testIdentifier("foo"))
 	assert.Nil(t, point)
 	assert.True(t, found)
-	point, found = c.GetNumberDataPoint("bar")
+	point, found = c.GetNumberDataPoint(testIdentifier("bar"))
 	assert.Nil(t, point)
 	assert.False(t, found)
 	setPoint := pmetric.NewNumberDataPoint()
-	c.SetNumberDataPoint("bar", &setPoint)
-	point, found = c.GetNumberDataPoint("bar")
+	c.SetNumberDataPoint(testIdentifier("bar"), &setPoint)
+	point, found = c.GetNumberDataPoint(testIdentifier("bar"))
 	assert.Equal(t, point, &setPoint)
 	assert.True(t, found)
 }
 
+func TestSetAndGetScale(t *testing.T) {
+	c := newCache(Options{})
+	_, found := c.GetScale(testIdentifier("foo"))
+	assert.False(t, found)
+	c.SetScale(testIdentifier("foo"), -2)
+	scale, found := c.GetScale(testIdentifier("foo"))
+	assert.True(t, found)
+	assert.Equal(t, int32(-2), scale)
+	// Scales don't replace points of the same timeseries.
+	_, found = c.GetNumberDataPoint(testIdentifier("foo"))
+	assert.False(t, found)
+}
+
 func TestShutdown(t *testing.T) {
 	shutdown := make(chan struct{})
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	close(shutdown)
 	// gc should return after shutdown is closed
 	cont := c.gc(shutdown, make(chan time.Time))
@@ -62,19 +63,14 @@ func TestShutdown(t *testing.T) {
 
 func TestGC(t *testing.T) {
 	shutdown := make(chan struct{})
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	fakeTicker := make(chan time.Time)
 
-	c.SetNumberDataPoint("bar", nil)
+	c.SetNumberDataPoint(testIdentifier("bar"), nil)
 
 	// bar exists since we just set it
-	usedPoint, found := c.numberCache["bar"]
-	assert.True(t, usedPoint.used.Load())
+	usedPoint, found := c.entries[entryKey{numberPoint, testIdentifier("bar").hash}]
+	assert.True(t, usedPoint.Value.(*entry).used)
 	assert.True(t, found)
 
 	// first gc tick marks bar stale
@@ -83,8 +79,8 @@ func TestGC(t *testing.T) {
 	}()
 	cont := c.gc(shutdown, fakeTicker)
 	assert.True(t, cont)
-	usedPoint, found = c.numberCache["bar"]
-	assert.False(t, usedPoint.used.Load())
+	usedPoint, found = c.entries[entryKey{numberPoint, testIdentifier("bar").hash}]
+	assert.False(t, usedPoint.Value.(*entry).used)
 	assert.True(t, found)
 
 	// second gc tick removes bar
@@ -93,24 +89,19 @@ func TestGC(t *testing.T) {
 	}()
 	cont = c.gc(shutdown, fakeTicker)
 	assert.True(t, cont)
-	_, found = c.numberCache["bar"]
+	_, found = c.entries[entryKey{numberPoint, testIdentifier("bar").hash}]
 	assert.False(t, found)
 }
 
 func TestGetPreventsGC(t *testing.T) {
 	shutdown := make(chan struct{})
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	fakeTicker := make(chan time.Time)
 
 	setPoint := pmetric.NewNumberDataPoint()
-	c.SetNumberDataPoint("bar", &setPoint)
+	c.SetNumberDataPoint(testIdentifier("bar"), &setPoint)
 	// bar exists since we just set it
-	_, found := c.numberCache["bar"]
+	_, found := c.entries[entryKey{numberPoint, testIdentifier("bar").hash}]
 	assert.True(t, found)
 	// first gc tick marks bar stale
 	go func() {
@@ -119,7 +110,7 @@ func TestGetPreventsGC(t *testing.T) {
 	cont := c.gc(shutdown, fakeTicker)
 	assert.True(t, cont)
 	// calling Get() marks it fresh again.
-	_, found = c.GetNumberDataPoint("bar")
+	_, found = c.GetNumberDataPoint(testIdentifier("bar"))
 	assert.True(t, found)
 	// second gc tick does not remove bar
 	go func() {
@@ -127,25 +118,98 @@ func TestGetPreventsGC(t *testing.T) {
 	}()
 	cont = c.gc(shutdown, fakeTicker)
 	assert.True(t, cont)
-	_, found = c.numberCache["bar"]
+	_, found = c.entries[entryKey{numberPoint, testIdentifier("bar").hash}]
+	assert.True(t, found)
+}
+
+func TestEvictMaxEntries(t *testing.T) {
+	c := newCache(Options{MaxEntries: 2})
+	number := pmetric.NewNumberDataPoint()
+	histogram := pmetric.NewHistogramDataPoint()
+	c.SetNumberDataPoint(testIdentifier("foo"), &number)
+	c.SetHistogramDataPoint(testIdentifier("bar"), &histogram)
+	// Reading foo makes bar the least recently used point.
+	_, found := c.GetNumberDataPoint(testIdentifier("foo"))
+	assert.True(t, found)
+	c.SetNumberDataPoint(testIdentifier("baz"), &number)
+
+	_, found = c.GetHistogramDataPoint(testIdentifier("bar"))
+	assert.False(t, found)
+	_, found = c.GetNumberDataPoint(testIdentifier("foo"))
 	assert.True(t, found)
+	_, found = c.GetNumberDataPoint(testIdentifier("baz"))
+	assert.True(t, found)
+	stats := c.Stats()
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+}
+
+func TestEvictMaxBytes(t *testing.T) {
+	small := pmetric.NewNumberDataPoint()
+	large := pmetric.NewExponentialHistogramDataPoint()
+	large.Positive().SetMBucketCounts(make([]uint64, 160))
+	large.Negative().SetMBucketCounts(make([]uint64, 160))
+
+	c := newCache(Options{MaxBytes: 2048})
+	c.SetNumberDataPoint(testIdentifier("a"), &small)
+	c.SetNumberDataPoint(testIdentifier("b"), &small)
+	assert.Equal(t, 2, c.Size())
+	bytes := c.Stats().Bytes
+	assert.Greater(t, bytes, int64(0))
+	assert.Less(t, bytes, int64(2048))
+
+	// The large point doesn't fit with the others, so they are evicted.
+	c.SetExponentialHistogramDataPoint(testIdentifier("c"), &large)
+	assert.Equal(t, 1, c.Size())
+	assert.Equal(t, int64(2), c.Stats().Evictions)
+	_, found := c.GetExponentialHistogramDataPoint(testIdentifier("c"))
+	assert.True(t, found)
+
+	// Adding a small point evicts the large point, since it is older.
+	c.SetNumberDataPoint(testIdentifier("a"), &small)
+	c.SetNumberDataPoint(testIdentifier("b"), &small)
+	_, found = c.GetExponentialHistogramDataPoint(testIdentifier("c"))
+	assert.False(t, found)
+	assert.Equal(t, bytes, c.Stats().Bytes)
+	assert.Equal(t, int64(3), c.Stats().Evictions)
+}
+
+func TestCollision(t *testing.T) {
+	c := newCache(Options{})
+	foo := testIdentifier("foo")
+	// bar has the same hash as foo, but is a different timeseries.
+	bar := foo
+	bar.check++
+	fooPoint := pmetric.NewNumberDataPoint()
+	barPoint := pmetric.NewNumberDataPoint()
+
+	c.SetNumberDataPoint(foo, &fooPoint)
+	_, found := c.GetNumberDataPoint(bar)
+	assert.False(t, found)
+	assert.Equal(t, int64(1), c.Stats().Collisions)
+
+	// Setting bar replaces foo, so foo is never normalized against bar.
+	c.SetNumberDataPoint(bar, &barPoint)
+	point, found := c.GetNumberDataPoint(bar)
+	assert.True(t, found)
+	assert.Same(t, &barPoint, point)
+	_, found = c.GetNumberDataPoint(foo)
+	assert.False(t, found)
+	assert.Equal(t, 1, c.Size())
 }
 
 func TestConcurrentNumber(t *testing.T) {
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	setPoint := pmetric.NewNumberDataPoint()
 
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
-			c.SetNumberDataPoint("bar", &setPoint)
-			point, found := c.GetNumberDataPoint("bar")
+			c.SetNumberDataPoint(testIdentifier("bar"), &setPoint)
+			point, found := c.GetNumberDataPoint(testIdentifier("bar"))
 			assert.Equal(t, point, &setPoint)
 			assert.True(t, found)
 			wg.Done()
@@ -163,20 +227,15 @@ func TestConcurrentNumber(t *testing.T) {
 }
 
 func TestConcurrentSummary(t *testing.T) {
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	setPoint := pmetric.NewSummaryDataPoint()
 
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
-			c.SetSummaryDataPoint("bar", &setPoint)
-			point, found := c.GetSummaryDataPoint("bar")
+			c.SetSummaryDataPoint(testIdentifier("bar"), &setPoint)
+			point, found := c.GetSummaryDataPoint(testIdentifier("bar"))
 			assert.Equal(t, point, &setPoint)
 			assert.True(t, found)
 			wg.Done()
@@ -194,20 +253,15 @@ func TestConcurrentSummary(t *testing.T) {
 }
 
 func TestConcurrentHistogram(t *testing.T) {
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	setPoint := pmetric.NewHistogramDataPoint()
 
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
-			c.SetHistogramDataPoint("bar", &setPoint)
-			point, found := c.GetHistogramDataPoint("bar")
+			c.SetHistogramDataPoint(testIdentifier("bar"), &setPoint)
+			point, found := c.GetHistogramDataPoint(testIdentifier("bar"))
 			assert.Equal(t, point, &setPoint)
 			assert.True(t, found)
 			wg.Done()
@@ -225,20 +279,15 @@ func TestConcurrentHistogram(t *testing.T) {
 }
 
 func TestConcurrentExponentialHistogram(t *testing.T) {
-	c := Cache{
-		numberCache:               make(map[string]usedNumberPoint),
-		summaryCache:              make(map[string]usedSummaryPoint),
-		histogramCache:            make(map[string]usedHistogramPoint),
-		exponentialHistogramCache: make(map[string]usedExponentialHistogramPoint),
-	}
+	c := newCache(Options{})
 	setPoint := pmetric.NewExponentialHistogramDataPoint()
 
 	var wg sync.WaitGroup
 	for i := 0; i < 5; i++ {
 		wg.Add(1)
 		go func() {
-			c.SetExponentialHistogramDataPoint("bar", &setPoint)
-			point, found := c.GetExponentialHistogramDataPoint("bar")
+			c.SetExponentialHistogramDataPoint(testIdentifier("bar"), &setPoint)
+			point, found := c.GetExponentialHistogramDataPoint(testIdentifier("bar"))
 			assert.Equal(t, point, &setPoint)
 			assert.True(t, found)
 			wg.Done()
@@ -254,79 +303,3 @@ func TestConcurrentExponentialHistogram(t *testing.T) {
 	}()
 	wg.Wait()
 }
-
-func TestIdentifier(t *testing.T) {
-	metricWithName := pmetric.NewMetric()
-	metricWithName.SetName("custom.googleapis.com/test.metric")
-	dpWithAttributes := pmetric.NewNumberDataPoint()
-	dpWithAttributes.Attributes().Insert("string", pcommon.NewValueString("strval"))
-	dpWithAttributes.Attributes().Insert("bool", pcommon.NewValueBool(true))
-	dpWithAttributes.Attributes().Insert("int", pcommon.NewValueInt(123))
-	monitoredResource := &monitoredrespb.MonitoredResource{
-		Type: "generic_task",
-		Labels: map[string]string{
-			"location": "us-central1-b",
-			"project":  "project-foo",
-		},
-	}
-	extraLabels := map[string]string{
-		"foo":   "bar",
-		"hello": "world",
-	}
-	for _, tc := range []struct {
-		resource    *monitoredrespb.MonitoredResource
-		extraLabels map[string]string
-		metric      pmetric.Metric
-		labels      pcommon.Map
-		desc        string
-		want        string
-	}{
-		{
-			desc:   "empty",
-			want:   " - map[] -  -",
-			metric: pmetric.NewMetric(),
-			labels: pmetric.NewNumberDataPoint().Attributes(),
-		},
-		{
-			desc:   "with name",
-			want:   " - map[] - custom.googleapis.com/test.metric -",
-			metric: metricWithName,
-			labels: pmetric.NewNumberDataPoint().Attributes(),
-		},
-		{
-			desc:   "with attributes",
-			want:   " - map[] -  - bool=true int=123 string=strval",
-			metric: pmetric.NewMetric(),
-			labels: dpWithAttributes.Attributes(),
-		},
-		{
-			desc:     "with resource",
-			want:     "map[location:us-central1-b project:project-foo] - map[] -  -",
-			resource: monitoredResource,
-			metric:   pmetric.NewMetric(),
-			labels:   pmetric.NewNumberDataPoint().Attributes(),
-		},
-		{
-			desc:        "with extra labels",
-			want:        " - map[foo:bar hello:world] -  -",
-			metric:      pmetric.NewMetric(),
-			labels:      pmetric.NewNumberDataPoint().Attributes(),
-			extraLabels: extraLabels,
-		},
-		{
-			desc:        "with all",
-			want:        "map[location:us-central1-b project:project-foo] - map[foo:bar hello:world] - custom.googleapis.com/test.metric - bool=true int=123 string=strval",
-			metric:      metricWithName,
-			labels:      dpWithAttributes.Attributes(),
-			extraLabels: extraLabels,
-			resource:    monitoredResource,
-		},
-	} {
-		t.Run(tc.desc, func(t *testing.T) {
-			got := Identifier(tc.resource, tc.extraLabels, tc.metric, tc.labels)
-			if tc.want != got {
-				t.Errorf("Identifier() = %q; want %q", got, tc.want)
-			}
-		})
-	}
-}
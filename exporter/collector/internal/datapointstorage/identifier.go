@@ -0,0 +1,237 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/bits"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// Identifier identifies a timeseries. It is a 128-bit hash of the
+// timeseries' resource labels, extra labels, metric name and attributes. A
+// separate 64-bit hash of the same data is used to detect the (very
+// unlikely) case of two timeseries with the same 128-bit hash.
+type Identifier struct {
+	hash  [2]uint64
+	check uint64
+}
+
+// NewIdentifier returns the Identifier of a timeseries. It doesn't allocate,
+// and doesn't modify its arguments.
+func NewIdentifier(resource *monitoredrespb.MonitoredResource, extraLabels map[string]string, metric pmetric.Metric, attributes pcommon.Map) Identifier {
+	h := newHasher()
+	// Resource identifiers
+	h.stringMap(resource.GetLabels())
+	// Instrumentation library labels and additional resource labels
+	h.stringMap(extraLabels)
+	// Metric identifiers
+	h.string(metric.Name())
+	h.attributes(attributes)
+	return Identifier{hash: [2]uint64{h.hi, h.lo}, check: h.check}
+}
+
+// NewLabelsIdentifier returns the Identifier of a timeseries from its
+// metric name and labels, which already include the point's attributes. It
+// identifies timeseries whose name and labels were changed by relabeling. It
+// doesn't allocate, and doesn't modify its arguments.
+func NewLabelsIdentifier(resource *monitoredrespb.MonitoredResource, name string, labels map[string]string) Identifier {
+	h := newHasher()
+	h.stringMap(resource.GetLabels())
+	h.string(name)
+	h.stringMap(labels)
+	return Identifier{hash: [2]uint64{h.hi, h.lo}, check: h.check}
+}
+
+// NewResourceIdentifier returns an Identifier of a resource, from its
+// attributes. It doesn't allocate, and doesn't modify its arguments.
+func NewResourceIdentifier(attributes pcommon.Map) Identifier {
+	h := newHasher()
+	h.attributes(attributes)
+	return Identifier{hash: [2]uint64{h.hi, h.lo}, check: h.check}
+}
+
+// String encodes the Identifier as hex, so it can be stored in snapshots.
+func (id Identifier) String() string {
+	var b [24]byte
+	binary.BigEndian.PutUint64(b[0:], id.hash[0])
+	binary.BigEndian.PutUint64(b[8:], id.hash[1])
+	binary.BigEndian.PutUint64(b[16:], id.check)
+	return hex.EncodeToString(b[:])
+}
+
+// parseIdentifier decodes an Identifier encoded with String.
+func parseIdentifier(s string) (Identifier, bool) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 24 {
+		return Identifier{}, false
+	}
+	return Identifier{
+		hash: [2]uint64{
+			binary.BigEndian.Uint64(b[0:]),
+			binary.BigEndian.Uint64(b[8:]),
+		},
+		check: binary.BigEndian.Uint64(b[16:]),
+	}, true
+}
+
+// FNV-1a parameters from http://www.isthe.com/chongo/tech/comp/fnv/
+const (
+	fnv128OffsetHi = 0x6c62272e07bb0142
+	fnv128OffsetLo = 0x62b821756295c58d
+	// The 128-bit prime is 2^88 + fnv128PrimeLo.
+	fnv128PrimeLo    = 0x13b
+	fnv128PrimeShift = 88 - 64
+	fnv64Offset      = 0xcbf29ce484222325
+	fnv64Prime       = 0x100000001b3
+)
+
+// Tags written before each value, so values of different types, or split
+// between fields differently, don't hash the same.
+const (
+	tagString byte = iota + 1
+	tagBool
+	tagInt
+	tagDouble
+	tagBytes
+	tagMap
+	tagSlice
+	tagEmpty
+)
+
+// hasher computes a 128-bit FNV-1a hash and a 64-bit FNV-1a hash of the
+// same data. It is a value type, so it doesn't allocate.
+type hasher struct {
+	hi, lo uint64
+	check  uint64
+}
+
+func newHasher() hasher {
+	return hasher{hi: fnv128OffsetHi, lo: fnv128OffsetLo, check: fnv64Offset}
+}
+
+func (h *hasher) byte(b byte) {
+	h.lo ^= uint64(b)
+	// Multiply the 128-bit state by the prime, modulo 2^128.
+	carry, lo := bits.Mul64(h.lo, fnv128PrimeLo)
+	h.hi = h.hi*fnv128PrimeLo + h.lo<<fnv128PrimeShift + carry
+	h.lo = lo
+
+	h.check ^= uint64(b)
+	h.check *= fnv64Prime
+}
+
+func (h *hasher) uint64(v uint64) {
+	for i := 0; i < 64; i += 8 {
+		h.byte(byte(v >> i))
+	}
+}
+
+func (h *hasher) string(s string) {
+	h.byte(tagString)
+	h.uint64(uint64(len(s)))
+	for i := 0; i < len(s); i++ {
+		h.byte(s[i])
+	}
+}
+
+// add combines the hash of one entry of a map into h. Addition is
+// commutative, so maps hash the same regardless of iteration order, without
+// sorting their keys.
+func (h *hasher) add(entry hasher) {
+	var carry uint64
+	h.lo, carry = bits.Add64(h.lo, entry.lo, 0)
+	h.hi, _ = bits.Add64(h.hi, entry.hi, carry)
+	h.check += entry.check
+}
+
+// merge writes the combined hash of the entries of a map into h.
+func (h *hasher) merge(entries hasher, n int) {
+	h.byte(tagMap)
+	h.uint64(uint64(n))
+	h.uint64(entries.hi)
+	h.uint64(entries.lo)
+	h.uint64(entries.check)
+}
+
+func (h *hasher) stringMap(m map[string]string) {
+	var entries hasher
+	for k, v := range m {
+		entry := newHasher()
+		entry.string(k)
+		entry.string(v)
+		entries.add(entry)
+	}
+	h.merge(entries, len(m))
+}
+
+func (h *hasher) attributes(m pcommon.Map) {
+	var entries hasher
+	m.Range(func(k string, v pcommon.Value) bool {
+		entries.add(attributeHash(k, v))
+		return true
+	})
+	h.merge(entries, m.Len())
+}
+
+func attributeHash(k string, v pcommon.Value) hasher {
+	entry := newHasher()
+	entry.string(k)
+	entry.value(v)
+	return entry
+}
+
+func (h *hasher) value(v pcommon.Value) {
+	switch v.Type() {
+	case pcommon.ValueTypeString:
+		h.string(v.StringVal())
+	case pcommon.ValueTypeBool:
+		h.byte(tagBool)
+		if v.BoolVal() {
+			h.byte(1)
+		} else {
+			h.byte(0)
+		}
+	case pcommon.ValueTypeInt:
+		h.byte(tagInt)
+		h.uint64(uint64(v.IntVal()))
+	case pcommon.ValueTypeDouble:
+		h.byte(tagDouble)
+		h.uint64(math.Float64bits(v.DoubleVal()))
+	case pcommon.ValueTypeBytes:
+		h.byte(tagBytes)
+		b := v.MBytesVal()
+		h.uint64(uint64(len(b)))
+		for _, c := range b {
+			h.byte(c)
+		}
+	case pcommon.ValueTypeMap:
+		h.attributes(v.MapVal())
+	case pcommon.ValueTypeSlice:
+		h.byte(tagSlice)
+		s := v.SliceVal()
+		h.uint64(uint64(s.Len()))
+		for i := 0; i < s.Len(); i++ {
+			h.value(s.At(i))
+		}
+	default:
+		h.byte(tagEmpty)
+	}
+}
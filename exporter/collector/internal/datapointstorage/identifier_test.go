@@ -0,0 +1,249 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+func testIdentifier(name string) Identifier {
+	metric := pmetric.NewMetric()
+	metric.SetName(name)
+	return NewIdentifier(nil, nil, metric, pcommon.NewMap())
+}
+
+func TestIdentifier(t *testing.T) {
+	metricWithName := pmetric.NewMetric()
+	metricWithName.SetName("custom.googleapis.com/test.metric")
+	dpWithAttributes := pmetric.NewNumberDataPoint()
+	dpWithAttributes.Attributes().Insert("string", pcommon.NewValueString("strval"))
+	dpWithAttributes.Attributes().Insert("bool", pcommon.NewValueBool(true))
+	dpWithAttributes.Attributes().Insert("int", pcommon.NewValueInt(123))
+	monitoredResource := &monitoredrespb.MonitoredResource{
+		Type: "generic_task",
+		Labels: map[string]string{
+			"location": "us-central1-b",
+			"project":  "project-foo",
+		},
+	}
+	extraLabels := map[string]string{
+		"foo":   "bar",
+		"hello": "world",
+	}
+	attributes := func(kvs ...interface{}) pcommon.Map {
+		m := pcommon.NewMap()
+		for i := 0; i < len(kvs); i += 2 {
+			switch v := kvs[i+1].(type) {
+			case string:
+				m.InsertString(kvs[i].(string), v)
+			case int:
+				m.InsertInt(kvs[i].(string), int64(v))
+			}
+		}
+		return m
+	}
+	testCases := []struct {
+		resource    *monitoredrespb.MonitoredResource
+		extraLabels map[string]string
+		metric      pmetric.Metric
+		labels      pcommon.Map
+		desc        string
+	}{
+		{
+			desc:   "empty",
+			metric: pmetric.NewMetric(),
+			labels: pmetric.NewNumberDataPoint().Attributes(),
+		},
+		{
+			desc:   "with name",
+			metric: metricWithName,
+			labels: pmetric.NewNumberDataPoint().Attributes(),
+		},
+		{
+			desc:   "with attributes",
+			metric: pmetric.NewMetric(),
+			labels: dpWithAttributes.Attributes(),
+		},
+		{
+			desc:     "with resource",
+			resource: monitoredResource,
+			metric:   pmetric.NewMetric(),
+			labels:   pmetric.NewNumberDataPoint().Attributes(),
+		},
+		{
+			desc:        "with extra labels",
+			metric:      pmetric.NewMetric(),
+			labels:      pmetric.NewNumberDataPoint().Attributes(),
+			extraLabels: extraLabels,
+		},
+		{
+			desc:        "with all",
+			metric:      metricWithName,
+			labels:      dpWithAttributes.Attributes(),
+			extraLabels: extraLabels,
+			resource:    monitoredResource,
+		},
+		{
+			desc:   "with attribute split differently",
+			metric: pmetric.NewMetric(),
+			labels: attributes("a", "bc"),
+		},
+		{
+			desc:   "with attribute split differently 2",
+			metric: pmetric.NewMetric(),
+			labels: attributes("ab", "c"),
+		},
+		{
+			desc:   "with string attribute",
+			metric: pmetric.NewMetric(),
+			labels: attributes("a", "1"),
+		},
+		{
+			desc:   "with int attribute",
+			metric: pmetric.NewMetric(),
+			labels: attributes("a", 1),
+		},
+		{
+			desc:        "with attribute as extra label",
+			metric:      pmetric.NewMetric(),
+			labels:      pmetric.NewNumberDataPoint().Attributes(),
+			extraLabels: map[string]string{"a": "1"},
+		},
+	}
+	// Each case is a different timeseries, so they must all have different
+	// identifiers.
+	var ids []Identifier
+	for _, tc := range testCases {
+		got := NewIdentifier(tc.resource, tc.extraLabels, tc.metric, tc.labels)
+		// Identifiers are stable, and survive encoding.
+		assert.Equal(t, got, NewIdentifier(tc.resource, tc.extraLabels, tc.metric, tc.labels), tc.desc)
+		parsed, ok := parseIdentifier(got.String())
+		assert.True(t, ok, tc.desc)
+		assert.Equal(t, got, parsed, tc.desc)
+		for j, other := range ids {
+			assert.NotEqual(t, other.hash, got.hash, "%s and %s", testCases[j].desc, tc.desc)
+			assert.NotEqual(t, other.check, got.check, "%s and %s", testCases[j].desc, tc.desc)
+		}
+		ids = append(ids, got)
+	}
+}
+
+func TestIdentifierIgnoresAttributeOrder(t *testing.T) {
+	metric := pmetric.NewMetric()
+	metric.SetName("custom.googleapis.com/test.metric")
+	a := pcommon.NewMap()
+	a.InsertString("foo", "bar")
+	a.InsertInt("hello", 1)
+	b := pcommon.NewMap()
+	b.InsertInt("hello", 1)
+	b.InsertString("foo", "bar")
+
+	assert.Equal(t, NewIdentifier(nil, nil, metric, a), NewIdentifier(nil, nil, metric, b))
+	// The attributes are not sorted in place.
+	var keys []string
+	b.Range(func(k string, _ pcommon.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	assert.Equal(t, []string{"hello", "foo"}, keys)
+}
+
+func TestResourceIdentifier(t *testing.T) {
+	a := pcommon.NewMap()
+	a.InsertString("service.name", "foo")
+	a.InsertString("service.instance.id", "1")
+	b := pcommon.NewMap()
+	b.InsertString("service.instance.id", "1")
+	b.InsertString("service.name", "foo")
+	c := pcommon.NewMap()
+	c.InsertString("service.name", "foo")
+	c.InsertString("service.instance.id", "2")
+
+	assert.Equal(t, NewResourceIdentifier(a), NewResourceIdentifier(b))
+	assert.NotEqual(t, NewResourceIdentifier(a), NewResourceIdentifier(c))
+}
+
+func TestIdentifierDoesNotAllocate(t *testing.T) {
+	resource, extraLabels, metric, attributes := benchmarkTimeseries()
+	allocs := testing.AllocsPerRun(100, func() {
+		NewIdentifier(resource, extraLabels, metric, attributes)
+	})
+	assert.Zero(t, allocs)
+}
+
+func benchmarkTimeseries() (*monitoredrespb.MonitoredResource, map[string]string, pmetric.Metric, pcommon.Map) {
+	resource := &monitoredrespb.MonitoredResource{
+		Type: "k8s_container",
+		Labels: map[string]string{
+			"project_id":     "my-project",
+			"location":       "us-central1-b",
+			"cluster_name":   "my-cluster",
+			"namespace_name": "default",
+			"pod_name":       "my-pod-6f9c8d7b5-x2x4q",
+			"container_name": "server",
+		},
+	}
+	extraLabels := map[string]string{
+		"instrumentation_source":  "otelcol/prometheusreceiver",
+		"instrumentation_version": "0.53.0",
+	}
+	metric := pmetric.NewMetric()
+	metric.SetName("http_server_requests_seconds")
+	point := pmetric.NewHistogramDataPoint()
+	point.Attributes().InsertString("method", "GET")
+	point.Attributes().InsertString("path", "/api/v1/items")
+	point.Attributes().InsertInt("status", 200)
+	point.Attributes().InsertBool("error", false)
+	return resource, extraLabels, metric, point.Attributes()
+}
+
+// legacyIdentifier is the string identifier NewIdentifier replaced, kept
+// for comparison in benchmarks.
+func legacyIdentifier(resource *monitoredrespb.MonitoredResource, extraLabels map[string]string, metric pmetric.Metric, attributes pcommon.Map) string {
+	var b strings.Builder
+	if resource != nil {
+		fmt.Fprintf(&b, "%v", resource.GetLabels())
+	}
+	fmt.Fprintf(&b, " - %v", extraLabels)
+	fmt.Fprintf(&b, " - %s -", metric.Name())
+	attributes.Sort().Range(func(k string, v pcommon.Value) bool {
+		fmt.Fprintf(&b, " %s=%s", k, v.AsString())
+		return true
+	})
+	return b.String()
+}
+
+func BenchmarkIdentifier(b *testing.B) {
+	resource, extraLabels, metric, attributes := benchmarkTimeseries()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewIdentifier(resource, extraLabels, metric, attributes)
+	}
+}
+
+func BenchmarkLegacyIdentifier(b *testing.B) {
+	resource, extraLabels, metric, attributes := benchmarkTimeseries()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyIdentifier(resource, extraLabels, metric, attributes)
+	}
+}
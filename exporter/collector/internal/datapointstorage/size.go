@@ -0,0 +1,86 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// The sizes below are rough estimates of the memory used by points, so the
+// cache can be limited to a memory budget. They count the fields of each
+// point, and the keys and values of its attributes and exemplars.
+const (
+	// entryOverhead is the memory used by a cache entry itself: the map
+	// entry, the list element, the point wrapper and the identifier.
+	entryOverhead    = 160
+	pointOverhead    = 96
+	valueOverhead    = 48
+	exemplarOverhead = 96
+)
+
+func numberDataPointSize(point pmetric.NumberDataPoint) int64 {
+	return pointOverhead + attributesSize(point.Attributes()) + exemplarsSize(point.Exemplars())
+}
+
+func summaryDataPointSize(point pmetric.SummaryDataPoint) int64 {
+	return pointOverhead + attributesSize(point.Attributes()) + int64(point.QuantileValues().Len())*(16+valueOverhead)
+}
+
+func histogramDataPointSize(point pmetric.HistogramDataPoint) int64 {
+	return pointOverhead + attributesSize(point.Attributes()) + exemplarsSize(point.Exemplars()) +
+		int64(len(point.MBucketCounts()))*8 + int64(len(point.MExplicitBounds()))*8
+}
+
+func exponentialHistogramDataPointSize(point pmetric.ExponentialHistogramDataPoint) int64 {
+	return pointOverhead + attributesSize(point.Attributes()) + exemplarsSize(point.Exemplars()) +
+		int64(len(point.Positive().MBucketCounts()))*8 + int64(len(point.Negative().MBucketCounts()))*8
+}
+
+func attributesSize(attributes pcommon.Map) int64 {
+	var size int64
+	attributes.Range(func(k string, v pcommon.Value) bool {
+		size += valueOverhead + int64(len(k)) + valueSize(v)
+		return true
+	})
+	return size
+}
+
+func valueSize(v pcommon.Value) int64 {
+	switch v.Type() {
+	case pcommon.ValueTypeString:
+		return int64(len(v.StringVal()))
+	case pcommon.ValueTypeBytes:
+		return int64(len(v.MBytesVal()))
+	case pcommon.ValueTypeMap:
+		return attributesSize(v.MapVal())
+	case pcommon.ValueTypeSlice:
+		var size int64
+		for i := 0; i < v.SliceVal().Len(); i++ {
+			size += valueOverhead + valueSize(v.SliceVal().At(i))
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+func exemplarsSize(exemplars pmetric.ExemplarSlice) int64 {
+	var size int64
+	for i := 0; i < exemplars.Len(); i++ {
+		size += exemplarOverhead + attributesSize(exemplars.At(i).FilteredAttributes())
+	}
+	return size
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// MarshalSnapshot encodes the points in the named caches as OTLP metrics.
+// Each cache is stored as a scope with its name, and each point as a metric
+// named after its identifier.
+func MarshalSnapshot(caches map[string]*Cache) ([]byte, error) {
+	metrics := pmetric.NewMetrics()
+	rm := metrics.ResourceMetrics().AppendEmpty()
+	for name, c := range caches {
+		sm := rm.ScopeMetrics().AppendEmpty()
+		sm.Scope().SetName(name)
+		c.snapshot(sm.Metrics())
+	}
+	return pmetric.NewProtoMarshaler().MarshalMetrics(metrics)
+}
+
+// UnmarshalSnapshot restores the points written by MarshalSnapshot into the
+// named caches. It returns the number of points restored.
+func UnmarshalSnapshot(data []byte, caches map[string]*Cache) (int, error) {
+	metrics, err := pmetric.NewProtoUnmarshaler().UnmarshalMetrics(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	restored := 0
+	rms := metrics.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		sms := rms.At(i).ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			c, ok := caches[sms.At(j).Scope().Name()]
+			if !ok {
+				continue
+			}
+			restored += c.restore(sms.At(j).Metrics())
+		}
+	}
+	return restored, nil
+}
+
+// snapshot appends a metric with a copy of each cached point. Points are
+// appended from least to most recently used, so restoring them in order
+// preserves their recency.
+func (c *Cache) snapshot(metrics pmetric.MetricSlice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*entry)
+		switch point := e.point.(type) {
+		case *pmetric.NumberDataPoint:
+			if point == nil {
+				continue
+			}
+			metric := metrics.AppendEmpty()
+			metric.SetName(Identifier{hash: e.key.hash, check: e.check}.String())
+			metric.SetDataType(pmetric.MetricDataTypeSum)
+			point.CopyTo(metric.Sum().DataPoints().AppendEmpty())
+		case *pmetric.SummaryDataPoint:
+			if point == nil {
+				continue
+			}
+			metric := metrics.AppendEmpty()
+			metric.SetName(Identifier{hash: e.key.hash, check: e.check}.String())
+			metric.SetDataType(pmetric.MetricDataTypeSummary)
+			point.CopyTo(metric.Summary().DataPoints().AppendEmpty())
+		case *pmetric.HistogramDataPoint:
+			if point == nil {
+				continue
+			}
+			metric := metrics.AppendEmpty()
+			metric.SetName(Identifier{hash: e.key.hash, check: e.check}.String())
+			metric.SetDataType(pmetric.MetricDataTypeHistogram)
+			point.CopyTo(metric.Histogram().DataPoints().AppendEmpty())
+		case *pmetric.ExponentialHistogramDataPoint:
+			if point == nil {
+				continue
+			}
+			metric := metrics.AppendEmpty()
+			metric.SetName(Identifier{hash: e.key.hash, check: e.check}.String())
+			metric.SetDataType(pmetric.MetricDataTypeExponentialHistogram)
+			point.CopyTo(metric.ExponentialHistogram().DataPoints().AppendEmpty())
+		}
+	}
+}
+
+// restore sets the point of each metric, and returns the number of points
+// set.
+func (c *Cache) restore(metrics pmetric.MetricSlice) int {
+	restored := 0
+	for i := 0; i < metrics.Len(); i++ {
+		metric := metrics.At(i)
+		id, ok := parseIdentifier(metric.Name())
+		if !ok {
+			continue
+		}
+		switch metric.DataType() {
+		case pmetric.MetricDataTypeSum:
+			if metric.Sum().DataPoints().Len() == 0 {
+				continue
+			}
+			point := metric.Sum().DataPoints().At(0)
+			c.SetNumberDataPoint(id, &point)
+		case pmetric.MetricDataTypeSummary:
+			if metric.Summary().DataPoints().Len() == 0 {
+				continue
+			}
+			point := metric.Summary().DataPoints().At(0)
+			c.SetSummaryDataPoint(id, &point)
+		case pmetric.MetricDataTypeHistogram:
+			if metric.Histogram().DataPoints().Len() == 0 {
+				continue
+			}
+			point := metric.Histogram().DataPoints().At(0)
+			c.SetHistogramDataPoint(id, &point)
+		case pmetric.MetricDataTypeExponentialHistogram:
+			if metric.ExponentialHistogram().DataPoints().Len() == 0 {
+				continue
+			}
+			point := metric.ExponentialHistogram().DataPoints().At(0)
+			c.SetExponentialHistogramDataPoint(id, &point)
+		default:
+			continue
+		}
+		restored++
+	}
+	return restored
+}
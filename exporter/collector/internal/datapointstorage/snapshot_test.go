@@ -0,0 +1,83 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datapointstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestSnapshot(t *testing.T) {
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	ts := pcommon.NewTimestampFromTime(time.Unix(1000, 0))
+
+	number := pmetric.NewNumberDataPoint()
+	number.SetTimestamp(ts)
+	number.SetIntVal(5)
+	summary := pmetric.NewSummaryDataPoint()
+	summary.SetTimestamp(ts)
+	summary.SetCount(3)
+	histogram := pmetric.NewHistogramDataPoint()
+	histogram.SetTimestamp(ts)
+	histogram.SetMBucketCounts([]uint64{1, 2})
+	expHistogram := pmetric.NewExponentialHistogramDataPoint()
+	expHistogram.SetTimestamp(ts)
+	expHistogram.SetScale(2)
+
+	start := NewCache(shutdown, Options{})
+	start.SetNumberDataPoint(testIdentifier("number"), &number)
+	start.SetSummaryDataPoint(testIdentifier("summary"), &summary)
+	start.SetHistogramDataPoint(testIdentifier("histogram"), &histogram)
+	start.SetExponentialHistogramDataPoint(testIdentifier("exphistogram"), &expHistogram)
+	// nil points are not saved
+	start.SetNumberDataPoint(testIdentifier("nil"), nil)
+	previous := NewCache(shutdown, Options{})
+	previous.SetNumberDataPoint(testIdentifier("number"), &number)
+
+	data, err := MarshalSnapshot(map[string]*Cache{"start": start, "previous": previous})
+	require.NoError(t, err)
+
+	restoredStart := NewCache(shutdown, Options{})
+	restoredPrevious := NewCache(shutdown, Options{})
+	restored, err := UnmarshalSnapshot(data, map[string]*Cache{"start": restoredStart, "previous": restoredPrevious})
+	require.NoError(t, err)
+	assert.Equal(t, 5, restored)
+	assert.Equal(t, 4, restoredStart.Size())
+	assert.Equal(t, 1, restoredPrevious.Size())
+
+	point, found := restoredStart.GetNumberDataPoint(testIdentifier("number"))
+	require.True(t, found)
+	assert.Equal(t, number, *point)
+	summaryPoint, found := restoredStart.GetSummaryDataPoint(testIdentifier("summary"))
+	require.True(t, found)
+	assert.Equal(t, summary, *summaryPoint)
+	histogramPoint, found := restoredStart.GetHistogramDataPoint(testIdentifier("histogram"))
+	require.True(t, found)
+	assert.Equal(t, histogram, *histogramPoint)
+	expHistogramPoint, found := restoredStart.GetExponentialHistogramDataPoint(testIdentifier("exphistogram"))
+	require.True(t, found)
+	assert.Equal(t, expHistogram, *expHistogramPoint)
+	_, found = restoredPrevious.GetNumberDataPoint(testIdentifier("number"))
+	assert.True(t, found)
+
+	_, err = UnmarshalSnapshot([]byte("not a snapshot"), map[string]*Cache{"start": restoredStart})
+	assert.Error(t, err)
+}
@@ -0,0 +1,308 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrictest provides an in-memory OpenTelemetry MeterProvider which
+// records the measurements made with its instruments, for use in tests.
+package metrictest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/asyncint64"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+)
+
+// InstrumentKind is the kind of an instrument.
+type InstrumentKind int
+
+const (
+	// Counter is a synchronous or asynchronous counter, which records the
+	// sum of its measurements.
+	Counter InstrumentKind = iota
+	// UpDownCounter is a synchronous or asynchronous up-down counter,
+	// which records the sum of its measurements.
+	UpDownCounter
+	// Histogram is a synchronous histogram, which records the count and sum
+	// of its measurements.
+	Histogram
+	// Gauge is an asynchronous gauge, which records its last measurement.
+	Gauge
+)
+
+// Point is the aggregated measurements of an instrument with one set of
+// attributes.
+type Point struct {
+	Attributes attribute.Set
+	// Value is the sum of the measurements of counters and histograms, or
+	// the last measurement of gauges.
+	Value float64
+	// Count is the number of measurements.
+	Count int64
+}
+
+// Instrument is a snapshot of the measurements made with an instrument.
+type Instrument struct {
+	Name        string
+	Description string
+	Unit        unit.Unit
+	Kind        InstrumentKind
+	// Int is true if the instrument records int64 measurements.
+	Int bool
+	// Points are sorted by their attributes.
+	Points []Point
+}
+
+// MeterProvider is a metric.MeterProvider which records measurements in
+// memory. All of its meters share the same instruments.
+type MeterProvider struct {
+	mu          sync.Mutex
+	instruments map[string]*instrumentState
+	callbacks   []func(context.Context)
+}
+
+type instrumentState struct {
+	Instrument
+	points map[attribute.Distinct]*Point
+}
+
+// NewMeterProvider creates a MeterProvider with no recorded measurements.
+func NewMeterProvider() *MeterProvider {
+	return &MeterProvider{instruments: make(map[string]*instrumentState)}
+}
+
+// Meter returns a Meter which records measurements in the MeterProvider.
+func (p *MeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return meter{p}
+}
+
+// Collect runs the registered callbacks and returns the instruments which
+// have recorded measurements, sorted by name.
+func (p *MeterProvider) Collect(ctx context.Context) []Instrument {
+	p.mu.Lock()
+	callbacks := append([]func(context.Context){}, p.callbacks...)
+	p.mu.Unlock()
+	for _, f := range callbacks {
+		f(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []Instrument
+	for _, state := range p.instruments {
+		if len(state.points) == 0 {
+			continue
+		}
+		inst := state.Instrument
+		for _, point := range state.points {
+			inst.Points = append(inst.Points, *point)
+		}
+		sort.Slice(inst.Points, func(i, j int) bool {
+			return inst.Points[i].Attributes.Encoded(attribute.DefaultEncoder()) <
+				inst.Points[j].Attributes.Encoded(attribute.DefaultEncoder())
+		})
+		result = append(result, inst)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Get returns the point recorded by the named instrument with the given
+// attributes, and whether it was found.
+func (p *MeterProvider) Get(ctx context.Context, name string, attrs ...attribute.KeyValue) (Point, bool) {
+	want := attribute.NewSet(attrs...)
+	for _, inst := range p.Collect(ctx) {
+		if inst.Name != name {
+			continue
+		}
+		for _, point := range inst.Points {
+			if point.Attributes.Equals(&want) {
+				return point, true
+			}
+		}
+	}
+	return Point{}, false
+}
+
+func (p *MeterProvider) instrument(name string, kind InstrumentKind, isInt bool, opts []instrument.Option) *instrumentState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state, ok := p.instruments[name]; ok {
+		return state
+	}
+	cfg := instrument.NewConfig(opts...)
+	state := &instrumentState{
+		Instrument: Instrument{
+			Name:        name,
+			Description: cfg.Description(),
+			Unit:        cfg.Unit(),
+			Kind:        kind,
+			Int:         isInt,
+		},
+		points: make(map[attribute.Distinct]*Point),
+	}
+	p.instruments[name] = state
+	return state
+}
+
+func (p *MeterProvider) record(state *instrumentState, value float64, attrs []attribute.KeyValue) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	set := attribute.NewSet(attrs...)
+	point, ok := state.points[set.Equivalent()]
+	if !ok {
+		point = &Point{Attributes: set}
+		state.points[set.Equivalent()] = point
+	}
+	point.Count++
+	if state.Kind == Gauge {
+		point.Value = value
+	} else {
+		point.Value += value
+	}
+}
+
+type meter struct {
+	p *MeterProvider
+}
+
+func (m meter) AsyncInt64() asyncint64.InstrumentProvider     { return asyncInt64Provider(m) }
+func (m meter) AsyncFloat64() asyncfloat64.InstrumentProvider { return asyncFloat64Provider(m) }
+func (m meter) SyncInt64() syncint64.InstrumentProvider       { return syncInt64Provider(m) }
+func (m meter) SyncFloat64() syncfloat64.InstrumentProvider   { return syncFloat64Provider(m) }
+
+func (m meter) RegisterCallback(_ []instrument.Asynchronous, f func(context.Context)) error {
+	m.p.mu.Lock()
+	defer m.p.mu.Unlock()
+	m.p.callbacks = append(m.p.callbacks, f)
+	return nil
+}
+
+// recorder implements every instrument interface. The embedded interfaces
+// satisfy their unexported methods, and are never called.
+type recorder struct {
+	instrument.Synchronous
+	instrument.Asynchronous
+	p     *MeterProvider
+	state *instrumentState
+}
+
+func (r recorder) recordInt64(v int64, attrs []attribute.KeyValue) {
+	r.p.record(r.state, float64(v), attrs)
+}
+
+func (r recorder) recordFloat64(v float64, attrs []attribute.KeyValue) {
+	r.p.record(r.state, v, attrs)
+}
+
+type int64Recorder struct{ recorder }
+
+func (r int64Recorder) Add(_ context.Context, v int64, attrs ...attribute.KeyValue) {
+	r.recordInt64(v, attrs)
+}
+
+func (r int64Recorder) Record(_ context.Context, v int64, attrs ...attribute.KeyValue) {
+	r.recordInt64(v, attrs)
+}
+
+func (r int64Recorder) Observe(_ context.Context, v int64, attrs ...attribute.KeyValue) {
+	r.recordInt64(v, attrs)
+}
+
+type float64Recorder struct{ recorder }
+
+func (r float64Recorder) Add(_ context.Context, v float64, attrs ...attribute.KeyValue) {
+	r.recordFloat64(v, attrs)
+}
+
+func (r float64Recorder) Record(_ context.Context, v float64, attrs ...attribute.KeyValue) {
+	r.recordFloat64(v, attrs)
+}
+
+func (r float64Recorder) Observe(_ context.Context, v float64, attrs ...attribute.KeyValue) {
+	r.recordFloat64(v, attrs)
+}
+
+func (m meter) int64Recorder(name string, kind InstrumentKind, opts []instrument.Option) int64Recorder {
+	return int64Recorder{recorder{p: m.p, state: m.p.instrument(name, kind, true, opts)}}
+}
+
+func (m meter) float64Recorder(name string, kind InstrumentKind, opts []instrument.Option) float64Recorder {
+	return float64Recorder{recorder{p: m.p, state: m.p.instrument(name, kind, false, opts)}}
+}
+
+type syncInt64Provider meter
+
+func (m syncInt64Provider) Counter(name string, opts ...instrument.Option) (syncint64.Counter, error) {
+	return meter(m).int64Recorder(name, Counter, opts), nil
+}
+
+func (m syncInt64Provider) UpDownCounter(name string, opts ...instrument.Option) (syncint64.UpDownCounter, error) {
+	return meter(m).int64Recorder(name, UpDownCounter, opts), nil
+}
+
+func (m syncInt64Provider) Histogram(name string, opts ...instrument.Option) (syncint64.Histogram, error) {
+	return meter(m).int64Recorder(name, Histogram, opts), nil
+}
+
+type syncFloat64Provider meter
+
+func (m syncFloat64Provider) Counter(name string, opts ...instrument.Option) (syncfloat64.Counter, error) {
+	return meter(m).float64Recorder(name, Counter, opts), nil
+}
+
+func (m syncFloat64Provider) UpDownCounter(name string, opts ...instrument.Option) (syncfloat64.UpDownCounter, error) {
+	return meter(m).float64Recorder(name, UpDownCounter, opts), nil
+}
+
+func (m syncFloat64Provider) Histogram(name string, opts ...instrument.Option) (syncfloat64.Histogram, error) {
+	return meter(m).float64Recorder(name, Histogram, opts), nil
+}
+
+type asyncInt64Provider meter
+
+func (m asyncInt64Provider) Counter(name string, opts ...instrument.Option) (asyncint64.Counter, error) {
+	return meter(m).int64Recorder(name, Counter, opts), nil
+}
+
+func (m asyncInt64Provider) UpDownCounter(name string, opts ...instrument.Option) (asyncint64.UpDownCounter, error) {
+	return meter(m).int64Recorder(name, UpDownCounter, opts), nil
+}
+
+func (m asyncInt64Provider) Gauge(name string, opts ...instrument.Option) (asyncint64.Gauge, error) {
+	return meter(m).int64Recorder(name, Gauge, opts), nil
+}
+
+type asyncFloat64Provider meter
+
+func (m asyncFloat64Provider) Counter(name string, opts ...instrument.Option) (asyncfloat64.Counter, error) {
+	return meter(m).float64Recorder(name, Counter, opts), nil
+}
+
+func (m asyncFloat64Provider) UpDownCounter(name string, opts ...instrument.Option) (asyncfloat64.UpDownCounter, error) {
+	return meter(m).float64Recorder(name, UpDownCounter, opts), nil
+}
+
+func (m asyncFloat64Provider) Gauge(name string, opts ...instrument.Option) (asyncfloat64.Gauge, error) {
+	return meter(m).float64Recorder(name, Gauge, opts), nil
+}
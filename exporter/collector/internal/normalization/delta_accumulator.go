@@ -0,0 +1,267 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package normalization
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+// NewDeltaAccumulator converts delta points into cumulative points by keeping
+// a running total for each series. The running total keeps the start time of
+// the first point it accumulated, so the resulting cumulative series has a
+// stable start time.
+// Points which are contiguous with the running total (their start time equals
+// the end time of the previous point, or is unset) are added to it. Points
+// which start after the end of the previous point leave a gap we can't account
+// for, so they are treated as a reset and start a new running total. Points
+// which end at or before the previous point, or which overlap it, are
+// out-of-order or duplicates, and are dropped. Points with a different value
+// type or bucket layout than the running total are also treated as a reset.
+func NewDeltaAccumulator(shutdown <-chan struct{}, logger *zap.Logger, cacheOptions datapointstorage.Options) Accumulator {
+	return &deltaAccumulator{
+		cache: datapointstorage.NewCache(shutdown, cacheOptions),
+		log:   logger,
+	}
+}
+
+type deltaAccumulator struct {
+	cache *datapointstorage.Cache
+	log   *zap.Logger
+}
+
+type accumulateAction int
+
+const (
+	accumulateActionAdd accumulateAction = iota
+	accumulateActionReset
+	accumulateActionDrop
+)
+
+// classifyDelta determines how a delta point covering (start, end] relates to
+// a running total which ends at previousEnd.
+func classifyDelta(start, end, previousEnd pcommon.Timestamp) accumulateAction {
+	switch {
+	case end <= previousEnd:
+		// out-of-order or duplicate point
+		return accumulateActionDrop
+	case start == 0 || start == previousEnd:
+		return accumulateActionAdd
+	case start < previousEnd:
+		// the point overlaps with points we have already accumulated
+		return accumulateActionDrop
+	default:
+		// there is a gap between the previous point and this one
+		return accumulateActionReset
+	}
+}
+
+// resetStartTimestamp returns the start timestamp to use for a new running
+// total beginning with a point with the given timestamps.
+func resetStartTimestamp(start, end pcommon.Timestamp) pcommon.Timestamp {
+	if start != 0 && start < end {
+		return start
+	}
+	// Assume the interval started at T - 1 ms, as we do for reset points.
+	return pcommon.Timestamp(uint64(end) - uint64(time.Millisecond))
+}
+
+// CacheStats returns the size and usage of the running totals cache.
+func (s *deltaAccumulator) CacheStats() datapointstorage.Stats {
+	return s.cache.Stats()
+}
+
+func (s *deltaAccumulator) logDrop(previousEnd, start, end pcommon.Timestamp) {
+	s.log.Debug(
+		"delta point overlaps with previously accumulated points, will not be emitted",
+		zap.String("lastAccumulated", previousEnd.String()),
+		zap.String("dataPointStart", start.String()),
+		zap.String("dataPoint", end.String()),
+	)
+}
+
+// AccumulateNumberDataPoint adds a delta, monotonic sum to the running total.
+func (s *deltaAccumulator) AccumulateNumberDataPoint(point pmetric.NumberDataPoint, identifier datapointstorage.Identifier) *pmetric.NumberDataPoint {
+	total, hasTotal := s.cache.GetNumberDataPoint(identifier)
+	action := accumulateActionReset
+	if hasTotal {
+		action = classifyDelta(point.StartTimestamp(), point.Timestamp(), total.Timestamp())
+		if action == accumulateActionAdd && point.ValueType() != total.ValueType() {
+			action = accumulateActionReset
+		}
+	}
+	switch action {
+	case accumulateActionDrop:
+		s.logDrop(total.Timestamp(), point.StartTimestamp(), point.Timestamp())
+		return nil
+	case accumulateActionReset:
+		// Make a copy so we don't mutate underlying data
+		newPoint := pmetric.NewNumberDataPoint()
+		point.CopyTo(newPoint)
+		newPoint.SetStartTimestamp(resetStartTimestamp(point.StartTimestamp(), point.Timestamp()))
+		s.cache.SetNumberDataPoint(identifier, &newPoint)
+		return &newPoint
+	}
+	newPoint := addNumberDataPoint(&point, total)
+	s.cache.SetNumberDataPoint(identifier, newPoint)
+	return newPoint
+}
+
+// addNumberDataPoint returns delta + total, using the start time of total.
+func addNumberDataPoint(delta, total *pmetric.NumberDataPoint) *pmetric.NumberDataPoint {
+	// Make a copy so we don't mutate underlying data
+	newPoint := pmetric.NewNumberDataPoint()
+	delta.CopyTo(newPoint)
+	newPoint.SetStartTimestamp(total.StartTimestamp())
+	switch newPoint.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		newPoint.SetIntVal(delta.IntVal() + total.IntVal())
+	case pmetric.NumberDataPointValueTypeDouble:
+		newPoint.SetDoubleVal(delta.DoubleVal() + total.DoubleVal())
+	}
+	return &newPoint
+}
+
+// AccumulateHistogramDataPoint adds a delta histogram to the running total.
+func (s *deltaAccumulator) AccumulateHistogramDataPoint(point pmetric.HistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.HistogramDataPoint {
+	total, hasTotal := s.cache.GetHistogramDataPoint(identifier)
+	action := accumulateActionReset
+	if hasTotal {
+		action = classifyDelta(point.StartTimestamp(), point.Timestamp(), total.Timestamp())
+		// The bucket boundaries changed, so we can't add the points.
+		if action == accumulateActionAdd && !bucketBoundariesEqual(point.MExplicitBounds(), total.MExplicitBounds()) {
+			action = accumulateActionReset
+		}
+	}
+	switch action {
+	case accumulateActionDrop:
+		s.logDrop(total.Timestamp(), point.StartTimestamp(), point.Timestamp())
+		return nil
+	case accumulateActionReset:
+		// Make a copy so we don't mutate underlying data
+		newPoint := pmetric.NewHistogramDataPoint()
+		point.CopyTo(newPoint)
+		newPoint.SetStartTimestamp(resetStartTimestamp(point.StartTimestamp(), point.Timestamp()))
+		s.cache.SetHistogramDataPoint(identifier, &newPoint)
+		return &newPoint
+	}
+	newPoint := addHistogramDataPoint(&point, total)
+	s.cache.SetHistogramDataPoint(identifier, newPoint)
+	return newPoint
+}
+
+// addHistogramDataPoint returns delta + total, using the start time of total.
+func addHistogramDataPoint(delta, total *pmetric.HistogramDataPoint) *pmetric.HistogramDataPoint {
+	// Make a copy so we don't mutate underlying data
+	newPoint := pmetric.NewHistogramDataPoint()
+	copyHistogramDataPointWithoutRange(*delta, newPoint)
+	addRange(histogramDataPointRange(delta), histogramDataPointRange(total)).setHistogramDataPoint(newPoint)
+	newPoint.SetStartTimestamp(total.StartTimestamp())
+	newPoint.SetCount(delta.Count() + total.Count())
+	// We drop points without a sum, so no need to check here.
+	newPoint.SetSum(delta.Sum() + total.Sum())
+	deltaBuckets := delta.MBucketCounts()
+	totalBuckets := total.MBucketCounts()
+	newBuckets := make([]uint64, len(deltaBuckets))
+	for i := range deltaBuckets {
+		newBuckets[i] = deltaBuckets[i]
+		if i < len(totalBuckets) {
+			newBuckets[i] += totalBuckets[i]
+		}
+	}
+	newPoint.SetMBucketCounts(newBuckets)
+	return &newPoint
+}
+
+// AccumulateExponentialHistogramDataPoint adds a delta exponential histogram
+// to the running total.
+func (s *deltaAccumulator) AccumulateExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint {
+	total, hasTotal := s.cache.GetExponentialHistogramDataPoint(identifier)
+	action := accumulateActionReset
+	if hasTotal {
+		action = classifyDelta(point.StartTimestamp(), point.Timestamp(), total.Timestamp())
+		// Buckets with different scales don't line up, so we can't add the points.
+		if action == accumulateActionAdd && point.Scale() != total.Scale() {
+			action = accumulateActionReset
+		}
+	}
+	switch action {
+	case accumulateActionDrop:
+		s.logDrop(total.Timestamp(), point.StartTimestamp(), point.Timestamp())
+		return nil
+	case accumulateActionReset:
+		// Make a copy so we don't mutate underlying data
+		newPoint := pmetric.NewExponentialHistogramDataPoint()
+		point.CopyTo(newPoint)
+		newPoint.SetStartTimestamp(resetStartTimestamp(point.StartTimestamp(), point.Timestamp()))
+		s.cache.SetExponentialHistogramDataPoint(identifier, &newPoint)
+		return &newPoint
+	}
+	newPoint := addExponentialHistogramDataPoint(&point, total)
+	s.cache.SetExponentialHistogramDataPoint(identifier, newPoint)
+	return newPoint
+}
+
+// addExponentialHistogramDataPoint returns delta + total, using the start time
+// of total. Both points must have the same scale.
+func addExponentialHistogramDataPoint(delta, total *pmetric.ExponentialHistogramDataPoint) *pmetric.ExponentialHistogramDataPoint {
+	// Make a copy so we don't mutate underlying data
+	newPoint := pmetric.NewExponentialHistogramDataPoint()
+	copyExponentialHistogramDataPointWithoutRange(*delta, newPoint)
+	addRange(exponentialHistogramDataPointRange(delta), exponentialHistogramDataPointRange(total)).setExponentialHistogramDataPoint(newPoint)
+	newPoint.SetStartTimestamp(total.StartTimestamp())
+	newPoint.SetCount(delta.Count() + total.Count())
+	newPoint.SetSum(delta.Sum() + total.Sum())
+	newPoint.SetZeroCount(delta.ZeroCount() + total.ZeroCount())
+	addExponentialBuckets(delta.Positive(), total.Positive(), newPoint.Positive())
+	addExponentialBuckets(delta.Negative(), total.Negative(), newPoint.Negative())
+	return &newPoint
+}
+
+// addExponentialBuckets sets dest to a + b. The buckets of the result span the
+// union of the ranges of a and b.
+func addExponentialBuckets(a, b, dest pmetric.Buckets) {
+	aCounts, bCounts := a.MBucketCounts(), b.MBucketCounts()
+	if len(aCounts) == 0 {
+		b.CopyTo(dest)
+		return
+	}
+	if len(bCounts) == 0 {
+		a.CopyTo(dest)
+		return
+	}
+	low := a.Offset()
+	if b.Offset() < low {
+		low = b.Offset()
+	}
+	high := a.Offset() + int32(len(aCounts))
+	if bHigh := b.Offset() + int32(len(bCounts)); bHigh > high {
+		high = bHigh
+	}
+	newBuckets := make([]uint64, high-low)
+	for i, v := range aCounts {
+		newBuckets[int32(i)+a.Offset()-low] += v
+	}
+	for i, v := range bCounts {
+		newBuckets[int32(i)+b.Offset()-low] += v
+	}
+	dest.SetOffset(low)
+	dest.SetMBucketCounts(newBuckets)
+}
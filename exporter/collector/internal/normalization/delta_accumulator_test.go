@@ -0,0 +1,65 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package normalization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+func TestAccumulateNumberDataPointAcrossGap(t *testing.T) {
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	accumulator := NewDeltaAccumulator(shutdown, zap.NewNop(), datapointstorage.Options{})
+	id := testIdentifier("delta_counter")
+	newPoint := func(start, end int, value int64) pmetric.NumberDataPoint {
+		point := pmetric.NewNumberDataPoint()
+		point.SetStartTimestamp(testTimestamp(start))
+		point.SetTimestamp(testTimestamp(end))
+		point.SetIntVal(value)
+		return point
+	}
+
+	for _, step := range []struct {
+		desc          string
+		point         pmetric.NumberDataPoint
+		expectedStart int
+		expected      int64
+		expectedDrop  bool
+	}{
+		{desc: "first point", point: newPoint(0, 1, 1), expectedStart: 0, expected: 1},
+		{desc: "contiguous point", point: newPoint(1, 2, 2), expectedStart: 0, expected: 3},
+		{desc: "point after a gap", point: newPoint(3, 4, 4), expectedStart: 3, expected: 4},
+		{desc: "contiguous point after the gap", point: newPoint(4, 5, 1), expectedStart: 3, expected: 5},
+		{desc: "overlapping point", point: newPoint(2, 6, 10), expectedDrop: true},
+		{desc: "duplicate point", point: newPoint(4, 5, 1), expectedDrop: true},
+	} {
+		got := accumulator.AccumulateNumberDataPoint(step.point, id)
+		if step.expectedDrop {
+			assert.Nil(t, got, step.desc)
+			continue
+		}
+		require.NotNil(t, got, step.desc)
+		assert.Equal(t, testTimestamp(step.expectedStart), got.StartTimestamp(), step.desc)
+		assert.Equal(t, step.point.Timestamp(), got.Timestamp(), step.desc)
+		assert.Equal(t, step.expected, got.IntVal(), step.desc)
+	}
+}
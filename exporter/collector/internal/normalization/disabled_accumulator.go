@@ -0,0 +1,50 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package normalization
+
+import (
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+// NewDisabledAccumulator returns an Accumulator which does not accumulate
+// delta points. Each delta point is exported as a cumulative point covering
+// only its own interval.
+func NewDisabledAccumulator() Accumulator {
+	return &disabledAccumulator{}
+}
+
+type disabledAccumulator struct{}
+
+// AccumulateExponentialHistogramDataPoint returns the point without accumulating.
+func (d *disabledAccumulator) AccumulateExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, _ datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint {
+	return &point
+}
+
+// AccumulateHistogramDataPoint returns the point without accumulating.
+func (d *disabledAccumulator) AccumulateHistogramDataPoint(point pmetric.HistogramDataPoint, _ datapointstorage.Identifier) *pmetric.HistogramDataPoint {
+	return &point
+}
+
+// AccumulateNumberDataPoint returns the point without accumulating.
+func (d *disabledAccumulator) AccumulateNumberDataPoint(point pmetric.NumberDataPoint, _ datapointstorage.Identifier) *pmetric.NumberDataPoint {
+	return &point
+}
+
+// CacheStats returns empty stats, since nothing is cached.
+func (d *disabledAccumulator) CacheStats() datapointstorage.Stats {
+	return datapointstorage.Stats{}
+}
@@ -19,6 +19,8 @@ import (
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
 )
 
 // NewDisabledNormalizer returns a Normalizer which does not perform any
@@ -34,7 +36,7 @@ func NewDisabledNormalizer() Normalizer {
 type disabledNormalizer struct{}
 
 // NormalizeExponentialHistogramDataPoint returns the point without normalizing.
-func (d *disabledNormalizer) NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, _ string) *pmetric.ExponentialHistogramDataPoint {
+func (d *disabledNormalizer) NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, _ datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint {
 	if !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
 		// Handle explicit reset points.
 		// Make a copy so we don't mutate underlying data.
@@ -48,7 +50,7 @@ func (d *disabledNormalizer) NormalizeExponentialHistogramDataPoint(point pmetri
 }
 
 // NormalizeHistogramDataPoint returns the point without normalizing.
-func (d *disabledNormalizer) NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, _ string) *pmetric.HistogramDataPoint {
+func (d *disabledNormalizer) NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, _ datapointstorage.Identifier) *pmetric.HistogramDataPoint {
 	if !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
 		// Handle explicit reset points.
 		// Make a copy so we don't mutate underlying data.
@@ -62,7 +64,7 @@ func (d *disabledNormalizer) NormalizeHistogramDataPoint(point pmetric.Histogram
 }
 
 // NormalizeNumberDataPoint returns the point without normalizing.
-func (d *disabledNormalizer) NormalizeNumberDataPoint(point pmetric.NumberDataPoint, _ string) *pmetric.NumberDataPoint {
+func (d *disabledNormalizer) NormalizeNumberDataPoint(point pmetric.NumberDataPoint, _ datapointstorage.Identifier) *pmetric.NumberDataPoint {
 	if !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
 		// Handle explicit reset points.
 		// Make a copy so we don't mutate underlying data.
@@ -76,7 +78,7 @@ func (d *disabledNormalizer) NormalizeNumberDataPoint(point pmetric.NumberDataPo
 }
 
 // NormalizeSummaryDataPoint returns the point without normalizing.
-func (d *disabledNormalizer) NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, _ string) *pmetric.SummaryDataPoint {
+func (d *disabledNormalizer) NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, _ datapointstorage.Identifier) *pmetric.SummaryDataPoint {
 	if !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
 		// Handle explicit reset points.
 		// Make a copy so we don't mutate underlying data.
@@ -88,3 +90,8 @@ func (d *disabledNormalizer) NormalizeSummaryDataPoint(point pmetric.SummaryData
 	}
 	return &point
 }
+
+// CacheStats returns empty stats, since nothing is cached.
+func (d *disabledNormalizer) CacheStats() datapointstorage.Stats {
+	return datapointstorage.Stats{}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package normalization
+
+import (
+	"math"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// The min and max of a histogram point are the extremes of the observations
+// between its start time and its timestamp. Subtracting or adding points
+// changes that interval, so the range of the result is only kept when it can
+// be derived exactly. Otherwise the result has no min or max, rather than an
+// approximation.
+
+// copyHistogramDataPointWithoutRange copies src to dest, except for its min
+// and max. pdata can't clear the min and max once they are set.
+func copyHistogramDataPointWithoutRange(src, dest pmetric.HistogramDataPoint) {
+	src.Attributes().CopyTo(dest.Attributes())
+	dest.SetStartTimestamp(src.StartTimestamp())
+	dest.SetTimestamp(src.Timestamp())
+	dest.SetCount(src.Count())
+	dest.SetSum(src.Sum())
+	dest.SetMBucketCounts(append([]uint64(nil), src.MBucketCounts()...))
+	dest.SetMExplicitBounds(append([]float64(nil), src.MExplicitBounds()...))
+	src.Exemplars().CopyTo(dest.Exemplars())
+	dest.SetFlags(src.Flags())
+}
+
+// copyExponentialHistogramDataPointWithoutRange copies src to dest, except
+// for its min and max.
+func copyExponentialHistogramDataPointWithoutRange(src, dest pmetric.ExponentialHistogramDataPoint) {
+	src.Attributes().CopyTo(dest.Attributes())
+	dest.SetStartTimestamp(src.StartTimestamp())
+	dest.SetTimestamp(src.Timestamp())
+	dest.SetCount(src.Count())
+	dest.SetSum(src.Sum())
+	dest.SetScale(src.Scale())
+	dest.SetZeroCount(src.ZeroCount())
+	src.Positive().CopyTo(dest.Positive())
+	src.Negative().CopyTo(dest.Negative())
+	src.Exemplars().CopyTo(dest.Exemplars())
+	dest.SetFlags(src.Flags())
+}
+
+// histogramRange is the min and max of a histogram point, and whether they
+// are known.
+type histogramRange struct {
+	count          uint64
+	min, max       float64
+	hasMin, hasMax bool
+}
+
+func histogramDataPointRange(p *pmetric.HistogramDataPoint) histogramRange {
+	return histogramRange{count: p.Count(), min: p.Min(), max: p.Max(), hasMin: p.HasMin(), hasMax: p.HasMax()}
+}
+
+func exponentialHistogramDataPointRange(p *pmetric.ExponentialHistogramDataPoint) histogramRange {
+	return histogramRange{count: p.Count(), min: p.Min(), max: p.Max(), hasMin: p.HasMin(), hasMax: p.HasMax()}
+}
+
+// subtractRange returns the range of the observations between the timestamps
+// of the cumulative points b and a. The min of a is only known to have been
+// observed after b if it is lower than the min of b, and likewise for the max.
+func subtractRange(a, b histogramRange) histogramRange {
+	if b.count == 0 {
+		// Nothing was observed before b, such as for the zero point stored
+		// after a reset.
+		return a
+	}
+	return histogramRange{
+		count:  a.count - b.count,
+		min:    a.min,
+		max:    a.max,
+		hasMin: a.hasMin && b.hasMin && a.min < b.min,
+		hasMax: a.hasMax && b.hasMax && a.max > b.max,
+	}
+}
+
+// addRange returns the range of the observations of both a and b.
+func addRange(a, b histogramRange) histogramRange {
+	if a.count == 0 {
+		return b
+	}
+	if b.count == 0 {
+		return a
+	}
+	return histogramRange{
+		count:  a.count + b.count,
+		min:    math.Min(a.min, b.min),
+		max:    math.Max(a.max, b.max),
+		hasMin: a.hasMin && b.hasMin,
+		hasMax: a.hasMax && b.hasMax,
+	}
+}
+
+func (r histogramRange) setHistogramDataPoint(p pmetric.HistogramDataPoint) {
+	if r.hasMin {
+		p.SetMin(r.min)
+	}
+	if r.hasMax {
+		p.SetMax(r.max)
+	}
+}
+
+func (r histogramRange) setExponentialHistogramDataPoint(p pmetric.ExponentialHistogramDataPoint) {
+	if r.hasMin {
+		p.SetMin(r.min)
+	}
+	if r.hasMax {
+		p.SetMax(r.max)
+	}
+}
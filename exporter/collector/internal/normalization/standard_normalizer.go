@@ -31,10 +31,11 @@ import (
 // NOT exported. Subsequent points "subtract" the initial point prior to exporting.
 // This normalizer also detects subsequent resets, and produces a new start time for those points.
 // It doesn't modify values after a reset, but does give it a new start time.
-func NewStandardNormalizer(shutdown <-chan struct{}, logger *zap.Logger) Normalizer {
+// The start and previous points are each limited by cacheOptions.
+func NewStandardNormalizer(shutdown <-chan struct{}, logger *zap.Logger, cacheOptions datapointstorage.Options) Normalizer {
 	return &standardNormalizer{
-		startCache:    datapointstorage.NewCache(shutdown),
-		previousCache: datapointstorage.NewCache(shutdown),
+		startCache:    datapointstorage.NewCache(shutdown, cacheOptions),
+		previousCache: datapointstorage.NewCache(shutdown, cacheOptions),
 		log:           logger,
 	}
 }
@@ -45,7 +46,30 @@ type standardNormalizer struct {
 	log           *zap.Logger
 }
 
-func (s *standardNormalizer) NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier string) *pmetric.ExponentialHistogramDataPoint {
+// CacheStats returns the combined size and usage of the start and previous
+// point caches.
+func (s *standardNormalizer) CacheStats() datapointstorage.Stats {
+	return s.startCache.Stats().Add(s.previousCache.Stats())
+}
+
+// MarshalSnapshot encodes the start and previous points.
+func (s *standardNormalizer) MarshalSnapshot() ([]byte, error) {
+	return datapointstorage.MarshalSnapshot(s.caches())
+}
+
+// UnmarshalSnapshot restores the start and previous points from a snapshot.
+func (s *standardNormalizer) UnmarshalSnapshot(data []byte) (int, error) {
+	return datapointstorage.UnmarshalSnapshot(data, s.caches())
+}
+
+func (s *standardNormalizer) caches() map[string]*datapointstorage.Cache {
+	return map[string]*datapointstorage.Cache{
+		"start":    s.startCache,
+		"previous": s.previousCache,
+	}
+}
+
+func (s *standardNormalizer) NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint {
 	start, hasStart := s.startCache.GetExponentialHistogramDataPoint(identifier)
 	if !hasStart {
 		if point.StartTimestamp() == 0 || !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
@@ -61,16 +85,6 @@ func (s *standardNormalizer) NormalizeExponentialHistogramDataPoint(point pmetri
 		return &point
 	}
 
-	// TODO(#366): It is possible, but difficult to compare exponential
-	// histograms with different scales. For now, treat a change in
-	// scale as a reset.  Drop this point, and normalize against it for
-	// subsequent points.
-	if point.Scale() != start.Scale() {
-		s.startCache.SetExponentialHistogramDataPoint(identifier, &point)
-		s.previousCache.SetExponentialHistogramDataPoint(identifier, &point)
-		return nil
-	}
-
 	previous, hasPrevious := s.previousCache.GetExponentialHistogramDataPoint(identifier)
 	if !hasPrevious {
 		// This should never happen, but fall-back to the start point if we
@@ -79,22 +93,7 @@ func (s *standardNormalizer) NormalizeExponentialHistogramDataPoint(point pmetri
 	}
 	if !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) ||
 		(point.StartTimestamp() == 0 && lessThanExponentialHistogramDataPoint(&point, previous)) {
-		// Make a copy so we don't mutate underlying data
-		newPoint := pmetric.NewExponentialHistogramDataPoint()
-		// This is a reset point, but we have seen this timeseries before, so we know the reset happened in the time period since the last point.
-		// Assume the reset occurred at T - 1 ms, and leave the value untouched.
-		point.CopyTo(newPoint)
-		newPoint.SetStartTimestamp(pcommon.Timestamp(uint64(point.Timestamp()) - uint64(time.Millisecond)))
-		s.previousCache.SetExponentialHistogramDataPoint(identifier, &newPoint)
-		// For subsequent points, we don't want to modify the value, but we do
-		// want to make the start timestamp match the point we write here.
-		// Store a point with the same timestamps, but zero value to achieve
-		// that behavior.
-		zeroPoint := pmetric.NewExponentialHistogramDataPoint()
-		zeroPoint.SetTimestamp(newPoint.StartTimestamp())
-		zeroPoint.SetScale(newPoint.Scale())
-		s.startCache.SetExponentialHistogramDataPoint(identifier, &zeroPoint)
-		return &newPoint
+		return s.resetExponentialHistogramDataPoint(point, identifier)
 	}
 	if !start.Timestamp().AsTime().Before(point.Timestamp().AsTime()) {
 		// We found a cached start timestamp that wouldn't produce a valid point.
@@ -107,21 +106,60 @@ func (s *standardNormalizer) NormalizeExponentialHistogramDataPoint(point pmetri
 		return nil
 	}
 	// There was no reset, so normalize the point against the start point
-	newPoint := subtractExponentialHistogramDataPoint(&point, start)
+	newPoint, ok := subtractExponentialHistogramDataPoint(&point, start)
+	if !ok {
+		// Some bucket has fewer observations than the start point, so the
+		// histogram must have been reset since.
+		return s.resetExponentialHistogramDataPoint(point, identifier)
+	}
 	s.previousCache.SetExponentialHistogramDataPoint(identifier, newPoint)
 	return newPoint
 }
 
-// lessThanExponentialHistogramDataPoint returns a < b
+// resetExponentialHistogramDataPoint handles a reset point of a timeseries
+// we have seen before.
+func (s *standardNormalizer) resetExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint {
+	// Make a copy so we don't mutate underlying data
+	newPoint := pmetric.NewExponentialHistogramDataPoint()
+	// This is a reset point, but we have seen this timeseries before, so we know the reset happened in the time period since the last point.
+	// Assume the reset occurred at T - 1 ms, and leave the value untouched.
+	point.CopyTo(newPoint)
+	newPoint.SetStartTimestamp(pcommon.Timestamp(uint64(point.Timestamp()) - uint64(time.Millisecond)))
+	s.previousCache.SetExponentialHistogramDataPoint(identifier, &newPoint)
+	// For subsequent points, we don't want to modify the value, but we do
+	// want to make the start timestamp match the point we write here.
+	// Store a point with the same timestamps, but zero value to achieve
+	// that behavior.
+	zeroPoint := pmetric.NewExponentialHistogramDataPoint()
+	zeroPoint.SetTimestamp(newPoint.StartTimestamp())
+	zeroPoint.SetScale(newPoint.Scale())
+	s.startCache.SetExponentialHistogramDataPoint(identifier, &zeroPoint)
+	return &newPoint
+}
+
+// lessThanExponentialHistogramDataPoint returns true if a has fewer
+// observations than b in total, or in any bucket once both are at the same
+// scale. The sum isn't compared, since it decreases when negative values are
+// observed.
 func lessThanExponentialHistogramDataPoint(a, b *pmetric.ExponentialHistogramDataPoint) bool {
-	return a.Count() < b.Count() || a.Sum() < b.Sum()
+	_, _, _, ok := subtractExponentialHistogramBuckets(a, b)
+	return !ok
 }
 
-// subtractExponentialHistogramDataPoint returns a - b
-func subtractExponentialHistogramDataPoint(a, b *pmetric.ExponentialHistogramDataPoint) *pmetric.ExponentialHistogramDataPoint {
+// subtractExponentialHistogramDataPoint returns a - b. If the points have
+// different scales, their buckets are merged to the lower of the two scales
+// first. It returns false if a has fewer observations than b in total or in
+// any bucket, which means the histogram was reset.
+func subtractExponentialHistogramDataPoint(a, b *pmetric.ExponentialHistogramDataPoint) (*pmetric.ExponentialHistogramDataPoint, bool) {
+	scale, positive, negative, ok := subtractExponentialHistogramBuckets(a, b)
+	if !ok {
+		return nil, false
+	}
+
 	// Make a copy so we don't mutate underlying data
 	newPoint := pmetric.NewExponentialHistogramDataPoint()
-	a.CopyTo(newPoint)
+	copyExponentialHistogramDataPointWithoutRange(*a, newPoint)
+	subtractRange(exponentialHistogramDataPointRange(a), exponentialHistogramDataPointRange(b)).setExponentialHistogramDataPoint(newPoint)
 	// Use the timestamp from the normalization point
 	newPoint.SetStartTimestamp(b.Timestamp())
 	// Adjust the value based on the start point's value
@@ -129,28 +167,78 @@ func subtractExponentialHistogramDataPoint(a, b *pmetric.ExponentialHistogramDat
 	// We drop points without a sum, so no need to check here.
 	newPoint.SetSum(a.Sum() - b.Sum())
 	newPoint.SetZeroCount(a.ZeroCount() - b.ZeroCount())
-	newPoint.Positive().SetMBucketCounts(subtractExponentialBuckets(a.Positive(), b.Positive()))
-	newPoint.Negative().SetMBucketCounts(subtractExponentialBuckets(a.Negative(), b.Negative()))
-	return &newPoint
+	newPoint.SetScale(scale)
+	newPoint.Positive().SetOffset(positive.offset)
+	newPoint.Positive().SetMBucketCounts(positive.counts)
+	newPoint.Negative().SetOffset(negative.offset)
+	newPoint.Negative().SetMBucketCounts(negative.counts)
+	return &newPoint, true
 }
 
-// subtractExponentialBuckets returns a - b
-func subtractExponentialBuckets(a, b pmetric.Buckets) []uint64 {
-	newBuckets := make([]uint64, len(a.MBucketCounts()))
-	offsetDiff := int(a.Offset() - b.Offset())
-	for i := range a.MBucketCounts() {
-		bOffset := i + offsetDiff
-		// if there is no corresponding bucket for the starting MBucketCounts, don't normalize
-		if bOffset < 0 || bOffset >= len(b.MBucketCounts()) {
-			newBuckets[i] = a.MBucketCounts()[i]
-		} else {
-			newBuckets[i] = a.MBucketCounts()[i] - b.MBucketCounts()[bOffset]
+// subtractExponentialHistogramBuckets returns the scale and the positive and
+// negative buckets of a - b. It returns false if a has fewer observations
+// than b in total or in any bucket.
+func subtractExponentialHistogramBuckets(a, b *pmetric.ExponentialHistogramDataPoint) (int32, exponentialBuckets, exponentialBuckets, bool) {
+	if a.Count() < b.Count() || a.ZeroCount() < b.ZeroCount() {
+		return 0, exponentialBuckets{}, exponentialBuckets{}, false
+	}
+	scale := a.Scale()
+	// A point without buckets, such as the zero point stored after a reset,
+	// can be subtracted at any scale.
+	if b.Scale() < scale && (len(b.Positive().MBucketCounts()) > 0 || len(b.Negative().MBucketCounts()) > 0) {
+		scale = b.Scale()
+	}
+	positive, ok := subtractExponentialBuckets(
+		downscaleExponentialBuckets(a.Positive(), a.Scale()-scale),
+		downscaleExponentialBuckets(b.Positive(), b.Scale()-scale),
+	)
+	if !ok {
+		return 0, exponentialBuckets{}, exponentialBuckets{}, false
+	}
+	negative, ok := subtractExponentialBuckets(
+		downscaleExponentialBuckets(a.Negative(), a.Scale()-scale),
+		downscaleExponentialBuckets(b.Negative(), b.Scale()-scale),
+	)
+	if !ok {
+		return 0, exponentialBuckets{}, exponentialBuckets{}, false
+	}
+	return scale, positive, negative, true
+}
+
+// exponentialBuckets are the bucket counts of one range of an exponential
+// histogram, where counts[i] is the count of the bucket with index offset+i.
+type exponentialBuckets struct {
+	offset int32
+	counts []uint64
+}
+
+// downscaleExponentialBuckets returns a copy of the buckets at a scale which
+// is lower by the given amount.
+func downscaleExponentialBuckets(b pmetric.Buckets, by int32) exponentialBuckets {
+	offset, counts := datapointstorage.DownscaleBuckets(b, by)
+	return exponentialBuckets{offset: offset, counts: counts}
+}
+
+// subtractExponentialBuckets returns a - b, which must be at the same scale.
+// It returns false if any bucket of b has a greater count than the same
+// bucket of a.
+func subtractExponentialBuckets(a, b exponentialBuckets) (exponentialBuckets, bool) {
+	newCounts := make([]uint64, len(a.counts))
+	copy(newCounts, a.counts)
+	for j, count := range b.counts {
+		if count == 0 {
+			continue
 		}
+		i := int(b.offset-a.offset) + j
+		if i < 0 || i >= len(newCounts) || newCounts[i] < count {
+			return exponentialBuckets{}, false
+		}
+		newCounts[i] -= count
 	}
-	return newBuckets
+	return exponentialBuckets{offset: a.offset, counts: newCounts}, true
 }
 
-func (s *standardNormalizer) NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, identifier string) *pmetric.HistogramDataPoint {
+func (s *standardNormalizer) NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.HistogramDataPoint {
 	start, hasStart := s.startCache.GetHistogramDataPoint(identifier)
 	if !hasStart {
 		if point.StartTimestamp() == 0 || !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
@@ -225,7 +313,8 @@ func lessThanHistogramDataPoint(a, b *pmetric.HistogramDataPoint) bool {
 func subtractHistogramDataPoint(a, b *pmetric.HistogramDataPoint) *pmetric.HistogramDataPoint {
 	// Make a copy so we don't mutate underlying data
 	newPoint := pmetric.NewHistogramDataPoint()
-	a.CopyTo(newPoint)
+	copyHistogramDataPointWithoutRange(*a, newPoint)
+	subtractRange(histogramDataPointRange(a), histogramDataPointRange(b)).setHistogramDataPoint(newPoint)
 	// Use the timestamp from the normalization point
 	newPoint.SetStartTimestamp(b.Timestamp())
 	// Adjust the value based on the start point's value
@@ -256,7 +345,7 @@ func bucketBoundariesEqual(a, b []float64) bool {
 
 // NormalizeNumberDataPoint normalizes a cumulative, monotonic sum.
 // It returns the normalized point, or nil if the point should be dropped.
-func (s *standardNormalizer) NormalizeNumberDataPoint(point pmetric.NumberDataPoint, identifier string) *pmetric.NumberDataPoint {
+func (s *standardNormalizer) NormalizeNumberDataPoint(point pmetric.NumberDataPoint, identifier datapointstorage.Identifier) *pmetric.NumberDataPoint {
 	start, hasStart := s.startCache.GetNumberDataPoint(identifier)
 	if !hasStart {
 		if point.StartTimestamp() == 0 || !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
@@ -339,7 +428,7 @@ func subtractNumberDataPoint(a, b *pmetric.NumberDataPoint) *pmetric.NumberDataP
 	return &newPoint
 }
 
-func (s *standardNormalizer) NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, identifier string) *pmetric.SummaryDataPoint {
+func (s *standardNormalizer) NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, identifier datapointstorage.Identifier) *pmetric.SummaryDataPoint {
 	start, hasStart := s.startCache.GetSummaryDataPoint(identifier)
 	if !hasStart {
 		if point.StartTimestamp() == 0 || !point.StartTimestamp().AsTime().Before(point.Timestamp().AsTime()) {
@@ -0,0 +1,194 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package normalization
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+var testStart = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// testTimestamp returns the timestamp the given number of seconds after
+// testStart.
+func testTimestamp(seconds int) pcommon.Timestamp {
+	return pcommon.NewTimestampFromTime(testStart.Add(time.Duration(seconds) * time.Second))
+}
+
+func testIdentifier(name string) datapointstorage.Identifier {
+	metric := pmetric.NewMetric()
+	metric.SetName(name)
+	return datapointstorage.NewIdentifier(&monitoredrespb.MonitoredResource{Type: "global"}, nil, metric, pcommon.NewMap())
+}
+
+func newTestNormalizer(t *testing.T) Normalizer {
+	shutdown := make(chan struct{})
+	t.Cleanup(func() { close(shutdown) })
+	return NewStandardNormalizer(shutdown, zap.NewNop(), datapointstorage.Options{})
+}
+
+func TestNormalizeNumberDataPointResets(t *testing.T) {
+	normalizer := newTestNormalizer(t)
+	id := testIdentifier("counter")
+	newPoint := func(start, end int, value int64) pmetric.NumberDataPoint {
+		point := pmetric.NewNumberDataPoint()
+		if start >= 0 {
+			point.SetStartTimestamp(testTimestamp(start))
+		}
+		point.SetTimestamp(testTimestamp(end))
+		point.SetIntVal(value)
+		return point
+	}
+
+	// The first point without a start time is the reference for later points.
+	assert.Nil(t, normalizer.NormalizeNumberDataPoint(newPoint(-1, 1, 5), id))
+
+	got := normalizer.NormalizeNumberDataPoint(newPoint(-1, 2, 8), id)
+	require.NotNil(t, got)
+	assert.Equal(t, testTimestamp(1), got.StartTimestamp())
+	assert.Equal(t, int64(3), got.IntVal())
+
+	// A decreasing value is a reset, which is assumed to have happened just
+	// before the point.
+	got = normalizer.NormalizeNumberDataPoint(newPoint(-1, 3, 2), id)
+	require.NotNil(t, got)
+	resetStart := pcommon.Timestamp(uint64(testTimestamp(3)) - uint64(time.Millisecond))
+	assert.Equal(t, resetStart, got.StartTimestamp())
+	assert.Equal(t, int64(2), got.IntVal())
+
+	// Points after the reset keep its start time and their value.
+	got = normalizer.NormalizeNumberDataPoint(newPoint(-1, 4, 6), id)
+	require.NotNil(t, got)
+	assert.Equal(t, resetStart, got.StartTimestamp())
+	assert.Equal(t, int64(6), got.IntVal())
+
+	// An explicit reset point, with a start time equal to its end time.
+	got = normalizer.NormalizeNumberDataPoint(newPoint(5, 5, 1), id)
+	require.NotNil(t, got)
+	assert.Equal(t, pcommon.Timestamp(uint64(testTimestamp(5))-uint64(time.Millisecond)), got.StartTimestamp())
+	assert.Equal(t, int64(1), got.IntVal())
+
+	// Points older than the last reset are dropped.
+	assert.Nil(t, normalizer.NormalizeNumberDataPoint(newPoint(-1, 4, 7), id))
+}
+
+func TestNormalizeExponentialHistogramDataPointScaleChangeThenReset(t *testing.T) {
+	normalizer := newTestNormalizer(t)
+	id := testIdentifier("exponential_histogram")
+	newPoint := func(end int, scale int32, counts ...uint64) pmetric.ExponentialHistogramDataPoint {
+		point := pmetric.NewExponentialHistogramDataPoint()
+		point.SetTimestamp(testTimestamp(end))
+		point.SetScale(scale)
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		point.SetCount(count)
+		point.SetSum(float64(count))
+		point.Positive().SetMBucketCounts(counts)
+		return point
+	}
+
+	assert.Nil(t, normalizer.NormalizeExponentialHistogramDataPoint(newPoint(1, 1, 2, 2), id))
+
+	// The scale decreased, so the reference point is downscaled before it is
+	// subtracted.
+	got := normalizer.NormalizeExponentialHistogramDataPoint(newPoint(2, 0, 5), id)
+	require.NotNil(t, got)
+	assert.Equal(t, testTimestamp(1), got.StartTimestamp())
+	assert.Equal(t, int32(0), got.Scale())
+	assert.Equal(t, uint64(1), got.Count())
+	assert.Equal(t, []uint64{1}, got.Positive().MBucketCounts())
+
+	// The bucket has fewer observations than the reference point at the new
+	// scale, so the histogram was reset.
+	got = normalizer.NormalizeExponentialHistogramDataPoint(newPoint(3, 0, 1), id)
+	require.NotNil(t, got)
+	resetStart := pcommon.Timestamp(uint64(testTimestamp(3)) - uint64(time.Millisecond))
+	assert.Equal(t, resetStart, got.StartTimestamp())
+	assert.Equal(t, uint64(1), got.Count())
+	assert.Equal(t, []uint64{1}, got.Positive().MBucketCounts())
+
+	// Points after the reset keep its start time and their buckets, even at
+	// a different scale.
+	got = normalizer.NormalizeExponentialHistogramDataPoint(newPoint(4, 1, 1, 2), id)
+	require.NotNil(t, got)
+	assert.Equal(t, resetStart, got.StartTimestamp())
+	assert.Equal(t, int32(1), got.Scale())
+	assert.Equal(t, uint64(3), got.Count())
+	assert.Equal(t, []uint64{1, 2}, got.Positive().MBucketCounts())
+}
+
+func TestLessThanExponentialHistogramDataPoint(t *testing.T) {
+	newPoint := func(scale int32, offset int32, counts ...uint64) *pmetric.ExponentialHistogramDataPoint {
+		point := pmetric.NewExponentialHistogramDataPoint()
+		point.SetScale(scale)
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		point.SetCount(count)
+		point.Positive().SetOffset(offset)
+		point.Positive().SetMBucketCounts(counts)
+		return &point
+	}
+	for _, tc := range []struct {
+		desc     string
+		a, b     *pmetric.ExponentialHistogramDataPoint
+		expected bool
+	}{
+		{
+			desc: "more observations in every bucket",
+			a:    newPoint(0, 0, 2, 2),
+			b:    newPoint(0, 0, 1, 1),
+		},
+		{
+			desc:     "fewer observations in total",
+			a:        newPoint(0, 0, 1),
+			b:        newPoint(0, 0, 2),
+			expected: true,
+		},
+		{
+			desc:     "fewer observations in one bucket",
+			a:        newPoint(0, 0, 0, 4),
+			b:        newPoint(0, 0, 1, 1),
+			expected: true,
+		},
+		{
+			desc: "more observations at a lower scale",
+			a:    newPoint(0, 0, 3),
+			b:    newPoint(1, 0, 1, 1),
+		},
+		{
+			desc:     "fewer observations in one bucket at a lower scale",
+			a:        newPoint(0, 0, 1, 3),
+			b:        newPoint(1, 0, 2, 1),
+			expected: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, lessThanExponentialHistogramDataPoint(tc.a, tc.b))
+		})
+	}
+}
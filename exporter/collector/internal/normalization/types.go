@@ -16,20 +16,52 @@ package normalization
 
 import (
 	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
 )
 
 // Normalizer can normalize data points to handle cases in which the start time is unknown.
 type Normalizer interface {
 	// NormalizeExponentialHistogramDataPoint normalizes an exponential histogram.
 	// It returns the normalized point, or nil if the point should be dropped.
-	NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier string) *pmetric.ExponentialHistogramDataPoint
+	NormalizeExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint
 	// NormalizeHistogramDataPoint normalizes a cumulative histogram.
 	// It returns the normalized point, or nil if the point should be dropped.
-	NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, identifier string) *pmetric.HistogramDataPoint
+	NormalizeHistogramDataPoint(point pmetric.HistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.HistogramDataPoint
 	// NormalizeNumberDataPoint normalizes a cumulative, monotonic sum.
 	// It returns the normalized point, or nil if the point should be dropped.
-	NormalizeNumberDataPoint(point pmetric.NumberDataPoint, identifier string) *pmetric.NumberDataPoint
+	NormalizeNumberDataPoint(point pmetric.NumberDataPoint, identifier datapointstorage.Identifier) *pmetric.NumberDataPoint
 	// NormalizeSummaryDataPoint normalizes a summary.
 	// It returns the normalized point, or nil if the point should be dropped.
-	NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, identifier string) *pmetric.SummaryDataPoint
+	NormalizeSummaryDataPoint(point pmetric.SummaryDataPoint, identifier datapointstorage.Identifier) *pmetric.SummaryDataPoint
+	// CacheStats returns the size and usage of the normalizer's caches.
+	CacheStats() datapointstorage.Stats
+}
+
+// Accumulator can convert delta data points into cumulative data points.
+type Accumulator interface {
+	// AccumulateExponentialHistogramDataPoint adds a delta exponential
+	// histogram to the running total for its series.
+	// It returns the cumulative point, or nil if the point should be dropped.
+	AccumulateExponentialHistogramDataPoint(point pmetric.ExponentialHistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.ExponentialHistogramDataPoint
+	// AccumulateHistogramDataPoint adds a delta histogram to the running
+	// total for its series.
+	// It returns the cumulative point, or nil if the point should be dropped.
+	AccumulateHistogramDataPoint(point pmetric.HistogramDataPoint, identifier datapointstorage.Identifier) *pmetric.HistogramDataPoint
+	// AccumulateNumberDataPoint adds a delta, monotonic sum to the running
+	// total for its series.
+	// It returns the cumulative point, or nil if the point should be dropped.
+	AccumulateNumberDataPoint(point pmetric.NumberDataPoint, identifier datapointstorage.Identifier) *pmetric.NumberDataPoint
+	// CacheStats returns the size and usage of the accumulator's cache.
+	CacheStats() datapointstorage.Stats
+}
+
+// Snapshotter can save its cached points and restore them later, so they
+// survive restarts.
+type Snapshotter interface {
+	// MarshalSnapshot encodes the cached points.
+	MarshalSnapshot() ([]byte, error)
+	// UnmarshalSnapshot restores the cached points from a snapshot. It
+	// returns the number of points restored.
+	UnmarshalSnapshot(data []byte) (int, error)
 }
@@ -0,0 +1,188 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wal implements a simple persistent queue, which stores each record
+// as a file in a directory.
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	recordSuffix = ".pb"
+	tmpSuffix    = ".tmp"
+)
+
+// Log is an ordered queue of records persisted to disk. Records are read
+// back in the order they were written, including after a restart.
+type Log struct {
+	dir string
+	// maxSize is the maximum total size of records, in bytes. When it is
+	// exceeded, the oldest records are removed. Zero means unbounded.
+	maxSize int64
+
+	mu sync.Mutex
+	// records holds the index of each record, in order.
+	records []uint64
+	sizes   map[uint64]int64
+	size    int64
+	next    uint64
+}
+
+// Open opens the log stored in dir, creating the directory if needed.
+func Open(dir string, maxSize int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read write-ahead log directory: %w", err)
+	}
+	l := &Log{
+		dir:     dir,
+		maxSize: maxSize,
+		sizes:   make(map[uint64]int64),
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			// Partially written record from a previous run.
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, recordSuffix) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, recordSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read write-ahead log record: %w", err)
+		}
+		l.records = append(l.records, index)
+		l.sizes[index] = info.Size()
+		l.size += info.Size()
+		if index >= l.next {
+			l.next = index + 1
+		}
+	}
+	sort.Slice(l.records, func(i, j int) bool { return l.records[i] < l.records[j] })
+	return l, nil
+}
+
+// Write appends a record to the log. If the log exceeds its maximum size,
+// the oldest records are removed, and the number removed is returned.
+func (l *Log) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index := l.next
+	path := l.path(index)
+	// Write to a temporary file first so a crash never leaves a truncated record.
+	if err := os.WriteFile(path+tmpSuffix, data, 0o600); err != nil {
+		return 0, fmt.Errorf("failed to write write-ahead log record: %w", err)
+	}
+	if err := os.Rename(path+tmpSuffix, path); err != nil {
+		return 0, fmt.Errorf("failed to write write-ahead log record: %w", err)
+	}
+	l.next++
+	l.records = append(l.records, index)
+	l.sizes[index] = int64(len(data))
+	l.size += int64(len(data))
+
+	evicted := 0
+	for l.maxSize > 0 && l.size > l.maxSize && len(l.records) > 1 {
+		if err := l.removeLocked(l.records[0]); err != nil {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
+
+// Peek returns the oldest record in the log, and its index. It returns false
+// if the log is empty.
+func (l *Log) Peek() (uint64, []byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return 0, nil, false, nil
+	}
+	index := l.records[0]
+	data, err := os.ReadFile(l.path(index))
+	if err != nil {
+		return index, nil, true, fmt.Errorf("failed to read write-ahead log record: %w", err)
+	}
+	return index, data, true, nil
+}
+
+// RemoveOldest deletes the oldest record from the log. It does nothing if the
+// log is empty.
+func (l *Log) RemoveOldest() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return nil
+	}
+	return l.removeLocked(l.records[0])
+}
+
+// Remove deletes the record with the given index from the log.
+func (l *Log) Remove(index uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.removeLocked(index)
+}
+
+func (l *Log) removeLocked(index uint64) error {
+	for i, r := range l.records {
+		if r != index {
+			continue
+		}
+		l.records = append(l.records[:i], l.records[i+1:]...)
+		l.size -= l.sizes[index]
+		delete(l.sizes, index)
+		if err := os.Remove(l.path(index)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove write-ahead log record: %w", err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// Len returns the number of records in the log.
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.records)
+}
+
+// Size returns the total size of records in the log, in bytes.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+func (l *Log) path(index uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", index, recordSuffix))
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndPeek(t *testing.T) {
+	l, err := Open(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	_, _, found, err := l.Peek()
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = l.Write([]byte("foo"))
+	require.NoError(t, err)
+	_, err = l.Write([]byte("bar"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+	assert.Equal(t, int64(6), l.Size())
+
+	index, data, found, err := l.Peek()
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("foo"), data)
+
+	require.NoError(t, l.Remove(index))
+	_, data, found, err = l.Peek()
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("bar"), data)
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 0)
+	require.NoError(t, err)
+	for _, record := range []string{"a", "b", "c"} {
+		_, err = l.Write([]byte(record))
+		require.NoError(t, err)
+	}
+	index, _, _, err := l.Peek()
+	require.NoError(t, err)
+	require.NoError(t, l.Remove(index))
+	// Simulate a crash in the middle of a write.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000003.pb.tmp"), []byte("d"), 0o600))
+
+	l, err = Open(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+	_, data, _, err := l.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), data)
+
+	// New records are written after existing records.
+	_, err = l.Write([]byte("e"))
+	require.NoError(t, err)
+	for _, expected := range []string{"b", "c", "e"} {
+		index, data, found, err := l.Peek()
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, []byte(expected), data)
+		require.NoError(t, l.Remove(index))
+	}
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000003.pb.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMaxSize(t *testing.T) {
+	l, err := Open(t.TempDir(), 10)
+	require.NoError(t, err)
+	evicted, err := l.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted)
+	evicted, err = l.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, evicted)
+	evicted, err = l.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 2, l.Len())
+	assert.Equal(t, int64(8), l.Size())
+}
+
+func TestRemoveOldest(t *testing.T) {
+	l, err := Open(t.TempDir(), 0)
+	require.NoError(t, err)
+	require.NoError(t, l.RemoveOldest())
+	_, err = l.Write([]byte("first"))
+	require.NoError(t, err)
+	_, err = l.Write([]byte("second"))
+	require.NoError(t, err)
+
+	require.NoError(t, l.RemoveOldest())
+	_, data, found, err := l.Peek()
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, []byte("second"), data)
+	assert.Equal(t, int64(6), l.Size())
+}
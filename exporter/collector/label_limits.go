@@ -0,0 +1,237 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+)
+
+// Cloud Monitoring limits for custom metrics. See
+// https://cloud.google.com/monitoring/quotas#custom_metrics_quotas
+const (
+	defaultMaxLabels         = 30
+	defaultMaxLabelKeyLength = 100
+	defaultMaxLabelValueLen  = 1024
+	// The hash suffix appended to truncated keys and values, e.g. "_1a2b3c4d".
+	labelHashSuffixLength = 9
+)
+
+// Actions recorded when label limits are enforced.
+const (
+	labelLimitActionTruncatedKey   = "truncated_key"
+	labelLimitActionTruncatedValue = "truncated_value"
+	labelLimitActionDroppedLabel   = "dropped_label"
+	labelLimitActionDroppedPoint   = "dropped_point"
+)
+
+func (c LabelLimitsConfig) maxLabels() int {
+	if c.MaxLabels > 0 {
+		return c.MaxLabels
+	}
+	return defaultMaxLabels
+}
+
+func (c LabelLimitsConfig) maxKeyLength() int {
+	if c.MaxKeyLength > 0 {
+		return c.MaxKeyLength
+	}
+	return defaultMaxLabelKeyLength
+}
+
+func (c LabelLimitsConfig) maxValueLength() int {
+	if c.MaxValueLength > 0 {
+		return c.MaxValueLength
+	}
+	return defaultMaxLabelValueLen
+}
+
+// truncateWithHash shortens s to at most maxLen bytes, replacing the end with
+// a hash of the whole string so that distinct strings stay distinct.
+func truncateWithHash(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+	prefixLen := maxLen - labelHashSuffixLength
+	if prefixLen < 0 {
+		return suffix[len(suffix)-maxLen:]
+	}
+	// Don't split multi-byte characters.
+	for prefixLen > 0 && !utf8.RuneStart(s[prefixLen]) {
+		prefixLen--
+	}
+	return s[:prefixLen] + suffix
+}
+
+// sortKeysByPriority sorts label keys by their position in the configured
+// priority list, with unlisted keys after those, in alphabetical order.
+func (c LabelLimitsConfig) sortKeysByPriority(keys []string) {
+	priority := make(map[string]int, len(c.Priority))
+	for i, key := range c.Priority {
+		priority[key] = i
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, iok := priority[keys[i]]
+		pj, jok := priority[keys[j]]
+		switch {
+		case iok && jok:
+			return pi < pj
+		case iok != jok:
+			return iok
+		default:
+			return keys[i] < keys[j]
+		}
+	})
+}
+
+// labelKeepSet maps the label keys of a metric which are written to the keys
+// they are written with, which differ if they are truncated.
+type labelKeepSet map[string]string
+
+// keepSet returns the label keys written for a metric, out of the keys of all
+// of its points. reserved is the number of labels the descriptor has in
+// addition to those, which count against MaxLabels.
+func (c LabelLimitsConfig) keepSet(keys []string, reserved int) labelKeepSet {
+	candidates := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if len(k) <= c.maxKeyLength() || c.Policy == LabelLimitsTruncate {
+			candidates = append(candidates, k)
+		}
+	}
+	if maxLabels := c.maxLabels() - reserved; len(candidates) > maxLabels {
+		if maxLabels < 0 {
+			maxLabels = 0
+		}
+		c.sortKeysByPriority(candidates)
+		candidates = candidates[:maxLabels]
+	}
+	keep := make(labelKeepSet, len(candidates))
+	for _, k := range candidates {
+		keep[k] = truncateWithHash(k, c.maxKeyLength())
+	}
+	return keep
+}
+
+// limitLabels returns the labels of a point with the keep-set of its metric
+// and the limits on values enforced, and the actions taken. If the point must
+// be dropped, it returns nil labels.
+func (c LabelLimitsConfig) limitLabels(ls labels, keep labelKeepSet) (labels, []string) {
+	var actions []string
+	result := make(labels, len(ls))
+	for k, v := range ls {
+		key, ok := keep[k]
+		if !ok {
+			if c.Policy == LabelLimitsDropPoint {
+				return nil, []string{labelLimitActionDroppedPoint}
+			}
+			actions = append(actions, labelLimitActionDroppedLabel)
+			continue
+		}
+		if key != k {
+			actions = append(actions, labelLimitActionTruncatedKey)
+		}
+		if len(v) > c.maxValueLength() {
+			switch c.Policy {
+			case LabelLimitsTruncate:
+				v = truncateWithHash(v, c.maxValueLength())
+				actions = append(actions, labelLimitActionTruncatedValue)
+			case LabelLimitsDropLabels:
+				actions = append(actions, labelLimitActionDroppedLabel)
+				continue
+			default:
+				return nil, []string{labelLimitActionDroppedPoint}
+			}
+		}
+		result[key] = v
+	}
+	return result, actions
+}
+
+// labelKeepSet returns the label keys written for the metric. The same
+// keep-set is applied to the label descriptors of the metric and to the labels
+// of its points, so they always match. It is nil if label limits aren't
+// enforced.
+func (m *metricMapper) labelKeepSet(pm pmetric.Metric, keysByName map[string][]string) labelKeepSet {
+	cfg := m.cfg.MetricConfig.LabelLimits
+	if cfg.Policy == "" {
+		return nil
+	}
+	names := make([]string, 0, len(keysByName))
+	for name := range keysByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys []string
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		for _, key := range keysByName[name] {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	// Labels added to the descriptor after the keys of the points count
+	// against the limit too.
+	reserved := 0
+	if m.cfg.MetricConfig.CardinalityLimit.enabled() {
+		reserved++
+	}
+	isSummary := pm.DataType() == pmetric.MetricDataTypeSummary
+	if isSummary {
+		reserved++
+	}
+	keep := cfg.keepSet(keys, reserved)
+	if isSummary {
+		keep[summaryQuantileLabelKey] = summaryQuantileLabelKey
+	}
+	return keep
+}
+
+// enforceLabelLimits applies the keep-set of the metric of the timeseries,
+// and the configured limits on label values, to their metric labels, dropping
+// timeseries if needed. Every action is counted.
+func (m *metricMapper) enforceLabelLimits(ctx context.Context, tss []*monitoringpb.TimeSeries, keep labelKeepSet) []*monitoringpb.TimeSeries {
+	if keep == nil {
+		return tss
+	}
+	cfg := m.cfg.MetricConfig.LabelLimits
+	result := tss[:0]
+	for _, ts := range tss {
+		if ts.Metric == nil {
+			result = append(result, ts)
+			continue
+		}
+		ls, actions := cfg.limitLabels(ts.Metric.Labels, keep)
+		for _, action := range actions {
+			m.obs.recordLabelLimitAction(ctx, action, ts.Metric.Type)
+		}
+		if ls == nil {
+			continue
+		}
+		ts.Metric.Labels = ls
+		result = append(result, ts)
+	}
+	return result
+}
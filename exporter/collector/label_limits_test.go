@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+)
+
+func TestTruncateWithHash(t *testing.T) {
+	long := strings.Repeat("a", 20)
+	assert.Equal(t, "short", truncateWithHash("short", 10))
+
+	truncated := truncateWithHash(long, 15)
+	assert.Len(t, truncated, 15)
+	assert.True(t, strings.HasPrefix(truncated, "aaaaaa_"))
+	assert.NotEqual(t, truncated, truncateWithHash(long+"b", 15), "distinct strings should stay distinct")
+
+	// Multi-byte characters are not split.
+	truncated = truncateWithHash(strings.Repeat("é", 10), 14)
+	assert.Equal(t, "éé_", truncated[:5])
+}
+
+func TestLimitLabels(t *testing.T) {
+	longValue := strings.Repeat("v", 20)
+	for _, tc := range []struct {
+		desc            string
+		cfg             LabelLimitsConfig
+		input           labels
+		expected        labels
+		expectedActions []string
+	}{
+		{
+			desc:     "Within limits",
+			cfg:      LabelLimitsConfig{Policy: LabelLimitsDropPoint},
+			input:    labels{"foo": "bar"},
+			expected: labels{"foo": "bar"},
+		},
+		{
+			desc:            "Truncate long value",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsTruncate, MaxValueLength: 12},
+			input:           labels{"foo": longValue},
+			expected:        labels{"foo": truncateWithHash(longValue, 12)},
+			expectedActions: []string{labelLimitActionTruncatedValue},
+		},
+		{
+			desc:            "Truncate long key",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsTruncate, MaxKeyLength: 12},
+			input:           labels{longValue: "bar"},
+			expected:        labels{truncateWithHash(longValue, 12): "bar"},
+			expectedActions: []string{labelLimitActionTruncatedKey},
+		},
+		{
+			desc:            "Drop label with long value",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsDropLabels, MaxValueLength: 12},
+			input:           labels{"foo": longValue, "bar": "baz"},
+			expected:        labels{"bar": "baz"},
+			expectedActions: []string{labelLimitActionDroppedLabel},
+		},
+		{
+			desc:            "Drop labels by priority",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsDropLabels, MaxLabels: 2, Priority: []string{"z"}},
+			input:           labels{"a": "1", "b": "2", "z": "3"},
+			expected:        labels{"a": "1", "z": "3"},
+			expectedActions: []string{labelLimitActionDroppedLabel},
+		},
+		{
+			desc:            "Drop point with long value",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsDropPoint, MaxValueLength: 12},
+			input:           labels{"foo": longValue},
+			expectedActions: []string{labelLimitActionDroppedPoint},
+		},
+		{
+			desc:            "Drop point with too many labels",
+			cfg:             LabelLimitsConfig{Policy: LabelLimitsDropPoint, MaxLabels: 1},
+			input:           labels{"a": "1", "b": "2"},
+			expectedActions: []string{labelLimitActionDroppedPoint},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			keys := make([]string, 0, len(tc.input))
+			for k := range tc.input {
+				keys = append(keys, k)
+			}
+			actual, actions := tc.cfg.limitLabels(tc.input, tc.cfg.keepSet(keys, 0))
+			assert.Equal(t, tc.expected, actual)
+			assert.Equal(t, tc.expectedActions, actions)
+		})
+	}
+}
+
+func TestEnforceLabelLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MetricConfig.LabelLimits = LabelLimitsConfig{Policy: LabelLimitsDropPoint, MaxLabels: 1}
+	mapper := metricMapper{cfg: cfg, obs: newTestSelfObservability()}
+	end := start.Add(time.Minute)
+
+	kept := newBatchTestTimeSeries("workload.googleapis.com/foo", map[string]string{"a": "1"}, end)
+	dropped := newBatchTestTimeSeries("workload.googleapis.com/foo", map[string]string{"a": "1", "b": "2"}, end)
+	keep := cfg.MetricConfig.LabelLimits.keepSet([]string{"a", "b"}, 0)
+	result := mapper.enforceLabelLimits(context.Background(), []*monitoringpb.TimeSeries{kept, dropped}, keep)
+	assert.Equal(t, []*monitoringpb.TimeSeries{kept}, result)
+}
+
+func TestLabelLimitsKeepSet(t *testing.T) {
+	longKey := strings.Repeat("k", 20)
+	for _, tc := range []struct {
+		desc     string
+		cfg      LabelLimitsConfig
+		reserved int
+		expected labelKeepSet
+	}{
+		{
+			desc:     "Truncate",
+			cfg:      LabelLimitsConfig{Policy: LabelLimitsTruncate, MaxLabels: 3, MaxKeyLength: 12, Priority: []string{"b"}},
+			expected: labelKeepSet{"a": "a", "b": "b", longKey: truncateWithHash(longKey, 12)},
+		},
+		{
+			desc:     "Drop labels",
+			cfg:      LabelLimitsConfig{Policy: LabelLimitsDropLabels, MaxLabels: 3, MaxKeyLength: 12},
+			expected: labelKeepSet{"a": "a", "b": "b"},
+		},
+		{
+			desc:     "Priority",
+			cfg:      LabelLimitsConfig{Policy: LabelLimitsTruncate, MaxLabels: 1, Priority: []string{"b"}},
+			expected: labelKeepSet{"b": "b"},
+		},
+		{
+			desc:     "Reserved labels",
+			cfg:      LabelLimitsConfig{Policy: LabelLimitsDropPoint, MaxLabels: 3},
+			reserved: 1,
+			expected: labelKeepSet{"a": "a", "b": "b"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.keepSet([]string{"a", "b", longKey}, tc.reserved))
+		})
+	}
+}
+
+func TestLabelLimitsDescriptorsMatchPoints(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MetricConfig.LabelLimits = LabelLimitsConfig{Policy: LabelLimitsDropLabels, MaxLabels: 3, Priority: []string{"c"}}
+	cfg.MetricConfig.CardinalityLimit = CardinalityLimitConfig{Default: 10}
+	cfg.MetricConfig.InstrumentationLibraryLabels = false
+	mapper := metricMapper{cfg: cfg, obs: newTestSelfObservability()}
+
+	// Each point is within the limit, but together they have too many
+	// labels.
+	metric := pmetric.NewMetric()
+	metric.SetName("foo")
+	metric.SetDataType(pmetric.MetricDataTypeGauge)
+	first := metric.Gauge().DataPoints().AppendEmpty()
+	first.SetIntVal(1)
+	first.Attributes().InsertString("a", "1")
+	first.Attributes().InsertString("b", "2")
+	second := metric.Gauge().DataPoints().AppendEmpty()
+	second.SetIntVal(1)
+	second.Attributes().InsertString("c", "3")
+	second.Attributes().InsertString("d", "4")
+
+	relabeled := mapper.relabelMetric(metric, labels{})
+	descriptors := mapper.labelDescriptors(relabeled)
+	var descriptorKeys []string
+	for _, d := range descriptors["foo"] {
+		descriptorKeys = append(descriptorKeys, d.Key)
+	}
+	// The overflow label counts against the limit.
+	assert.Equal(t, []string{"a", "c", OverflowLabelKey}, descriptorKeys)
+
+	end := start.Add(time.Minute)
+	tss := mapper.enforceLabelLimits(context.Background(), []*monitoringpb.TimeSeries{
+		newBatchTestTimeSeries("workload.googleapis.com/foo", map[string]string{"a": "1", "b": "2"}, end),
+		newBatchTestTimeSeries("workload.googleapis.com/foo", map[string]string{"c": "3", "d": "4"}, end),
+	}, relabeled.keep)
+	require.Len(t, tss, 2)
+	assert.Equal(t, map[string]string{"a": "1"}, tss[0].Metric.Labels)
+	assert.Equal(t, map[string]string{"c": "3"}, tss[1].Metric.Labels)
+}
@@ -28,14 +28,15 @@ import (
 	loggingv2 "cloud.google.com/go/logging/apiv2"
 	"google.golang.org/genproto/googleapis/api/monitoredres"
 	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
-	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping"
-
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping"
 )
 
 const (
@@ -119,9 +120,11 @@ type LogsExporter struct {
 }
 
 type logMapper struct {
-	obs          selfObservability
-	cfg          Config
-	maxEntrySize int
+	obs                  selfObservability
+	cfg                  Config
+	router               *projectRouter
+	mapMonitoredResource func(pcommon.Resource) *monitoredres.MonitoredResource
+	maxEntrySize         int
 }
 
 func NewGoogleCloudLogsExporter(
@@ -134,7 +137,12 @@ func NewGoogleCloudLogsExporter(
 		return nil, err
 	}
 
-	clientOpts, err := generateClientOptions(ctx, &cfg.LogConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig)
+	obs, err := newSelfObservability(log, cfg.MeterProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts, err := generateClientOptions(ctx, &cfg.LogConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig, obs)
 	if err != nil {
 		return nil, err
 	}
@@ -144,18 +152,21 @@ func NewGoogleCloudLogsExporter(
 		return nil, err
 	}
 
-	obs := selfObservability{
-		log: log,
+	mapper := logMapper{
+		obs:                  obs,
+		cfg:                  cfg,
+		router:               newProjectRouter(cfg),
+		mapMonitoredResource: defaultResourceToLogMonitoredResource,
+		maxEntrySize:         defaultMaxEntrySize,
+	}
+	if len(cfg.ResourceMappings) > 0 {
+		mapper.mapMonitoredResource = newResourceMapper(cfg.ResourceMappings, resourcemapping.DefaultLoggingMappings())
 	}
 
 	return &LogsExporter{
-		cfg: cfg,
-		obs: obs,
-		mapper: logMapper{
-			obs:          obs,
-			cfg:          cfg,
-			maxEntrySize: defaultMaxEntrySize,
-		},
+		cfg:    cfg,
+		obs:    obs,
+		mapper: mapper,
 
 		loggingClient: loggingClient,
 	}, nil
@@ -215,13 +226,7 @@ func (l logMapper) createEntries(ld plog.Logs) ([]*logpb.LogEntry, error) {
 	entries := make([]*logpb.LogEntry, 0, 0)
 	for i := 0; i < ld.ResourceLogs().Len(); i++ {
 		rl := ld.ResourceLogs().At(i)
-		mr := defaultResourceToMonitoredResource(rl.Resource())
-		projectID := l.cfg.ProjectID
-		// override project ID with gcp.project.id, if present
-		if projectFromResource, found := rl.Resource().Attributes().Get(resourcemapping.ProjectIDAttributeKey); found {
-			projectID = projectFromResource.AsString()
-		}
-
+		mr := l.mapMonitoredResource(rl.Resource())
 		for j := 0; j < rl.ScopeLogs().Len(); j++ {
 			sl := rl.ScopeLogs().At(j)
 			instrumentationSource := sl.Scope().Name()
@@ -229,6 +234,10 @@ func (l logMapper) createEntries(ld plog.Logs) ([]*logpb.LogEntry, error) {
 
 			for k := 0; k < sl.LogRecords().Len(); k++ {
 				log := sl.LogRecords().At(k)
+				projectID, ok := l.router.route(rl.Resource().Attributes(), log.Attributes())
+				if !ok {
+					continue
+				}
 
 				// We can't just set logName on these entries otherwise the conversion to internal will fail
 				// We also need the logName here to be able to accurately calculate the overhead of entry
@@ -301,7 +310,9 @@ func (l *LogsExporter) writeLogEntries(ctx context.Context, batch []*logpb.LogEn
 	}
 
 	// TODO(damemi): handle response code
-	return l.loggingClient.WriteLogEntries(ctx, request)
+	resp, err := l.loggingClient.WriteLogEntries(ctx, request)
+	l.obs.recordLogEntryBatch(ctx, len(batch), statusCodeToString(status.Convert(err)))
+	return resp, err
 }
 
 func (l logMapper) getLogName(log plog.LogRecord) (string, error) {
@@ -25,19 +25,20 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
-	"go.uber.org/zap"
 	"google.golang.org/genproto/googleapis/api/monitoredres"
 	logpb "google.golang.org/genproto/googleapis/logging/v2"
 )
 
 func newTestLogMapper(entrySize int) logMapper {
-	obs := selfObservability{log: zap.NewNop()}
+	obs := newTestSelfObservability()
 	cfg := DefaultConfig()
 	cfg.LogConfig.DefaultLogName = "default-log"
 	return logMapper{
-		cfg:          cfg,
-		obs:          obs,
-		maxEntrySize: entrySize,
+		cfg:                  cfg,
+		obs:                  obs,
+		router:               newProjectRouter(cfg),
+		mapMonitoredResource: defaultResourceToLogMonitoredResource,
+		maxEntrySize:         entrySize,
 	}
 }
 
@@ -23,6 +23,7 @@ import (
 	"math"
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,11 +31,8 @@ import (
 	"unicode"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
-	"go.opencensus.io/plugin/ocgrpc"
-	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
-	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"google.golang.org/genproto/googleapis/api/distribution"
 	"google.golang.org/genproto/googleapis/api/label"
@@ -48,14 +46,9 @@ import (
 	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
 	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/normalization"
 	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping"
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/unitmapping"
 )
 
-// self-observability reporting meters/tracers/loggers.
-type selfObservability struct {
-	// Logger to use for this exporter.
-	log *zap.Logger
-}
-
 // MetricsExporter is the GCM exporter that uses pdata directly
 type MetricsExporter struct {
 	mapper metricMapper
@@ -65,26 +58,76 @@ type MetricsExporter struct {
 	obs               selfObservability
 	// shutdownC is a channel for signaling a graceful shutdown
 	shutdownC chan struct{}
-	// mdCache tracks the metric descriptors that have already been sent to GCM
-	mdCache map[string]*monitoringpb.CreateMetricDescriptorRequest
-	cfg     Config
+	// mdCache tracks the metric descriptors that have already been sent to
+	// GCM, or which already existed. It, warmedProjects and mdConflicts are
+	// guarded by mdCacheMu.
+	mdCache   map[string]*monitoringpb.CreateMetricDescriptorRequest
+	mdCacheMu sync.Mutex
+	// warmedProjects tracks the projects whose existing metric descriptors
+	// are added to mdCache. Callers wait on the project's Once until they
+	// have been added.
+	warmedProjects map[string]*sync.Once
+	// mdConflicts tracks the conflicting metric descriptors which have been
+	// logged.
+	mdConflicts map[string]struct{}
+	cfg         Config
 	// goroutines tracks the currently running child tasks
 	goroutines sync.WaitGroup
 	timeout    time.Duration
+	// wal persists requests which failed with a retryable error. It is nil
+	// unless the write-ahead log is enabled.
+	wal *metricsWAL
+	// cardinalityLimiter folds timeseries over the cardinality limit of their
+	// metric type into overflow timeseries. It is nil unless limits are set.
+	cardinalityLimiter *cardinalityLimiter
+	// requestSem limits the number of CreateTimeSeries requests in flight
+	// across all projects.
+	requestSem chan struct{}
+	// router determines the project each timeseries is sent to.
+	router *projectRouter
+	// snapshot saves the points cached by cumulative normalization. It is nil
+	// unless the normalization snapshot is enabled.
+	snapshot *normalizationSnapshot
+	// processStartTimes remembers the process start time of each resource.
+	// It is nil unless the process start time is used as the start time of
+	// cumulative points.
+	processStartTimes *processStartTimeCache
 }
 
 // metricMapper is the part that transforms metrics. Separate from MetricsExporter since it has
 // all pure functions.
 type metricMapper struct {
-	normalizer normalization.Normalizer
+	normalizer  normalization.Normalizer
+	accumulator normalization.Accumulator
+	// relabeler applies the metric relabeling rules. It is nil if there are
+	// none.
+	relabeler *relabeler
+	// downscaler reduces the scale of exponential histograms with too many
+	// buckets. It is nil if downscaling is disabled.
+	downscaler *exponentialHistogramDownscaler
 	obs        selfObservability
 	cfg        Config
+	// processStartTime is the start time of the process which produced the
+	// points being mapped, if it is known and used as the start time of
+	// cumulative points without one.
+	processStartTime pcommon.Timestamp
+	// router resolves the project of the spans of exemplars. It is nil if
+	// exemplars link to spans in the project of their point.
+	router *projectRouter
+	// resourceAttributes are the attributes of the resource of the points
+	// being mapped, used to route the spans of exemplars.
+	resourceAttributes pcommon.Map
+	// descriptorOverrides are the configured metadata of metric
+	// descriptors. It is nil if there are none.
+	descriptorOverrides *descriptorOverrides
 }
 
 // Constants we use when translating summary metrics into GCP.
 const (
 	SummaryCountPrefix = "_count"
 	SummarySumSuffix   = "_sum"
+
+	summaryQuantileLabelKey = "quantile"
 )
 
 const (
@@ -119,12 +162,23 @@ func NewGoogleCloudMetricsExporter(
 	version string,
 	timeout time.Duration,
 ) (*MetricsExporter, error) {
-	view.Register(MetricViews()...)
-	view.Register(ocgrpc.DefaultClientViews...)
 	setVersionInUserAgent(&cfg, version)
 	setProjectFromADC(ctx, &cfg, monitoring.DefaultAuthScopes())
+	if len(cfg.ResourceMappings) > 0 {
+		cfg.MetricConfig.MapMonitoredResource = newResourceMapper(cfg.ResourceMappings, resourcemapping.DefaultMappings())
+	}
 
-	clientOpts, err := generateClientOptions(ctx, &cfg.MetricConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig)
+	relabeler, err := newRelabeler(cfg.MetricConfig.MetricRelabelConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	obs, err := newSelfObservability(log, cfg.MeterProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts, err := generateClientOptions(ctx, &cfg.MetricConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig, obs)
 	if err != nil {
 		return nil, err
 	}
@@ -133,20 +187,60 @@ func NewGoogleCloudMetricsExporter(
 	if err != nil {
 		return nil, err
 	}
-	obs := selfObservability{log: log}
+	var wal *metricsWAL
+	if cfg.MetricConfig.WALConfig != nil {
+		wal, err = newMetricsWAL(*cfg.MetricConfig.WALConfig)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
 	shutdown := make(chan struct{})
+	cacheOptions := datapointstorage.Options{
+		MaxEntries: cfg.MetricConfig.NormalizationCache.MaxEntries,
+		MaxBytes:   cfg.MetricConfig.NormalizationCache.MaxBytes,
+		GCInterval: cfg.MetricConfig.NormalizationCache.GCInterval,
+	}
 	normalizer := normalization.NewDisabledNormalizer()
+	var snapshot *normalizationSnapshot
 	if cfg.MetricConfig.CumulativeNormalization {
-		normalizer = normalization.NewStandardNormalizer(shutdown, log)
+		normalizer = normalization.NewStandardNormalizer(shutdown, log, cacheOptions)
+		if snapshotConfig := cfg.MetricConfig.CumulativeNormalizationSnapshot; snapshotConfig != nil {
+			snapshot = newNormalizationSnapshot(*snapshotConfig, normalizer.(normalization.Snapshotter))
+			restored, err := snapshot.load(time.Now())
+			if err != nil {
+				log.Warn("Unable to restore normalization snapshot. Cumulative timeseries will be reset.", zap.Error(err))
+			} else if restored > 0 {
+				log.Debug("Restored normalization snapshot.", zap.Int("points", restored))
+			}
+		}
+	}
+	accumulator := normalization.NewDisabledAccumulator()
+	if cfg.MetricConfig.DeltaToCumulative {
+		accumulator = normalization.NewDeltaAccumulator(shutdown, log, cacheOptions)
+	}
+	var downscaler *exponentialHistogramDownscaler
+	if cfg.MetricConfig.MaxExponentialHistogramBuckets > 0 {
+		downscaler = newExponentialHistogramDownscaler(cfg.MetricConfig.MaxExponentialHistogramBuckets, cacheOptions)
+	}
+	router := newProjectRouter(cfg)
+	if err := obs.observeNormalizationCache(normalizer, accumulator); err != nil {
+		log.Warn("Unable to report the normalization cache metrics.", zap.Error(err))
 	}
 	mExp := &MetricsExporter{
 		cfg:    cfg,
 		client: client,
 		obs:    obs,
 		mapper: metricMapper{
-			obs:        obs,
-			cfg:        cfg,
-			normalizer: normalizer,
+			obs:         obs,
+			cfg:         cfg,
+			normalizer:  normalizer,
+			accumulator: accumulator,
+			relabeler:   relabeler,
+			downscaler:  downscaler,
+			router:      router,
+
+			descriptorOverrides: newDescriptorOverrides(cfg.MetricConfig.Descriptors),
 		},
 		// We create a buffered channel for metric descriptors.
 		// MetricDescritpors are asychronously sent and optimistic.
@@ -154,8 +248,54 @@ func NewGoogleCloudMetricsExporter(
 		// to drop / conserve resources for sending timeseries.
 		metricDescriptorC: make(chan *monitoringpb.CreateMetricDescriptorRequest, cfg.MetricConfig.CreateMetricDescriptorBufferSize),
 		mdCache:           make(map[string]*monitoringpb.CreateMetricDescriptorRequest),
+		warmedProjects:    make(map[string]*sync.Once),
+		mdConflicts:       make(map[string]struct{}),
 		shutdownC:         shutdown,
 		timeout:           timeout,
+		wal:               wal,
+		requestSem:        make(chan struct{}, maxConcurrentRequests(cfg)),
+		snapshot:          snapshot,
+		router:            router,
+	}
+
+	if cfg.MetricConfig.CumulativeStartTime == CumulativeStartTimeProcessStart {
+		mExp.processStartTimes = newProcessStartTimeCache()
+		// Fire up the process start time garbage collection.
+		mExp.goroutines.Add(1)
+		go mExp.processStartTimeGCRunner()
+	}
+
+	if cfg.MetricConfig.MetricDescriptorConflictStrategy == MetricDescriptorConflictRecreate {
+		log.Warn("Conflicting metric descriptors will be DELETED, including ALL of their data, and recreated.",
+			zap.String("metric_descriptor_conflict_strategy", MetricDescriptorConflictRecreate))
+	}
+
+	if downscaler != nil {
+		// Fire up the exponential histogram scale garbage collection.
+		mExp.goroutines.Add(1)
+		go mExp.downscalerGCRunner()
+	}
+
+	if cfg.MetricConfig.CardinalityLimit.enabled() {
+		mExp.cardinalityLimiter = newCardinalityLimiter(cfg.MetricConfig.CardinalityLimit, obs)
+		// Fire up the cardinality limiter garbage collection.
+		mExp.goroutines.Add(1)
+		go mExp.cardinalityGCRunner()
+	}
+
+	if mExp.wal != nil {
+		// Fire up the write-ahead log replay of projects with pending
+		// requests. Other projects' replay starts when their first request
+		// is written to the log.
+		for _, q := range mExp.wal.queues() {
+			mExp.startWALRunner(q)
+		}
+	}
+
+	if mExp.snapshot != nil {
+		// Fire up the normalization snapshot writer.
+		mExp.goroutines.Add(1)
+		go mExp.normalizationSnapshotRunner()
 	}
 
 	// Fire up the metric descriptor exporter.
@@ -176,96 +316,103 @@ func (me *MetricsExporter) PushMetrics(ctx context.Context, m pmetric.Metrics) e
 		rm := rms.At(i)
 		monitoredResource := me.cfg.MetricConfig.MapMonitoredResource(rm.Resource())
 		extraResourceLabels := me.mapper.resourceToMetricLabels(rm.Resource())
-		projectID := me.cfg.ProjectID
-		// override project ID with gcp.project.id, if present
-		if projectFromResource, found := rm.Resource().Attributes().Get(resourcemapping.ProjectIDAttributeKey); found {
-			projectID = projectFromResource.AsString()
+		projectID, routed := me.router.route(rm.Resource().Attributes(), pcommon.NewMap())
+		if !routed && !me.router.routesRecords() {
+			continue
+		}
+		mapper := me.mapper
+		mapper.resourceAttributes = rm.Resource().Attributes()
+		if me.processStartTimes != nil {
+			mapper.processStartTime = me.processStartTimes.get(rm)
 		}
 		sms := rm.ScopeMetrics()
 		for j := 0; j < sms.Len(); j++ {
 			sm := sms.At(j)
 
-			instrumentationScopeLabels := me.mapper.instrumentationScopeToLabels(sm.Scope())
+			instrumentationScopeLabels := mapper.instrumentationScopeToLabels(sm.Scope())
 			metricLabels := mergeLabels(nil, instrumentationScopeLabels, extraResourceLabels)
 
 			mes := sm.Metrics()
 			for k := 0; k < mes.Len(); k++ {
 				metric := mes.At(k)
-				pendingTimeSeries[projectID] = append(pendingTimeSeries[projectID], me.mapper.metricToTimeSeries(monitoredResource, metricLabels, metric, projectID)...)
-
-				// We only send metric descriptors if we're configured *and* we're not sending service timeseries.
-				if me.cfg.MetricConfig.SkipCreateMetricDescriptor || me.cfg.MetricConfig.CreateServiceTimeSeries {
+				if !me.router.routesRecords() {
+					me.appendMetric(ctx, pendingTimeSeries, mapper, monitoredResource, metricLabels, metric, projectID)
 					continue
 				}
-
-				for _, md := range me.mapper.metricDescriptor(metric, metricLabels) {
-					if md == nil {
-						continue
-					}
-					req := &monitoringpb.CreateMetricDescriptorRequest{
-						Name:             projectName(projectID),
-						MetricDescriptor: md,
-					}
-					select {
-					case me.metricDescriptorC <- req:
-					default:
-						// Ignore drops, we'll catch descriptor next time around.
-					}
+				for projectID, projectMetric := range me.router.splitMetric(rm.Resource().Attributes(), metric) {
+					me.appendMetric(ctx, pendingTimeSeries, mapper, monitoredResource, metricLabels, projectMetric, projectID)
 				}
 			}
 		}
 	}
-	var errs []error
-	// timeseries for each project are batched and exported separately
-	for projectID, projectTS := range pendingTimeSeries {
-		// Batch and export
-		for len(projectTS) > 0 {
-			var sendSize int
-			if len(projectTS) < sendBatchSize {
-				sendSize = len(projectTS)
-			} else {
-				sendSize = sendBatchSize
-			}
-
-			var ts []*monitoringpb.TimeSeries
-			ts, projectTS = projectTS[:sendSize], projectTS[sendSize:]
-
-			var err error
-			req := &monitoringpb.CreateTimeSeriesRequest{
-				Name:       projectName(projectID),
-				TimeSeries: ts,
-			}
-			if me.cfg.MetricConfig.CreateServiceTimeSeries {
-				err = me.createServiceTimeSeries(ctx, req)
-			} else {
-				err = me.createTimeSeries(ctx, req)
-			}
-
-			var st string
-			s, _ := status.FromError(err)
-			st = statusCodeToString(s)
+	if me.cardinalityLimiter != nil {
+		for projectID, projectTS := range pendingTimeSeries {
+			pendingTimeSeries[projectID] = me.cardinalityLimiter.apply(ctx, projectID, projectTS)
+		}
+	}
+	// timeseries for each project are batched and exported concurrently, so
+	// a slow or throttled project doesn't delay the others.
+	return me.exportProjects(ctx, pendingTimeSeries)
+}
 
-			succeededPoints := len(ts)
-			failedPoints := 0
-			for _, detail := range s.Details() {
-				if summary, ok := detail.(*monitoringpb.CreateTimeSeriesSummary); ok {
-					failedPoints = int(summary.TotalPointCount - summary.SuccessPointCount)
-					succeededPoints = int(summary.SuccessPointCount)
-				}
-			}
+// appendMetric converts the metric to timeseries sent to the project, and
+// queues its metric descriptors to be created.
+func (me *MetricsExporter) appendMetric(
+	ctx context.Context,
+	pendingTimeSeries map[string][]*monitoringpb.TimeSeries,
+	mapper metricMapper,
+	monitoredResource *monitoredrespb.MonitoredResource,
+	metricLabels labels,
+	metric pmetric.Metric,
+	projectID string,
+) {
+	relabeled := mapper.relabelMetric(metric, metricLabels)
+	tss := mapper.metricToTimeSeries(ctx, monitoredResource, metricLabels, metric, projectID, relabeled)
+	tss = mapper.enforceLabelLimits(ctx, tss, relabeled.keep)
+	if me.cfg.MetricConfig.MetricDescriptorConflictStrategy == MetricDescriptorConflictRename {
+		// Conflicts must be known before the timeseries are renamed, rather
+		// than after the descriptors are asynchronously exported.
+		me.warmMetricDescriptorCache(projectName(projectID))
+	}
+	me.renameConflictingTimeSeries(projectID, tss)
+	pendingTimeSeries[projectID] = append(pendingTimeSeries[projectID], tss...)
+
+	// We only send metric descriptors if we're configured *and* we're not sending service timeseries.
+	if me.cfg.MetricConfig.SkipCreateMetricDescriptor || me.cfg.MetricConfig.CreateServiceTimeSeries {
+		return
+	}
 
-			// always record the number of successful points
-			recordPointCountDataPoint(ctx, succeededPoints, "OK")
-			if failedPoints > 0 {
-				recordPointCountDataPoint(ctx, failedPoints, st)
-			}
-			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to export time series to GCM: %v", err))
-			}
+	for _, md := range mapper.metricDescriptor(metric, relabeled) {
+		if md == nil {
+			continue
+		}
+		md.Type = me.renamedMetricType(projectID, md.Type, md.MetricKind, md.ValueType)
+		req := &monitoringpb.CreateMetricDescriptorRequest{
+			Name:             projectName(projectID),
+			MetricDescriptor: md,
+		}
+		select {
+		case me.metricDescriptorC <- req:
+		default:
+			// Ignore drops, we'll catch descriptor next time around.
 		}
 	}
-	if len(errs) > 0 {
-		return multierr.Combine(errs...)
+}
+
+func (me *MetricsExporter) enqueueToWAL(projectID string, req *monitoringpb.CreateTimeSeriesRequest) error {
+	q, opened, err := me.wal.project(projectID)
+	if err != nil {
+		return fmt.Errorf("failed to write time series to the write-ahead log: %w", err)
+	}
+	if opened {
+		me.startWALRunner(q)
+	}
+	dropped, err := me.wal.enqueue(q, req)
+	if dropped > 0 {
+		me.obs.log.Warn("Write-ahead log is full. Dropped the oldest pending requests.", zap.Int("requests", dropped))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write time series to the write-ahead log: %w", err)
 	}
 	return nil
 }
@@ -304,13 +451,26 @@ func projectName(projectID string) string {
 
 // Helper method to send metric descriptors to GCM.
 func (me *MetricsExporter) exportMetricDescriptor(req *monitoringpb.CreateMetricDescriptorRequest) {
-	cacheKey := fmt.Sprintf("%s/%s", req.Name, req.MetricDescriptor.Type)
-	if _, exists := me.mdCache[cacheKey]; exists {
-		return
+	if me.cfg.MetricConfig.WarmMetricDescriptorCache || me.cfg.MetricConfig.MetricDescriptorConflictStrategy == MetricDescriptorConflictRename {
+		me.warmMetricDescriptorCache(req.Name)
+	}
+	cacheKey := mdCacheKey(req.Name, req.MetricDescriptor.Type)
+	if existing, exists := me.cachedMetricDescriptor(cacheKey); exists {
+		if !metricDescriptorConflicts(existing.MetricDescriptor, req.MetricDescriptor.MetricKind, req.MetricDescriptor.ValueType) {
+			if !metricDescriptorMetadataChanged(existing.MetricDescriptor, req.MetricDescriptor) {
+				return
+			}
+			// Creating an existing descriptor updates its metadata. Its
+			// labels are kept, since they can't be removed.
+			req = withExistingLabels(req, existing.MetricDescriptor)
+		} else if !me.handleMetricDescriptorConflict(existing, req) {
+			return
+		}
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), me.timeout)
 	defer cancel()
 	_, err := me.client.CreateMetricDescriptor(ctx, req)
+	me.obs.recordMetricDescriptorCreation(ctx, statusCodeToString(status.Convert(err)))
 	if err != nil {
 		// TODO: Log-once on error, per metric descriptor?
 		me.obs.log.Error("Unable to send metric descriptor.", zap.Error(err), zap.Any("metric_descriptor", req.MetricDescriptor))
@@ -318,7 +478,15 @@ func (me *MetricsExporter) exportMetricDescriptor(req *monitoringpb.CreateMetric
 	}
 
 	// only cache if we are successful. We want to retry if there is an error
-	me.mdCache[cacheKey] = req
+	me.setCachedMetricDescriptor(cacheKey, req)
+}
+
+// Sends a timeseries using the method selected by the configuration.
+func (me *MetricsExporter) sendTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) error {
+	if me.cfg.MetricConfig.CreateServiceTimeSeries {
+		return me.createServiceTimeSeries(ctx, req)
+	}
+	return me.createTimeSeries(ctx, req)
 }
 
 // Sends a user-custom-metric timeseries.
@@ -346,10 +514,12 @@ func (m *metricMapper) instrumentationScopeToLabels(is pcommon.InstrumentationSc
 }
 
 func (m *metricMapper) metricToTimeSeries(
+	ctx context.Context,
 	resource *monitoredrespb.MonitoredResource,
 	extraLabels labels,
 	metric pmetric.Metric,
 	projectID string,
+	relabeled relabeledMetric,
 ) []*monitoringpb.TimeSeries {
 	timeSeries := []*monitoringpb.TimeSeries{}
 
@@ -358,35 +528,35 @@ func (m *metricMapper) metricToTimeSeries(
 		sum := metric.Sum()
 		points := sum.DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			ts := m.sumPointToTimeSeries(resource, extraLabels, metric, sum, points.At(i))
+			ts := m.sumPointToTimeSeries(resource, extraLabels, metric, sum, points.At(i), relabeled.points[i])
 			timeSeries = append(timeSeries, ts...)
 		}
 	case pmetric.MetricDataTypeGauge:
 		gauge := metric.Gauge()
 		points := gauge.DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			ts := m.gaugePointToTimeSeries(resource, extraLabels, metric, gauge, points.At(i))
+			ts := m.gaugePointToTimeSeries(resource, extraLabels, metric, gauge, points.At(i), relabeled.points[i])
 			timeSeries = append(timeSeries, ts...)
 		}
 	case pmetric.MetricDataTypeSummary:
 		summary := metric.Summary()
 		points := summary.DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			ts := m.summaryPointToTimeSeries(resource, extraLabels, metric, summary, points.At(i))
+			ts := m.summaryPointToTimeSeries(resource, extraLabels, metric, summary, points.At(i), relabeled.points[i])
 			timeSeries = append(timeSeries, ts...)
 		}
 	case pmetric.MetricDataTypeHistogram:
 		hist := metric.Histogram()
 		points := hist.DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			ts := m.histogramToTimeSeries(resource, extraLabels, metric, hist, points.At(i), projectID)
+			ts := m.histogramToTimeSeries(ctx, resource, extraLabels, metric, hist, points.At(i), relabeled.points[i], projectID)
 			timeSeries = append(timeSeries, ts...)
 		}
 	case pmetric.MetricDataTypeExponentialHistogram:
 		eh := metric.ExponentialHistogram()
 		points := eh.DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			ts := m.exponentialHistogramToTimeSeries(resource, extraLabels, metric, eh, points.At(i), projectID)
+			ts := m.exponentialHistogramToTimeSeries(ctx, resource, extraLabels, metric, eh, points.At(i), relabeled.points[i], projectID)
 			timeSeries = append(timeSeries, ts...)
 		}
 	default:
@@ -402,19 +572,33 @@ func (m *metricMapper) summaryPointToTimeSeries(
 	metric pmetric.Metric,
 	sum pmetric.Summary,
 	point pmetric.SummaryDataPoint,
+	relabeled relabeledPoint,
 ) []*monitoringpb.TimeSeries {
 	if point.Flags().HasFlag(pmetric.MetricDataPointFlagNoRecordedValue) {
 		// Drop points without a value.
 		return nil
 	}
+	if start, ok := m.cumulativeStartTimestamp(point.StartTimestamp(), point.Timestamp()); ok {
+		newPoint := pmetric.NewSummaryDataPoint()
+		point.CopyTo(newPoint)
+		newPoint.SetStartTimestamp(start)
+		point = newPoint
+	}
+	if !relabeled.ok {
+		return nil
+	}
+	name, pointLabels := relabeled.name, relabeled.labels
 	// Normalize the summary point.
-	metricIdentifier := datapointstorage.Identifier(resource, extraLabels, metric, point.Attributes())
+	var metricIdentifier datapointstorage.Identifier
+	if m.cfg.MetricConfig.CumulativeNormalization {
+		metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+	}
 	normalizedPoint := m.normalizer.NormalizeSummaryDataPoint(point, metricIdentifier)
 	if normalizedPoint == nil {
 		return nil
 	}
 	point = *normalizedPoint
-	sumType, countType, quantileType, err := m.summaryMetricTypes(metric)
+	sumType, countType, quantileType, err := m.summaryMetricTypes(name, metric)
 	if err != nil {
 		m.obs.log.Debug("Failed to get metric type (i.e. name) for summary metric. Dropping the metric.", zap.Error(err), zap.Any("metric", metric))
 		return nil
@@ -424,7 +608,7 @@ func (m *metricMapper) summaryPointToTimeSeries(
 	result := []*monitoringpb.TimeSeries{
 		{
 			Resource:   resource,
-			Unit:       metric.Unit(),
+			Unit:       m.unit(metric),
 			MetricKind: metricpb.MetricDescriptor_CUMULATIVE,
 			ValueType:  metricpb.MetricDescriptor_DOUBLE,
 			Points: []*monitoringpb.Point{{
@@ -437,16 +621,13 @@ func (m *metricMapper) summaryPointToTimeSeries(
 				}},
 			}},
 			Metric: &metricpb.Metric{
-				Type: sumType,
-				Labels: mergeLabels(
-					attributesToLabels(point.Attributes()),
-					extraLabels,
-				),
+				Type:   sumType,
+				Labels: mergeLabels(nil, pointLabels),
 			},
 		},
 		{
 			Resource:   resource,
-			Unit:       metric.Unit(),
+			Unit:       m.unit(metric),
 			MetricKind: metricpb.MetricDescriptor_CUMULATIVE,
 			ValueType:  metricpb.MetricDescriptor_DOUBLE,
 			Points: []*monitoringpb.Point{{
@@ -459,11 +640,8 @@ func (m *metricMapper) summaryPointToTimeSeries(
 				}},
 			}},
 			Metric: &metricpb.Metric{
-				Type: countType,
-				Labels: mergeLabels(
-					attributesToLabels(point.Attributes()),
-					extraLabels,
-				),
+				Type:   countType,
+				Labels: mergeLabels(nil, pointLabels),
 			},
 		},
 	}
@@ -471,11 +649,11 @@ func (m *metricMapper) summaryPointToTimeSeries(
 	for i := 0; i < quantiles.Len(); i++ {
 		quantile := quantiles.At(i)
 		pLabel := labels{
-			"quantile": strconv.FormatFloat(quantile.Quantile(), 'f', -1, 64),
+			summaryQuantileLabelKey: strconv.FormatFloat(quantile.Quantile(), 'f', -1, 64),
 		}
 		result = append(result, &monitoringpb.TimeSeries{
 			Resource:   resource,
-			Unit:       metric.Unit(),
+			Unit:       m.unit(metric),
 			MetricKind: metricpb.MetricDescriptor_GAUGE,
 			ValueType:  metricpb.MetricDescriptor_DOUBLE,
 			Points: []*monitoringpb.Point{{
@@ -487,34 +665,49 @@ func (m *metricMapper) summaryPointToTimeSeries(
 				}},
 			}},
 			Metric: &metricpb.Metric{
-				Type: quantileType,
-				Labels: mergeLabels(
-					attributesToLabels(point.Attributes()),
-					extraLabels,
-					pLabel,
-				),
+				Type:   quantileType,
+				Labels: mergeLabels(nil, pointLabels, pLabel),
 			},
 		})
 	}
 	return result
 }
 
-func (m *metricMapper) exemplar(ex pmetric.Exemplar, projectID string) *distribution.Distribution_Exemplar {
-	ctx := context.TODO()
-	attachments := []*anypb.Any{}
-	// TODO: Look into still sending exemplars with no span.
-	if !ex.TraceID().IsEmpty() && !ex.SpanID().IsEmpty() {
+// Reasons exemplar attachments are dropped.
+const (
+	// exemplarDropIncompleteSpanContext is used when an exemplar has only
+	// one of a trace ID and a span ID.
+	exemplarDropIncompleteSpanContext = "incomplete_span_context"
+	// exemplarDropUnroutedSpan is used when the span of an exemplar isn't
+	// routed to any project, so it isn't exported to Cloud Trace.
+	exemplarDropUnroutedSpan = "unrouted_span"
+	// exemplarDropEncoding is used when an attachment can't be encoded.
+	exemplarDropEncoding = "encoding"
+)
+
+// exemplar maps an exemplar into a GCM exemplar. Every exemplar is sent, with
+// its filtered attributes as a DroppedLabels attachment and, if it has a
+// span, a SpanContext attachment.
+func (m *metricMapper) exemplar(ctx context.Context, ex pmetric.Exemplar, pointAttrs pcommon.Map, projectID string) *distribution.Distribution_Exemplar {
+	var attachments []*anypb.Any
+	hasTraceID, hasSpanID := !ex.TraceID().IsEmpty(), !ex.SpanID().IsEmpty()
+	switch {
+	case hasTraceID && hasSpanID:
+		traceProjectID, ok := m.exemplarTraceProject(ex, pointAttrs, projectID)
+		if !ok {
+			m.obs.recordExemplarAttachmentDrop(ctx, exemplarDropUnroutedSpan)
+			break
+		}
 		sctx, err := anypb.New(&monitoringpb.SpanContext{
-			// TODO - make sure project id is correct.
-			SpanName: fmt.Sprintf("projects/%s/traces/%s/spans/%s", projectID, ex.TraceID().HexString(), ex.SpanID().HexString()),
+			SpanName: fmt.Sprintf("projects/%s/traces/%s/spans/%s", traceProjectID, ex.TraceID().HexString(), ex.SpanID().HexString()),
 		})
-		if err == nil {
-			attachments = append(attachments, sctx)
-		} else {
-			// This happens in the event of logic error (e.g. missing required fields).
-			// As such we complaining loudly to fail our unit tests.
-			recordExemplarFailure(ctx, 1)
+		if err != nil {
+			m.obs.recordExemplarAttachmentDrop(ctx, exemplarDropEncoding)
+			break
 		}
+		attachments = append(attachments, sctx)
+	case hasTraceID || hasSpanID:
+		m.obs.recordExemplarAttachmentDrop(ctx, exemplarDropIncompleteSpanContext)
 	}
 	if ex.FilteredAttributes().Len() > 0 {
 		attr, err := anypb.New(&monitoringpb.DroppedLabels{
@@ -523,28 +716,64 @@ func (m *metricMapper) exemplar(ex pmetric.Exemplar, projectID string) *distribu
 		if err == nil {
 			attachments = append(attachments, attr)
 		} else {
-			// This happens in the event of logic error (e.g. missing required fields).
-			// As such we complaining loudly to fail our unit tests.
-			recordExemplarFailure(ctx, 1)
+			m.obs.recordExemplarAttachmentDrop(ctx, exemplarDropEncoding)
 		}
 	}
+	var value float64
+	switch ex.ValueType() {
+	case pmetric.ExemplarValueTypeInt:
+		value = float64(ex.IntVal())
+	case pmetric.ExemplarValueTypeDouble:
+		value = ex.DoubleVal()
+	}
 	return &distribution.Distribution_Exemplar{
-		Value:       ex.DoubleVal(),
+		Value:       value,
 		Timestamp:   timestamppb.New(ex.Timestamp().AsTime()),
 		Attachments: attachments,
 	}
 }
 
-func (m *metricMapper) exemplars(exs pmetric.ExemplarSlice, projectID string) []*distribution.Distribution_Exemplar {
+// exemplarTraceProject returns the project the span of the exemplar was
+// exported to, using the same routing as the traces exporter. Record rules
+// are evaluated against the attributes of the point and the filtered
+// attributes of the exemplar. It returns false if the span isn't routed.
+func (m *metricMapper) exemplarTraceProject(ex pmetric.Exemplar, pointAttrs pcommon.Map, projectID string) (string, bool) {
+	if m.router == nil || !m.router.routesRecords() {
+		// The project of the point is routed from the resource alone.
+		return projectID, true
+	}
+	attrs := pcommon.NewMap()
+	pointAttrs.CopyTo(attrs)
+	ex.FilteredAttributes().Range(func(k string, v pcommon.Value) bool {
+		attrs.Upsert(k, v)
+		return true
+	})
+	return m.router.route(m.resourceAttributes, attrs)
+}
+
+func (m *metricMapper) exemplars(ctx context.Context, exs pmetric.ExemplarSlice, pointAttrs pcommon.Map, projectID string) []*distribution.Distribution_Exemplar {
 	exemplars := make([]*distribution.Distribution_Exemplar, exs.Len())
 	for i := 0; i < exs.Len(); i++ {
-		exemplars[i] = m.exemplar(exs.At(i), projectID)
+		exemplars[i] = m.exemplar(ctx, exs.At(i), pointAttrs, projectID)
 	}
 	return exemplars
 }
 
+// distributionRange returns the range of a distribution with the min and max
+// of its histogram point. It returns nil, which leaves the range unset, when
+// either is unknown, for example because normalization subtracted an earlier
+// point, or when the distribution is empty, as Cloud Monitoring rejects a
+// range on an empty distribution. Ranges omitted from distributions with
+// values are counted by the callers, which know the metric type.
+func distributionRange(count uint64, hasMin bool, min float64, hasMax bool, max float64) *distribution.Distribution_Range {
+	if count == 0 || !hasMin || !hasMax {
+		return nil
+	}
+	return &distribution.Distribution_Range{Min: min, Max: max}
+}
+
 // histogramPoint maps a histogram data point into a GCM point.
-func (m *metricMapper) histogramPoint(point pmetric.HistogramDataPoint, projectID string) *monitoringpb.TypedValue {
+func (m *metricMapper) histogramPoint(ctx context.Context, point pmetric.HistogramDataPoint, projectID string) *monitoringpb.TypedValue {
 	counts := make([]int64, len(point.MBucketCounts()))
 	var mean, deviation, prevBound float64
 
@@ -585,14 +814,15 @@ func (m *metricMapper) histogramPoint(point pmetric.HistogramDataPoint, projectI
 						},
 					},
 				},
-				Exemplars: m.exemplars(point.Exemplars(), projectID),
+				Range:     distributionRange(point.Count(), point.HasMin(), point.Min(), point.HasMax(), point.Max()),
+				Exemplars: m.exemplars(ctx, point.Exemplars(), point.Attributes(), projectID),
 			},
 		},
 	}
 }
 
 // Maps an exponential distribution into a GCM point.
-func (m *metricMapper) exponentialHistogramPoint(point pmetric.ExponentialHistogramDataPoint, projectID string) *monitoringpb.TypedValue {
+func (m *metricMapper) exponentialHistogramPoint(ctx context.Context, point pmetric.ExponentialHistogramDataPoint, projectID string) *monitoringpb.TypedValue {
 	// First calculate underflow bucket with all negatives + zeros.
 	underflow := point.ZeroCount()
 	for _, v := range point.Negative().MBucketCounts() {
@@ -641,47 +871,77 @@ func (m *metricMapper) exponentialHistogramPoint(point pmetric.ExponentialHistog
 				Mean:          mean,
 				BucketCounts:  counts,
 				BucketOptions: bucketOptions,
-				Exemplars:     m.exemplars(point.Exemplars(), projectID),
+				Range:         distributionRange(point.Count(), point.HasMin(), point.Min(), point.HasMax(), point.Max()),
+				Exemplars:     m.exemplars(ctx, point.Exemplars(), point.Attributes(), projectID),
 			},
 		},
 	}
 }
 
 func (m *metricMapper) histogramToTimeSeries(
+	ctx context.Context,
 	resource *monitoredrespb.MonitoredResource,
 	extraLabels labels,
 	metric pmetric.Metric,
 	hist pmetric.Histogram,
 	point pmetric.HistogramDataPoint,
+	relabeled relabeledPoint,
 	projectID string,
 ) []*monitoringpb.TimeSeries {
 	if point.Flags().HasFlag(pmetric.MetricDataPointFlagNoRecordedValue) || !point.HasSum() {
 		// Drop points without a value or without a sum
 		return nil
 	}
-	t, err := m.metricNameToType(metric.Name(), metric)
+	if !relabeled.ok {
+		return nil
+	}
+	name, pointLabels := relabeled.name, relabeled.labels
+	t, err := m.metricNameToType(name, metric)
 	if err != nil {
 		m.obs.log.Debug("Failed to get metric type (i.e. name) for histogram metric. Dropping the metric.", zap.Error(err), zap.Any("metric", metric))
 		return nil
 	}
 	if hist.AggregationTemporality() == pmetric.MetricAggregationTemporalityCumulative {
+		if start, ok := m.cumulativeStartTimestamp(point.StartTimestamp(), point.Timestamp()); ok {
+			newPoint := pmetric.NewHistogramDataPoint()
+			point.CopyTo(newPoint)
+			newPoint.SetStartTimestamp(start)
+			point = newPoint
+		}
 		// Normalize cumulative histogram points.
-		metricIdentifier := datapointstorage.Identifier(resource, extraLabels, metric, point.Attributes())
+		var metricIdentifier datapointstorage.Identifier
+		if m.cfg.MetricConfig.CumulativeNormalization {
+			metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+		}
 		normalizedPoint := m.normalizer.NormalizeHistogramDataPoint(point, metricIdentifier)
 		if normalizedPoint == nil {
 			return nil
 		}
 		point = *normalizedPoint
+	} else {
+		// Accumulate delta histogram points, if enabled.
+		var metricIdentifier datapointstorage.Identifier
+		if m.cfg.MetricConfig.DeltaToCumulative {
+			metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+		}
+		accumulatedPoint := m.accumulator.AccumulateHistogramDataPoint(point, metricIdentifier)
+		if accumulatedPoint == nil {
+			return nil
+		}
+		point = *accumulatedPoint
 	}
 
 	// We treat deltas as cumulatives w/ resets.
 	metricKind := metricpb.MetricDescriptor_CUMULATIVE
 	startTime := timestamppb.New(point.StartTimestamp().AsTime())
 	endTime := timestamppb.New(point.Timestamp().AsTime())
-	value := m.histogramPoint(point, projectID)
+	value := m.histogramPoint(ctx, point, projectID)
+	if value.GetDistributionValue().GetRange() == nil && point.Count() > 0 {
+		m.obs.recordDistributionRangeOmitted(ctx, t)
+	}
 	return []*monitoringpb.TimeSeries{{
 		Resource:   resource,
-		Unit:       metric.Unit(),
+		Unit:       m.unit(metric),
 		MetricKind: metricKind,
 		ValueType:  metricpb.MetricDescriptor_DISTRIBUTION,
 		Points: []*monitoringpb.Point{{
@@ -692,49 +952,81 @@ func (m *metricMapper) histogramToTimeSeries(
 			Value: value,
 		}},
 		Metric: &metricpb.Metric{
-			Type: t,
-			Labels: mergeLabels(
-				attributesToLabels(point.Attributes()),
-				extraLabels,
-			),
+			Type:   t,
+			Labels: pointLabels,
 		},
 	}}
 }
 
 func (m *metricMapper) exponentialHistogramToTimeSeries(
+	ctx context.Context,
 	resource *monitoredrespb.MonitoredResource,
 	extraLabels labels,
 	metric pmetric.Metric,
 	exponentialHist pmetric.ExponentialHistogram,
 	point pmetric.ExponentialHistogramDataPoint,
+	relabeled relabeledPoint,
 	projectID string,
 ) []*monitoringpb.TimeSeries {
 	if point.Flags().HasFlag(pmetric.MetricDataPointFlagNoRecordedValue) {
 		// Drop points without a value.
 		return nil
 	}
-	t, err := m.metricNameToType(metric.Name(), metric)
+	if !relabeled.ok {
+		return nil
+	}
+	name, pointLabels := relabeled.name, relabeled.labels
+	t, err := m.metricNameToType(name, metric)
 	if err != nil {
 		m.obs.log.Debug("Failed to get metric type (i.e. name) for exponential histogram metric. Dropping the metric.", zap.Error(err), zap.Any("metric", metric))
 		return nil
 	}
-	if exponentialHist.AggregationTemporality() == pmetric.MetricAggregationTemporalityCumulative {
+	// The identifier is only computed if the point is downscaled,
+	// normalized or accumulated, since it hashes all of the point's labels.
+	var metricIdentifier datapointstorage.Identifier
+	cumulative := exponentialHist.AggregationTemporality() == pmetric.MetricAggregationTemporalityCumulative
+	if m.downscaler != nil ||
+		(cumulative && m.cfg.MetricConfig.CumulativeNormalization) ||
+		(!cumulative && m.cfg.MetricConfig.DeltaToCumulative) {
+		metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+	}
+	if m.downscaler != nil {
+		// Downscale before normalizing, so points are subtracted from start
+		// points with the same scale.
+		point = m.downscaler.downscale(point, metricIdentifier)
+	}
+	if cumulative {
+		if start, ok := m.cumulativeStartTimestamp(point.StartTimestamp(), point.Timestamp()); ok {
+			newPoint := pmetric.NewExponentialHistogramDataPoint()
+			point.CopyTo(newPoint)
+			newPoint.SetStartTimestamp(start)
+			point = newPoint
+		}
 		// Normalize the histogram point.
-		metricIdentifier := datapointstorage.Identifier(resource, extraLabels, metric, point.Attributes())
 		normalizedPoint := m.normalizer.NormalizeExponentialHistogramDataPoint(point, metricIdentifier)
 		if normalizedPoint == nil {
 			return nil
 		}
 		point = *normalizedPoint
+	} else {
+		// Accumulate delta exponential histogram points, if enabled.
+		accumulatedPoint := m.accumulator.AccumulateExponentialHistogramDataPoint(point, metricIdentifier)
+		if accumulatedPoint == nil {
+			return nil
+		}
+		point = *accumulatedPoint
 	}
 	// We treat deltas as cumulatives w/ resets.
 	metricKind := metricpb.MetricDescriptor_CUMULATIVE
 	startTime := timestamppb.New(point.StartTimestamp().AsTime())
 	endTime := timestamppb.New(point.Timestamp().AsTime())
-	value := m.exponentialHistogramPoint(point, projectID)
+	value := m.exponentialHistogramPoint(ctx, point, projectID)
+	if value.GetDistributionValue().GetRange() == nil && point.Count() > 0 {
+		m.obs.recordDistributionRangeOmitted(ctx, t)
+	}
 	return []*monitoringpb.TimeSeries{{
 		Resource:   resource,
-		Unit:       metric.Unit(),
+		Unit:       m.unit(metric),
 		MetricKind: metricKind,
 		ValueType:  metricpb.MetricDescriptor_DISTRIBUTION,
 		Points: []*monitoringpb.Point{{
@@ -745,11 +1037,8 @@ func (m *metricMapper) exponentialHistogramToTimeSeries(
 			Value: value,
 		}},
 		Metric: &metricpb.Metric{
-			Type: t,
-			Labels: mergeLabels(
-				attributesToLabels(point.Attributes()),
-				extraLabels,
-			),
+			Type:   t,
+			Labels: pointLabels,
 		},
 	}}
 }
@@ -760,6 +1049,7 @@ func (m *metricMapper) sumPointToTimeSeries(
 	metric pmetric.Metric,
 	sum pmetric.Sum,
 	point pmetric.NumberDataPoint,
+	relabeled relabeledPoint,
 ) []*monitoringpb.TimeSeries {
 	metricKind := metricpb.MetricDescriptor_CUMULATIVE
 	var startTime *timestamppb.Timestamp
@@ -768,19 +1058,43 @@ func (m *metricMapper) sumPointToTimeSeries(
 		// prometheus.
 		return nil
 	}
-	t, err := m.metricNameToType(metric.Name(), metric)
+	if !relabeled.ok {
+		return nil
+	}
+	name, pointLabels := relabeled.name, relabeled.labels
+	t, err := m.metricNameToType(name, metric)
 	if err != nil {
 		m.obs.log.Debug("Failed to get metric type (i.e. name) for sum metric. Dropping the metric.", zap.Error(err), zap.Any("metric", metric))
 		return nil
 	}
 	if sum.IsMonotonic() {
 		if sum.AggregationTemporality() == pmetric.MetricAggregationTemporalityCumulative {
-			metricIdentifier := datapointstorage.Identifier(resource, extraLabels, metric, point.Attributes())
+			if start, ok := m.cumulativeStartTimestamp(point.StartTimestamp(), point.Timestamp()); ok {
+				newPoint := pmetric.NewNumberDataPoint()
+				point.CopyTo(newPoint)
+				newPoint.SetStartTimestamp(start)
+				point = newPoint
+			}
+			var metricIdentifier datapointstorage.Identifier
+			if m.cfg.MetricConfig.CumulativeNormalization {
+				metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+			}
 			normalizedPoint := m.normalizer.NormalizeNumberDataPoint(point, metricIdentifier)
 			if normalizedPoint == nil {
 				return nil
 			}
 			point = *normalizedPoint
+		} else {
+			// Accumulate delta sum points, if enabled.
+			var metricIdentifier datapointstorage.Identifier
+			if m.cfg.MetricConfig.DeltaToCumulative {
+				metricIdentifier = m.identifier(resource, extraLabels, metric, point.Attributes(), name, pointLabels)
+			}
+			accumulatedPoint := m.accumulator.AccumulateNumberDataPoint(point, metricIdentifier)
+			if accumulatedPoint == nil {
+				return nil
+			}
+			point = *accumulatedPoint
 		}
 		startTime = timestamppb.New(point.StartTimestamp().AsTime())
 	} else {
@@ -791,7 +1105,7 @@ func (m *metricMapper) sumPointToTimeSeries(
 
 	return []*monitoringpb.TimeSeries{{
 		Resource:   resource,
-		Unit:       metric.Unit(),
+		Unit:       m.unit(metric),
 		MetricKind: metricKind,
 		ValueType:  valueType,
 		Points: []*monitoringpb.Point{{
@@ -802,11 +1116,8 @@ func (m *metricMapper) sumPointToTimeSeries(
 			Value: value,
 		}},
 		Metric: &metricpb.Metric{
-			Type: t,
-			Labels: mergeLabels(
-				attributesToLabels(point.Attributes()),
-				extraLabels,
-			),
+			Type:   t,
+			Labels: pointLabels,
 		},
 	}}
 }
@@ -817,12 +1128,17 @@ func (m *metricMapper) gaugePointToTimeSeries(
 	metric pmetric.Metric,
 	gauge pmetric.Gauge,
 	point pmetric.NumberDataPoint,
+	relabeled relabeledPoint,
 ) []*monitoringpb.TimeSeries {
 	if point.Flags().HasFlag(pmetric.MetricDataPointFlagNoRecordedValue) {
 		// Drop points without a value.
 		return nil
 	}
-	t, err := m.metricNameToType(metric.Name(), metric)
+	if !relabeled.ok {
+		return nil
+	}
+	name, pointLabels := relabeled.name, relabeled.labels
+	t, err := m.metricNameToType(name, metric)
 	if err != nil {
 		m.obs.log.Debug("Unable to get metric type (i.e. name) for gauge metric.", zap.Error(err), zap.Any("metric", metric))
 		return nil
@@ -832,7 +1148,7 @@ func (m *metricMapper) gaugePointToTimeSeries(
 
 	return []*monitoringpb.TimeSeries{{
 		Resource:   resource,
-		Unit:       metric.Unit(),
+		Unit:       m.unit(metric),
 		MetricKind: metricKind,
 		ValueType:  valueType,
 		Points: []*monitoringpb.Point{{
@@ -842,15 +1158,74 @@ func (m *metricMapper) gaugePointToTimeSeries(
 			Value: value,
 		}},
 		Metric: &metricpb.Metric{
-			Type: t,
-			Labels: mergeLabels(
-				attributesToLabels(point.Attributes()),
-				extraLabels,
-			),
+			Type:   t,
+			Labels: pointLabels,
 		},
 	}}
 }
 
+// relabeledPoint is the metric name and labels of a point after relabeling.
+type relabeledPoint struct {
+	name   string
+	labels labels
+	// ok is false if the point is dropped by a relabeling rule.
+	ok bool
+}
+
+// relabeledMetric holds the relabeled points of a metric, in order, and the
+// label keys written for it. It is computed once per metric, so relabeling
+// rules run once for each point.
+type relabeledMetric struct {
+	points []relabeledPoint
+	// keysByName maps from the metric names the points are written to, to
+	// their label keys.
+	keysByName map[string][]string
+	// keep is the keep-set of the metric, or nil if label limits aren't
+	// enforced.
+	keep labelKeepSet
+}
+
+// relabelMetric relabels the points of the metric, and returns them with
+// the label keys written for the metric.
+func (m *metricMapper) relabelMetric(pm pmetric.Metric, extraLabels labels) relabeledMetric {
+	var relabeled relabeledMetric
+	forEachPointAttributes(pm, func(attrs pcommon.Map) {
+		relabeled.points = append(relabeled.points, m.pointLabels(pm, attrs, extraLabels))
+	})
+	relabeled.keysByName = m.labelKeys(pm, extraLabels, relabeled.points)
+	relabeled.keep = m.labelKeepSet(pm, relabeled.keysByName)
+	return relabeled
+}
+
+// pointLabels returns the metric name and labels of a point after
+// relabeling.
+func (m *metricMapper) pointLabels(metric pmetric.Metric, attrs pcommon.Map, extraLabels labels) relabeledPoint {
+	ls := mergeLabels(attributesToLabels(attrs), extraLabels)
+	if m.relabeler == nil {
+		return relabeledPoint{name: metric.Name(), labels: ls, ok: true}
+	}
+	name, ls, ok := m.relabeler.apply(metric.Name(), ls)
+	return relabeledPoint{name: name, labels: ls, ok: ok}
+}
+
+// identifier returns the Identifier of the timeseries of a point. With
+// relabeling rules, it identifies the relabeled name and labels of the point,
+// so points which are relabeled into the same timeseries are normalized and
+// accumulated together.
+func (m *metricMapper) identifier(
+	resource *monitoredrespb.MonitoredResource,
+	extraLabels labels,
+	metric pmetric.Metric,
+	attrs pcommon.Map,
+	name string,
+	pointLabels labels,
+) datapointstorage.Identifier {
+	if m.relabeler == nil {
+		return datapointstorage.NewIdentifier(resource, extraLabels, metric, attrs)
+	}
+	return datapointstorage.NewLabelsIdentifier(resource, name, pointLabels)
+}
+
 // Returns any configured prefix to add to unknown metric name.
 func (m *metricMapper) getMetricNamePrefix(name string) string {
 	for _, domain := range m.cfg.MetricConfig.KnownDomains {
@@ -941,8 +1316,8 @@ func mergeLabels(mergeInto labels, others ...labels) labels {
 
 // Takes a GCM metric type, like (workload.googleapis.com/MyCoolMetric) and returns the display name.
 func (m *metricMapper) metricTypeToDisplayName(mURL string) string {
-	// TODO - user configuration around display name?
-	// Default: strip domain, keep path after domain.
+	// Strip domain, keep path after domain. The display name can be
+	// overridden in MetricConfig.Descriptors.
 	u, err := url.Parse(fmt.Sprintf("metrics://%s", mURL))
 	if err != nil {
 		return mURL
@@ -950,74 +1325,138 @@ func (m *metricMapper) metricTypeToDisplayName(mURL string) string {
 	return strings.TrimLeft(u.Path, "/")
 }
 
-// Returns label descriptors for a metric.
-func (m *metricMapper) labelDescriptors(
-	pm pmetric.Metric,
-	extraLabels labels,
-) []*label.LabelDescriptor {
-	// TODO - allow customization of label descriptions.
-	result := []*label.LabelDescriptor{}
-	for key := range extraLabels {
-		result = append(result, &label.LabelDescriptor{
-			Key: sanitizeKey(key),
-		})
-	}
-
-	seenKeys := map[string]struct{}{}
-	addAttributes := func(attr pcommon.Map) {
-		attr.Range(func(key string, _ pcommon.Value) bool {
-			// Skip keys that have already been set
-			if _, ok := seenKeys[sanitizeKey(key)]; ok {
-				return true
-			}
-			result = append(result, &label.LabelDescriptor{
-				Key: sanitizeKey(key),
-			})
-			seenKeys[sanitizeKey(key)] = struct{}{}
-			return true
-		})
-	}
+// forEachPointAttributes calls f with the attributes of each point of the
+// metric.
+func forEachPointAttributes(pm pmetric.Metric, f func(pcommon.Map)) {
 	switch pm.DataType() {
 	case pmetric.MetricDataTypeGauge:
 		points := pm.Gauge().DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			addAttributes(points.At(i).Attributes())
+			f(points.At(i).Attributes())
 		}
 	case pmetric.MetricDataTypeSum:
 		points := pm.Sum().DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			addAttributes(points.At(i).Attributes())
+			f(points.At(i).Attributes())
 		}
 	case pmetric.MetricDataTypeSummary:
 		points := pm.Summary().DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			addAttributes(points.At(i).Attributes())
+			f(points.At(i).Attributes())
 		}
 	case pmetric.MetricDataTypeHistogram:
 		points := pm.Histogram().DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			addAttributes(points.At(i).Attributes())
+			f(points.At(i).Attributes())
 		}
 	case pmetric.MetricDataTypeExponentialHistogram:
 		points := pm.ExponentialHistogram().DataPoints()
 		for i := 0; i < points.Len(); i++ {
-			addAttributes(points.At(i).Attributes())
+			f(points.At(i).Attributes())
+		}
+	}
+}
+
+// labelKeys returns the label keys of the points of a metric, keyed by the
+// metric name its points are written to, given its relabeled points. The
+// metric name is only changed by relabeling.
+func (m *metricMapper) labelKeys(pm pmetric.Metric, extraLabels labels, points []relabeledPoint) map[string][]string {
+	result := map[string][]string{}
+	seenKeys := map[string]map[string]struct{}{}
+	addKey := func(name, key string) {
+		seen, ok := seenKeys[name]
+		if !ok {
+			seen = map[string]struct{}{}
+			seenKeys[name] = seen
+		}
+		// Skip keys that have already been set
+		if _, ok := seen[key]; ok {
+			return
+		}
+		result[name] = append(result[name], key)
+		seen[key] = struct{}{}
+	}
+	if m.relabeler == nil {
+		result[pm.Name()] = []string{}
+		for key := range extraLabels {
+			addKey(pm.Name(), sanitizeKey(key))
+		}
+		forEachPointAttributes(pm, func(attr pcommon.Map) {
+			attr.Range(func(key string, _ pcommon.Value) bool {
+				addKey(pm.Name(), sanitizeKey(key))
+				return true
+			})
+		})
+	} else {
+		for _, point := range points {
+			if !point.ok {
+				continue
+			}
+			keys := make([]string, 0, len(point.labels))
+			for key := range point.labels {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				addKey(point.name, key)
+			}
+		}
+	}
+	return result
+}
+
+// Returns label descriptors for a metric, keyed by the metric name its
+// points are written to. Label limits are enforced with the keep-set of the
+// metric.
+func (m *metricMapper) labelDescriptors(relabeled relabeledMetric) map[string][]*label.LabelDescriptor {
+	keep := relabeled.keep
+	result := make(map[string][]*label.LabelDescriptor, len(relabeled.keysByName))
+	for name, keys := range relabeled.keysByName {
+		descriptors := []*label.LabelDescriptor{}
+		hasOverflowKey := false
+		for _, key := range keys {
+			if keep != nil {
+				var ok bool
+				if key, ok = keep[key]; !ok {
+					continue
+				}
+			}
+			hasOverflowKey = hasOverflowKey || key == OverflowLabelKey
+			descriptors = append(descriptors, &label.LabelDescriptor{Key: key})
+		}
+		if m.cfg.MetricConfig.CardinalityLimit.enabled() && !hasOverflowKey {
+			descriptors = append(descriptors, &label.LabelDescriptor{
+				Key:         OverflowLabelKey,
+				Description: "Set to true on the timeseries that label combinations over the cardinality limit are written to.",
+			})
 		}
+		result[name] = descriptors
 	}
 	return result
 }
 
+// sortedNames returns the metric names of label descriptors returned by
+// labelDescriptors, in order.
+func sortedNames(descriptors map[string][]*label.LabelDescriptor) []string {
+	names := make([]string, 0, len(descriptors))
+	for name := range descriptors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Returns (sum, count, quantile) metric types (i.e. names) for a summary metric.
-func (m *metricMapper) summaryMetricTypes(pm pmetric.Metric) (string, string, string, error) {
-	sumType, err := m.metricNameToType(pm.Name()+SummarySumSuffix, pm)
+func (m *metricMapper) summaryMetricTypes(name string, pm pmetric.Metric) (string, string, string, error) {
+	sumType, err := m.metricNameToType(name+SummarySumSuffix, pm)
 	if err != nil {
 		return "", "", "", err
 	}
-	countType, err := m.metricNameToType(pm.Name()+SummaryCountPrefix, pm)
+	countType, err := m.metricNameToType(name+SummaryCountPrefix, pm)
 	if err != nil {
 		return "", "", "", err
 	}
-	quantileType, err := m.metricNameToType(pm.Name(), pm)
+	quantileType, err := m.metricNameToType(name, pm)
 	if err != nil {
 		return "", "", "", err
 	}
@@ -1026,81 +1465,106 @@ func (m *metricMapper) summaryMetricTypes(pm pmetric.Metric) (string, string, st
 
 func (m *metricMapper) summaryMetricDescriptors(
 	pm pmetric.Metric,
-	extraLabels labels,
+	relabeled relabeledMetric,
 ) []*metricpb.MetricDescriptor {
-	sumType, countType, quantileType, err := m.summaryMetricTypes(pm)
-	if err != nil {
-		m.obs.log.Debug("Failed to get metric types (i.e. names) for summary metric. Dropping the metric.", zap.Error(err), zap.Any("metric", pm))
-		return nil
+	labelsByName := m.labelDescriptors(relabeled)
+	var result []*metricpb.MetricDescriptor
+	for _, name := range sortedNames(labelsByName) {
+		sumType, countType, quantileType, err := m.summaryMetricTypes(name, pm)
+		if err != nil {
+			m.obs.log.Debug("Failed to get metric types (i.e. names) for summary metric. Dropping the metric.", zap.Error(err), zap.Any("metric", pm))
+			return nil
+		}
+		labels := labelsByName[name]
+		result = append(result,
+			&metricpb.MetricDescriptor{
+				Type:        sumType,
+				Labels:      labels,
+				MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
+				ValueType:   metricpb.MetricDescriptor_DOUBLE,
+				Unit:        m.unit(pm),
+				Description: pm.Description(),
+				DisplayName: name + SummarySumSuffix,
+			},
+			&metricpb.MetricDescriptor{
+				Type:        countType,
+				Labels:      labels,
+				MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
+				ValueType:   metricpb.MetricDescriptor_DOUBLE,
+				Unit:        m.unit(pm),
+				Description: pm.Description(),
+				DisplayName: name + SummaryCountPrefix,
+			},
+			&metricpb.MetricDescriptor{
+				Type: quantileType,
+				Labels: append(
+					labels,
+					&label.LabelDescriptor{
+						Key:         summaryQuantileLabelKey,
+						Description: "the value at a given quantile of a distribution",
+					}),
+				MetricKind:  metricpb.MetricDescriptor_GAUGE,
+				ValueType:   metricpb.MetricDescriptor_DOUBLE,
+				Unit:        m.unit(pm),
+				Description: pm.Description(),
+				DisplayName: name,
+			},
+		)
+		n := len(result)
+		m.descriptorOverrides.apply(name, SummarySumSuffix, result[n-3])
+		m.descriptorOverrides.apply(name, SummaryCountPrefix, result[n-2])
+		m.descriptorOverrides.apply(name, "", result[n-1])
 	}
-	labels := m.labelDescriptors(pm, extraLabels)
-	return []*metricpb.MetricDescriptor{
-		{
-			Type:        sumType,
-			Labels:      labels,
-			MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
-			ValueType:   metricpb.MetricDescriptor_DOUBLE,
-			Unit:        pm.Unit(),
-			Description: pm.Description(),
-			DisplayName: pm.Name() + SummarySumSuffix,
-		},
-		{
-			Type:        countType,
-			Labels:      labels,
-			MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
-			ValueType:   metricpb.MetricDescriptor_DOUBLE,
-			Unit:        pm.Unit(),
-			Description: pm.Description(),
-			DisplayName: pm.Name() + SummaryCountPrefix,
-		},
-		{
-			Type: quantileType,
-			Labels: append(
-				labels,
-				&label.LabelDescriptor{
-					Key:         "quantile",
-					Description: "the value at a given quantile of a distribution",
-				}),
-			MetricKind:  metricpb.MetricDescriptor_GAUGE,
-			ValueType:   metricpb.MetricDescriptor_DOUBLE,
-			Unit:        pm.Unit(),
-			Description: pm.Description(),
-			DisplayName: pm.Name(),
-		},
+	return result
+}
+
+// unit returns the Cloud Monitoring unit of the metric.
+func (m *metricMapper) unit(pm pmetric.Metric) string {
+	fallback := unitmapping.FallbackAnnotation
+	switch m.cfg.MetricConfig.UnknownUnitPolicy {
+	case UnknownUnitVerbatim:
+		fallback = unitmapping.FallbackVerbatim
+	case UnknownUnitDrop:
+		fallback = unitmapping.FallbackNone
 	}
+	return unitmapping.Translate(pm.Unit(), fallback)
 }
 
 // Extract the metric descriptor from a metric data point.
 func (m *metricMapper) metricDescriptor(
 	pm pmetric.Metric,
-	extraLabels labels,
+	relabeled relabeledMetric,
 ) []*metricpb.MetricDescriptor {
 	if pm.DataType() == pmetric.MetricDataTypeSummary {
-		return m.summaryMetricDescriptors(pm, extraLabels)
+		return m.summaryMetricDescriptors(pm, relabeled)
 	}
 	kind, typ := mapMetricPointKind(pm)
-	metricType, err := m.metricNameToType(pm.Name(), pm)
-	if err != nil {
-		m.obs.log.Debug("Failed to get metric type (i.e. name) for metric descriptor. Dropping the metric descriptor.", zap.Error(err), zap.Any("metric", pm))
-		return nil
-	}
-	labels := m.labelDescriptors(pm, extraLabels)
 	// Return nil for unsupported types.
 	if kind == metricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED {
 		return nil
 	}
-	return []*metricpb.MetricDescriptor{
-		{
-			Name:        pm.Name(),
+	labelsByName := m.labelDescriptors(relabeled)
+	var result []*metricpb.MetricDescriptor
+	for _, name := range sortedNames(labelsByName) {
+		metricType, err := m.metricNameToType(name, pm)
+		if err != nil {
+			m.obs.log.Debug("Failed to get metric type (i.e. name) for metric descriptor. Dropping the metric descriptor.", zap.Error(err), zap.Any("metric", pm))
+			return nil
+		}
+		md := &metricpb.MetricDescriptor{
+			Name:        name,
 			DisplayName: m.metricTypeToDisplayName(metricType),
 			Type:        metricType,
 			MetricKind:  kind,
 			ValueType:   typ,
-			Unit:        pm.Unit(),
+			Unit:        m.unit(pm),
 			Description: pm.Description(),
-			Labels:      labels,
-		},
+			Labels:      labelsByName[name],
+		}
+		m.descriptorOverrides.apply(name, "", md)
+		result = append(result, md)
 	}
+	return result
 }
 
 func metricPointValueType(pt pmetric.NumberDataPointValueType) metricpb.MetricDescriptor_ValueType {
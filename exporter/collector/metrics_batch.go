@@ -0,0 +1,151 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sort"
+	"strings"
+
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// splitBatches splits timeseries into batches of at most
+// MaxTimeSeriesPerRequest timeseries, whose CreateTimeSeries requests to the
+// named project are at most MaxRequestBytes. A timeseries which is too large
+// on its own is sent in a batch by itself.
+func (me *MetricsExporter) splitBatches(name string, tss []*monitoringpb.TimeSeries) [][]*monitoringpb.TimeSeries {
+	maxCount, maxBytes := me.maxTimeSeriesPerRequest(), me.maxRequestBytes()
+	overhead := proto.Size(&monitoringpb.CreateTimeSeriesRequest{Name: name})
+	var batches [][]*monitoringpb.TimeSeries
+	start, size := 0, overhead
+	for i, ts := range tss {
+		// The size of a timeseries in the request includes its field tag
+		// and length.
+		tsSize := protowire.SizeTag(2) + protowire.SizeBytes(proto.Size(ts))
+		if i > start && (i-start == maxCount || size+tsSize > maxBytes) {
+			batches = append(batches, tss[start:i])
+			start, size = i, overhead
+		}
+		size += tsSize
+	}
+	if start < len(tss) {
+		batches = append(batches, tss[start:])
+	}
+	return batches
+}
+
+func (me *MetricsExporter) maxTimeSeriesPerRequest() int {
+	if n := me.cfg.MetricConfig.MaxTimeSeriesPerRequest; n > 0 {
+		return n
+	}
+	return sendBatchSize
+}
+
+func (me *MetricsExporter) maxRequestBytes() int {
+	if n := me.cfg.MetricConfig.MaxRequestBytes; n > 0 {
+		return n
+	}
+	return defaultMaxRequestSize
+}
+
+// groupDuplicateTimeSeries splits timeseries into groups which each contain
+// at most one point for each timeseries. Groups must be sent in order.
+func (me *MetricsExporter) groupDuplicateTimeSeries(tss []*monitoringpb.TimeSeries) [][]*monitoringpb.TimeSeries {
+	// occurrences maps from a timeseries key to the indices of the points
+	// for that timeseries.
+	occurrences := make(map[string][]int, len(tss))
+	hasDuplicates := false
+	for i, ts := range tss {
+		key := timeSeriesKey(ts)
+		occurrences[key] = append(occurrences[key], i)
+		if len(occurrences[key]) > 1 {
+			hasDuplicates = true
+		}
+	}
+	if !hasDuplicates {
+		return [][]*monitoringpb.TimeSeries{tss}
+	}
+
+	// rank is the position of each point among the points of the same
+	// timeseries, ordered by timestamp.
+	rank := make([]int, len(tss))
+	for _, indices := range occurrences {
+		if len(indices) == 1 {
+			continue
+		}
+		sort.SliceStable(indices, func(a, b int) bool {
+			return endTimeNanos(tss[indices[a]]) < endTimeNanos(tss[indices[b]])
+		})
+		for r, i := range indices {
+			rank[i] = r
+		}
+		if me.cfg.MetricConfig.DuplicateTimeSeriesPolicy == DuplicateTimeSeriesKeepNewest {
+			// Only the newest point is kept, in the first group.
+			for _, i := range indices[:len(indices)-1] {
+				rank[i] = -1
+			}
+			rank[indices[len(indices)-1]] = 0
+		}
+	}
+
+	var groups [][]*monitoringpb.TimeSeries
+	for i, ts := range tss {
+		r := rank[i]
+		if r < 0 {
+			continue
+		}
+		for len(groups) <= r {
+			groups = append(groups, nil)
+		}
+		groups[r] = append(groups[r], ts)
+	}
+	return groups
+}
+
+func endTimeNanos(ts *monitoringpb.TimeSeries) int64 {
+	if len(ts.Points) == 0 {
+		return 0
+	}
+	return ts.Points[0].GetInterval().GetEndTime().AsTime().UnixNano()
+}
+
+// timeSeriesKey returns a string which uniquely identifies the timeseries by
+// its monitored resource, metric type and labels.
+func timeSeriesKey(ts *monitoringpb.TimeSeries) string {
+	var b strings.Builder
+	b.WriteString(ts.GetResource().GetType())
+	writeSortedLabels(&b, ts.GetResource().GetLabels())
+	b.WriteByte(0)
+	b.WriteString(ts.GetMetric().GetType())
+	writeSortedLabels(&b, ts.GetMetric().GetLabels())
+	return b.String()
+}
+
+func writeSortedLabels(b *strings.Builder, ls map[string]string) {
+	keys := make([]string, 0, len(ls))
+	for k := range ls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// Label keys and values are valid UTF-8, so they can't contain 0xff.
+		b.WriteByte(0xff)
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(ls[k])
+	}
+}
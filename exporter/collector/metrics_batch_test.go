@@ -0,0 +1,151 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newBatchTestTimeSeries(metricType string, metricLabels map[string]string, end time.Time) *monitoringpb.TimeSeries {
+	return &monitoringpb.TimeSeries{
+		Resource: &monitoredrespb.MonitoredResource{
+			Type:   "generic_task",
+			Labels: map[string]string{"job": "foo"},
+		},
+		Metric: &metricpb.Metric{
+			Type:   metricType,
+			Labels: metricLabels,
+		},
+		Points: []*monitoringpb.Point{{
+			Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.New(end)},
+		}},
+	}
+}
+
+// exportBatches exports the timeseries to myproject, one request at a time,
+// and returns the timeseries of each request, in the order they were sent.
+func exportBatches(t *testing.T, configure func(cfg *Config), tss []*monitoringpb.TimeSeries) [][]*monitoringpb.TimeSeries {
+	fake := &fakeTimeSeriesServer{}
+	me := newExportTestExporter(t, fake, 1)
+	configure(&me.cfg)
+	require.NoError(t, me.exportProjects(context.Background(), map[string][]*monitoringpb.TimeSeries{"myproject": tss}))
+	var batches [][]*monitoringpb.TimeSeries
+	for _, req := range fake.requests {
+		assert.Equal(t, "projects/myproject", req.Name)
+		batches = append(batches, req.TimeSeries)
+	}
+	return batches
+}
+
+func assertBatch(t *testing.T, expected, batch []*monitoringpb.TimeSeries) {
+	require.Len(t, batch, len(expected))
+	for i := range expected {
+		assert.True(t, proto.Equal(expected[i], batch[i]), "timeseries %d", i)
+	}
+}
+
+func TestBatchTimeSeries(t *testing.T) {
+	t.Run("No duplicates", func(t *testing.T) {
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 450; i++ {
+			tss = append(tss, newBatchTestTimeSeries("foo", map[string]string{"i": fmt.Sprint(i)}, start))
+		}
+		batches := exportBatches(t, func(*Config) {}, tss)
+		require.Len(t, batches, 3)
+		assert.Len(t, batches[0], 200)
+		assert.Len(t, batches[1], 200)
+		assert.Len(t, batches[2], 50)
+	})
+
+	t.Run("Max timeseries per request", func(t *testing.T) {
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 45; i++ {
+			tss = append(tss, newBatchTestTimeSeries("foo", map[string]string{"i": fmt.Sprint(i)}, start))
+		}
+		batches := exportBatches(t, func(cfg *Config) {
+			cfg.MetricConfig.MaxTimeSeriesPerRequest = 20
+		}, tss)
+		require.Len(t, batches, 3)
+		assert.Len(t, batches[0], 20)
+		assert.Len(t, batches[1], 20)
+		assert.Len(t, batches[2], 5)
+	})
+
+	t.Run("Max request bytes", func(t *testing.T) {
+		var tss []*monitoringpb.TimeSeries
+		for i := 0; i < 10; i++ {
+			value := strings.Repeat("x", 100)
+			if i == 5 {
+				// Larger than a request on its own.
+				value = strings.Repeat("x", 1000)
+			}
+			tss = append(tss, newBatchTestTimeSeries("foo", map[string]string{"i": fmt.Sprint(i), "v": value}, start))
+		}
+		maxRequestBytes := 3*proto.Size(tss[0]) + 50
+		batches := exportBatches(t, func(cfg *Config) {
+			cfg.MetricConfig.MaxRequestBytes = maxRequestBytes
+		}, tss)
+		var sizes []int
+		for _, batch := range batches {
+			sizes = append(sizes, len(batch))
+			if len(batch) > 1 {
+				req := &monitoringpb.CreateTimeSeriesRequest{Name: "projects/myproject", TimeSeries: batch}
+				assert.LessOrEqual(t, proto.Size(req), maxRequestBytes)
+			}
+		}
+		assert.Equal(t, []int{3, 2, 1, 3, 1}, sizes)
+	})
+
+	t.Run("Split duplicates", func(t *testing.T) {
+		newer := newBatchTestTimeSeries("foo", map[string]string{"a": "b"}, start.Add(time.Minute))
+		older := newBatchTestTimeSeries("foo", map[string]string{"a": "b"}, start)
+		other := newBatchTestTimeSeries("foo", map[string]string{"a": "c"}, start)
+		batches := exportBatches(t, func(*Config) {}, []*monitoringpb.TimeSeries{newer, other, older})
+		require.Len(t, batches, 2)
+		assertBatch(t, []*monitoringpb.TimeSeries{other, older}, batches[0])
+		assertBatch(t, []*monitoringpb.TimeSeries{newer}, batches[1])
+	})
+
+	t.Run("Keep newest", func(t *testing.T) {
+		newer := newBatchTestTimeSeries("foo", map[string]string{"a": "b"}, start.Add(time.Minute))
+		older := newBatchTestTimeSeries("foo", map[string]string{"a": "b"}, start)
+		other := newBatchTestTimeSeries("bar", map[string]string{"a": "b"}, start)
+		batches := exportBatches(t, func(cfg *Config) {
+			cfg.MetricConfig.DuplicateTimeSeriesPolicy = DuplicateTimeSeriesKeepNewest
+		}, []*monitoringpb.TimeSeries{newer, older, other})
+		require.Len(t, batches, 1)
+		assertBatch(t, []*monitoringpb.TimeSeries{newer, other}, batches[0])
+	})
+}
+
+func TestTimeSeriesKey(t *testing.T) {
+	a := newBatchTestTimeSeries("foo", map[string]string{"a": "b", "c": "d"}, start)
+	b := newBatchTestTimeSeries("foo", map[string]string{"c": "d", "a": "b"}, start.Add(time.Minute))
+	c := newBatchTestTimeSeries("foo", map[string]string{"a": "b", "c": "e"}, start)
+	assert.Equal(t, timeSeriesKey(a), timeSeriesKey(b))
+	assert.NotEqual(t, timeSeriesKey(a), timeSeriesKey(c))
+}
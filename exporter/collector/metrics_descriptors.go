@@ -0,0 +1,251 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+func mdCacheKey(name, metricType string) string {
+	return fmt.Sprintf("%s/%s", name, metricType)
+}
+
+// warmMetricDescriptorCache adds the existing metric descriptors under the
+// configured prefix in the project to the cache. Each project is only listed
+// once, even if listing fails. Concurrent callers wait until the project has
+// been listed.
+func (me *MetricsExporter) warmMetricDescriptorCache(name string) {
+	me.mdCacheMu.Lock()
+	once, ok := me.warmedProjects[name]
+	if !ok {
+		once = &sync.Once{}
+		me.warmedProjects[name] = once
+	}
+	me.mdCacheMu.Unlock()
+	once.Do(func() { me.listMetricDescriptors(name) })
+}
+
+func (me *MetricsExporter) listMetricDescriptors(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), me.timeout)
+	defer cancel()
+	it := me.client.ListMetricDescriptors(ctx, &monitoringpb.ListMetricDescriptorsRequest{
+		Name:   name,
+		Filter: fmt.Sprintf("metric.type = starts_with(%q)", me.cfg.MetricConfig.Prefix),
+	})
+	var count int
+	for {
+		md, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			me.obs.log.Error("Unable to list existing metric descriptors.", zap.Error(err), zap.String("project", name))
+			return
+		}
+		me.setCachedMetricDescriptor(mdCacheKey(name, md.Type), &monitoringpb.CreateMetricDescriptorRequest{
+			Name:             name,
+			MetricDescriptor: md,
+		})
+		count++
+	}
+	me.obs.log.Debug("Loaded existing metric descriptors.", zap.Int("count", count), zap.String("project", name))
+}
+
+func (me *MetricsExporter) cachedMetricDescriptor(cacheKey string) (*monitoringpb.CreateMetricDescriptorRequest, bool) {
+	me.mdCacheMu.Lock()
+	defer me.mdCacheMu.Unlock()
+	req, ok := me.mdCache[cacheKey]
+	return req, ok
+}
+
+func (me *MetricsExporter) setCachedMetricDescriptor(cacheKey string, req *monitoringpb.CreateMetricDescriptorRequest) {
+	me.mdCacheMu.Lock()
+	defer me.mdCacheMu.Unlock()
+	me.mdCache[cacheKey] = req
+}
+
+// metricDescriptorConflicts returns true if timeseries with the metric kind and
+// value type can't be written to the existing metric descriptor.
+func metricDescriptorConflicts(
+	existing *metricpb.MetricDescriptor,
+	kind metricpb.MetricDescriptor_MetricKind,
+	valueType metricpb.MetricDescriptor_ValueType,
+) bool {
+	return existing.GetMetricKind() != kind || existing.GetValueType() != valueType
+}
+
+// metricDescriptorMetadataChanged returns true if the metadata of the
+// descriptor differs from the existing descriptor of the same metric type.
+// Labels are only compared by their descriptions, since the existing
+// descriptor may have labels which aren't set on every export.
+func metricDescriptorMetadataChanged(existing, md *metricpb.MetricDescriptor) bool {
+	if existing.GetDisplayName() != md.GetDisplayName() ||
+		existing.GetDescription() != md.GetDescription() ||
+		existing.GetUnit() != md.GetUnit() ||
+		existing.GetLaunchStage() != md.GetLaunchStage() ||
+		!stringSetsEqual(existing.GetMonitoredResourceTypes(), md.GetMonitoredResourceTypes()) {
+		return true
+	}
+	descriptions := make(map[string]string, len(existing.GetLabels()))
+	for _, l := range existing.GetLabels() {
+		descriptions[l.Key] = l.Description
+	}
+	for _, l := range md.GetLabels() {
+		if description, ok := descriptions[l.Key]; l.Description != "" && (!ok || description != l.Description) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringSetsEqual(a, b []string) bool {
+	set := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		set[s] = struct{}{}
+	}
+	for _, s := range b {
+		if _, ok := set[s]; !ok {
+			return false
+		}
+		delete(set, s)
+	}
+	return len(set) == 0
+}
+
+// withExistingLabels returns a copy of the request whose descriptor also
+// has the labels of the existing descriptor it doesn't set.
+func withExistingLabels(req *monitoringpb.CreateMetricDescriptorRequest, existing *metricpb.MetricDescriptor) *monitoringpb.CreateMetricDescriptorRequest {
+	req = proto.Clone(req).(*monitoringpb.CreateMetricDescriptorRequest)
+	keys := make(map[string]struct{}, len(req.MetricDescriptor.Labels))
+	for _, l := range req.MetricDescriptor.Labels {
+		keys[l.Key] = struct{}{}
+	}
+	for _, l := range existing.GetLabels() {
+		if _, ok := keys[l.Key]; !ok {
+			req.MetricDescriptor.Labels = append(req.MetricDescriptor.Labels, l)
+		}
+	}
+	return req
+}
+
+// conflictMetricType returns the metric type that timeseries which conflict
+// with the existing descriptor of metricType are renamed to.
+func conflictMetricType(
+	metricType string,
+	kind metricpb.MetricDescriptor_MetricKind,
+	valueType metricpb.MetricDescriptor_ValueType,
+) string {
+	return fmt.Sprintf("%s_%s_%s", metricType, strings.ToLower(kind.String()), strings.ToLower(valueType.String()))
+}
+
+// renamedMetricType returns the metric type timeseries with the metric kind
+// and value type are written to in the project. It only differs from
+// metricType if the "rename" conflict strategy is used, and the existing
+// descriptor of metricType conflicts.
+func (me *MetricsExporter) renamedMetricType(
+	projectID string,
+	metricType string,
+	kind metricpb.MetricDescriptor_MetricKind,
+	valueType metricpb.MetricDescriptor_ValueType,
+) string {
+	if me.cfg.MetricConfig.MetricDescriptorConflictStrategy != MetricDescriptorConflictRename {
+		return metricType
+	}
+	existing, ok := me.cachedMetricDescriptor(mdCacheKey(projectName(projectID), metricType))
+	if !ok || !metricDescriptorConflicts(existing.MetricDescriptor, kind, valueType) {
+		return metricType
+	}
+	return conflictMetricType(metricType, kind, valueType)
+}
+
+// renameConflictingTimeSeries renames the metric types of timeseries which
+// conflict with existing metric descriptors, if configured.
+func (me *MetricsExporter) renameConflictingTimeSeries(projectID string, tss []*monitoringpb.TimeSeries) {
+	for _, ts := range tss {
+		if ts.Metric == nil {
+			continue
+		}
+		ts.Metric.Type = me.renamedMetricType(projectID, ts.Metric.Type, ts.MetricKind, ts.ValueType)
+	}
+}
+
+// handleMetricDescriptorConflict is called when the metric descriptor in req
+// conflicts with the existing one. It returns true if req should be created.
+func (me *MetricsExporter) handleMetricDescriptorConflict(
+	existing *monitoringpb.CreateMetricDescriptorRequest,
+	req *monitoringpb.CreateMetricDescriptorRequest,
+) bool {
+	cacheKey := mdCacheKey(req.Name, req.MetricDescriptor.Type)
+	strategy := me.cfg.MetricConfig.MetricDescriptorConflictStrategy
+	if me.firstMetricDescriptorConflict(cacheKey) {
+		me.obs.log.Warn(
+			"Metric descriptor conflicts with the existing metric descriptor.",
+			zap.String("metric_type", req.MetricDescriptor.Type),
+			zap.String("existing_metric_kind", existing.MetricDescriptor.GetMetricKind().String()),
+			zap.String("existing_value_type", existing.MetricDescriptor.GetValueType().String()),
+			zap.String("metric_kind", req.MetricDescriptor.MetricKind.String()),
+			zap.String("value_type", req.MetricDescriptor.ValueType.String()),
+			zap.String("strategy", strategy),
+		)
+		me.obs.recordMetricDescriptorConflict(context.Background(), req.MetricDescriptor.Type)
+	}
+	if strategy != MetricDescriptorConflictRecreate {
+		// With the "rename" strategy, later timeseries and descriptors are
+		// renamed when they are mapped.
+		return false
+	}
+
+	me.obs.log.Warn(
+		"Deleting conflicting metric descriptor, including ALL of its data.",
+		zap.String("metric_type", req.MetricDescriptor.Type),
+		zap.String("project", req.Name),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), me.timeout)
+	defer cancel()
+	err := me.client.DeleteMetricDescriptor(ctx, &monitoringpb.DeleteMetricDescriptorRequest{
+		Name: fmt.Sprintf("%s/metricDescriptors/%s", req.Name, req.MetricDescriptor.Type),
+	})
+	if err != nil {
+		me.obs.log.Error("Unable to delete conflicting metric descriptor.", zap.Error(err), zap.String("metric_type", req.MetricDescriptor.Type))
+		return false
+	}
+	// Log the next conflict for this metric type, since it means the
+	// descriptor is recreated repeatedly.
+	me.mdCacheMu.Lock()
+	delete(me.mdConflicts, cacheKey)
+	me.mdCacheMu.Unlock()
+	return true
+}
+
+// firstMetricDescriptorConflict returns true if the conflict of the cached
+// metric descriptor hasn't been logged yet, and marks it as logged.
+func (me *MetricsExporter) firstMetricDescriptorConflict(cacheKey string) bool {
+	me.mdCacheMu.Lock()
+	defer me.mdCacheMu.Unlock()
+	if _, logged := me.mdConflicts[cacheKey]; logged {
+		return false
+	}
+	me.mdConflicts[cacheKey] = struct{}{}
+	return true
+}
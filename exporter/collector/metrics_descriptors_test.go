@@ -0,0 +1,247 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/api/label"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeDescriptorServer struct {
+	monitoringpb.UnimplementedMetricServiceServer
+	existing []*metricpb.MetricDescriptor
+
+	mu      sync.Mutex
+	filters []string
+	created []string
+	deleted []string
+	// descriptors are the created descriptors.
+	descriptors []*metricpb.MetricDescriptor
+}
+
+func (f *fakeDescriptorServer) ListMetricDescriptors(
+	ctx context.Context,
+	req *monitoringpb.ListMetricDescriptorsRequest,
+) (*monitoringpb.ListMetricDescriptorsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.filters = append(f.filters, req.Filter)
+	return &monitoringpb.ListMetricDescriptorsResponse{MetricDescriptors: f.existing}, nil
+}
+
+func (f *fakeDescriptorServer) CreateMetricDescriptor(
+	ctx context.Context,
+	req *monitoringpb.CreateMetricDescriptorRequest,
+) (*metricpb.MetricDescriptor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, req.MetricDescriptor.Type)
+	f.descriptors = append(f.descriptors, req.MetricDescriptor)
+	return req.MetricDescriptor, nil
+}
+
+func (f *fakeDescriptorServer) DeleteMetricDescriptor(
+	ctx context.Context,
+	req *monitoringpb.DeleteMetricDescriptorRequest,
+) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func newDescriptorTestExporter(t *testing.T, strategy string, existing ...*metricpb.MetricDescriptor) (*MetricsExporter, *fakeDescriptorServer) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	fake := &fakeDescriptorServer{existing: existing}
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cfg := DefaultConfig()
+	cfg.ProjectID = "myproject"
+	cfg.MetricConfig.ClientConfig.Endpoint = lis.Addr().String()
+	cfg.MetricConfig.ClientConfig.UseInsecure = true
+	cfg.MetricConfig.WarmMetricDescriptorCache = true
+	cfg.MetricConfig.MetricDescriptorConflictStrategy = strategy
+	cfg.MetricConfig.AllowMetricDescriptorDeletion = strategy == MetricDescriptorConflictRecreate
+	me, err := NewGoogleCloudMetricsExporter(context.Background(), cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { me.Shutdown(context.Background()) })
+	return me, fake
+}
+
+func TestMetricDescriptorConflicts(t *testing.T) {
+	existing := &metricpb.MetricDescriptor{
+		Type:       "workload.googleapis.com/foo",
+		MetricKind: metricpb.MetricDescriptor_GAUGE,
+		ValueType:  metricpb.MetricDescriptor_INT64,
+	}
+	newReq := func(kind metricpb.MetricDescriptor_MetricKind, valueType metricpb.MetricDescriptor_ValueType) *monitoringpb.CreateMetricDescriptorRequest {
+		return &monitoringpb.CreateMetricDescriptorRequest{
+			Name: "projects/myproject",
+			MetricDescriptor: &metricpb.MetricDescriptor{
+				Type:       "workload.googleapis.com/foo",
+				MetricKind: kind,
+				ValueType:  valueType,
+			},
+		}
+	}
+
+	t.Run("Existing descriptors are not created", func(t *testing.T) {
+		me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictSkip, existing)
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_GAUGE, metricpb.MetricDescriptor_INT64))
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_GAUGE, metricpb.MetricDescriptor_INT64))
+		assert.Equal(t, []string{`metric.type = starts_with("workload.googleapis.com")`}, fake.filters)
+		assert.Empty(t, fake.created)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictSkip, existing)
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_INT64))
+		assert.Empty(t, fake.created)
+		assert.Empty(t, fake.deleted)
+		assert.Equal(t, "workload.googleapis.com/foo", me.renamedMetricType("myproject", existing.Type, metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_INT64))
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictRename, existing)
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_DOUBLE))
+		assert.Empty(t, fake.created)
+
+		tss := []*monitoringpb.TimeSeries{{
+			Metric:     &metricpb.Metric{Type: existing.Type},
+			MetricKind: metricpb.MetricDescriptor_CUMULATIVE,
+			ValueType:  metricpb.MetricDescriptor_DOUBLE,
+		}, {
+			Metric:     &metricpb.Metric{Type: existing.Type},
+			MetricKind: metricpb.MetricDescriptor_GAUGE,
+			ValueType:  metricpb.MetricDescriptor_INT64,
+		}}
+		me.renameConflictingTimeSeries("myproject", tss)
+		assert.Equal(t, "workload.googleapis.com/foo_cumulative_double", tss[0].Metric.Type)
+		assert.Equal(t, "workload.googleapis.com/foo", tss[1].Metric.Type)
+	})
+
+	t.Run("Recreate", func(t *testing.T) {
+		me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictRecreate, existing)
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_INT64))
+		assert.Equal(t, []string{"projects/myproject/metricDescriptors/workload.googleapis.com/foo"}, fake.deleted)
+		assert.Equal(t, []string{"workload.googleapis.com/foo"}, fake.created)
+		// The new descriptor is cached.
+		me.exportMetricDescriptor(newReq(metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_INT64))
+		assert.Len(t, fake.created, 1)
+	})
+}
+
+func TestMetricDescriptorConflictRenameBeforeExport(t *testing.T) {
+	existing := &metricpb.MetricDescriptor{
+		Type:       "workload.googleapis.com/foo",
+		MetricKind: metricpb.MetricDescriptor_GAUGE,
+		ValueType:  metricpb.MetricDescriptor_INT64,
+	}
+	me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictRename, existing)
+
+	metric := pmetric.NewMetric()
+	metric.SetName("foo")
+	metric.SetDataType(pmetric.MetricDataTypeGauge)
+	metric.Gauge().DataPoints().AppendEmpty().SetDoubleVal(1)
+
+	// Conflicts are detected by the first export of each concurrent push,
+	// before any descriptor has been exported.
+	const pushes = 10
+	results := make([]map[string][]*monitoringpb.TimeSeries, pushes)
+	var wg sync.WaitGroup
+	for i := range results {
+		results[i] = map[string][]*monitoringpb.TimeSeries{}
+		wg.Add(1)
+		go func(pending map[string][]*monitoringpb.TimeSeries) {
+			defer wg.Done()
+			me.appendMetric(context.Background(), pending, me.mapper, nil, labels{}, metric, "myproject")
+		}(results[i])
+	}
+	wg.Wait()
+
+	for _, pending := range results {
+		require.Len(t, pending["myproject"], 1)
+		assert.Equal(t, "workload.googleapis.com/foo_gauge_double", pending["myproject"][0].Metric.Type)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Len(t, fake.filters, 1)
+}
+
+func TestMetricDescriptorMetadataUpdates(t *testing.T) {
+	existing := &metricpb.MetricDescriptor{
+		Type:        "workload.googleapis.com/foo",
+		MetricKind:  metricpb.MetricDescriptor_GAUGE,
+		ValueType:   metricpb.MetricDescriptor_INT64,
+		Description: "foo",
+		Labels:      []*label.LabelDescriptor{{Key: "a"}, {Key: "b"}},
+	}
+	newReq := func(description string, labels ...*label.LabelDescriptor) *monitoringpb.CreateMetricDescriptorRequest {
+		return &monitoringpb.CreateMetricDescriptorRequest{
+			Name: "projects/myproject",
+			MetricDescriptor: &metricpb.MetricDescriptor{
+				Type:        "workload.googleapis.com/foo",
+				MetricKind:  metricpb.MetricDescriptor_GAUGE,
+				ValueType:   metricpb.MetricDescriptor_INT64,
+				Description: description,
+				Labels:      labels,
+			},
+		}
+	}
+
+	me, fake := newDescriptorTestExporter(t, MetricDescriptorConflictSkip, existing)
+	// Labels without descriptions don't update the descriptor.
+	me.exportMetricDescriptor(newReq("foo", &label.LabelDescriptor{Key: "a"}))
+	assert.Empty(t, fake.created)
+
+	me.exportMetricDescriptor(newReq("bar", &label.LabelDescriptor{Key: "a"}))
+	require.Len(t, fake.descriptors, 1)
+	assert.Equal(t, "bar", fake.descriptors[0].Description)
+	// The labels of the existing descriptor are kept.
+	assert.Equal(t, []string{"a", "b"}, labelKeys(fake.descriptors[0].Labels))
+
+	me.exportMetricDescriptor(newReq("bar", &label.LabelDescriptor{Key: "b", Description: "the b label"}))
+	require.Len(t, fake.descriptors, 2)
+	assert.Equal(t, []string{"b", "a"}, labelKeys(fake.descriptors[1].Labels))
+	assert.Equal(t, "the b label", fake.descriptors[1].Labels[0].Description)
+
+	// The updated descriptor is cached.
+	me.exportMetricDescriptor(newReq("bar", &label.LabelDescriptor{Key: "b", Description: "the b label"}))
+	assert.Len(t, fake.descriptors, 2)
+}
+
+func labelKeys(labels []*label.LabelDescriptor) []string {
+	var keys []string
+	for _, l := range labels {
+		keys = append(keys, l.Key)
+	}
+	return keys
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc/status"
+)
+
+// exportProjects sends the timeseries of each project concurrently, and
+// returns the combined errors.
+func (me *MetricsExporter) exportProjects(ctx context.Context, pendingTimeSeries map[string][]*monitoringpb.TimeSeries) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for projectID, projectTS := range pendingTimeSeries {
+		wg.Add(1)
+		go func(projectID string, projectTS []*monitoringpb.TimeSeries) {
+			defer wg.Done()
+			if err := me.exportProject(ctx, projectID, projectTS); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(projectID, projectTS)
+	}
+	wg.Wait()
+	return multierr.Combine(errs...)
+}
+
+// exportProject sends the timeseries of a project, with at most
+// MaxConcurrentRequestsPerProject requests in flight for the project, and
+// MaxConcurrentRequests across all projects. Groups of duplicate timeseries
+// are sent one after another, so the points of each timeseries are written
+// in order. If the context is done while waiting to send a request, the
+// remaining timeseries are not sent.
+func (me *MetricsExporter) exportProject(ctx context.Context, projectID string, tss []*monitoringpb.TimeSeries) error {
+	projectSem := make(chan struct{}, me.maxConcurrentRequestsPerProject())
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, group := range me.groupDuplicateTimeSeries(tss) {
+		var wg sync.WaitGroup
+		for _, batch := range me.splitBatches(projectName(projectID), group) {
+			select {
+			case projectSem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return multierr.Append(multierr.Combine(errs...), ctx.Err())
+			}
+			select {
+			case me.requestSem <- struct{}{}:
+			case <-ctx.Done():
+				<-projectSem
+				wg.Wait()
+				return multierr.Append(multierr.Combine(errs...), ctx.Err())
+			}
+			wg.Add(1)
+			go func(batch []*monitoringpb.TimeSeries) {
+				defer func() {
+					<-me.requestSem
+					<-projectSem
+					wg.Done()
+				}()
+				if err := me.exportBatch(ctx, projectID, batch); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(batch)
+		}
+		wg.Wait()
+	}
+	return multierr.Combine(errs...)
+}
+
+// exportBatch sends a single CreateTimeSeries request, retrying it or writing
+// it to the write-ahead log if enabled.
+func (me *MetricsExporter) exportBatch(ctx context.Context, projectID string, batch []*monitoringpb.TimeSeries) error {
+	req := &monitoringpb.CreateTimeSeriesRequest{
+		Name:       projectName(projectID),
+		TimeSeries: batch,
+	}
+	if me.wal != nil && me.wal.pending(projectID) {
+		// Earlier requests are waiting to be replayed, so this one
+		// must be written after them.
+		return me.enqueueToWAL(projectID, req)
+	}
+	me.obs.recordTimeSeriesBatch(ctx, len(req.TimeSeries))
+	start := time.Now()
+	err := me.sendTimeSeries(ctx, req)
+	if err != nil && me.cfg.MetricConfig.RetryPartialFailures {
+		req, err = me.retryPartialFailures(ctx, req, err)
+	}
+	s, _ := status.FromError(err)
+	me.obs.recordProjectRequest(ctx, projectID, statusCodeToString(s), time.Since(start))
+	if err != nil && me.wal != nil && isRetryableStatus(s) {
+		// The request will be retried from the write-ahead log.
+		walErr := me.enqueueToWAL(projectID, req)
+		if walErr == nil {
+			return nil
+		}
+		me.obs.recordCreateTimeSeriesResult(ctx, s, len(req.TimeSeries), 0)
+		return multierr.Append(fmt.Errorf("failed to export time series to GCM: %v", err), walErr)
+	}
+
+	me.obs.recordCreateTimeSeriesResult(ctx, s, len(req.TimeSeries), 0)
+	if err != nil {
+		return fmt.Errorf("failed to export time series to GCM: %v", err)
+	}
+	return nil
+}
+
+func maxConcurrentRequests(cfg Config) int {
+	if n := cfg.MetricConfig.MaxConcurrentRequests; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (me *MetricsExporter) maxConcurrentRequestsPerProject() int {
+	if n := me.cfg.MetricConfig.MaxConcurrentRequestsPerProject; n > 0 {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeTimeSeriesServer struct {
+	monitoringpb.UnimplementedMetricServiceServer
+	// block, if set, is called before a request for the project is answered.
+	block func(project string)
+	// respond, if set, returns the error of each request.
+	respond func(req *monitoringpb.CreateTimeSeriesRequest) error
+
+	mu          sync.Mutex
+	requests    []*monitoringpb.CreateTimeSeriesRequest
+	inFlight    map[string]int
+	maxInFlight map[string]int
+}
+
+func (f *fakeTimeSeriesServer) CreateTimeSeries(
+	ctx context.Context,
+	req *monitoringpb.CreateTimeSeriesRequest,
+) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.inFlight[req.Name]++
+	if f.inFlight[req.Name] > f.maxInFlight[req.Name] {
+		f.maxInFlight[req.Name] = f.inFlight[req.Name]
+	}
+	f.mu.Unlock()
+	if f.block != nil {
+		f.block(req.Name)
+	}
+	f.mu.Lock()
+	f.inFlight[req.Name]--
+	f.mu.Unlock()
+	if f.respond != nil {
+		return &emptypb.Empty{}, f.respond(req)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func newExportTestExporter(t *testing.T, fake *fakeTimeSeriesServer, perProject int) *MetricsExporter {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	fake.inFlight = map[string]int{}
+	fake.maxInFlight = map[string]int{}
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cfg := DefaultConfig()
+	cfg.ProjectID = "myproject"
+	cfg.MetricConfig.ClientConfig.Endpoint = lis.Addr().String()
+	cfg.MetricConfig.ClientConfig.UseInsecure = true
+	cfg.MetricConfig.MaxConcurrentRequestsPerProject = perProject
+	me, err := NewGoogleCloudMetricsExporter(context.Background(), cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { me.Shutdown(context.Background()) })
+	return me
+}
+
+func newExportTestTimeSeries(n int, end time.Time) []*monitoringpb.TimeSeries {
+	tss := make([]*monitoringpb.TimeSeries, n)
+	for i := range tss {
+		tss[i] = newBatchTestTimeSeries("workload.googleapis.com/foo", map[string]string{"i": fmt.Sprint(i)}, end)
+	}
+	return tss
+}
+
+func TestExportProjects(t *testing.T) {
+	end := start.Add(time.Minute)
+
+	t.Run("Slow projects don't block other projects", func(t *testing.T) {
+		release := make(chan struct{})
+		fastDone := make(chan struct{})
+		fake := &fakeTimeSeriesServer{block: func(project string) {
+			if project == "projects/slow" {
+				<-release
+			} else {
+				close(fastDone)
+			}
+		}}
+		me := newExportTestExporter(t, fake, 1)
+
+		errC := make(chan error)
+		go func() {
+			errC <- me.exportProjects(context.Background(), map[string][]*monitoringpb.TimeSeries{
+				"slow": newExportTestTimeSeries(1, end),
+				"fast": newExportTestTimeSeries(1, end),
+			})
+		}()
+		select {
+		case <-fastDone:
+		case <-time.After(10 * time.Second):
+			t.Fatal("request for the fast project was blocked by the slow project")
+		}
+		close(release)
+		assert.NoError(t, <-errC)
+	})
+
+	t.Run("Concurrency is limited per project", func(t *testing.T) {
+		fake := &fakeTimeSeriesServer{block: func(string) { time.Sleep(10 * time.Millisecond) }}
+		me := newExportTestExporter(t, fake, 2)
+
+		err := me.exportProjects(context.Background(), map[string][]*monitoringpb.TimeSeries{
+			"myproject": newExportTestTimeSeries(5*sendBatchSize, end),
+		})
+		require.NoError(t, err)
+		assert.Len(t, fake.requests, 5)
+		assert.LessOrEqual(t, fake.maxInFlight["projects/myproject"], 2)
+	})
+
+	t.Run("Points of a timeseries are written in order", func(t *testing.T) {
+		fake := &fakeTimeSeriesServer{}
+		me := newExportTestExporter(t, fake, 4)
+
+		tss := newExportTestTimeSeries(2*sendBatchSize, end)
+		for _, ts := range tss {
+			ts.Metric.Type = "workload.googleapis.com/bar"
+		}
+		newer := newBatchTestTimeSeries("workload.googleapis.com/foo", nil, end.Add(time.Minute))
+		older := newBatchTestTimeSeries("workload.googleapis.com/foo", nil, end)
+		err := me.exportProjects(context.Background(), map[string][]*monitoringpb.TimeSeries{
+			"myproject": append(tss, newer, older),
+		})
+		require.NoError(t, err)
+		require.Len(t, fake.requests, 4)
+		// The newer point is sent after all requests with the older point
+		// have completed.
+		last := fake.requests[len(fake.requests)-1]
+		require.Len(t, last.TimeSeries, 1)
+		assert.True(t, last.TimeSeries[0].Points[0].Interval.EndTime.AsTime().Equal(end.Add(time.Minute)))
+	})
+	t.Run("Waiting to send stops when the context is done", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		fake := &fakeTimeSeriesServer{block: func(string) {
+			started <- struct{}{}
+			<-release
+		}}
+		me := newExportTestExporter(t, fake, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errC := make(chan error)
+		go func() {
+			errC <- me.exportProjects(ctx, map[string][]*monitoringpb.TimeSeries{
+				"myproject": newExportTestTimeSeries(2*sendBatchSize, end),
+			})
+		}()
+		<-started
+		// The second request is waiting for the first one.
+		cancel()
+		close(release)
+		assert.ErrorIs(t, <-errC, context.Canceled)
+		assert.Len(t, fake.requests, 1)
+	})
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// The maximum number of times timeseries which failed with a retryable
+	// error are resent within a single export.
+	maxPartialFailureRetries = 3
+	partialFailureBackoff    = 500 * time.Millisecond
+)
+
+// isRetryableCode returns true for codes where sending the same points again
+// can succeed.
+func isRetryableCode(c codes.Code) bool {
+	return c == codes.Unavailable || c == codes.DeadlineExceeded
+}
+
+// rejectionReasons map the messages of errors of Cloud Monitoring to the
+// reasons recorded for the points they reject. The first match is used.
+var rejectionReasons = []struct {
+	message *regexp.Regexp
+	reason  string
+}{
+	{regexp.MustCompile(`(?i)points must be written in order`), "out_of_order"},
+	{regexp.MustCompile(`(?i)more frequently than the maximum sampling period`), "written_too_frequently"},
+	{regexp.MustCompile(`(?i)duplicate timeseries`), "duplicate_series"},
+	{regexp.MustCompile(`(?i)label.*(too long|exceeds the maximum)`), "label_too_long"},
+	{regexp.MustCompile(`(?i)(over|more than) [0-9]+ labels`), "too_many_labels"},
+	{regexp.MustCompile(`(?i)(too old|hours in the past)`), "too_old"},
+	{regexp.MustCompile(`(?i)(resource labels is incomplete|missing labels)`), "missing_resource_labels"},
+	{regexp.MustCompile(`(?i)(unrecognized|unknown|invalid) (metric|resource) type`), "unknown_type"},
+}
+
+// retryableMessage matches the messages Cloud Monitoring lists for
+// timeseries which failed with a retryable error.
+var retryableMessage = regexp.MustCompile(`(?i)(internal error|currently unavailable|deadline exceeded|please retry)`)
+
+// messageReason returns the reason of the error message, or false if it isn't
+// known.
+func messageReason(message string) (string, bool) {
+	for _, r := range rejectionReasons {
+		if r.message.MatchString(message) {
+			return r.reason, true
+		}
+	}
+	return "", false
+}
+
+// rejectionReason returns the reason recorded for points which were rejected
+// with the status. It is the reason of the ErrorInfo detail of the status, if
+// it has one, then the reason of its message, and otherwise its code.
+func rejectionReason(s *status.Status) string {
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason != "" {
+			return strings.ToLower(info.Reason)
+		}
+	}
+	if reason, ok := messageReason(s.Message()); ok {
+		return reason
+	}
+	return strings.ToLower(statusCodeToString(status.New(s.Code(), "")))
+}
+
+// timeSeriesError is the error of some of the timeseries of a request,
+// parsed from the message of a failed CreateTimeSeries request.
+type timeSeriesError struct {
+	message string
+	// indexes are the indexes of the timeseries in the request.
+	indexes []int
+}
+
+var (
+	// timeSeriesIndexes matches the timeseries of an error, e.g.
+	// timeSeries[0,2-3].
+	timeSeriesIndexes = regexp.MustCompile(`timeSeries\[([0-9][0-9,\- ]*)\]`)
+	// timeSeriesErrorsPrefix precedes the errors of each timeseries.
+	timeSeriesErrorsPrefix = regexp.MustCompile(`(?i)^.*could not be written: `)
+)
+
+// parseTimeSeriesErrors returns the errors listed in the message of a failed
+// CreateTimeSeries request. Cloud Monitoring doesn't set the messages of the
+// errors of the CreateTimeSeriesSummary, but lists the error of each
+// timeseries in the message of the status, e.g. "One or more TimeSeries could
+// not be written: Points must be written in order. (...): timeSeries[0,2];
+// Internal error encountered. (...): timeSeries[1]".
+func parseTimeSeriesErrors(message string) []timeSeriesError {
+	message = timeSeriesErrorsPrefix.ReplaceAllString(message, "")
+	var errs []timeSeriesError
+	for _, part := range strings.Split(message, "; ") {
+		matches := timeSeriesIndexes.FindAllStringSubmatchIndex(part, -1)
+		if matches == nil {
+			continue
+		}
+		tsErr := timeSeriesError{message: part}
+		if last := matches[len(matches)-1]; last[1] == len(part) {
+			// The list of timeseries which failed with the error.
+			tsErr.message = strings.TrimSuffix(part[:last[0]], ": ")
+			matches = matches[len(matches)-1:]
+		}
+		for _, match := range matches {
+			tsErr.indexes = append(tsErr.indexes, parseIndexes(part[match[2]:match[3]])...)
+		}
+		errs = append(errs, tsErr)
+	}
+	return errs
+}
+
+// parseIndexes parses a list of indexes and ranges of indexes, e.g. 0,2-3.
+// Invalid entries are skipped.
+func parseIndexes(list string) []int {
+	var indexes []int
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		first, last := entry, entry
+		if i := strings.IndexByte(entry, '-'); i >= 0 {
+			first, last = entry[:i], entry[i+1:]
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			continue
+		}
+		for i := from; i <= to; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// pointRejections returns the number of points of a partially failed request
+// which were rejected permanently, by reason. The reasons of errors of the
+// summary without a message are taken from the errors listed in the message
+// of the status, and otherwise from their code.
+func pointRejections(s *status.Status, summary *monitoringpb.CreateTimeSeriesSummary) map[string]int {
+	rejections := map[string]int{}
+	unattributed := map[codes.Code]int{}
+	remaining := 0
+	for _, e := range summary.GetErrors() {
+		c := codes.Code(e.GetStatus().GetCode())
+		if isRetryableCode(c) {
+			continue
+		}
+		if e.GetStatus().GetMessage() != "" {
+			rejections[rejectionReason(status.FromProto(e.GetStatus()))] += int(e.PointCount)
+			continue
+		}
+		unattributed[c] += int(e.PointCount)
+		remaining += int(e.PointCount)
+	}
+	for _, tsErr := range parseTimeSeriesErrors(s.Message()) {
+		if remaining == 0 {
+			break
+		}
+		if retryableMessage.MatchString(tsErr.message) {
+			continue
+		}
+		reason, ok := messageReason(tsErr.message)
+		if !ok {
+			continue
+		}
+		points := len(tsErr.indexes)
+		if points > remaining {
+			points = remaining
+		}
+		rejections[reason] += points
+		remaining -= points
+	}
+	// The remaining points are recorded with their code.
+	var unattributedCodes []codes.Code
+	for c := range unattributed {
+		unattributedCodes = append(unattributedCodes, c)
+	}
+	sort.Slice(unattributedCodes, func(i, j int) bool { return unattributedCodes[i] < unattributedCodes[j] })
+	for _, c := range unattributedCodes {
+		points := unattributed[c]
+		if points > remaining {
+			points = remaining
+		}
+		if points > 0 {
+			rejections[strings.ToLower(statusCodeToString(status.New(c, "")))] += points
+		}
+		remaining -= points
+	}
+	return rejections
+}
+
+// createTimeSeriesSummary returns the CreateTimeSeriesSummary detail of the
+// status, or nil if it has none.
+func createTimeSeriesSummary(s *status.Status) *monitoringpb.CreateTimeSeriesSummary {
+	for _, detail := range s.Details() {
+		if summary, ok := detail.(*monitoringpb.CreateTimeSeriesSummary); ok {
+			return summary
+		}
+	}
+	return nil
+}
+
+// retryablePointCount returns the number of points of a request which failed
+// with a retryable error, according to the summary.
+func retryablePointCount(summary *monitoringpb.CreateTimeSeriesSummary) int {
+	var points int
+	for _, e := range summary.GetErrors() {
+		if isRetryableCode(codes.Code(e.GetStatus().GetCode())) {
+			points += int(e.PointCount)
+		}
+	}
+	return points
+}
+
+// retryableTimeSeries returns a request containing only the timeseries of req
+// which failed with a retryable error, or nil if there are none, or if the
+// failed timeseries can't be identified. They are identified by the
+// timeseries listed in the message of the status, or by the
+// CreateTimeSeriesError details of the status, which must account for every
+// point the summary reports as failing with a retryable error.
+func retryableTimeSeries(req *monitoringpb.CreateTimeSeriesRequest, s *status.Status) *monitoringpb.CreateTimeSeriesRequest {
+	summary := createTimeSeriesSummary(s)
+	if summary == nil {
+		// Nothing was written, so the whole request can be sent again.
+		if isRetryableCode(s.Code()) {
+			return req
+		}
+		return nil
+	}
+	points := retryablePointCount(summary)
+	if points == 0 {
+		return nil
+	}
+
+	// If every error of the summary is retryable, so is every error listed
+	// in the message. Otherwise, retryable errors are told apart by their
+	// message.
+	allRetryable := true
+	for _, e := range summary.GetErrors() {
+		allRetryable = allRetryable && isRetryableCode(codes.Code(e.GetStatus().GetCode()))
+	}
+	failed := map[int]struct{}{}
+	for _, tsErr := range parseTimeSeriesErrors(s.Message()) {
+		if !allRetryable && !retryableMessage.MatchString(tsErr.message) {
+			continue
+		}
+		for _, i := range tsErr.indexes {
+			if i < len(req.TimeSeries) {
+				failed[i] = struct{}{}
+			}
+		}
+	}
+	if len(failed) == 0 {
+		failedKeys := map[string]struct{}{}
+		for _, detail := range s.Details() {
+			tsErr, ok := detail.(*monitoringpb.CreateTimeSeriesError)
+			if !ok {
+				continue
+			}
+			// The timeseries of errors are deprecated, and not set by
+			// Cloud Monitoring, but some proxies of the API set them.
+			ts, tsStatus := tsErr.GetTimeSeries(), tsErr.GetStatus() //nolint:staticcheck
+			if ts != nil && isRetryableCode(codes.Code(tsStatus.GetCode())) {
+				failedKeys[timeSeriesKey(ts)] = struct{}{}
+			}
+		}
+		for i, ts := range req.TimeSeries {
+			if _, ok := failedKeys[timeSeriesKey(ts)]; ok {
+				failed[i] = struct{}{}
+			}
+		}
+	}
+	retryReq := &monitoringpb.CreateTimeSeriesRequest{Name: req.Name}
+	for i, ts := range req.TimeSeries {
+		if _, ok := failed[i]; ok {
+			retryReq.TimeSeries = append(retryReq.TimeSeries, ts)
+		}
+	}
+	if len(retryReq.TimeSeries) != points {
+		// We can't tell which timeseries failed. Resending the wrong ones
+		// would fail, since they were already written.
+		return nil
+	}
+	return retryReq
+}
+
+// retryPartialFailures resends the timeseries of a failed request which
+// failed with a retryable error, until they succeed or the retries are
+// exhausted. It returns the last request sent, and its error. The outcome of
+// the points which aren't resent is recorded, so the caller only records the
+// outcome of the points of the returned request.
+func (me *MetricsExporter) retryPartialFailures(
+	ctx context.Context,
+	req *monitoringpb.CreateTimeSeriesRequest,
+	err error,
+) (*monitoringpb.CreateTimeSeriesRequest, error) {
+	backoff := partialFailureBackoff
+	for attempt := 0; attempt < maxPartialFailureRetries && err != nil; attempt++ {
+		s, _ := status.FromError(err)
+		retryReq := retryableTimeSeries(req, s)
+		if retryReq == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return req, err
+		case <-time.After(backoff):
+		}
+		me.obs.recordCreateTimeSeriesResult(ctx, s, len(req.TimeSeries), len(retryReq.TimeSeries))
+		me.obs.log.Debug("Retrying timeseries which failed with a retryable error.", zap.Error(err), zap.Int("timeseries", len(retryReq.TimeSeries)))
+		backoff *= 2
+		req = retryReq
+		err = me.sendTimeSeries(ctx, req)
+	}
+	return req, err
+}
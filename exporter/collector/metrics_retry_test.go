@@ -0,0 +1,284 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/metrictest"
+)
+
+func TestRejectionReason(t *testing.T) {
+	withReason, err := status.New(codes.InvalidArgument, "Duplicate TimeSeries encountered.").WithDetails(&errdetails.ErrorInfo{
+		Reason: "DUPLICATE_SERIES",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "duplicate_series", rejectionReason(withReason))
+	for _, tc := range []struct {
+		message  string
+		expected string
+	}{
+		{message: "Duplicate TimeSeries encountered. Only one point can be written per TimeSeries per request.", expected: "duplicate_series"},
+		{message: "Points must be written in order. One or more of the points specified had an older start time than the most recent point.", expected: "out_of_order"},
+		{message: "One or more points were written more frequently than the maximum sampling period configured for the metric.", expected: "written_too_frequently"},
+		{message: "The label value is too long.", expected: "label_too_long"},
+		{message: "The new labels would cause the metric workload.googleapis.com/foo to have over 30 labels.", expected: "too_many_labels"},
+		{message: "Something else went wrong.", expected: "invalid_argument"},
+	} {
+		t.Run(tc.expected, func(t *testing.T) {
+			assert.Equal(t, tc.expected, rejectionReason(status.New(codes.InvalidArgument, tc.message)))
+		})
+	}
+}
+
+func TestParseTimeSeriesErrors(t *testing.T) {
+	errs := parseTimeSeriesErrors("One or more TimeSeries could not be written: " +
+		"Points must be written in order. One or more of the points specified had an older start time than the most recent point.: timeSeries[0,2-3]; " +
+		"Field timeSeries[5].metric.labels[1] had an invalid value of \"foo\": The label value is too long.; " +
+		"Internal error encountered. Please retry after a few seconds.: timeSeries[1]")
+	assert.Equal(t, []timeSeriesError{
+		{
+			message: "Points must be written in order. One or more of the points specified had an older start time than the most recent point.",
+			indexes: []int{0, 2, 3},
+		},
+		{
+			message: "Field timeSeries[5].metric.labels[1] had an invalid value of \"foo\": The label value is too long.",
+			indexes: []int{5},
+		},
+		{
+			message: "Internal error encountered. Please retry after a few seconds.",
+			indexes: []int{1},
+		},
+	}, errs)
+	assert.Empty(t, parseTimeSeriesErrors("Duplicate TimeSeries encountered."))
+}
+
+func TestRetryableTimeSeries(t *testing.T) {
+	req := &monitoringpb.CreateTimeSeriesRequest{Name: "projects/my-project"}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		req.TimeSeries = append(req.TimeSeries, &monitoringpb.TimeSeries{Metric: &metricpb.Metric{Type: name}})
+	}
+	withSummary := func(tsErrs []*monitoringpb.CreateTimeSeriesError, errs ...*monitoringpb.CreateTimeSeriesSummary_Error) *status.Status {
+		details := []protoiface.MessageV1{&monitoringpb.CreateTimeSeriesSummary{
+			TotalPointCount:   4,
+			SuccessPointCount: 1,
+			Errors:            errs,
+		}}
+		for _, tsErr := range tsErrs {
+			details = append(details, tsErr)
+		}
+		s, err := status.New(codes.InvalidArgument, "failed").WithDetails(details...)
+		require.NoError(t, err)
+		return s
+	}
+	tsErr := func(ts *monitoringpb.TimeSeries, c codes.Code) *monitoringpb.CreateTimeSeriesError {
+		return &monitoringpb.CreateTimeSeriesError{TimeSeries: ts, Status: &statuspb.Status{Code: int32(c)}}
+	}
+
+	t.Run("Whole request unavailable", func(t *testing.T) {
+		assert.Same(t, req, retryableTimeSeries(req, status.New(codes.Unavailable, "")))
+	})
+
+	t.Run("Whole request invalid", func(t *testing.T) {
+		assert.Nil(t, retryableTimeSeries(req, status.New(codes.InvalidArgument, "")))
+	})
+
+	t.Run("Partial failure", func(t *testing.T) {
+		s := withSummary(
+			[]*monitoringpb.CreateTimeSeriesError{
+				tsErr(req.TimeSeries[1], codes.Unavailable),
+				tsErr(req.TimeSeries[2], codes.InvalidArgument),
+				tsErr(req.TimeSeries[3], codes.Unavailable),
+			},
+			&monitoringpb.CreateTimeSeriesSummary_Error{
+				Status:     &statuspb.Status{Code: int32(codes.Unavailable)},
+				PointCount: 2,
+			},
+			&monitoringpb.CreateTimeSeriesSummary_Error{
+				Status:     &statuspb.Status{Code: int32(codes.InvalidArgument)},
+				PointCount: 1,
+			},
+		)
+		retryReq := retryableTimeSeries(req, s)
+		require.NotNil(t, retryReq)
+		assert.Equal(t, req.Name, retryReq.Name)
+		require.Len(t, retryReq.TimeSeries, 2)
+		assert.Same(t, req.TimeSeries[1], retryReq.TimeSeries[0])
+		assert.Same(t, req.TimeSeries[3], retryReq.TimeSeries[1])
+	})
+
+	t.Run("Unattributable failure", func(t *testing.T) {
+		s := withSummary(nil, &monitoringpb.CreateTimeSeriesSummary_Error{
+			Status:     &statuspb.Status{Code: int32(codes.DeadlineExceeded)},
+			PointCount: 2,
+		})
+		assert.Nil(t, retryableTimeSeries(req, s))
+	})
+
+	t.Run("Failures listed in the message", func(t *testing.T) {
+		// The shape of the errors returned by Cloud Monitoring: the errors
+		// of the summary only have a code, and the message lists the
+		// timeseries of each error.
+		s, err := status.New(codes.InvalidArgument, "One or more TimeSeries could not be written: "+
+			"Internal error encountered. Please retry after a few seconds.: timeSeries[1,3]; "+
+			"Points must be written in order. One or more of the points specified had an older start time than the most recent point.: timeSeries[2]",
+		).WithDetails(&monitoringpb.CreateTimeSeriesSummary{
+			TotalPointCount:   4,
+			SuccessPointCount: 1,
+			Errors: []*monitoringpb.CreateTimeSeriesSummary_Error{
+				{Status: &statuspb.Status{Code: int32(codes.Internal)}, PointCount: 2},
+				{Status: &statuspb.Status{Code: int32(codes.InvalidArgument)}, PointCount: 1},
+			},
+		})
+		require.NoError(t, err)
+		// INTERNAL isn't retried.
+		assert.Nil(t, retryableTimeSeries(req, s))
+
+		s, err = status.New(codes.Unavailable, "One or more TimeSeries could not be written: "+
+			"The service is currently unavailable.: timeSeries[1,3]; "+
+			"Points must be written in order. One or more of the points specified had an older start time than the most recent point.: timeSeries[2]",
+		).WithDetails(&monitoringpb.CreateTimeSeriesSummary{
+			TotalPointCount:   4,
+			SuccessPointCount: 1,
+			Errors: []*monitoringpb.CreateTimeSeriesSummary_Error{
+				{Status: &statuspb.Status{Code: int32(codes.Unavailable)}, PointCount: 2},
+				{Status: &statuspb.Status{Code: int32(codes.InvalidArgument)}, PointCount: 1},
+			},
+		})
+		require.NoError(t, err)
+		retryReq := retryableTimeSeries(req, s)
+		require.NotNil(t, retryReq)
+		require.Len(t, retryReq.TimeSeries, 2)
+		assert.Same(t, req.TimeSeries[1], retryReq.TimeSeries[0])
+		assert.Same(t, req.TimeSeries[3], retryReq.TimeSeries[1])
+		assert.Equal(t, map[string]int{"out_of_order": 1}, pointRejections(s, createTimeSeriesSummary(s)))
+	})
+
+	t.Run("All failures listed in the message are retryable", func(t *testing.T) {
+		s := withSummary(nil, &monitoringpb.CreateTimeSeriesSummary_Error{
+			Status:     &statuspb.Status{Code: int32(codes.DeadlineExceeded)},
+			PointCount: 2,
+		})
+		s, err := status.New(s.Code(), "One or more TimeSeries could not be written: The request timed out.: timeSeries[0-1]").WithDetails(createTimeSeriesSummary(s))
+		require.NoError(t, err)
+		retryReq := retryableTimeSeries(req, s)
+		require.NotNil(t, retryReq)
+		require.Len(t, retryReq.TimeSeries, 2)
+		assert.Same(t, req.TimeSeries[0], retryReq.TimeSeries[0])
+		assert.Same(t, req.TimeSeries[1], retryReq.TimeSeries[1])
+	})
+
+	t.Run("Partially attributable failure", func(t *testing.T) {
+		s := withSummary(
+			[]*monitoringpb.CreateTimeSeriesError{tsErr(req.TimeSeries[0], codes.Unavailable)},
+			&monitoringpb.CreateTimeSeriesSummary_Error{
+				Status:     &statuspb.Status{Code: int32(codes.Unavailable)},
+				PointCount: 2,
+			},
+		)
+		assert.Nil(t, retryableTimeSeries(req, s))
+	})
+
+	t.Run("Only permanent failures", func(t *testing.T) {
+		s := withSummary(
+			[]*monitoringpb.CreateTimeSeriesError{tsErr(req.TimeSeries[0], codes.InvalidArgument)},
+			&monitoringpb.CreateTimeSeriesSummary_Error{
+				Status:     &statuspb.Status{Code: int32(codes.InvalidArgument)},
+				PointCount: 1,
+			},
+		)
+		assert.Nil(t, retryableTimeSeries(req, s))
+	})
+}
+
+func TestRetryPartialFailuresPointCount(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	fake := &fakeTimeSeriesServer{
+		inFlight:    map[string]int{},
+		maxInFlight: map[string]int{},
+		respond: func(req *monitoringpb.CreateTimeSeriesRequest) error {
+			if len(req.TimeSeries) != 3 {
+				return nil
+			}
+			// The first timeseries is written, the second is rejected,
+			// and the third can be retried.
+			s, err := status.New(codes.Unavailable, "One or more TimeSeries could not be written: "+
+				"Points must be written in order. One or more of the points specified had an older start time than the most recent point.: timeSeries[1]; "+
+				"The service is currently unavailable.: timeSeries[2]",
+			).WithDetails(
+				&monitoringpb.CreateTimeSeriesSummary{
+					TotalPointCount:   3,
+					SuccessPointCount: 1,
+					Errors: []*monitoringpb.CreateTimeSeriesSummary_Error{
+						{Status: &statuspb.Status{Code: int32(codes.InvalidArgument)}, PointCount: 1},
+						{Status: &statuspb.Status{Code: int32(codes.Unavailable)}, PointCount: 1},
+					},
+				},
+			)
+			require.NoError(t, err)
+			return s.Err()
+		},
+	}
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	mp := metrictest.NewMeterProvider()
+	cfg := DefaultConfig()
+	cfg.ProjectID = "myproject"
+	cfg.MeterProvider = mp
+	cfg.MetricConfig.ClientConfig.Endpoint = lis.Addr().String()
+	cfg.MetricConfig.ClientConfig.UseInsecure = true
+	cfg.MetricConfig.RetryPartialFailures = true
+	me, err := NewGoogleCloudMetricsExporter(context.Background(), cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { me.Shutdown(context.Background()) })
+
+	ctx := context.Background()
+	tss := newExportTestTimeSeries(3, start.Add(time.Minute))
+	require.NoError(t, me.exportBatch(ctx, "myproject", tss))
+	require.Len(t, fake.requests, 2)
+	require.Len(t, fake.requests[1].TimeSeries, 1)
+	assert.True(t, proto.Equal(tss[2], fake.requests[1].TimeSeries[0]))
+
+	// Each point is counted once, with its final outcome.
+	point, ok := mp.Get(ctx, "googlecloudmonitoring/point_count", statusKey.String("OK"))
+	require.True(t, ok)
+	assert.Equal(t, float64(2), point.Value)
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/point_count", statusKey.String("UNAVAILABLE"))
+	require.True(t, ok)
+	assert.Equal(t, float64(1), point.Value)
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/point_rejection_count", reasonKey.String("out_of_order"))
+	require.True(t, ok)
+	assert.Equal(t, float64(1), point.Value)
+}
@@ -15,6 +15,7 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"testing"
@@ -26,6 +27,7 @@ import (
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/api/distribution"
 	"google.golang.org/genproto/googleapis/api/label"
 	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
@@ -33,6 +35,8 @@ import (
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/metrictest"
 	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/normalization"
 )
 
@@ -41,17 +45,26 @@ var (
 )
 
 func newTestMetricMapper() (metricMapper, func()) {
-	obs := selfObservability{log: zap.NewNop()}
+	obs := newTestSelfObservability()
 	s := make(chan struct{})
 	cfg := DefaultConfig()
 	cfg.MetricConfig.EnableSumOfSquaredDeviation = true
 	return metricMapper{
-		obs:        obs,
-		cfg:        cfg,
-		normalizer: normalization.NewStandardNormalizer(s, zap.NewNop()),
+		obs:         obs,
+		cfg:         cfg,
+		normalizer:  normalization.NewStandardNormalizer(s, zap.NewNop(), datapointstorage.Options{}),
+		accumulator: normalization.NewDisabledAccumulator(),
 	}, func() { close(s) }
 }
 
+// testIdentifier returns the identifier of a timeseries of the named metric,
+// without labels.
+func testIdentifier(name string) datapointstorage.Identifier {
+	metric := pmetric.NewMetric()
+	metric.SetName(name)
+	return datapointstorage.NewIdentifier(nil, nil, metric, pcommon.NewMap())
+}
+
 func TestMetricToTimeSeries(t *testing.T) {
 	mr := &monitoredrespb.MonitoredResource{}
 
@@ -80,10 +93,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		point.SetTimestamp(endTs)
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 3, "Should create one timeseries for each sum point")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -116,10 +131,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		point.SetTimestamp(endTs)
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 2, "Should create one timeseries for each sum point")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -155,10 +172,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		point.SetTimestamp(endTs)
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 2, "Should create one timeseries for each sum point")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -189,10 +208,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		point.SetFlags(pmetric.MetricDataPointFlags(pmetric.MetricDataPointFlagNoRecordedValue))
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 2, "Should create one timeseries for each sum point, but omit the stale point")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -210,10 +231,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		gauge.DataPoints().AppendEmpty().SetIntVal(16)
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 3, "Should create one timeseries for each gauge point")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -232,10 +255,12 @@ func TestMetricToTimeSeries(t *testing.T) {
 		gauge.DataPoints().AppendEmpty().SetFlags(pmetric.MetricDataPointFlags(pmetric.MetricDataPointFlagNoRecordedValue))
 
 		ts := mapper.metricToTimeSeries(
+			context.Background(),
 			mr,
 			labels{},
 			metric,
 			mapper.cfg.ProjectID,
+			mapper.relabelMetric(metric, labels{}),
 		)
 		require.Len(t, ts, 2, "Should create one timeseries for each gauge point, except the point without a value")
 		require.Same(t, ts[0].Resource, mr, "Should assign the passed in monitored resource")
@@ -286,7 +311,7 @@ func TestHistogramPointToTimeSeries(t *testing.T) {
 	exemplar.SetSpanID(pcommon.NewSpanID([8]byte{0, 1, 2, 3, 4, 5, 6, 7}))
 	exemplar.FilteredAttributes().InsertString("test", "extra")
 
-	tsl := mapper.histogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.histogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -327,6 +352,81 @@ func TestHistogramPointToTimeSeries(t *testing.T) {
 	assert.Equal(t, map[string]string{"test": "extra"}, dropped.Label)
 }
 
+func TestHistogramPointRange(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	mp := metrictest.NewMeterProvider()
+	obs, err := newSelfObservability(zap.NewNop(), mp)
+	require.NoError(t, err)
+	mapper.obs = obs
+	mr := &monitoredrespb.MonitoredResource{}
+	metric := pmetric.NewMetric()
+	metric.SetName("myhist")
+	metric.SetDataType(pmetric.MetricDataTypeHistogram)
+	hist := metric.Histogram()
+	hist.SetAggregationTemporality(pmetric.MetricAggregationTemporalityCumulative)
+	addPoint := func(ts time.Time, count uint64, min, max float64) {
+		point := hist.DataPoints().AppendEmpty()
+		// leave start time unset, so the first point is the reference point
+		point.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+		point.SetMBucketCounts([]uint64{count})
+		point.SetCount(count)
+		point.SetSum(float64(count))
+		point.SetMin(min)
+		point.SetMax(max)
+	}
+	addPoint(start, 1, 5, 5)
+	// The max changed, but the min may have been observed before the
+	// reference point.
+	addPoint(start.Add(time.Minute), 3, 5, 9)
+	// The min and max of the point are lower and higher than those of the
+	// reference point, so they were observed after it.
+	addPoint(start.Add(2*time.Minute), 4, 1, 10)
+
+	tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
+	require.Len(t, tsl, 2)
+	assert.Nil(t, tsl[0].Points[0].Value.GetDistributionValue().Range)
+	assert.Equal(t, &distribution.Distribution_Range{Min: 1, Max: 10}, tsl[1].Points[0].Value.GetDistributionValue().Range)
+	// Omitted ranges are counted.
+	recorded, ok := mp.Get(context.Background(), "googlecloudmonitoring/distribution_range_omitted_count", metricTypeKey.String("workload.googleapis.com/myhist"))
+	require.True(t, ok)
+	assert.Equal(t, float64(1), recorded.Value)
+
+	// Points with a start time are sent unchanged.
+	point := pmetric.NewHistogramDataPoint()
+	point.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+	point.SetTimestamp(pcommon.NewTimestampFromTime(start.Add(time.Minute)))
+	point.SetMBucketCounts([]uint64{2})
+	point.SetCount(2)
+	point.SetSum(2)
+	point.SetMin(-1)
+	point.SetMax(3)
+	assert.Equal(t, &distribution.Distribution_Range{Min: -1, Max: 3}, mapper.histogramPoint(context.Background(), point, "").GetDistributionValue().Range)
+	point = pmetric.NewHistogramDataPoint()
+	point.SetMBucketCounts([]uint64{2})
+	point.SetCount(2)
+	point.SetMax(3)
+	assert.Nil(t, mapper.histogramPoint(context.Background(), point, "").GetDistributionValue().Range)
+}
+
+func TestExponentialHistogramPointRange(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	point := pmetric.NewExponentialHistogramDataPoint()
+	point.SetCount(2)
+	point.SetSum(2)
+	point.SetMin(0.5)
+	point.SetMax(1.5)
+	point.Positive().SetMBucketCounts([]uint64{2})
+	assert.Equal(t, &distribution.Distribution_Range{Min: 0.5, Max: 1.5}, mapper.exponentialHistogramPoint(context.Background(), point, "").GetDistributionValue().Range)
+
+	// An empty distribution can't have a range.
+	point = pmetric.NewExponentialHistogramDataPoint()
+	point.SetMin(0.5)
+	point.SetMax(1.5)
+	assert.Nil(t, mapper.exponentialHistogramPoint(context.Background(), point, "").GetDistributionValue().Range)
+}
+
 func TestHistogramPointWithoutTimestampToTimeSeries(t *testing.T) {
 	mapper, shutdown := newTestMetricMapper()
 	defer shutdown()
@@ -385,7 +485,7 @@ func TestHistogramPointWithoutTimestampToTimeSeries(t *testing.T) {
 	exemplar.SetSpanID(pcommon.NewSpanID([8]byte{0, 1, 2, 3, 4, 5, 6, 7}))
 	exemplar.FilteredAttributes().InsertString("test", "extra")
 
-	tsl := mapper.metricToTimeSeries(mr, labels{}, metric, mapper.cfg.ProjectID)
+	tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
 	// the first point should be dropped, so we expect 2 points
 	assert.Len(t, tsl, 2)
 	ts := tsl[0]
@@ -485,7 +585,7 @@ func TestNoValueHistogramPointToTimeSeries(t *testing.T) {
 	point.SetSum(42)
 	point.SetMExplicitBounds([]float64{10, 20, 30, 40})
 
-	tsl := mapper.histogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.histogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	// Points without a value are dropped
 	assert.Len(t, tsl, 0)
 }
@@ -510,7 +610,7 @@ func TestNoSumHistogramPointToTimeSeries(t *testing.T) {
 	// Leave the sum unset
 	point.SetMExplicitBounds([]float64{10, 20, 30, 40})
 
-	tsl := mapper.histogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.histogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	// Points without a sum are dropped
 	assert.Len(t, tsl, 0)
 }
@@ -535,7 +635,7 @@ func TestEmptyHistogramPointToTimeSeries(t *testing.T) {
 	point.SetSum(0)
 	point.SetMExplicitBounds([]float64{10, 20, 30, 40})
 
-	tsl := mapper.histogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.histogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -582,7 +682,7 @@ func TestNaNSumHistogramPointToTimeSeries(t *testing.T) {
 	point.SetSum(math.NaN())
 	point.SetMExplicitBounds([]float64{10, 20, 30, 40})
 
-	tsl := mapper.histogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.histogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -640,7 +740,7 @@ func TestExponentialHistogramPointToTimeSeries(t *testing.T) {
 	// Add a second point with no value
 	hist.DataPoints().AppendEmpty().SetFlags(pmetric.MetricDataPointFlags(pmetric.MetricDataPointFlagNoRecordedValue))
 
-	tsl := mapper.metricToTimeSeries(mr, labels{}, metric, mapper.cfg.ProjectID)
+	tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -754,7 +854,7 @@ func TestExponentialHistogramPointWithoutStartTimeToTimeSeries(t *testing.T) {
 	exemplar.SetSpanID(pcommon.NewSpanID([8]byte{0, 1, 2, 3, 4, 5, 6, 7}))
 	exemplar.FilteredAttributes().InsertString("test", "extra")
 
-	tsl := mapper.metricToTimeSeries(mr, labels{}, metric, mapper.cfg.ProjectID)
+	tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
 	// expect 2 timeseries, since the first is dropped
 	assert.Len(t, tsl, 2)
 	ts := tsl[0]
@@ -837,6 +937,220 @@ func TestExponentialHistogramPointWithoutStartTimeToTimeSeries(t *testing.T) {
 	assert.Equal(t, map[string]string{"test": "extra"}, dropped.Label)
 }
 
+func TestExponentialHistogramPointResetsWithoutStartTime(t *testing.T) {
+	type bucketsSpec struct {
+		offset int32
+		counts []uint64
+	}
+	type pointSpec struct {
+		scale     int32
+		positive  bucketsSpec
+		negative  bucketsSpec
+		zeroCount uint64
+	}
+	type outputSpec struct {
+		// startOffset is the expected start time, relative to start. If
+		// zero, the start time is that of the first point.
+		startOffset time.Duration
+		pointSpec
+	}
+	makePoint := func(ts time.Time, spec pointSpec) pmetric.ExponentialHistogramDataPoint {
+		point := pmetric.NewExponentialHistogramDataPoint()
+		point.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+		point.SetScale(spec.scale)
+		point.SetZeroCount(spec.zeroCount)
+		point.Positive().SetOffset(spec.positive.offset)
+		point.Positive().SetMBucketCounts(spec.positive.counts)
+		point.Negative().SetOffset(spec.negative.offset)
+		point.Negative().SetMBucketCounts(spec.negative.counts)
+		count := spec.zeroCount
+		for _, c := range append(append([]uint64{}, spec.positive.counts...), spec.negative.counts...) {
+			count += c
+		}
+		point.SetCount(count)
+		point.SetSum(float64(count))
+		return point
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		points []pointSpec
+		// expected has one entry per point, nil if the point is dropped.
+		expected []*outputSpec
+	}{
+		{
+			desc: "scale decreases",
+			points: []pointSpec{
+				{
+					scale:     1,
+					positive:  bucketsSpec{offset: 0, counts: []uint64{1, 1, 1, 1}},
+					negative:  bucketsSpec{offset: -3, counts: []uint64{1, 1, 1}},
+					zeroCount: 1,
+				},
+				{
+					scale:     0,
+					positive:  bucketsSpec{offset: 0, counts: []uint64{3, 4}},
+					negative:  bucketsSpec{offset: -2, counts: []uint64{2, 3}},
+					zeroCount: 2,
+				},
+			},
+			expected: []*outputSpec{
+				nil,
+				{
+					pointSpec: pointSpec{
+						scale:     0,
+						positive:  bucketsSpec{offset: 0, counts: []uint64{1, 2}},
+						negative:  bucketsSpec{offset: -2, counts: []uint64{1, 1}},
+						zeroCount: 1,
+					},
+				},
+			},
+		},
+		{
+			desc: "scale increases",
+			points: []pointSpec{
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 1, counts: []uint64{1, 1}},
+				},
+				{
+					scale:    1,
+					positive: bucketsSpec{offset: 2, counts: []uint64{2, 1, 1, 2}},
+				},
+				{
+					scale:    1,
+					positive: bucketsSpec{offset: 2, counts: []uint64{2, 2, 1, 3}},
+				},
+			},
+			expected: []*outputSpec{
+				nil,
+				{
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 1, counts: []uint64{2, 2}},
+					},
+				},
+				{
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 1, counts: []uint64{3, 3}},
+					},
+				},
+			},
+		},
+		{
+			desc: "bucket decreases while count increases",
+			points: []pointSpec{
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 0, counts: []uint64{2, 2}},
+				},
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 0, counts: []uint64{1, 4}},
+				},
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 0, counts: []uint64{2, 5}},
+				},
+			},
+			expected: []*outputSpec{
+				nil,
+				{
+					startOffset: 2*time.Hour - time.Millisecond,
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 0, counts: []uint64{1, 4}},
+					},
+				},
+				{
+					startOffset: 2*time.Hour - time.Millisecond,
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 0, counts: []uint64{2, 5}},
+					},
+				},
+			},
+		},
+		{
+			desc: "bucket disappears",
+			points: []pointSpec{
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 0, counts: []uint64{1, 1}},
+				},
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 1, counts: []uint64{5}},
+				},
+			},
+			expected: []*outputSpec{
+				nil,
+				{
+					startOffset: 2*time.Hour - time.Millisecond,
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 1, counts: []uint64{5}},
+					},
+				},
+			},
+		},
+		{
+			desc: "reset across scale change",
+			points: []pointSpec{
+				{
+					scale:    1,
+					positive: bucketsSpec{offset: 0, counts: []uint64{3, 3}},
+				},
+				{
+					scale:    0,
+					positive: bucketsSpec{offset: 0, counts: []uint64{5, 2}},
+				},
+			},
+			expected: []*outputSpec{
+				nil,
+				{
+					startOffset: 2*time.Hour - time.Millisecond,
+					pointSpec: pointSpec{
+						scale:    0,
+						positive: bucketsSpec{offset: 0, counts: []uint64{5, 2}},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			shutdown := make(chan struct{})
+			defer close(shutdown)
+			normalizer := normalization.NewStandardNormalizer(shutdown, zap.NewNop(), datapointstorage.Options{})
+			for i, spec := range tc.points {
+				ts := start.Add(time.Duration(i+1) * time.Hour)
+				got := normalizer.NormalizeExponentialHistogramDataPoint(makePoint(ts, spec), testIdentifier("id"))
+				want := tc.expected[i]
+				if want == nil {
+					assert.Nil(t, got, "point %d", i)
+					continue
+				}
+				require.NotNil(t, got, "point %d", i)
+				expected := makePoint(ts, want.pointSpec)
+				startOffset := want.startOffset
+				if startOffset == 0 {
+					startOffset = time.Hour
+				}
+				expected.SetStartTimestamp(pcommon.NewTimestampFromTime(start.Add(startOffset)))
+				assert.Equal(t, expected.StartTimestamp(), got.StartTimestamp(), "point %d", i)
+				assert.Equal(t, expected.Scale(), got.Scale(), "point %d", i)
+				assert.Equal(t, expected.Count(), got.Count(), "point %d", i)
+				assert.Equal(t, expected.ZeroCount(), got.ZeroCount(), "point %d", i)
+				assert.Equal(t, expected.Positive().Offset(), got.Positive().Offset(), "point %d", i)
+				assert.Equal(t, append([]uint64{}, expected.Positive().MBucketCounts()...), append([]uint64{}, got.Positive().MBucketCounts()...), "point %d", i)
+				assert.Equal(t, expected.Negative().Offset(), got.Negative().Offset(), "point %d", i)
+				assert.Equal(t, append([]uint64{}, expected.Negative().MBucketCounts()...), append([]uint64{}, got.Negative().MBucketCounts()...), "point %d", i)
+			}
+		})
+	}
+}
+
 func TestNaNSumExponentialHistogramPointToTimeSeries(t *testing.T) {
 	mapper, shutdown := newTestMetricMapper()
 	defer shutdown()
@@ -859,7 +1173,7 @@ func TestNaNSumExponentialHistogramPointToTimeSeries(t *testing.T) {
 	point.SetScale(-1)
 	point.SetSum(math.NaN())
 
-	tsl := mapper.exponentialHistogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.exponentialHistogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -909,7 +1223,7 @@ func TestZeroCountExponentialHistogramPointToTimeSeries(t *testing.T) {
 	point.SetScale(-1)
 	point.SetSum(0)
 
-	tsl := mapper.exponentialHistogramToTimeSeries(mr, labels{}, metric, hist, point, mapper.cfg.ProjectID)
+	tsl := mapper.exponentialHistogramToTimeSeries(context.Background(), mr, labels{}, metric, hist, point, mapper.pointLabels(metric, point.Attributes(), labels{}), mapper.cfg.ProjectID)
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	// Verify aspects
@@ -937,6 +1251,40 @@ func TestZeroCountExponentialHistogramPointToTimeSeries(t *testing.T) {
 	assert.Equal(t, int32(3), hdp.BucketOptions.GetExponentialBuckets().NumFiniteBuckets)
 }
 
+func TestMetricUnit(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		unit     string
+		expected string
+	}{
+		{policy: UnknownUnitAnnotation, unit: "By/s", expected: "By/s"},
+		{policy: UnknownUnitAnnotation, unit: "/s", expected: "1/s"},
+		{policy: UnknownUnitAnnotation, unit: "{dropped packets}", expected: "{dropped_packets}"},
+		{policy: UnknownUnitAnnotation, unit: "Cel", expected: "{Cel}"},
+		{policy: "", unit: "Cel", expected: "{Cel}"},
+		{policy: UnknownUnitVerbatim, unit: "Cel", expected: "Cel"},
+		{policy: UnknownUnitDrop, unit: "Cel", expected: ""},
+	} {
+		t.Run(tc.policy+"/"+tc.unit, func(t *testing.T) {
+			mapper, shutdown := newTestMetricMapper()
+			defer shutdown()
+			mapper.cfg.MetricConfig.UnknownUnitPolicy = tc.policy
+			metric := pmetric.NewMetric()
+			metric.SetName("mygauge")
+			metric.SetUnit(tc.unit)
+			metric.SetDataType(pmetric.MetricDataTypeGauge)
+			metric.Gauge().DataPoints().AppendEmpty().SetIntVal(1)
+
+			tss := mapper.metricToTimeSeries(context.Background(), &monitoredrespb.MonitoredResource{}, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
+			require.Len(t, tss, 1)
+			assert.Equal(t, tc.expected, tss[0].Unit)
+			mds := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, labels{}))
+			require.Len(t, mds, 1)
+			assert.Equal(t, tc.expected, mds[0].Unit)
+		})
+	}
+}
+
 func TestExemplarNoAttachements(t *testing.T) {
 	mapper, shutdown := newTestMetricMapper()
 	defer shutdown()
@@ -944,7 +1292,7 @@ func TestExemplarNoAttachements(t *testing.T) {
 	exemplar.SetTimestamp(pcommon.NewTimestampFromTime(start))
 	exemplar.SetDoubleVal(1)
 
-	result := mapper.exemplar(exemplar, mapper.cfg.ProjectID)
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), mapper.cfg.ProjectID)
 	assert.Equal(t, float64(1), result.Value)
 	assert.Equal(t, timestamppb.New(start), result.Timestamp)
 	assert.Len(t, result.Attachments, 0)
@@ -958,7 +1306,7 @@ func TestExemplarOnlyDroppedLabels(t *testing.T) {
 	exemplar.SetDoubleVal(1)
 	exemplar.FilteredAttributes().InsertString("test", "drop")
 
-	result := mapper.exemplar(exemplar, mapper.cfg.ProjectID)
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), mapper.cfg.ProjectID)
 	assert.Equal(t, float64(1), result.Value)
 	assert.Equal(t, timestamppb.New(start), result.Timestamp)
 	assert.Len(t, result.Attachments, 1)
@@ -983,7 +1331,7 @@ func TestExemplarOnlyTraceId(t *testing.T) {
 		0, 0, 0, 0, 0, 0, 0, 2,
 	}))
 
-	result := mapper.exemplar(exemplar, mapper.cfg.ProjectID)
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), mapper.cfg.ProjectID)
 	assert.Equal(t, float64(1), result.Value)
 	assert.Equal(t, timestamppb.New(start), result.Timestamp)
 	assert.Len(t, result.Attachments, 1)
@@ -994,6 +1342,85 @@ func TestExemplarOnlyTraceId(t *testing.T) {
 	assert.Equal(t, "projects/p/traces/00000000000000000000000000000001/spans/0000000000000002", context.SpanName)
 }
 
+func TestExemplarIntValue(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	exemplar := pmetric.NewExemplar()
+	exemplar.SetTimestamp(pcommon.NewTimestampFromTime(start))
+	exemplar.SetIntVal(3)
+
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), mapper.cfg.ProjectID)
+	assert.Equal(t, float64(3), result.Value)
+}
+
+func TestExemplarIncompleteSpanContext(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	exemplar := pmetric.NewExemplar()
+	exemplar.SetTimestamp(pcommon.NewTimestampFromTime(start))
+	exemplar.SetDoubleVal(1)
+	exemplar.SetTraceID(pcommon.NewTraceID([16]byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+	}))
+	exemplar.FilteredAttributes().InsertString("test", "drop")
+
+	// The exemplar is still sent, with its dropped labels.
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), mapper.cfg.ProjectID)
+	assert.Equal(t, float64(1), result.Value)
+	require.Len(t, result.Attachments, 1)
+	assert.True(t, result.Attachments[0].MessageIs(&monitoringpb.DroppedLabels{}))
+}
+
+func TestExemplarTraceProjectRouting(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	cfg := testProjectRoutingConfig()
+	cfg.ProjectRouting.DropUnmatched = true
+	mapper.router = newProjectRouter(cfg)
+	mapper.resourceAttributes = pcommon.NewMapFromRaw(map[string]interface{}{"k8s.namespace.name": "team-a"})
+
+	newExemplar := func() pmetric.Exemplar {
+		exemplar := pmetric.NewExemplar()
+		exemplar.SetDoubleVal(1)
+		exemplar.SetTraceID(pcommon.NewTraceID([16]byte{
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		}))
+		exemplar.SetSpanID(pcommon.NewSpanID([8]byte{
+			0, 0, 0, 0, 0, 0, 0, 2,
+		}))
+		return exemplar
+	}
+	spanName := func(result *distribution.Distribution_Exemplar) string {
+		require.Len(t, result.Attachments, 1)
+		sctx := &monitoringpb.SpanContext{}
+		require.NoError(t, result.Attachments[0].UnmarshalTo(sctx))
+		return sctx.SpanName
+	}
+
+	// The record rule matches the filtered attributes of the exemplar.
+	exemplar := newExemplar()
+	exemplar.FilteredAttributes().InsertString("tenant", "red")
+	result := mapper.exemplar(context.Background(), exemplar, pcommon.NewMap(), "project-a")
+	require.Len(t, result.Attachments, 2)
+	sctx := &monitoringpb.SpanContext{}
+	require.NoError(t, result.Attachments[0].UnmarshalTo(sctx))
+	assert.Equal(t, "projects/tenant-red/traces/00000000000000000000000000000001/spans/0000000000000002", sctx.SpanName)
+
+	// The record rule matches the attributes of the point.
+	pointAttrs := pcommon.NewMapFromRaw(map[string]interface{}{"tenant": "blue"})
+	result = mapper.exemplar(context.Background(), newExemplar(), pointAttrs, "tenant-blue")
+	assert.Equal(t, "projects/tenant-blue/traces/00000000000000000000000000000001/spans/0000000000000002", spanName(result))
+
+	// The resource rule.
+	result = mapper.exemplar(context.Background(), newExemplar(), pcommon.NewMap(), "project-a")
+	assert.Equal(t, "projects/project-a/traces/00000000000000000000000000000001/spans/0000000000000002", spanName(result))
+
+	// The span isn't routed to any project.
+	mapper.resourceAttributes = pcommon.NewMapFromRaw(map[string]interface{}{"k8s.namespace.name": "team-c"})
+	result = mapper.exemplar(context.Background(), newExemplar(), pcommon.NewMap(), "project-a")
+	assert.Empty(t, result.Attachments)
+}
+
 func TestSumPointToTimeSeries(t *testing.T) {
 	mapper, shutdown := newTestMetricMapper()
 	defer shutdown()
@@ -1022,7 +1449,7 @@ func TestSumPointToTimeSeries(t *testing.T) {
 		point.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
 		point.SetTimestamp(pcommon.NewTimestampFromTime(end))
 
-		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point)
+		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 		assert.Equal(t, 1, len(tsl))
 		ts := tsl[0]
 		assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_CUMULATIVE)
@@ -1044,7 +1471,7 @@ func TestSumPointToTimeSeries(t *testing.T) {
 
 		// Test double as well
 		point.SetDoubleVal(float64(value))
-		tsl = mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point)
+		tsl = mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 		assert.Equal(t, 1, len(tsl))
 		ts = tsl[0]
 		assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_CUMULATIVE)
@@ -1063,7 +1490,7 @@ func TestSumPointToTimeSeries(t *testing.T) {
 		point.SetTimestamp(pcommon.NewTimestampFromTime(end))
 
 		// Should output a "pseudo-cumulative" with same interval as the delta
-		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point)
+		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 		assert.Equal(t, 1, len(tsl))
 		ts := tsl[0]
 		assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_CUMULATIVE)
@@ -1084,7 +1511,7 @@ func TestSumPointToTimeSeries(t *testing.T) {
 		point.SetTimestamp(pcommon.NewTimestampFromTime(end))
 
 		// Should output a gauge regardless of temporality, only setting end time
-		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point)
+		tsl := mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 		assert.Equal(t, 1, len(tsl))
 		ts := tsl[0]
 		assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_GAUGE)
@@ -1093,7 +1520,7 @@ func TestSumPointToTimeSeries(t *testing.T) {
 		})
 
 		sum.SetAggregationTemporality(pmetric.MetricAggregationTemporalityDelta)
-		tsl = mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point)
+		tsl = mapper.sumPointToTimeSeries(mr, labels{}, metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 		assert.Equal(t, 1, len(tsl))
 		ts = tsl[0]
 		assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_GAUGE)
@@ -1108,20 +1535,149 @@ func TestSumPointToTimeSeries(t *testing.T) {
 		point.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
 		point.SetTimestamp(pcommon.NewTimestampFromTime(end))
 		extraLabels := map[string]string{"foo": "bar"}
-		tsl := mapper.sumPointToTimeSeries(mr, labels(extraLabels), metric, sum, point)
+		tsl := mapper.sumPointToTimeSeries(mr, labels(extraLabels), metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels(extraLabels)))
 		assert.Equal(t, 1, len(tsl))
 		ts := tsl[0]
 		assert.Equal(t, ts.Metric.Labels, extraLabels)
 
 		// Full set of labels
 		point.Attributes().InsertString("baz", "bar")
-		tsl = mapper.sumPointToTimeSeries(mr, labels(extraLabels), metric, sum, point)
+		tsl = mapper.sumPointToTimeSeries(mr, labels(extraLabels), metric, sum, point, mapper.pointLabels(metric, point.Attributes(), labels(extraLabels)))
 		assert.Equal(t, 1, len(tsl))
 		ts = tsl[0]
 		assert.Equal(t, ts.Metric.Labels, map[string]string{"foo": "bar", "baz": "bar"})
 	})
 }
 
+func TestDeltaToCumulative(t *testing.T) {
+	newMapper := func() (metricMapper, func()) {
+		mapper, shutdown := newTestMetricMapper()
+		s := make(chan struct{})
+		mapper.cfg.MetricConfig.DeltaToCumulative = true
+		mapper.accumulator = normalization.NewDeltaAccumulator(s, zap.NewNop(), datapointstorage.Options{})
+		return mapper, func() {
+			close(s)
+			shutdown()
+		}
+	}
+	mr := &monitoredrespb.MonitoredResource{}
+	t1 := start.Add(time.Minute)
+	t2 := start.Add(2 * time.Minute)
+	t3 := start.Add(3 * time.Minute)
+	t4 := start.Add(4 * time.Minute)
+
+	t.Run("Sum", func(t *testing.T) {
+		mapper, shutdown := newMapper()
+		defer shutdown()
+		metric := pmetric.NewMetric()
+		metric.SetName("mysum")
+		metric.SetDataType(pmetric.MetricDataTypeSum)
+		sum := metric.Sum()
+		sum.SetIsMonotonic(true)
+		sum.SetAggregationTemporality(pmetric.MetricAggregationTemporalityDelta)
+		addPoint := func(value int64, startTime, endTime time.Time) {
+			point := sum.DataPoints().AppendEmpty()
+			point.SetIntVal(value)
+			point.SetStartTimestamp(pcommon.NewTimestampFromTime(startTime))
+			point.SetTimestamp(pcommon.NewTimestampFromTime(endTime))
+		}
+		addPoint(10, start, t1)
+		addPoint(5, t1, t2)
+		// Duplicate of the previous point
+		addPoint(5, t1, t2)
+		// Gap between t2 and t3, which resets the cumulative
+		addPoint(7, t3, t4)
+
+		tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, tsl, 3, "Should drop the duplicate point")
+		for _, ts := range tsl {
+			assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_CUMULATIVE)
+		}
+		assert.Equal(t, tsl[0].Points[0].Value.GetInt64Value(), int64(10))
+		assert.Equal(t, tsl[0].Points[0].Interval.StartTime, timestamppb.New(start))
+		assert.Equal(t, tsl[1].Points[0].Value.GetInt64Value(), int64(15))
+		assert.Equal(t, tsl[1].Points[0].Interval, &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(t2),
+		})
+		assert.Equal(t, tsl[2].Points[0].Value.GetInt64Value(), int64(7))
+		assert.Equal(t, tsl[2].Points[0].Interval, &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(t3),
+			EndTime:   timestamppb.New(t4),
+		})
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		mapper, shutdown := newMapper()
+		defer shutdown()
+		metric := pmetric.NewMetric()
+		metric.SetName("myhist")
+		metric.SetDataType(pmetric.MetricDataTypeHistogram)
+		hist := metric.Histogram()
+		hist.SetAggregationTemporality(pmetric.MetricAggregationTemporalityDelta)
+		addPoint := func(counts []uint64, sum float64, startTime, endTime time.Time) {
+			point := hist.DataPoints().AppendEmpty()
+			point.SetMExplicitBounds([]float64{10, 20})
+			point.SetMBucketCounts(counts)
+			var count uint64
+			for _, c := range counts {
+				count += c
+			}
+			point.SetCount(count)
+			point.SetSum(sum)
+			point.SetStartTimestamp(pcommon.NewTimestampFromTime(startTime))
+			point.SetTimestamp(pcommon.NewTimestampFromTime(endTime))
+		}
+		addPoint([]uint64{1, 2, 3}, 50, start, t1)
+		addPoint([]uint64{1, 0, 1}, 30, t1, t2)
+		// Out-of-order point
+		addPoint([]uint64{1, 1, 1}, 30, start, t1)
+
+		tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, tsl, 2, "Should drop the out-of-order point")
+		dist := tsl[1].Points[0].Value.GetDistributionValue()
+		assert.Equal(t, dist.Count, int64(8))
+		assert.Equal(t, dist.Mean, 10.0)
+		assert.Equal(t, dist.BucketCounts, []int64{2, 2, 4})
+		assert.Equal(t, tsl[1].Points[0].Interval.StartTime, timestamppb.New(start))
+	})
+
+	t.Run("Exponential histogram", func(t *testing.T) {
+		mapper, shutdown := newMapper()
+		defer shutdown()
+		metric := pmetric.NewMetric()
+		metric.SetName("myexphist")
+		metric.SetDataType(pmetric.MetricDataTypeExponentialHistogram)
+		hist := metric.ExponentialHistogram()
+		hist.SetAggregationTemporality(pmetric.MetricAggregationTemporalityDelta)
+		point := hist.DataPoints().AppendEmpty()
+		point.SetScale(1)
+		point.SetCount(3)
+		point.SetSum(6)
+		point.Positive().SetOffset(1)
+		point.Positive().SetMBucketCounts([]uint64{1, 2})
+		point.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+		point.SetTimestamp(pcommon.NewTimestampFromTime(t1))
+		point = hist.DataPoints().AppendEmpty()
+		point.SetScale(1)
+		point.SetCount(2)
+		point.SetSum(1)
+		point.SetZeroCount(1)
+		point.Positive().SetOffset(0)
+		point.Positive().SetMBucketCounts([]uint64{1})
+		point.SetStartTimestamp(pcommon.NewTimestampFromTime(t1))
+		point.SetTimestamp(pcommon.NewTimestampFromTime(t2))
+
+		tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
+		require.Len(t, tsl, 2)
+		dist := tsl[1].Points[0].Value.GetDistributionValue()
+		assert.Equal(t, dist.Count, int64(5))
+		// underflow, followed by buckets at offsets 0, 1, 2, followed by overflow
+		assert.Equal(t, dist.BucketCounts, []int64{1, 1, 1, 2, 0})
+		assert.Equal(t, tsl[1].Points[0].Interval.StartTime, timestamppb.New(start))
+	})
+}
+
 func TestGaugePointToTimeSeries(t *testing.T) {
 	mapper, shutdown := newTestMetricMapper()
 	defer shutdown()
@@ -1146,7 +1702,7 @@ func TestGaugePointToTimeSeries(t *testing.T) {
 	end := start.Add(time.Hour)
 	point.SetTimestamp(pcommon.NewTimestampFromTime(end))
 
-	tsl := mapper.gaugePointToTimeSeries(mr, labels{}, metric, gauge, point)
+	tsl := mapper.gaugePointToTimeSeries(mr, labels{}, metric, gauge, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 	assert.Len(t, tsl, 1)
 	ts := tsl[0]
 	assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_GAUGE)
@@ -1167,7 +1723,7 @@ func TestGaugePointToTimeSeries(t *testing.T) {
 
 	// Test double as well
 	point.SetDoubleVal(float64(value))
-	tsl = mapper.gaugePointToTimeSeries(mr, labels{}, metric, gauge, point)
+	tsl = mapper.gaugePointToTimeSeries(mr, labels{}, metric, gauge, point, mapper.pointLabels(metric, point.Attributes(), labels{}))
 	assert.Len(t, tsl, 1)
 	ts = tsl[0]
 	assert.Equal(t, ts.MetricKind, metricpb.MetricDescriptor_GAUGE)
@@ -1176,14 +1732,14 @@ func TestGaugePointToTimeSeries(t *testing.T) {
 
 	// Add extra labels
 	extraLabels := map[string]string{"foo": "bar"}
-	tsl = mapper.gaugePointToTimeSeries(mr, labels(extraLabels), metric, gauge, point)
+	tsl = mapper.gaugePointToTimeSeries(mr, labels(extraLabels), metric, gauge, point, mapper.pointLabels(metric, point.Attributes(), labels(extraLabels)))
 	assert.Len(t, tsl, 1)
 	ts = tsl[0]
 	assert.Equal(t, ts.Metric.Labels, extraLabels)
 
 	// Full set of labels
 	point.Attributes().InsertString("baz", "bar")
-	tsl = mapper.gaugePointToTimeSeries(mr, labels(extraLabels), metric, gauge, point)
+	tsl = mapper.gaugePointToTimeSeries(mr, labels(extraLabels), metric, gauge, point, mapper.pointLabels(metric, point.Attributes(), labels(extraLabels)))
 	assert.Len(t, tsl, 1)
 	ts = tsl[0]
 	assert.Equal(t, ts.Metric.Labels, map[string]string{"foo": "bar", "baz": "bar"})
@@ -1216,7 +1772,7 @@ func TestSummaryPointToTimeSeries(t *testing.T) {
 	// Add a second point with no value
 	summary.DataPoints().AppendEmpty().SetFlags(pmetric.MetricDataPointFlags(pmetric.MetricDataPointFlagNoRecordedValue))
 
-	ts := mapper.metricToTimeSeries(mr, labels{}, metric, mapper.cfg.ProjectID)
+	ts := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
 	assert.Len(t, ts, 3)
 	sumResult := ts[0]
 	countResult := ts[1]
@@ -1311,7 +1867,7 @@ func TestSummaryPointWithoutStartTimeToTimeSeries(t *testing.T) {
 	// Don't set start timestamp.  This point will be normalized
 	point.SetTimestamp(pcommon.NewTimestampFromTime(end2))
 
-	ts := mapper.metricToTimeSeries(mr, labels{}, metric, mapper.cfg.ProjectID)
+	ts := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, mapper.cfg.ProjectID, mapper.relabelMetric(metric, labels{}))
 	assert.Len(t, ts, 6)
 	sumResult := ts[0]
 	countResult := ts[1]
@@ -1867,7 +2423,7 @@ func TestMetricDescriptorMapping(t *testing.T) {
 			mapper, shutdown := newTestMetricMapper()
 			defer shutdown()
 			metric := test.metricCreator()
-			md := mapper.metricDescriptor(metric, test.extraLabels)
+			md := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, test.extraLabels))
 			diff := cmp.Diff(
 				test.expected,
 				md,
@@ -0,0 +1,327 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/wal"
+)
+
+const (
+	defaultWALMaxSize    = 1 << 30 // 1 GiB
+	defaultWALMaxBackoff = 5 * time.Minute
+	walInitialBackoff    = time.Second
+	// Cloud Monitoring rejects points older than 25 hours. Leave some margin
+	// for the time it takes to send the request.
+	maxWALAge = 24 * time.Hour
+	// walProjectDirPrefix is the prefix of the subdirectory of each
+	// project's queue.
+	walProjectDirPrefix = "project-"
+)
+
+// metricsWAL persists CreateTimeSeries requests which failed with a retryable
+// error, so they can be replayed later. Each project has its own queue of
+// pending requests, in a subdirectory of the configured directory, so a
+// project whose requests keep failing doesn't delay the others.
+type metricsWAL struct {
+	dir        string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackoff time.Duration
+
+	mu sync.Mutex
+	// projects maps from project ID to its queue of pending requests.
+	projects map[string]*projectWAL
+}
+
+// projectWAL is the queue of pending requests of one project.
+type projectWAL struct {
+	projectID string
+	log       *wal.Log
+	// notifyC is signaled when a request is added to the log
+	notifyC chan struct{}
+}
+
+func newMetricsWAL(cfg WALConfig) (*metricsWAL, error) {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultWALMaxSize
+	}
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 || maxAge > maxWALAge {
+		maxAge = maxWALAge
+	}
+	maxBackoff := cfg.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultWALMaxBackoff
+	}
+	w := &metricsWAL{
+		dir:        cfg.Directory,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackoff: maxBackoff,
+		projects:   make(map[string]*projectWAL),
+	}
+	if err := os.MkdirAll(w.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %w", err)
+	}
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read write-ahead log directory: %w", err)
+	}
+	// Reopen the queues of projects with requests pending from a previous
+	// run.
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), walProjectDirPrefix) {
+			continue
+		}
+		projectID, err := url.PathUnescape(strings.TrimPrefix(entry.Name(), walProjectDirPrefix))
+		if err != nil {
+			continue
+		}
+		if _, _, err := w.project(projectID); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// project returns the queue of the project, and true if it was just opened.
+func (w *metricsWAL) project(projectID string) (*projectWAL, bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if q, ok := w.projects[projectID]; ok {
+		return q, false, nil
+	}
+	// Project IDs are escaped, since routing can produce arbitrary strings.
+	dir := filepath.Join(w.dir, walProjectDirPrefix+url.PathEscape(projectID))
+	// The size limit applies to all projects together, so it is enforced
+	// by enqueue rather than by each project's log.
+	log, err := wal.Open(dir, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	q := &projectWAL{
+		projectID: projectID,
+		log:       log,
+		notifyC:   make(chan struct{}, 1),
+	}
+	w.projects[projectID] = q
+	return q, true, nil
+}
+
+// queues returns the queues of all projects opened so far.
+func (w *metricsWAL) queues() []*projectWAL {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	queues := make([]*projectWAL, 0, len(w.projects))
+	for _, q := range w.projects {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// pending returns true if there are requests to the project waiting to be
+// replayed. Cloud Monitoring rejects points which are older than the most
+// recent point written to a timeseries, so while requests are pending, new
+// requests to the project must be queued behind them rather than sent
+// directly.
+func (w *metricsWAL) pending(projectID string) bool {
+	w.mu.Lock()
+	q, ok := w.projects[projectID]
+	w.mu.Unlock()
+	return ok && q.log.Len() > 0
+}
+
+// enqueue persists the request to the project's queue. If the queues of all
+// projects exceed the maximum size together, the oldest requests of the
+// largest queues are dropped, and the number dropped is returned.
+func (w *metricsWAL) enqueue(q *projectWAL, req *monitoringpb.CreateTimeSeriesRequest) (int, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := q.log.Write(data); err != nil {
+		return 0, err
+	}
+	select {
+	case q.notifyC <- struct{}{}:
+	default:
+	}
+	return w.evict(q)
+}
+
+// evict drops the oldest requests of the largest queues until the queues are
+// within the maximum size. The request just written to the queue is kept,
+// even if it exceeds the maximum size alone.
+func (w *metricsWAL) evict(written *projectWAL) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dropped := 0
+	for {
+		var (
+			total       int64
+			largest     *projectWAL
+			largestSize int64
+		)
+		for _, q := range w.projects {
+			size := q.log.Size()
+			total += size
+			if q == written && q.log.Len() <= 1 {
+				continue
+			}
+			if size > largestSize {
+				largest, largestSize = q, size
+			}
+		}
+		if total <= w.maxSize || largest == nil {
+			return dropped, nil
+		}
+		if err := largest.log.RemoveOldest(); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+}
+
+// dropStalePoints removes points which are too old to be accepted by Cloud
+// Monitoring, and timeseries left without points. It returns the number of
+// timeseries removed.
+func (w *metricsWAL) dropStalePoints(req *monitoringpb.CreateTimeSeriesRequest, now time.Time) int {
+	cutoff := now.Add(-w.maxAge)
+	kept := req.TimeSeries[:0]
+	for _, ts := range req.TimeSeries {
+		points := ts.Points[:0]
+		for _, point := range ts.Points {
+			if !point.GetInterval().GetEndTime().AsTime().Before(cutoff) {
+				points = append(points, point)
+			}
+		}
+		ts.Points = points
+		if len(ts.Points) == 0 {
+			continue
+		}
+		kept = append(kept, ts)
+	}
+	dropped := len(req.TimeSeries) - len(kept)
+	req.TimeSeries = kept
+	return dropped
+}
+
+// isRetryableStatus returns true if a request which failed with the status can
+// succeed if it is sent again. Requests which were partially written are not
+// retryable, since Cloud Monitoring rejects points which were already written.
+func isRetryableStatus(s *status.Status) bool {
+	// Quota errors are retryable here, since requests are replayed with a
+	// much longer backoff than partial failures.
+	if !isRetryableCode(s.Code()) && s.Code() != codes.ResourceExhausted {
+		return false
+	}
+	for _, detail := range s.Details() {
+		if summary, ok := detail.(*monitoringpb.CreateTimeSeriesSummary); ok && summary.SuccessPointCount > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// startWALRunner starts replaying the project's requests, unless the
+// exporter is shutting down, in which case they are replayed after a restart.
+func (me *MetricsExporter) startWALRunner(q *projectWAL) {
+	select {
+	case <-me.shutdownC:
+		return
+	default:
+	}
+	me.goroutines.Add(1)
+	go me.walRunner(q)
+}
+
+// Replays requests to a project from its write-ahead log, in order, until
+// shutdown.
+func (me *MetricsExporter) walRunner(q *projectWAL) {
+	defer me.goroutines.Done()
+
+	backoff := walInitialBackoff
+	for {
+		index, data, found, err := q.log.Peek()
+		if !found {
+			select {
+			case <-me.shutdownC:
+				return
+			case <-q.notifyC:
+			}
+			continue
+		}
+		req := &monitoringpb.CreateTimeSeriesRequest{}
+		if err == nil {
+			err = proto.Unmarshal(data, req)
+		}
+		if err != nil {
+			me.obs.log.Error("Unable to read request from write-ahead log. Dropping it.", zap.Error(err), zap.String("project", q.projectID))
+			me.removeFromWAL(q, index)
+			continue
+		}
+		if dropped := me.wal.dropStalePoints(req, time.Now()); dropped > 0 {
+			me.obs.log.Warn("Dropping stale points from write-ahead log.", zap.Int("timeseries", dropped), zap.String("project", q.projectID))
+		}
+		if len(req.TimeSeries) == 0 {
+			me.removeFromWAL(q, index)
+			continue
+		}
+
+		err = me.sendTimeSeries(context.Background(), req)
+		s, _ := status.FromError(err)
+		if err != nil && isRetryableStatus(s) {
+			me.obs.log.Debug("Failed to replay request from write-ahead log. Will retry.", zap.Error(err), zap.Duration("backoff", backoff), zap.String("project", q.projectID))
+			select {
+			case <-me.shutdownC:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > me.wal.maxBackoff {
+				backoff = me.wal.maxBackoff
+			}
+			continue
+		}
+		backoff = walInitialBackoff
+		me.obs.recordCreateTimeSeriesResult(context.Background(), s, len(req.TimeSeries), 0)
+		if err != nil {
+			me.obs.log.Error("Failed to replay request from write-ahead log. Dropping it.", zap.Error(err), zap.String("project", q.projectID))
+		}
+		me.removeFromWAL(q, index)
+	}
+}
+
+func (me *MetricsExporter) removeFromWAL(q *projectWAL, index uint64) {
+	if err := q.log.Remove(index); err != nil {
+		me.obs.log.Error("Unable to remove request from write-ahead log.", zap.Error(err), zap.String("project", q.projectID))
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	partial, err := status.New(codes.Unavailable, "partial").WithDetails(&monitoringpb.CreateTimeSeriesSummary{
+		TotalPointCount:   2,
+		SuccessPointCount: 1,
+	})
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		desc     string
+		status   *status.Status
+		expected bool
+	}{
+		{desc: "OK", status: status.New(codes.OK, "")},
+		{desc: "Unavailable", status: status.New(codes.Unavailable, ""), expected: true},
+		{desc: "DeadlineExceeded", status: status.New(codes.DeadlineExceeded, ""), expected: true},
+		{desc: "InvalidArgument", status: status.New(codes.InvalidArgument, "")},
+		{desc: "Partial success", status: partial},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isRetryableStatus(tc.status))
+		})
+	}
+}
+
+func TestMetricsWAL(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newMetricsWAL(WALConfig{Directory: dir})
+	require.NoError(t, err)
+	assert.False(t, w.pending("my-project"))
+
+	now := time.Now()
+	newTimeSeries := func(end time.Time) *monitoringpb.TimeSeries {
+		return &monitoringpb.TimeSeries{
+			Points: []*monitoringpb.Point{{
+				Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.New(end)},
+			}},
+		}
+	}
+	req := &monitoringpb.CreateTimeSeriesRequest{
+		Name: "projects/my-project",
+		TimeSeries: []*monitoringpb.TimeSeries{
+			newTimeSeries(now.Add(-25 * time.Hour)),
+			newTimeSeries(now.Add(-time.Hour)),
+		},
+	}
+	q, opened, err := w.project("my-project")
+	require.NoError(t, err)
+	assert.True(t, opened)
+	_, err = w.enqueue(q, req)
+	require.NoError(t, err)
+	assert.True(t, w.pending("my-project"))
+	// Each project has its own queue.
+	assert.False(t, w.pending("other-project"))
+
+	_, data, found, err := q.log.Peek()
+	require.NoError(t, err)
+	require.True(t, found)
+	replayed := &monitoringpb.CreateTimeSeriesRequest{}
+	require.NoError(t, proto.Unmarshal(data, replayed))
+	assert.True(t, proto.Equal(req, replayed))
+
+	assert.Equal(t, 1, w.dropStalePoints(replayed, now))
+	require.Len(t, replayed.TimeSeries, 1)
+	assert.True(t, proto.Equal(req.TimeSeries[1], replayed.TimeSeries[0]))
+
+	// Every point is checked, not only the first one.
+	ts := newTimeSeries(now.Add(-time.Hour))
+	ts.Points = append(ts.Points, newTimeSeries(now.Add(-25*time.Hour)).Points...)
+	stale := &monitoringpb.CreateTimeSeriesRequest{
+		TimeSeries: []*monitoringpb.TimeSeries{ts},
+	}
+	assert.Equal(t, 0, w.dropStalePoints(stale, now))
+	require.Len(t, stale.TimeSeries, 1)
+	assert.Len(t, stale.TimeSeries[0].Points, 1)
+
+	// The queues of projects with pending requests are reopened.
+	w, err = newMetricsWAL(WALConfig{Directory: dir})
+	require.NoError(t, err)
+	require.Len(t, w.queues(), 1)
+	assert.Equal(t, "my-project", w.queues()[0].projectID)
+	assert.True(t, w.pending("my-project"))
+}
+
+func TestMetricsWALMaxSize(t *testing.T) {
+	req := &monitoringpb.CreateTimeSeriesRequest{Name: "projects/my-project"}
+	size := int64(proto.Size(req))
+	w, err := newMetricsWAL(WALConfig{Directory: t.TempDir(), MaxSize: 3 * size})
+	require.NoError(t, err)
+	a, _, err := w.project("a")
+	require.NoError(t, err)
+	b, _, err := w.project("b")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		dropped, err := w.enqueue(a, req)
+		require.NoError(t, err)
+		assert.Zero(t, dropped)
+	}
+	// The limit applies to all projects together, and requests are dropped
+	// from the largest queue.
+	dropped, err := w.enqueue(b, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, 2, a.log.Len())
+	assert.Equal(t, 1, b.log.Len())
+}
+
+func TestExportBatchWAL(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	fake := &fakeTimeSeriesServer{
+		inFlight:    map[string]int{},
+		maxInFlight: map[string]int{},
+		respond: func(req *monitoringpb.CreateTimeSeriesRequest) error {
+			if req.Name == "projects/ok" {
+				return nil
+			}
+			return status.Error(codes.Unavailable, "unavailable")
+		},
+	}
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dir := t.TempDir()
+	// The queue of the broken project can't be opened.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walProjectDirPrefix+"broken"), nil, 0o600))
+	cfg := DefaultConfig()
+	cfg.ProjectID = "myproject"
+	cfg.MetricConfig.ClientConfig.Endpoint = lis.Addr().String()
+	cfg.MetricConfig.ClientConfig.UseInsecure = true
+	cfg.MetricConfig.WALConfig = &WALConfig{Directory: dir}
+	me, err := NewGoogleCloudMetricsExporter(context.Background(), cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { me.Shutdown(context.Background()) })
+
+	ctx := context.Background()
+	tss := newExportTestTimeSeries(1, start.Add(time.Minute))
+	require.NoError(t, me.exportBatch(ctx, "unavailable", tss))
+	assert.True(t, me.wal.pending("unavailable"))
+
+	// Requests pending for one project don't hold back other projects.
+	require.NoError(t, me.exportBatch(ctx, "ok", tss))
+	assert.False(t, me.wal.pending("ok"))
+
+	assert.Error(t, me.exportBatch(ctx, "broken", tss))
+}
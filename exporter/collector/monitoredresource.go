@@ -42,6 +42,58 @@ func defaultResourceToMonitoredResource(resource pcommon.Resource) *monitoredres
 	gmr := resourcemapping.ResourceAttributesToMonitoredResource(&attributes{
 		Attrs: &attrs,
 	})
+	return gceResourceToMonitoredResource(gmr)
+}
+
+// defaultResourceToLogMonitoredResource maps a pdata Resource to a Monitored
+// Resource for Cloud Logging, which also accepts serverless resource types.
+func defaultResourceToLogMonitoredResource(resource pcommon.Resource) *monitoredrespb.MonitoredResource {
+	attrs := resource.Attributes()
+	gmr := resourcemapping.ResourceAttributesToLoggingMonitoredResource(&attributes{
+		Attrs: &attrs,
+	})
+	return gceResourceToMonitoredResource(gmr)
+}
+
+// newResourceMapper returns a function which maps pdata Resources to GCM
+// Monitored Resources with the configured mappings, falling back to the
+// defaults.
+func newResourceMapper(mappings []ResourceMapping, defaults []resourcemapping.Mapping) func(pcommon.Resource) *monitoredrespb.MonitoredResource {
+	rmMappings := make([]resourcemapping.Mapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		rmMapping := resourcemapping.Mapping{Type: mapping.Type}
+		for _, match := range mapping.Match {
+			rmMapping.Predicates = append(rmMapping.Predicates, resourcemapping.Predicate{
+				Key:    match.Key,
+				Values: match.Values,
+			})
+		}
+		if mapping.Labels != nil {
+			rmMapping.Labels = make(map[string]resourcemapping.LabelMapping, len(mapping.Labels))
+			for label, labelMapping := range mapping.Labels {
+				var otelKeys []string
+				if labelMapping.Key != "" {
+					otelKeys = append(otelKeys, labelMapping.Key)
+				}
+				rmMapping.Labels[label] = resourcemapping.LabelMapping{
+					OTelKeys:        append(otelKeys, labelMapping.FallbackKeys...),
+					FallbackLiteral: labelMapping.Default,
+				}
+			}
+		}
+		rmMappings = append(rmMappings, rmMapping)
+	}
+	rmMappings = append(rmMappings, defaults...)
+	return func(resource pcommon.Resource) *monitoredrespb.MonitoredResource {
+		attrs := resource.Attributes()
+		gmr := resourcemapping.MapResourceAttributes(&attributes{
+			Attrs: &attrs,
+		}, rmMappings)
+		return gceResourceToMonitoredResource(gmr)
+	}
+}
+
+func gceResourceToMonitoredResource(gmr *resourcemapping.GceResource) *monitoredrespb.MonitoredResource {
 	newLabels := make(labels, len(gmr.Labels))
 	for k, v := range gmr.Labels {
 		newLabels[k] = sanitizeUTF8(v)
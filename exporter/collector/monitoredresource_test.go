@@ -18,8 +18,12 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping"
 )
 
 func TestResourceMetricsToMonitoredResource(t *testing.T) {
@@ -519,3 +523,159 @@ func TestResourceMetricsToMonitoredResourceUTF8(t *testing.T) {
 	extraLabels := mapper.resourceToMetricLabels(r)
 	assert.Equal(t, expectExtraLabels, extraLabels)
 }
+
+func TestResourceMapperWithMappings(t *testing.T) {
+	mapResource := newResourceMapper([]ResourceMapping{
+		{
+			Type: "cloud_run_job",
+			Match: []ResourceAttributeMatch{
+				{Key: "cloud.platform", Values: []string{"gcp_cloud_run_job"}},
+			},
+			Labels: map[string]ResourceLabelMapping{
+				"job_name": {Key: "service.name"},
+				"location": {Key: "cloud.region", FallbackKeys: []string{"cloud.availability_zone"}, Default: "global"},
+			},
+		},
+		{
+			// Built-in labels are used if none are configured.
+			Type:  "k8s_container",
+			Match: []ResourceAttributeMatch{{Key: "k8s.container.name"}},
+		},
+	}, resourcemapping.DefaultMappings())
+	for _, test := range []struct {
+		name           string
+		resourceLabels map[string]string
+		expectMr       *monitoredrespb.MonitoredResource
+	}{
+		{
+			name: "Configured type",
+			resourceLabels: map[string]string{
+				"cloud.platform":          "gcp_cloud_run_job",
+				"service.name":            "myjob",
+				"cloud.availability_zone": "us-central1-c",
+			},
+			expectMr: &monitoredrespb.MonitoredResource{
+				Type: "cloud_run_job",
+				Labels: map[string]string{
+					"job_name": "myjob",
+					"location": "us-central1-c",
+				},
+			},
+		},
+		{
+			name: "Configured type with default label",
+			resourceLabels: map[string]string{
+				"cloud.platform": "gcp_cloud_run_job",
+			},
+			expectMr: &monitoredrespb.MonitoredResource{
+				Type: "cloud_run_job",
+				Labels: map[string]string{
+					"job_name": "",
+					"location": "global",
+				},
+			},
+		},
+		{
+			name: "Built-in type with new predicates",
+			resourceLabels: map[string]string{
+				"cloud.platform":     "aws_eks",
+				"k8s.cluster.name":   "mycluster",
+				"k8s.namespace.name": "mynamespace",
+				"k8s.pod.name":       "mypod",
+				"k8s.container.name": "mycontainer",
+				"cloud.region":       "us-east-1",
+			},
+			expectMr: &monitoredrespb.MonitoredResource{
+				Type: "k8s_container",
+				Labels: map[string]string{
+					"location":       "us-east-1",
+					"cluster_name":   "mycluster",
+					"namespace_name": "mynamespace",
+					"pod_name":       "mypod",
+					"container_name": "mycontainer",
+				},
+			},
+		},
+		{
+			name: "Falls back to built-in mappings",
+			resourceLabels: map[string]string{
+				"cloud.platform":          "gcp_compute_engine",
+				"cloud.availability_zone": "us-central1-c",
+				"host.id":                 "myhostid",
+			},
+			expectMr: &monitoredrespb.MonitoredResource{
+				Type: "gce_instance",
+				Labels: map[string]string{
+					"zone":        "us-central1-c",
+					"instance_id": "myhostid",
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := pcommon.NewResource()
+			for k, v := range test.resourceLabels {
+				r.Attributes().InsertString(k, v)
+			}
+			assert.Equal(t, test.expectMr, mapResource(r))
+		})
+	}
+}
+
+func TestLogsWithResourceMappings(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProjectID = "fakeprojectid"
+	cfg.LogConfig.DefaultLogName = "default-log"
+	cfg.ResourceMappings = []ResourceMapping{
+		{
+			Type:   "generic_task",
+			Match:  []ResourceAttributeMatch{{Key: "service.name"}},
+			Labels: map[string]ResourceLabelMapping{"job": {Key: "service.name"}},
+		},
+	}
+	mapper := logMapper{
+		cfg:                  cfg,
+		obs:                  newTestSelfObservability(),
+		router:               newProjectRouter(cfg),
+		mapMonitoredResource: newResourceMapper(cfg.ResourceMappings, resourcemapping.DefaultLoggingMappings()),
+		maxEntrySize:         defaultMaxEntrySize,
+	}
+
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("service.name", "myservice")
+	rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+
+	entries, err := mapper.createEntries(logs)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, &monitoredrespb.MonitoredResource{
+		Type:   "generic_task",
+		Labels: map[string]string{"job": "myservice"},
+	}, entries[0].Resource)
+}
+
+func TestLogsServerlessMonitoredResource(t *testing.T) {
+	mapper := newTestLogMapper(defaultMaxEntrySize)
+
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("cloud.platform", "gcp_cloud_run")
+	rl.Resource().Attributes().InsertString("cloud.region", "us-central1")
+	rl.Resource().Attributes().InsertString("faas.name", "myservice")
+	rl.Resource().Attributes().InsertString("faas.version", "myservice-00001")
+	rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+
+	entries, err := mapper.createEntries(logs)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, &monitoredrespb.MonitoredResource{
+		Type: "cloud_run_revision",
+		Labels: map[string]string{
+			"location":           "us-central1",
+			"service_name":       "myservice",
+			"configuration_name": "myservice",
+			"revision_name":      "myservice-00001",
+		},
+	}, entries[0].Resource)
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/normalization"
+)
+
+const (
+	defaultNormalizationSnapshotInterval = time.Minute
+	defaultNormalizationSnapshotTTL      = time.Hour
+)
+
+// normalizationSnapshot saves the points cached by cumulative normalization
+// to a file, so they can be restored after a restart.
+type normalizationSnapshot struct {
+	path        string
+	interval    time.Duration
+	ttl         time.Duration
+	snapshotter normalization.Snapshotter
+}
+
+func newNormalizationSnapshot(cfg NormalizationSnapshotConfig, snapshotter normalization.Snapshotter) *normalizationSnapshot {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultNormalizationSnapshotInterval
+	}
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultNormalizationSnapshotTTL
+	}
+	return &normalizationSnapshot{
+		path:        cfg.Path,
+		interval:    interval,
+		ttl:         ttl,
+		snapshotter: snapshotter,
+	}
+}
+
+// load restores the snapshot, unless it doesn't exist or is older than the
+// TTL. It returns the number of points restored.
+func (s *normalizationSnapshot) load(now time.Time) (int, error) {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read normalization snapshot: %w", err)
+	}
+	if now.Sub(info.ModTime()) > s.ttl {
+		return 0, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read normalization snapshot: %w", err)
+	}
+	return s.snapshotter.UnmarshalSnapshot(data)
+}
+
+// save writes the snapshot. The file is replaced atomically, so a crash
+// while saving leaves the previous snapshot intact.
+func (s *normalizationSnapshot) save() error {
+	data, err := s.snapshotter.MarshalSnapshot()
+	if err != nil {
+		return fmt.Errorf("failed to encode normalization snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create normalization snapshot directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write normalization snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write normalization snapshot: %w", err)
+	}
+	return nil
+}
+
+// normalizationSnapshotRunner saves the snapshot periodically, and once more
+// at shutdown.
+func (me *MetricsExporter) normalizationSnapshotRunner() {
+	defer me.goroutines.Done()
+
+	ticker := time.NewTicker(me.snapshot.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-me.shutdownC:
+			me.saveNormalizationSnapshot()
+			return
+		case <-ticker.C:
+			me.saveNormalizationSnapshot()
+		}
+	}
+}
+
+func (me *MetricsExporter) saveNormalizationSnapshot() {
+	if err := me.snapshot.save(); err != nil {
+		me.obs.log.Error("Unable to save normalization snapshot.", zap.Error(err))
+	}
+}
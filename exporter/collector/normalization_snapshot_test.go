@@ -0,0 +1,91 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/normalization"
+)
+
+func TestNormalizationSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot", "normalization.pb")
+	newSnapshot := func(t *testing.T) (normalization.Normalizer, *normalizationSnapshot) {
+		shutdown := make(chan struct{})
+		t.Cleanup(func() { close(shutdown) })
+		normalizer := normalization.NewStandardNormalizer(shutdown, zap.NewNop(), datapointstorage.Options{})
+		return normalizer, newNormalizationSnapshot(NormalizationSnapshotConfig{Path: path}, normalizer.(normalization.Snapshotter))
+	}
+	newPoint := func(ts time.Time, value int64) pmetric.NumberDataPoint {
+		point := pmetric.NewNumberDataPoint()
+		point.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+		point.SetIntVal(value)
+		return point
+	}
+	now := time.Now()
+
+	t.Run("Missing snapshot", func(t *testing.T) {
+		_, snapshot := newSnapshot(t)
+		restored, err := snapshot.load(now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, restored)
+	})
+
+	t.Run("Restored points continue the timeseries", func(t *testing.T) {
+		normalizer, snapshot := newSnapshot(t)
+		// The first point has no start time, so it is cached and dropped.
+		assert.Nil(t, normalizer.NormalizeNumberDataPoint(newPoint(start, 10), testIdentifier("foo")))
+		require.NoError(t, snapshot.save())
+
+		restartedNormalizer, restartedSnapshot := newSnapshot(t)
+		restored, err := restartedSnapshot.load(now)
+		require.NoError(t, err)
+		assert.Equal(t, 2, restored)
+		point := restartedNormalizer.NormalizeNumberDataPoint(newPoint(start.Add(time.Minute), 15), testIdentifier("foo"))
+		require.NotNil(t, point)
+		assert.Equal(t, int64(5), point.IntVal())
+		assert.Equal(t, pcommon.NewTimestampFromTime(start), point.StartTimestamp())
+	})
+
+	t.Run("Expired snapshot", func(t *testing.T) {
+		normalizer, snapshot := newSnapshot(t)
+		assert.Nil(t, normalizer.NormalizeNumberDataPoint(newPoint(start, 10), testIdentifier("foo")))
+		require.NoError(t, snapshot.save())
+		old := now.Add(-2 * defaultNormalizationSnapshotTTL)
+		require.NoError(t, os.Chtimes(path, old, old))
+
+		_, restartedSnapshot := newSnapshot(t)
+		restored, err := restartedSnapshot.load(now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, restored)
+	})
+
+	t.Run("Corrupt snapshot", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0o600))
+		_, snapshot := newSnapshot(t)
+		_, err := snapshot.load(now)
+		assert.Error(t, err)
+	})
+}
@@ -17,44 +17,310 @@ package collector
 import (
 	"context"
 	"strconv"
+	"strings"
+	"time"
 
-	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
-	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncint64"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
 )
 
-var (
-	pointCount                  = stats.Int64("googlecloudmonitoring/point_count", "Count of metric points written to Cloud Monitoring.", "1")
-	exemplarAttachmentDropCount = stats.Int64("googlecloudmonitoring/exemplar_attachments_dropped", "Count of exemplar attachments dropped.", "{attachments}")
-	statusKey                   = tag.MustNewKey("status")
+// instrumentationName is the name of the meter used to report metrics about
+// the exporters themselves.
+const instrumentationName = "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector"
+
+const (
+	statusKey      = attribute.Key("status")
+	reasonKey      = attribute.Key("reason")
+	metricTypeKey  = attribute.Key("metric_type")
+	actionKey      = attribute.Key("action")
+	projectIDKey   = attribute.Key("project_id")
+	cacheNameKey   = attribute.Key("cache")
+	rpcSystemKey   = attribute.Key("rpc.system")
+	rpcServiceKey  = attribute.Key("rpc.service")
+	rpcMethodKey   = attribute.Key("rpc.method")
+	rpcGRPCCodeKey = attribute.Key("rpc.grpc.status_code")
 )
 
-var viewPointCount = &view.View{
-	Name:        pointCount.Name(),
-	Description: pointCount.Description(),
-	Measure:     pointCount,
-	Aggregation: view.Sum(),
-	TagKeys:     []tag.Key{statusKey},
+// self-observability reporting meters/tracers/loggers. It is shared by the
+// metrics, logs and traces exporters, so they all report the same set of
+// metrics.
+type selfObservability struct {
+	// Logger to use for this exporter.
+	log *zap.Logger
+
+	meter                         metric.Meter
+	pointCount                    syncint64.Counter
+	exemplarAttachmentDropCount   syncint64.Counter
+	pointRejectionCount           syncint64.Counter
+	cardinalityOverflowCount      syncint64.Counter
+	distributionRangeOmittedCount syncint64.Counter
+	metricDescriptorConflictCount syncint64.Counter
+	metricDescriptorCreationCount syncint64.Counter
+	labelLimitActionCount         syncint64.Counter
+	projectRequestCount           syncint64.Counter
+	projectRequestLatency         syncfloat64.Histogram
+	timeSeriesBatchSize           syncint64.Histogram
+	normalizationCacheSize        asyncint64.Gauge
+	normalizationCacheBytes       asyncint64.Gauge
+	normalizationCacheHits        asyncint64.Counter
+	normalizationCacheMisses      asyncint64.Counter
+	normalizationCacheEvictions   asyncint64.Counter
+	normalizationCacheCollisions  asyncint64.Counter
+	logEntryCount                 syncint64.Counter
+	logEntryBatchSize             syncint64.Histogram
+	spanCount                     syncint64.Counter
+	rpcClientDuration             syncfloat64.Histogram
+	rpcClientRequestSize          syncint64.Histogram
+	rpcClientResponseSize         syncint64.Histogram
+	rpcClientRequestsPerRPC       syncint64.Histogram
+	rpcClientResponsesPerRPC      syncint64.Histogram
+}
+
+// newSelfObservability creates the instruments used to report metrics about
+// the exporter using the given MeterProvider, or the global MeterProvider if
+// it is nil.
+func newSelfObservability(log *zap.Logger, mp metric.MeterProvider) (selfObservability, error) {
+	if mp == nil {
+		mp = global.MeterProvider()
+	}
+	o := selfObservability{
+		log:   log,
+		meter: mp.Meter(instrumentationName),
+	}
+	var errs error
+	int64Counter := func(name, description string, u unit.Unit) syncint64.Counter {
+		c, err := o.meter.SyncInt64().Counter(name, instrument.WithDescription(description), instrument.WithUnit(u))
+		errs = multierr.Append(errs, err)
+		return c
+	}
+	int64Histogram := func(name, description string, u unit.Unit) syncint64.Histogram {
+		h, err := o.meter.SyncInt64().Histogram(name, instrument.WithDescription(description), instrument.WithUnit(u))
+		errs = multierr.Append(errs, err)
+		return h
+	}
+	float64Histogram := func(name, description string, u unit.Unit) syncfloat64.Histogram {
+		h, err := o.meter.SyncFloat64().Histogram(name, instrument.WithDescription(description), instrument.WithUnit(u))
+		errs = multierr.Append(errs, err)
+		return h
+	}
+
+	o.pointCount = int64Counter("googlecloudmonitoring/point_count", "Count of metric points written to Cloud Monitoring.", unit.Dimensionless)
+	o.exemplarAttachmentDropCount = int64Counter("googlecloudmonitoring/exemplar_attachments_dropped", "Count of exemplar attachments dropped.", "{attachments}")
+	o.pointRejectionCount = int64Counter("googlecloudmonitoring/point_rejection_count", "Count of metric points permanently rejected by Cloud Monitoring.", unit.Dimensionless)
+	o.cardinalityOverflowCount = int64Counter("googlecloudmonitoring/cardinality_overflow_count", "Count of metric points written to an overflow timeseries because their metric exceeded its cardinality limit.", unit.Dimensionless)
+	o.distributionRangeOmittedCount = int64Counter("googlecloudmonitoring/distribution_range_omitted_count", "Count of distribution points written without a range because the min or max of their histogram point is unknown.", unit.Dimensionless)
+	o.metricDescriptorConflictCount = int64Counter("googlecloudmonitoring/metric_descriptor_conflict_count", "Count of metric descriptors which conflict with the existing metric descriptor.", unit.Dimensionless)
+	o.metricDescriptorCreationCount = int64Counter("googlecloudmonitoring/metric_descriptor_creation_count", "Count of CreateMetricDescriptor requests sent to Cloud Monitoring.", unit.Dimensionless)
+	o.labelLimitActionCount = int64Counter("googlecloudmonitoring/label_limit_action_count", "Count of actions taken to enforce metric label limits.", unit.Dimensionless)
+	o.projectRequestCount = int64Counter("googlecloudmonitoring/project_request_count", "Count of CreateTimeSeries requests sent to each project.", unit.Dimensionless)
+	o.projectRequestLatency = float64Histogram("googlecloudmonitoring/project_request_latency", "Latency of CreateTimeSeries requests sent to each project, including retries of partial failures.", unit.Milliseconds)
+	o.timeSeriesBatchSize = int64Histogram("googlecloudmonitoring/time_series_batch_size", "Number of timeseries in each CreateTimeSeries request.", "{timeseries}")
+	o.logEntryCount = int64Counter("googlecloudlogging/log_entry_count", "Count of log entries written to Cloud Logging.", unit.Dimensionless)
+	o.logEntryBatchSize = int64Histogram("googlecloudlogging/log_entry_batch_size", "Number of log entries in each WriteLogEntries request.", "{entries}")
+	o.spanCount = int64Counter("googlecloudtrace/span_count", "Count of spans written to Cloud Trace.", unit.Dimensionless)
+	o.rpcClientDuration = float64Histogram("rpc.client.duration", "Duration of RPCs made to Google Cloud APIs.", unit.Milliseconds)
+	o.rpcClientRequestSize = int64Histogram("rpc.client.request.size", "Size of the request messages of RPCs made to Google Cloud APIs.", unit.Bytes)
+	o.rpcClientResponseSize = int64Histogram("rpc.client.response.size", "Size of the response messages of RPCs made to Google Cloud APIs.", unit.Bytes)
+	o.rpcClientRequestsPerRPC = int64Histogram("rpc.client.requests_per_rpc", "Number of request messages sent per RPC made to Google Cloud APIs.", unit.Dimensionless)
+	o.rpcClientResponsesPerRPC = int64Histogram("rpc.client.responses_per_rpc", "Number of response messages received per RPC made to Google Cloud APIs.", unit.Dimensionless)
+
+	int64Gauge := func(name, description string, u unit.Unit) asyncint64.Gauge {
+		g, err := o.meter.AsyncInt64().Gauge(name, instrument.WithDescription(description), instrument.WithUnit(u))
+		errs = multierr.Append(errs, err)
+		return g
+	}
+	int64ObservableCounter := func(name, description string, u unit.Unit) asyncint64.Counter {
+		c, err := o.meter.AsyncInt64().Counter(name, instrument.WithDescription(description), instrument.WithUnit(u))
+		errs = multierr.Append(errs, err)
+		return c
+	}
+	o.normalizationCacheSize = int64Gauge("googlecloudmonitoring/normalization_cache_size", "Number of points cached to normalize cumulative points and accumulate delta points.", "{points}")
+	o.normalizationCacheBytes = int64Gauge("googlecloudmonitoring/normalization_cache_bytes", "Estimated memory used by points cached to normalize cumulative points and accumulate delta points.", unit.Bytes)
+	o.normalizationCacheHits = int64ObservableCounter("googlecloudmonitoring/normalization_cache_hit_count", "Count of lookups which found a cached point.", unit.Dimensionless)
+	o.normalizationCacheMisses = int64ObservableCounter("googlecloudmonitoring/normalization_cache_miss_count", "Count of lookups which didn't find a cached point.", unit.Dimensionless)
+	o.normalizationCacheEvictions = int64ObservableCounter("googlecloudmonitoring/normalization_cache_eviction_count", "Count of cached points evicted because the cache was full.", unit.Dimensionless)
+	o.normalizationCacheCollisions = int64ObservableCounter("googlecloudmonitoring/normalization_cache_collision_count", "Count of lookups which found a cached point of a different timeseries with the same identifier hash.", unit.Dimensionless)
+	return o, errs
 }
 
-// MetricViews returns a slice of views for this exporter's metrics.
+// MetricViews returns no views. Self-observability metrics are no longer
+// recorded with OpenCensus, so there are no views to register.
+//
+// Deprecated: Self-observability metrics are reported with
+// Config.MeterProvider, or the global MeterProvider if it is unset.
 func MetricViews() []*view.View {
-	return []*view.View{viewPointCount}
+	return nil
+}
+
+// pointCacher is implemented by the normalizer and accumulator, which cache
+// points between exports.
+type pointCacher interface {
+	CacheStats() datapointstorage.Stats
+}
+
+// observeNormalizationCache reports the size and usage of the caches of the
+// normalizer and accumulator whenever the cache metrics are collected.
+func (o selfObservability) observeNormalizationCache(normalizer, accumulator pointCacher) error {
+	instruments := []instrument.Asynchronous{
+		o.normalizationCacheSize,
+		o.normalizationCacheBytes,
+		o.normalizationCacheHits,
+		o.normalizationCacheMisses,
+		o.normalizationCacheEvictions,
+		o.normalizationCacheCollisions,
+	}
+	return o.meter.RegisterCallback(instruments, func(ctx context.Context) {
+		o.observeCacheStats(ctx, normalizer.CacheStats(), "normalizer")
+		o.observeCacheStats(ctx, accumulator.CacheStats(), "accumulator")
+	})
+}
+
+func (o selfObservability) observeCacheStats(ctx context.Context, stats datapointstorage.Stats, cacheName string) {
+	attr := cacheNameKey.String(cacheName)
+	o.normalizationCacheSize.Observe(ctx, int64(stats.Entries), attr)
+	o.normalizationCacheBytes.Observe(ctx, stats.Bytes, attr)
+	o.normalizationCacheHits.Observe(ctx, stats.Hits, attr)
+	o.normalizationCacheMisses.Observe(ctx, stats.Misses, attr)
+	o.normalizationCacheEvictions.Observe(ctx, stats.Evictions, attr)
+	o.normalizationCacheCollisions.Observe(ctx, stats.Collisions, attr)
+}
+
+func (o selfObservability) recordExemplarAttachmentDrop(ctx context.Context, reason string) {
+	o.exemplarAttachmentDropCount.Add(ctx, 1, reasonKey.String(reason))
+}
+
+func (o selfObservability) recordPointCountDataPoint(ctx context.Context, points int, status string) {
+	o.pointCount.Add(ctx, int64(points), statusKey.String(status))
+}
+
+func (o selfObservability) recordPointRejection(ctx context.Context, points int, reason string) {
+	o.pointRejectionCount.Add(ctx, int64(points), reasonKey.String(reason))
+}
+
+func (o selfObservability) recordDistributionRangeOmitted(ctx context.Context, metricType string) {
+	o.distributionRangeOmittedCount.Add(ctx, 1, metricTypeKey.String(metricType))
+}
+
+func (o selfObservability) recordCardinalityOverflow(ctx context.Context, points int, metricType string) {
+	o.cardinalityOverflowCount.Add(ctx, int64(points), metricTypeKey.String(metricType))
+}
+
+func (o selfObservability) recordMetricDescriptorConflict(ctx context.Context, metricType string) {
+	o.metricDescriptorConflictCount.Add(ctx, 1, metricTypeKey.String(metricType))
 }
 
-func recordExemplarFailure(ctx context.Context, point int) {
-	stats.Record(ctx, exemplarAttachmentDropCount.M(int64(point)))
+func (o selfObservability) recordMetricDescriptorCreation(ctx context.Context, status string) {
+	o.metricDescriptorCreationCount.Add(ctx, 1, statusKey.String(status))
 }
 
-func recordPointCountDataPoint(ctx context.Context, points int, status string) {
-	ctx, err := tag.New(ctx, tag.Insert(statusKey, status))
+func (o selfObservability) recordLabelLimitAction(ctx context.Context, action, metricType string) {
+	o.labelLimitActionCount.Add(ctx, 1, actionKey.String(action), metricTypeKey.String(metricType))
+}
+
+func (o selfObservability) recordProjectRequest(ctx context.Context, projectID, status string, latency time.Duration) {
+	o.projectRequestCount.Add(ctx, 1, projectIDKey.String(projectID), statusKey.String(status))
+	o.projectRequestLatency.Record(ctx, durationToMillis(latency), projectIDKey.String(projectID))
+}
+
+func (o selfObservability) recordTimeSeriesBatch(ctx context.Context, timeSeries int) {
+	o.timeSeriesBatchSize.Record(ctx, int64(timeSeries))
+}
+
+func (o selfObservability) recordLogEntryBatch(ctx context.Context, entries int, status string) {
+	o.logEntryCount.Add(ctx, int64(entries), statusKey.String(status))
+	o.logEntryBatchSize.Record(ctx, int64(entries))
+}
+
+func (o selfObservability) recordSpans(ctx context.Context, spans int, status string) {
+	o.spanCount.Add(ctx, int64(spans), statusKey.String(status))
+}
+
+// recordCreateTimeSeriesResult records the number of points which succeeded
+// and failed in a CreateTimeSeries request of the given number of points.
+// The retried points, which failed with a retryable error and are sent again,
+// are left out, so each point is only recorded with its final outcome.
+func (o selfObservability) recordCreateTimeSeriesResult(ctx context.Context, s *status.Status, points, retried int) {
+	succeededPoints := points
+	failedPoints := 0
+	if summary := createTimeSeriesSummary(s); summary != nil {
+		failedPoints = int(summary.TotalPointCount-summary.SuccessPointCount) - retried
+		succeededPoints = int(summary.SuccessPointCount)
+		for reason, rejected := range pointRejections(s, summary) {
+			o.recordPointRejection(ctx, rejected, reason)
+		}
+	} else if s.Code() != codes.OK {
+		// Nothing was written.
+		succeededPoints = 0
+		failedPoints = points - retried
+		if !isRetryableCode(s.Code()) {
+			o.recordPointRejection(ctx, failedPoints, rejectionReason(s))
+		}
+	}
+
+	// always record the number of successful points
+	o.recordPointCountDataPoint(ctx, succeededPoints, "OK")
+	if failedPoints > 0 {
+		o.recordPointCountDataPoint(ctx, failedPoints, statusCodeToString(s))
+	}
+}
+
+// unaryClientInterceptor records the duration, message sizes and message
+// counts of each RPC made by the exporter's Google Cloud API clients,
+// following the OpenTelemetry semantic conventions for RPC metrics.
+func (o selfObservability) unaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	duration := time.Since(start)
+	// method is of the form /package.service/method
+	service, rpcMethod := "", strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(rpcMethod, "/"); i >= 0 {
+		service, rpcMethod = rpcMethod[:i], rpcMethod[i+1:]
+	}
+	attrs := []attribute.KeyValue{
+		rpcSystemKey.String("grpc"),
+		rpcServiceKey.String(service),
+		rpcMethodKey.String(rpcMethod),
+		rpcGRPCCodeKey.Int64(int64(status.Code(err))),
+	}
+	o.rpcClientDuration.Record(ctx, durationToMillis(duration), attrs...)
+	o.rpcClientRequestsPerRPC.Record(ctx, 1, attrs...)
+	if m, ok := req.(proto.Message); ok {
+		o.rpcClientRequestSize.Record(ctx, int64(proto.Size(m)), attrs...)
+	}
 	if err != nil {
-		return
+		return err
+	}
+	o.rpcClientResponsesPerRPC.Record(ctx, 1, attrs...)
+	if m, ok := reply.(proto.Message); ok {
+		o.rpcClientResponseSize.Record(ctx, int64(proto.Size(m)), attrs...)
 	}
+	return nil
+}
 
-	stats.Record(ctx, pointCount.M(int64(points)))
+func durationToMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
 }
 
 func statusCodeToString(s *status.Status) string {
@@ -0,0 +1,111 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/metrictest"
+)
+
+func newTestSelfObservability() selfObservability {
+	obs, err := newSelfObservability(zap.NewNop(), metrictest.NewMeterProvider())
+	if err != nil {
+		panic(err)
+	}
+	return obs
+}
+
+func TestSelfObservability(t *testing.T) {
+	end := start.Add(time.Minute)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	fake := &fakeTimeSeriesServer{inFlight: map[string]int{}, maxInFlight: map[string]int{}}
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	mp := metrictest.NewMeterProvider()
+	cfg := DefaultConfig()
+	cfg.ProjectID = "myproject"
+	cfg.MeterProvider = mp
+	cfg.MetricConfig.ClientConfig.Endpoint = lis.Addr().String()
+	cfg.MetricConfig.ClientConfig.UseInsecure = true
+	me, err := NewGoogleCloudMetricsExporter(context.Background(), cfg, zap.NewNop(), "latest", DefaultTimeout)
+	require.NoError(t, err)
+	t.Cleanup(func() { me.Shutdown(context.Background()) })
+
+	ctx := context.Background()
+	require.NoError(t, me.exportProjects(ctx, map[string][]*monitoringpb.TimeSeries{
+		"myproject": newExportTestTimeSeries(sendBatchSize+1, end),
+	}))
+
+	point, ok := mp.Get(ctx, "googlecloudmonitoring/point_count", statusKey.String("OK"))
+	require.True(t, ok)
+	assert.Equal(t, float64(sendBatchSize+1), point.Value)
+
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/project_request_count", projectIDKey.String("myproject"), statusKey.String("OK"))
+	require.True(t, ok)
+	assert.Equal(t, float64(2), point.Value)
+
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/time_series_batch_size")
+	require.True(t, ok)
+	assert.Equal(t, int64(2), point.Count)
+	assert.Equal(t, float64(sendBatchSize+1), point.Value)
+
+	point, ok = mp.Get(
+		ctx,
+		"rpc.client.duration",
+		rpcSystemKey.String("grpc"),
+		rpcServiceKey.String("google.monitoring.v3.MetricService"),
+		rpcMethodKey.String("CreateTimeSeries"),
+		rpcGRPCCodeKey.Int64(0),
+	)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), point.Count)
+
+	for _, name := range []string{"rpc.client.request.size", "rpc.client.response.size", "rpc.client.requests_per_rpc", "rpc.client.responses_per_rpc"} {
+		point, ok = mp.Get(
+			ctx,
+			name,
+			rpcSystemKey.String("grpc"),
+			rpcServiceKey.String("google.monitoring.v3.MetricService"),
+			rpcMethodKey.String("CreateTimeSeries"),
+			rpcGRPCCodeKey.Int64(0),
+		)
+		require.True(t, ok, name)
+		assert.Equal(t, int64(2), point.Count, name)
+	}
+
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/normalization_cache_size", attribute.String("cache", "normalizer"))
+	require.True(t, ok)
+	assert.Equal(t, float64(0), point.Value)
+
+	point, ok = mp.Get(ctx, "googlecloudmonitoring/normalization_cache_eviction_count", attribute.String("cache", "accumulator"))
+	require.True(t, ok)
+	assert.Equal(t, float64(0), point.Value)
+}
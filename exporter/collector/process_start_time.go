@@ -0,0 +1,179 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/collector/internal/datapointstorage"
+)
+
+const (
+	// processStartTimeAttributeKey is the resource attribute with the start
+	// time of the process, as an RFC 3339 timestamp or seconds since the
+	// epoch.
+	processStartTimeAttributeKey = "process.start_time"
+	// processStartTimeMetricName is the Prometheus metric with the start
+	// time of the process, in seconds since the epoch.
+	processStartTimeMetricName = "process_start_time_seconds"
+)
+
+// processStartTime returns the start time of the process which produced the
+// resource's metrics, or zero if it isn't known. The process.start_time
+// resource attribute takes precedence over the process_start_time_seconds
+// metric.
+func processStartTime(rm pmetric.ResourceMetrics) pcommon.Timestamp {
+	if v, ok := rm.Resource().Attributes().Get(processStartTimeAttributeKey); ok {
+		switch v.Type() {
+		case pcommon.ValueTypeString:
+			if t, err := time.Parse(time.RFC3339Nano, v.StringVal()); err == nil {
+				return pcommon.NewTimestampFromTime(t)
+			}
+		case pcommon.ValueTypeInt:
+			return secondsToTimestamp(float64(v.IntVal()))
+		case pcommon.ValueTypeDouble:
+			return secondsToTimestamp(v.DoubleVal())
+		}
+	}
+	sms := rm.ScopeMetrics()
+	for i := 0; i < sms.Len(); i++ {
+		metrics := sms.At(i).Metrics()
+		for j := 0; j < metrics.Len(); j++ {
+			metric := metrics.At(j)
+			if metric.Name() != processStartTimeMetricName {
+				continue
+			}
+			var points pmetric.NumberDataPointSlice
+			switch metric.DataType() {
+			case pmetric.MetricDataTypeGauge:
+				points = metric.Gauge().DataPoints()
+			case pmetric.MetricDataTypeSum:
+				points = metric.Sum().DataPoints()
+			default:
+				continue
+			}
+			if points.Len() == 0 {
+				continue
+			}
+			switch point := points.At(0); point.ValueType() {
+			case pmetric.NumberDataPointValueTypeDouble:
+				return secondsToTimestamp(point.DoubleVal())
+			case pmetric.NumberDataPointValueTypeInt:
+				return secondsToTimestamp(float64(point.IntVal()))
+			}
+		}
+	}
+	return 0
+}
+
+func secondsToTimestamp(seconds float64) pcommon.Timestamp {
+	if seconds <= 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0
+	}
+	return pcommon.Timestamp(seconds * float64(time.Second))
+}
+
+// cumulativeStartTimestamp returns the process start time, and true, if it
+// should be used as the start time of a cumulative point with the given
+// timestamps. Points which already have a start time keep it.
+func (m *metricMapper) cumulativeStartTimestamp(start, end pcommon.Timestamp) (pcommon.Timestamp, bool) {
+	if start != 0 || m.processStartTime == 0 || m.processStartTime >= end {
+		return 0, false
+	}
+	return m.processStartTime, true
+}
+
+// processStartTimeCache remembers the process start time of each resource,
+// so its points keep the same start time when the batch with the
+// process_start_time_seconds metric and the batch with the points are
+// exported in separate pushes.
+type processStartTimeCache struct {
+	mu      sync.Mutex
+	entries map[datapointstorage.Identifier]*processStartTimeEntry
+}
+
+type processStartTimeEntry struct {
+	start pcommon.Timestamp
+	// used is set when the start time is read or written, and cleared by gc.
+	used bool
+}
+
+func newProcessStartTimeCache() *processStartTimeCache {
+	return &processStartTimeCache{entries: make(map[datapointstorage.Identifier]*processStartTimeEntry)}
+}
+
+// processStartTimeGCRunner removes the start times of resources which haven't
+// been seen for a full GC interval until shutdown.
+func (me *MetricsExporter) processStartTimeGCRunner() {
+	defer me.goroutines.Done()
+	interval := me.cfg.MetricConfig.NormalizationCache.GCInterval
+	if interval <= 0 {
+		interval = defaultNormalizationCacheGCInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-me.shutdownC:
+			return
+		case <-ticker.C:
+			me.processStartTimes.gc()
+		}
+	}
+}
+
+// get returns the process start time of the resource. A start time found in
+// the resource replaces the stored one, since the process may have
+// restarted. Otherwise, the stored start time is returned, or zero if it
+// isn't known.
+func (c *processStartTimeCache) get(rm pmetric.ResourceMetrics) pcommon.Timestamp {
+	id := datapointstorage.NewResourceIdentifier(rm.Resource().Attributes())
+	start := processStartTime(rm)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if start == 0 {
+		if !ok {
+			return 0
+		}
+		e.used = true
+		return e.start
+	}
+	if !ok {
+		e = &processStartTimeEntry{}
+		c.entries[id] = e
+	}
+	e.start = start
+	e.used = true
+	return start
+}
+
+// gc removes the start times which haven't been used since the previous gc.
+func (c *processStartTimeCache) gc() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if !e.used {
+			delete(c.entries, id)
+			continue
+		}
+		e.used = false
+	}
+}
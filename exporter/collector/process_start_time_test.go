@@ -0,0 +1,176 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestProcessStartTime(t *testing.T) {
+	processStart := time.Unix(1600000000, 500000000).UTC()
+	withMetric := func(rm pmetric.ResourceMetrics, dataType pmetric.MetricDataType) pmetric.NumberDataPoint {
+		metric := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		metric.SetName("process_start_time_seconds")
+		metric.SetDataType(dataType)
+		if dataType == pmetric.MetricDataTypeSum {
+			return metric.Sum().DataPoints().AppendEmpty()
+		}
+		return metric.Gauge().DataPoints().AppendEmpty()
+	}
+	for _, tc := range []struct {
+		desc     string
+		setup    func(rm pmetric.ResourceMetrics)
+		expected time.Time
+	}{
+		{
+			desc:  "unknown",
+			setup: func(rm pmetric.ResourceMetrics) {},
+		},
+		{
+			desc: "RFC 3339 attribute",
+			setup: func(rm pmetric.ResourceMetrics) {
+				rm.Resource().Attributes().InsertString("process.start_time", processStart.Format(time.RFC3339Nano))
+			},
+			expected: processStart,
+		},
+		{
+			desc: "seconds attribute",
+			setup: func(rm pmetric.ResourceMetrics) {
+				rm.Resource().Attributes().InsertInt("process.start_time", 1600000000)
+			},
+			expected: time.Unix(1600000000, 0),
+		},
+		{
+			desc: "Prometheus gauge",
+			setup: func(rm pmetric.ResourceMetrics) {
+				withMetric(rm, pmetric.MetricDataTypeGauge).SetDoubleVal(1600000000.5)
+			},
+			expected: processStart,
+		},
+		{
+			desc: "Prometheus sum",
+			setup: func(rm pmetric.ResourceMetrics) {
+				withMetric(rm, pmetric.MetricDataTypeSum).SetIntVal(1600000000)
+			},
+			expected: time.Unix(1600000000, 0),
+		},
+		{
+			desc: "attribute takes precedence over metric",
+			setup: func(rm pmetric.ResourceMetrics) {
+				rm.Resource().Attributes().InsertDouble("process.start_time", 1600000000.5)
+				withMetric(rm, pmetric.MetricDataTypeGauge).SetDoubleVal(1500000000)
+			},
+			expected: processStart,
+		},
+		{
+			desc: "invalid attribute falls back to metric",
+			setup: func(rm pmetric.ResourceMetrics) {
+				rm.Resource().Attributes().InsertString("process.start_time", "yesterday")
+				withMetric(rm, pmetric.MetricDataTypeGauge).SetDoubleVal(1600000000.5)
+			},
+			expected: processStart,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			rm := pmetric.NewResourceMetrics()
+			tc.setup(rm)
+			got := processStartTime(rm)
+			if tc.expected.IsZero() {
+				assert.Equal(t, pcommon.Timestamp(0), got)
+				return
+			}
+			assert.Equal(t, pcommon.NewTimestampFromTime(tc.expected), got)
+		})
+	}
+}
+
+func TestProcessStartTimeCache(t *testing.T) {
+	c := newProcessStartTimeCache()
+	newResourceMetrics := func(instance string, processStart int64) pmetric.ResourceMetrics {
+		rm := pmetric.NewResourceMetrics()
+		rm.Resource().Attributes().InsertString("service.instance.id", instance)
+		if processStart != 0 {
+			metric := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+			metric.SetName("process_start_time_seconds")
+			metric.SetDataType(pmetric.MetricDataTypeGauge)
+			metric.Gauge().DataPoints().AppendEmpty().SetIntVal(processStart)
+		}
+		return rm
+	}
+
+	assert.Equal(t, pcommon.Timestamp(0), c.get(newResourceMetrics("a", 0)))
+	assert.Equal(t, secondsToTimestamp(1600000000), c.get(newResourceMetrics("a", 1600000000)))
+	// Later batches of the resource without the metric keep its start time.
+	assert.Equal(t, secondsToTimestamp(1600000000), c.get(newResourceMetrics("a", 0)))
+	// Other resources don't.
+	assert.Equal(t, pcommon.Timestamp(0), c.get(newResourceMetrics("b", 0)))
+	// A restarted process replaces the start time.
+	assert.Equal(t, secondsToTimestamp(1700000000), c.get(newResourceMetrics("a", 1700000000)))
+	assert.Equal(t, secondsToTimestamp(1700000000), c.get(newResourceMetrics("a", 0)))
+
+	// Start times are removed when they aren't used for a full interval.
+	c.gc()
+	assert.Equal(t, secondsToTimestamp(1700000000), c.get(newResourceMetrics("a", 0)))
+	c.gc()
+	c.gc()
+	assert.Equal(t, pcommon.Timestamp(0), c.get(newResourceMetrics("a", 0)))
+}
+
+func TestSumPointWithProcessStartTime(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	processStart := start.Add(-time.Minute)
+	mapper.processStartTime = pcommon.NewTimestampFromTime(processStart)
+	mr := &monitoredrespb.MonitoredResource{}
+
+	metric := pmetric.NewMetric()
+	metric.SetName("mysum")
+	metric.SetDataType(pmetric.MetricDataTypeSum)
+	sum := metric.Sum()
+	sum.SetIsMonotonic(true)
+	sum.SetAggregationTemporality(pmetric.MetricAggregationTemporalityCumulative)
+	// Neither point has a start time.
+	point := sum.DataPoints().AppendEmpty()
+	point.SetIntVal(10)
+	point.SetTimestamp(pcommon.NewTimestampFromTime(start))
+	point = sum.DataPoints().AppendEmpty()
+	point.SetIntVal(15)
+	point.SetTimestamp(pcommon.NewTimestampFromTime(start.Add(time.Minute)))
+
+	tsl := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, "myproject", mapper.relabelMetric(metric, labels{}))
+	// The first point isn't dropped, and values are not subtracted.
+	assert.Len(t, tsl, 2)
+	for i, expected := range []int64{10, 15} {
+		assert.Equal(t, timestamppb.New(processStart), tsl[i].Points[0].Interval.StartTime)
+		assert.Equal(t, expected, tsl[i].Points[0].Value.GetInt64Value())
+	}
+	// The input points are not modified.
+	assert.Equal(t, pcommon.Timestamp(0), sum.DataPoints().At(0).StartTimestamp())
+
+	// Points from before the process started are normalized.
+	mapper.processStartTime = pcommon.NewTimestampFromTime(start.Add(time.Hour))
+	tsl = mapper.metricToTimeSeries(context.Background(), mr, labels{"other": "resource"}, metric, "myproject", mapper.relabelMetric(metric, labels{"other": "resource"}))
+	assert.Len(t, tsl, 1)
+	assert.Equal(t, timestamppb.New(start), tsl[0].Points[0].Interval.StartTime)
+	assert.Equal(t, int64(5), tsl[0].Points[0].Value.GetInt64Value())
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping"
+)
+
+const projectRoutingTemplateValue = "${value}"
+
+// projectRouter determines the project telemetry is sent to, from the
+// attributes of its resource and of the record itself. It is shared by the
+// metrics, logs and traces exporters.
+type projectRouter struct {
+	rules            []ProjectRoutingRule
+	defaultProjectID string
+	dropUnmatched    bool
+	recordRules      bool
+}
+
+func newProjectRouter(cfg Config) *projectRouter {
+	r := &projectRouter{
+		rules:            cfg.ProjectRouting.Rules,
+		defaultProjectID: cfg.ProjectRouting.DefaultProjectID,
+		dropUnmatched:    cfg.ProjectRouting.DropUnmatched,
+	}
+	if r.defaultProjectID == "" {
+		r.defaultProjectID = cfg.ProjectID
+	}
+	for _, rule := range r.rules {
+		if rule.Source == ProjectRoutingSourceRecord {
+			r.recordRules = true
+		}
+	}
+	return r
+}
+
+// routesRecords returns true if any rule matches record attributes, so
+// records of the same resource can be routed to different projects.
+func (r *projectRouter) routesRecords() bool {
+	return r.recordRules
+}
+
+// route returns the project of a record with the given resource and record
+// attributes, or false if the record should be dropped.
+func (r *projectRouter) route(resource, record pcommon.Map) (string, bool) {
+	for _, rule := range r.rules {
+		attrs := resource
+		if rule.Source == ProjectRoutingSourceRecord {
+			attrs = record
+		}
+		v, ok := attrs.Get(rule.Attribute)
+		if !ok {
+			continue
+		}
+		value := v.AsString()
+		if value == "" {
+			continue
+		}
+		switch {
+		case len(rule.Projects) > 0:
+			projectID, ok := rule.Projects[value]
+			if !ok {
+				// The value is unknown, so later rules aren't evaluated.
+				return r.unmatched(resource)
+			}
+			return projectID, true
+		case rule.Template != "":
+			return strings.ReplaceAll(rule.Template, projectRoutingTemplateValue, value), true
+		default:
+			return value, true
+		}
+	}
+	return r.unmatched(resource)
+}
+
+// unmatched returns the project of a record which matches no rule: its
+// gcp.project.id resource attribute, or the default project. It returns
+// false if unmatched records are dropped.
+func (r *projectRouter) unmatched(resource pcommon.Map) (string, bool) {
+	if r.dropUnmatched {
+		return "", false
+	}
+	if projectFromResource, found := resource.Get(resourcemapping.ProjectIDAttributeKey); found {
+		return projectFromResource.AsString(), true
+	}
+	return r.defaultProjectID, true
+}
+
+// splitMetric returns the metric's points grouped by the project they are
+// routed to. Points which are dropped are removed. The metric is returned
+// as-is if all of its points are routed to the same project.
+func (r *projectRouter) splitMetric(resource pcommon.Map, metric pmetric.Metric) map[string]pmetric.Metric {
+	var projects []string
+	var routed []bool
+	dropped := false
+	rangePointAttributes(metric, func(attrs pcommon.Map) {
+		projectID, ok := r.route(resource, attrs)
+		projects = append(projects, projectID)
+		routed = append(routed, ok)
+		dropped = dropped || !ok
+	})
+	split := make(map[string]pmetric.Metric)
+	for i, projectID := range projects {
+		if routed[i] {
+			split[projectID] = metric
+		}
+	}
+	if len(split) < 2 && !dropped {
+		return split
+	}
+	for projectID := range split {
+		projectMetric := pmetric.NewMetric()
+		metric.CopyTo(projectMetric)
+		i := 0
+		removePoints(projectMetric, func() bool {
+			remove := !routed[i] || projects[i] != projectID
+			i++
+			return remove
+		})
+		split[projectID] = projectMetric
+	}
+	return split
+}
+
+// rangePointAttributes calls f with the attributes of each point of the
+// metric, in order.
+func rangePointAttributes(metric pmetric.Metric, f func(pcommon.Map)) {
+	switch metric.DataType() {
+	case pmetric.MetricDataTypeGauge:
+		points := metric.Gauge().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			f(points.At(i).Attributes())
+		}
+	case pmetric.MetricDataTypeSum:
+		points := metric.Sum().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			f(points.At(i).Attributes())
+		}
+	case pmetric.MetricDataTypeSummary:
+		points := metric.Summary().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			f(points.At(i).Attributes())
+		}
+	case pmetric.MetricDataTypeHistogram:
+		points := metric.Histogram().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			f(points.At(i).Attributes())
+		}
+	case pmetric.MetricDataTypeExponentialHistogram:
+		points := metric.ExponentialHistogram().DataPoints()
+		for i := 0; i < points.Len(); i++ {
+			f(points.At(i).Attributes())
+		}
+	}
+}
+
+// removePoints removes the points of the metric for which remove returns
+// true. remove is called once for each point, in order.
+func removePoints(metric pmetric.Metric, remove func() bool) {
+	switch metric.DataType() {
+	case pmetric.MetricDataTypeGauge:
+		metric.Gauge().DataPoints().RemoveIf(func(pmetric.NumberDataPoint) bool { return remove() })
+	case pmetric.MetricDataTypeSum:
+		metric.Sum().DataPoints().RemoveIf(func(pmetric.NumberDataPoint) bool { return remove() })
+	case pmetric.MetricDataTypeSummary:
+		metric.Summary().DataPoints().RemoveIf(func(pmetric.SummaryDataPoint) bool { return remove() })
+	case pmetric.MetricDataTypeHistogram:
+		metric.Histogram().DataPoints().RemoveIf(func(pmetric.HistogramDataPoint) bool { return remove() })
+	case pmetric.MetricDataTypeExponentialHistogram:
+		metric.ExponentialHistogram().DataPoints().RemoveIf(func(pmetric.ExponentialHistogramDataPoint) bool { return remove() })
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func testProjectRoutingConfig() Config {
+	cfg := DefaultConfig()
+	cfg.ProjectID = "fallback-project"
+	cfg.ProjectRouting = ProjectRoutingConfig{
+		Rules: []ProjectRoutingRule{
+			{
+				Attribute: "tenant",
+				Source:    ProjectRoutingSourceRecord,
+				Template:  "tenant-${value}",
+			},
+			{
+				Attribute: "k8s.namespace.name",
+				Projects: map[string]string{
+					"team-a": "project-a",
+					"team-b": "project-b",
+				},
+			},
+			{
+				Attribute: "project",
+			},
+		},
+		DefaultProjectID: "default-project",
+	}
+	return cfg
+}
+
+func TestProjectRouterRoute(t *testing.T) {
+	for _, tc := range []struct {
+		desc          string
+		resource      map[string]interface{}
+		record        map[string]interface{}
+		dropUnmatched bool
+		expected      string
+		expectedDrop  bool
+	}{
+		{
+			desc:     "default",
+			expected: "default-project",
+		},
+		{
+			desc:     "lookup table",
+			resource: map[string]interface{}{"k8s.namespace.name": "team-b"},
+			expected: "project-b",
+		},
+		{
+			desc:     "value missing from lookup table",
+			resource: map[string]interface{}{"k8s.namespace.name": "team-c"},
+			expected: "default-project",
+		},
+		{
+			desc:     "value missing from lookup table doesn't match later rules",
+			resource: map[string]interface{}{"k8s.namespace.name": "team-c", "project": "my-project"},
+			expected: "default-project",
+		},
+		{
+			desc:     "value missing from lookup table falls back to gcp.project.id",
+			resource: map[string]interface{}{"k8s.namespace.name": "team-c", "gcp.project.id": "resource-project"},
+			expected: "resource-project",
+		},
+		{
+			desc:     "template",
+			resource: map[string]interface{}{"k8s.namespace.name": "team-a"},
+			record:   map[string]interface{}{"tenant": 42},
+			expected: "tenant-42",
+		},
+		{
+			desc:     "record rule doesn't match resource attributes",
+			resource: map[string]interface{}{"tenant": "blue"},
+			expected: "default-project",
+		},
+		{
+			desc:     "attribute value",
+			resource: map[string]interface{}{"project": "my-project"},
+			expected: "my-project",
+		},
+		{
+			desc:     "empty attribute value",
+			resource: map[string]interface{}{"project": ""},
+			expected: "default-project",
+		},
+		{
+			desc:     "rules take precedence over gcp.project.id",
+			resource: map[string]interface{}{"gcp.project.id": "resource-project", "k8s.namespace.name": "team-a"},
+			expected: "project-a",
+		},
+		{
+			desc:     "gcp.project.id",
+			resource: map[string]interface{}{"gcp.project.id": "resource-project"},
+			expected: "resource-project",
+		},
+		{
+			desc:          "drop unmatched with gcp.project.id",
+			resource:      map[string]interface{}{"gcp.project.id": "resource-project"},
+			dropUnmatched: true,
+			expectedDrop:  true,
+		},
+		{
+			desc:          "drop unmatched",
+			resource:      map[string]interface{}{"k8s.namespace.name": "team-c"},
+			dropUnmatched: true,
+			expectedDrop:  true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := testProjectRoutingConfig()
+			cfg.ProjectRouting.DropUnmatched = tc.dropUnmatched
+			router := newProjectRouter(cfg)
+			projectID, ok := router.route(pcommon.NewMapFromRaw(tc.resource), pcommon.NewMapFromRaw(tc.record))
+			assert.Equal(t, !tc.expectedDrop, ok)
+			assert.Equal(t, tc.expected, projectID)
+		})
+	}
+}
+
+func TestProjectRouterDefaultsToProjectID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProjectID = "my-project"
+	router := newProjectRouter(cfg)
+	assert.False(t, router.routesRecords())
+
+	projectID, ok := router.route(pcommon.NewMap(), pcommon.NewMap())
+	assert.True(t, ok)
+	assert.Equal(t, "my-project", projectID)
+}
+
+func TestProjectRouterSplitMetric(t *testing.T) {
+	cfg := testProjectRoutingConfig()
+	cfg.ProjectRouting.DropUnmatched = true
+	router := newProjectRouter(cfg)
+	require.True(t, router.routesRecords())
+
+	metric := pmetric.NewMetric()
+	metric.SetDataType(pmetric.MetricDataTypeGauge)
+	for i, tenant := range []string{"red", "blue", "", "red"} {
+		point := metric.Gauge().DataPoints().AppendEmpty()
+		point.SetIntVal(int64(i))
+		if tenant != "" {
+			point.Attributes().InsertString("tenant", tenant)
+		}
+	}
+
+	split := router.splitMetric(pcommon.NewMap(), metric)
+	require.Len(t, split, 2)
+	values := func(m pmetric.Metric) []int64 {
+		var result []int64
+		for i := 0; i < m.Gauge().DataPoints().Len(); i++ {
+			result = append(result, m.Gauge().DataPoints().At(i).IntVal())
+		}
+		return result
+	}
+	assert.Equal(t, []int64{0, 3}, values(split["tenant-red"]))
+	assert.Equal(t, []int64{1}, values(split["tenant-blue"]))
+	// The original metric is unchanged.
+	assert.Equal(t, 4, metric.Gauge().DataPoints().Len())
+}
+
+func TestProjectRouterSplitMetricSingleProject(t *testing.T) {
+	router := newProjectRouter(testProjectRoutingConfig())
+
+	metric := pmetric.NewMetric()
+	metric.SetDataType(pmetric.MetricDataTypeSum)
+	for i := 0; i < 2; i++ {
+		metric.Sum().DataPoints().AppendEmpty().Attributes().InsertString("tenant", "red")
+	}
+
+	split := router.splitMetric(pcommon.NewMap(), metric)
+	require.Len(t, split, 1)
+	assert.Equal(t, metric, split["tenant-red"])
+}
+
+func TestLogsProjectRouting(t *testing.T) {
+	cfg := testProjectRoutingConfig()
+	cfg.LogConfig.DefaultLogName = "default-log"
+	cfg.ProjectRouting.DropUnmatched = true
+	mapper := logMapper{
+		cfg:                  cfg,
+		obs:                  newTestSelfObservability(),
+		router:               newProjectRouter(cfg),
+		mapMonitoredResource: defaultResourceToLogMonitoredResource,
+		maxEntrySize:         defaultMaxEntrySize,
+	}
+
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("k8s.namespace.name", "team-a")
+	records := rl.ScopeLogs().AppendEmpty().LogRecords()
+	records.AppendEmpty().Attributes().InsertString("tenant", "red")
+	records.AppendEmpty()
+
+	rl = logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().InsertString("k8s.namespace.name", "team-c")
+	rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+
+	entries, err := mapper.createEntries(logs)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "projects/tenant-red/logs/default-log", entries[0].LogName)
+	assert.Equal(t, "projects/project-a/logs/default-log", entries[1].LogName)
+}
+
+func TestTracesProjectRouting(t *testing.T) {
+	cfg := testProjectRoutingConfig()
+	cfg.ProjectRouting.DropUnmatched = true
+	router := newProjectRouter(cfg)
+
+	rs := ptrace.NewResourceSpans()
+	rs.Resource().Attributes().InsertString("k8s.namespace.name", "team-b")
+	spans := rs.ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().Attributes().InsertString("tenant", "red")
+	spans.AppendEmpty()
+
+	sds := pdataResourceSpansToOTSpanData(rs, router)
+	require.Len(t, sds["tenant-red"], 1)
+	require.Len(t, sds["project-b"], 1)
+	assert.Len(t, sds, 2)
+	// The project isn't added to the resource.
+	_, ok := sds["tenant-red"][0].Resource().Set().Value(attribute.Key("gcp.project.id"))
+	assert.False(t, ok)
+
+	rs.Resource().Attributes().UpdateString("k8s.namespace.name", "team-c")
+	sds = pdataResourceSpansToOTSpanData(rs, router)
+	assert.Len(t, sds["tenant-red"], 1)
+	assert.Len(t, sds, 1)
+}
@@ -0,0 +1,191 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// metricNameLabel is the label which holds the metric name during
+	// relabeling.
+	metricNameLabel = "__name__"
+
+	defaultRelabelSeparator   = ";"
+	defaultRelabelRegex       = "(.*)"
+	defaultRelabelReplacement = "$1"
+)
+
+// relabelRule is a RelabelConfig with its defaults applied and its regex
+// compiled.
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// relabeler applies relabeling rules to the name and labels of points.
+type relabeler struct {
+	rules []relabelRule
+}
+
+// newRelabeler compiles the relabeling rules. It returns nil if there are no
+// rules.
+func newRelabeler(cfgs []RelabelConfig) (*relabeler, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	rules := make([]relabelRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rule, err := newRelabelRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("metric.metric_relabel_configs[%d]: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return &relabeler{rules: rules}, nil
+}
+
+func newRelabelRule(cfg RelabelConfig) (relabelRule, error) {
+	rule := relabelRule{
+		sourceLabels: make([]string, 0, len(cfg.SourceLabels)),
+		separator:    cfg.Separator,
+		modulus:      cfg.Modulus,
+		targetLabel:  sanitizeKey(cfg.TargetLabel),
+		replacement:  defaultRelabelReplacement,
+		action:       cfg.Action,
+	}
+	// Source labels refer to labels by their sanitized keys, like the
+	// target label.
+	for _, key := range cfg.SourceLabels {
+		rule.sourceLabels = append(rule.sourceLabels, sanitizeKey(key))
+	}
+	if rule.separator == "" {
+		rule.separator = defaultRelabelSeparator
+	}
+	if cfg.Replacement != nil {
+		rule.replacement = *cfg.Replacement
+	}
+	if rule.action == "" {
+		rule.action = RelabelReplace
+	}
+	expr := cfg.Regex
+	if expr == "" {
+		expr = defaultRelabelRegex
+	}
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return relabelRule{}, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+	rule.regex = regex
+	switch rule.action {
+	case RelabelReplace:
+		if rule.targetLabel == "" {
+			return relabelRule{}, errors.New("target_label is required for the replace action")
+		}
+	case RelabelHashMod:
+		if rule.targetLabel == "" {
+			return relabelRule{}, errors.New("target_label is required for the hashmod action")
+		}
+		if rule.modulus == 0 {
+			return relabelRule{}, errors.New("modulus is required for the hashmod action")
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop:
+	default:
+		return relabelRule{}, fmt.Errorf("unknown action %q", rule.action)
+	}
+	return rule, nil
+}
+
+// apply returns the metric name and labels of a point after relabeling, or
+// false if the point is dropped. The labels passed in are not modified.
+func (r *relabeler) apply(name string, ls labels) (string, labels, bool) {
+	result := make(labels, len(ls)+1)
+	for k, v := range ls {
+		result[k] = v
+	}
+	result[metricNameLabel] = name
+	for _, rule := range r.rules {
+		if !rule.apply(result) {
+			return "", nil, false
+		}
+	}
+	name = result[metricNameLabel]
+	delete(result, metricNameLabel)
+	if name == "" {
+		// There is nothing to write the point to.
+		return "", nil, false
+	}
+	return name, result, true
+}
+
+// apply modifies the labels according to the rule, and returns false if the
+// point is dropped.
+func (r relabelRule) apply(ls labels) bool {
+	values := make([]string, len(r.sourceLabels))
+	for i, key := range r.sourceLabels {
+		values[i] = ls[key]
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.action {
+	case RelabelReplace:
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		replacement := string(r.regex.ExpandString(nil, r.replacement, value, match))
+		if replacement == "" {
+			delete(ls, r.targetLabel)
+		} else {
+			ls[r.targetLabel] = replacement
+		}
+	case RelabelKeep:
+		return r.regex.MatchString(value)
+	case RelabelDrop:
+		return !r.regex.MatchString(value)
+	case RelabelHashMod:
+		sum := md5.Sum([]byte(value))
+		ls[r.targetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.modulus, 10)
+	case RelabelLabelMap:
+		mapped := labels{}
+		for key, v := range ls {
+			if !r.regex.MatchString(key) {
+				continue
+			}
+			if mappedKey := sanitizeKey(r.regex.ReplaceAllString(key, r.replacement)); mappedKey != "" {
+				mapped[mappedKey] = v
+			}
+		}
+		mergeLabels(ls, mapped)
+	case RelabelLabelDrop:
+		for key := range ls {
+			if key != metricNameLabel && r.regex.MatchString(key) {
+				delete(ls, key)
+			}
+		}
+	}
+	return true
+}
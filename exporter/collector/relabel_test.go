@@ -0,0 +1,288 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"google.golang.org/genproto/googleapis/api/label"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRelabeler(t *testing.T) {
+	for _, tc := range []struct {
+		desc           string
+		rules          []RelabelConfig
+		name           string
+		input          labels
+		expectedName   string
+		expectedLabels labels
+		expectedDrop   bool
+	}{
+		{
+			desc: "Rename metric",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"__name__"},
+				Regex:        "vendor_(.*)",
+				TargetLabel:  "__name__",
+				Replacement:  replacement("acme.$1"),
+			}},
+			name:           "vendor_requests",
+			input:          labels{"foo": "bar"},
+			expectedName:   "acme.requests",
+			expectedLabels: labels{"foo": "bar"},
+		},
+		{
+			desc: "Replace joins source labels",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"host", "port"},
+				Separator:    ":",
+				TargetLabel:  "address",
+			}},
+			name:           "m",
+			input:          labels{"host": "localhost", "port": "80"},
+			expectedName:   "m",
+			expectedLabels: labels{"host": "localhost", "port": "80", "address": "localhost:80"},
+		},
+		{
+			desc: "Source labels are sanitized",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"http.method"},
+				TargetLabel:  "method",
+			}},
+			name:           "m",
+			input:          labels{"http_method": "GET"},
+			expectedName:   "m",
+			expectedLabels: labels{"http_method": "GET", "method": "GET"},
+		},
+		{
+			desc: "Empty replacement removes the target label",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"foo"},
+				TargetLabel:  "foo",
+				Replacement:  replacement(""),
+			}},
+			name:           "m",
+			input:          labels{"foo": "bar", "baz": "qux"},
+			expectedName:   "m",
+			expectedLabels: labels{"baz": "qux"},
+		},
+		{
+			desc: "Replace doesn't change non-matching labels",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"foo"},
+				Regex:        "baz",
+				TargetLabel:  "foo",
+				Replacement:  replacement("qux"),
+			}},
+			name:           "m",
+			input:          labels{"foo": "bar"},
+			expectedName:   "m",
+			expectedLabels: labels{"foo": "bar"},
+		},
+		{
+			desc: "Keep",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"env"},
+				Regex:        "prod",
+				Action:       RelabelKeep,
+			}},
+			name:         "m",
+			input:        labels{"env": "dev"},
+			expectedDrop: true,
+		},
+		{
+			desc: "Drop",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"__name__"},
+				Regex:        "debug_.*",
+				Action:       RelabelDrop,
+			}},
+			name:         "debug_metric",
+			input:        labels{},
+			expectedDrop: true,
+		},
+		{
+			desc: "Hashmod",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"id"},
+				Modulus:      1,
+				TargetLabel:  "shard",
+				Action:       RelabelHashMod,
+			}},
+			name:           "m",
+			input:          labels{"id": "123"},
+			expectedName:   "m",
+			expectedLabels: labels{"id": "123", "shard": "0"},
+		},
+		{
+			desc: "Labelmap",
+			rules: []RelabelConfig{{
+				Regex:       "vendor_(.*)",
+				Replacement: replacement("$1"),
+				Action:      RelabelLabelMap,
+			}},
+			name:           "m",
+			input:          labels{"vendor_zone": "a"},
+			expectedName:   "m",
+			expectedLabels: labels{"vendor_zone": "a", "zone": "a"},
+		},
+		{
+			desc: "Target label is sanitized",
+			rules: []RelabelConfig{{
+				SourceLabels: []string{"method"},
+				TargetLabel:  "http.method",
+			}},
+			name:           "m",
+			input:          labels{"method": "GET"},
+			expectedName:   "m",
+			expectedLabels: labels{"method": "GET", "http_method": "GET"},
+		},
+		{
+			desc: "Labelmap keys are sanitized",
+			rules: []RelabelConfig{{
+				Regex:       "vendor_(.*)",
+				Replacement: replacement("vendor.$1"),
+				Action:      RelabelLabelMap,
+			}},
+			name:           "m",
+			input:          labels{"vendor_zone": "a"},
+			expectedName:   "m",
+			expectedLabels: labels{"vendor_zone": "a"},
+		},
+		{
+			desc: "Labeldrop",
+			rules: []RelabelConfig{{
+				Regex:  "vendor_.*|__name__",
+				Action: RelabelLabelDrop,
+			}},
+			name:           "m",
+			input:          labels{"vendor_zone": "a", "zone": "a"},
+			expectedName:   "m",
+			expectedLabels: labels{"zone": "a"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			r, err := newRelabeler(tc.rules)
+			require.NoError(t, err)
+			name, ls, ok := r.apply(tc.name, tc.input)
+			assert.Equal(t, !tc.expectedDrop, ok)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedLabels, ls)
+		})
+	}
+}
+
+func TestNewRelabelerErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		rule RelabelConfig
+	}{
+		{desc: "Invalid regex", rule: RelabelConfig{Regex: "(", TargetLabel: "foo"}},
+		{desc: "Unknown action", rule: RelabelConfig{Action: "labelkeep"}},
+		{desc: "Replace without target", rule: RelabelConfig{Action: RelabelReplace}},
+		{desc: "Hashmod without modulus", rule: RelabelConfig{Action: RelabelHashMod, TargetLabel: "foo"}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := newRelabeler([]RelabelConfig{tc.rule})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRelabeledMetricDescriptor(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	var err error
+	mapper.relabeler, err = newRelabeler([]RelabelConfig{{
+		SourceLabels: []string{"kind"},
+		TargetLabel:  "__name__",
+		Replacement:  replacement("requests_$1"),
+	}, {
+		Regex:  "kind",
+		Action: RelabelLabelDrop,
+	}})
+	require.NoError(t, err)
+
+	metric := pmetric.NewMetric()
+	metric.SetName("requests")
+	metric.SetDataType(pmetric.MetricDataTypeGauge)
+	for _, kind := range []string{"a", "b"} {
+		point := metric.Gauge().DataPoints().AppendEmpty()
+		point.SetIntVal(1)
+		point.Attributes().InsertString("kind", kind)
+		point.Attributes().InsertString("zone", "z")
+	}
+
+	mds := mapper.metricDescriptor(metric, mapper.relabelMetric(metric, labels{}))
+	require.Len(t, mds, 2)
+	for i, name := range []string{"requests_a", "requests_b"} {
+		assert.Equal(t, "workload.googleapis.com/"+name, mds[i].Type)
+		assert.Equal(t, []*label.LabelDescriptor{{Key: "zone"}}, mds[i].Labels)
+	}
+
+	point := metric.Gauge().DataPoints().At(1)
+	tss := mapper.gaugePointToTimeSeries(nil, labels{}, metric, metric.Gauge(), point, mapper.pointLabels(metric, point.Attributes(), labels{}))
+	require.Len(t, tss, 1)
+	assert.Equal(t, "workload.googleapis.com/requests_b", tss[0].Metric.Type)
+	assert.Equal(t, map[string]string{"zone": "z"}, tss[0].Metric.Labels)
+}
+
+func TestRelabeledPointsNormalizedTogether(t *testing.T) {
+	mapper, shutdown := newTestMetricMapper()
+	defer shutdown()
+	var err error
+	mapper.relabeler, err = newRelabeler([]RelabelConfig{{
+		SourceLabels: []string{"__name__"},
+		Regex:        "requests_v[0-9]+",
+		TargetLabel:  "__name__",
+		Replacement:  replacement("requests"),
+	}})
+	require.NoError(t, err)
+	newMetric := func(name string, end time.Time, value int64) pmetric.Metric {
+		metric := pmetric.NewMetric()
+		metric.SetName(name)
+		metric.SetDataType(pmetric.MetricDataTypeSum)
+		metric.Sum().SetIsMonotonic(true)
+		metric.Sum().SetAggregationTemporality(pmetric.MetricAggregationTemporalityCumulative)
+		point := metric.Sum().DataPoints().AppendEmpty()
+		point.SetTimestamp(pcommon.NewTimestampFromTime(end))
+		point.SetIntVal(value)
+		return metric
+	}
+	mr := &monitoredrespb.MonitoredResource{}
+
+	// The first point has no start time, so it is the reference point.
+	metric := newMetric("requests_v1", start, 5)
+	assert.Empty(t, mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, "myproject", mapper.relabelMetric(metric, labels{})))
+	// Points of a metric relabeled to the same name are subtracted from it.
+	metric = newMetric("requests_v2", start.Add(time.Minute), 8)
+	tss := mapper.metricToTimeSeries(context.Background(), mr, labels{}, metric, "myproject", mapper.relabelMetric(metric, labels{}))
+	require.Len(t, tss, 1)
+	assert.Equal(t, "workload.googleapis.com/requests", tss[0].Metric.Type)
+	assert.Equal(t, timestamppb.New(start), tss[0].Points[0].Interval.StartTime)
+	assert.Equal(t, int64(3), tss[0].Points[0].Value.GetInt64Value())
+}
+
+func replacement(s string) *string {
+	return &s
+}
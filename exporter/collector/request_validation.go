@@ -0,0 +1,192 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	tracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v2"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits enforced by Cloud Trace, see
+// https://cloud.google.com/trace/docs/quotas. The limits of Cloud Monitoring
+// and Cloud Logging are shared with the exporters.
+const (
+	maxSpanDisplayNameLen = 128
+	maxSpanAttributes     = 32
+)
+
+var (
+	projectNameRegex = regexp.MustCompile(`^projects/[^/]+$`)
+	labelKeyRegex    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	logNameRegex     = regexp.MustCompile(`^projects/[^/]+/logs/[^/]+$`)
+	spanNameRegex    = regexp.MustCompile(`^projects/[^/]+/traces/[0-9a-f]{32}/spans/[0-9a-f]{16}$`)
+)
+
+// validateRequest returns an INVALID_ARGUMENT error if the request would be
+// rejected by the Google Cloud APIs. Only the most common causes of
+// rejection are checked.
+func validateRequest(req proto.Message) error {
+	switch req := req.(type) {
+	case *monitoringpb.CreateTimeSeriesRequest:
+		return validateCreateTimeSeriesRequest(req)
+	case *monitoringpb.CreateMetricDescriptorRequest:
+		return validateCreateMetricDescriptorRequest(req)
+	case *logpb.WriteLogEntriesRequest:
+		return validateWriteLogEntriesRequest(req)
+	case *tracepb.BatchWriteSpansRequest:
+		return validateBatchWriteSpansRequest(req)
+	}
+	return nil
+}
+
+func validateCreateTimeSeriesRequest(req *monitoringpb.CreateTimeSeriesRequest) error {
+	if !projectNameRegex.MatchString(req.Name) {
+		return status.Errorf(codes.InvalidArgument, "invalid project name %q", req.Name)
+	}
+	if len(req.TimeSeries) == 0 || len(req.TimeSeries) > sendBatchSize {
+		return status.Errorf(codes.InvalidArgument, "request must contain between 1 and %d time series, got %d", sendBatchSize, len(req.TimeSeries))
+	}
+	seen := make(map[string]struct{}, len(req.TimeSeries))
+	for i, ts := range req.TimeSeries {
+		if ts.GetMetric().GetType() == "" {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: metric type is required", i)
+		}
+		if ts.GetResource().GetType() == "" {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: monitored resource type is required", i)
+		}
+		if err := validateLabels(ts.Metric.Labels); err != nil {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: %v", i, err)
+		}
+		if len(ts.Points) != 1 {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: time series must contain exactly one point, got %d", i, len(ts.Points))
+		}
+		interval := ts.Points[0].GetInterval()
+		if interval.GetEndTime() == nil {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: end time is required", i)
+		}
+		start, end := interval.GetStartTime().AsTime(), interval.GetEndTime().AsTime()
+		switch ts.MetricKind {
+		case metricpb.MetricDescriptor_CUMULATIVE, metricpb.MetricDescriptor_DELTA:
+			if interval.GetStartTime() == nil || !start.Before(end) {
+				return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: start time must be before the end time of %v points", i, ts.MetricKind)
+			}
+		case metricpb.MetricDescriptor_GAUGE:
+			if interval.GetStartTime() != nil && !start.Equal(end) {
+				return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: start time must equal the end time of GAUGE points", i)
+			}
+		}
+		key := timeSeriesKey(ts)
+		if _, ok := seen[key]; ok {
+			return status.Errorf(codes.InvalidArgument, "timeSeries[%d]: duplicate time series in request", i)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+func validateCreateMetricDescriptorRequest(req *monitoringpb.CreateMetricDescriptorRequest) error {
+	if !projectNameRegex.MatchString(req.Name) {
+		return status.Errorf(codes.InvalidArgument, "invalid project name %q", req.Name)
+	}
+	md := req.GetMetricDescriptor()
+	if md.GetType() == "" {
+		return status.Error(codes.InvalidArgument, "metric descriptor type is required")
+	}
+	if md.GetMetricKind() == metricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED {
+		return status.Errorf(codes.InvalidArgument, "metric descriptor %q: metric kind is required", md.Type)
+	}
+	if md.GetValueType() == metricpb.MetricDescriptor_VALUE_TYPE_UNSPECIFIED {
+		return status.Errorf(codes.InvalidArgument, "metric descriptor %q: value type is required", md.Type)
+	}
+	if len(md.Labels) > defaultMaxLabels {
+		return status.Errorf(codes.InvalidArgument, "metric descriptor %q: at most %d labels are allowed, got %d", md.Type, defaultMaxLabels, len(md.Labels))
+	}
+	for _, label := range md.Labels {
+		if !labelKeyRegex.MatchString(label.Key) || len(label.Key) > defaultMaxLabelKeyLength {
+			return status.Errorf(codes.InvalidArgument, "metric descriptor %q: invalid label key %q", md.Type, label.Key)
+		}
+	}
+	return nil
+}
+
+func validateWriteLogEntriesRequest(req *logpb.WriteLogEntriesRequest) error {
+	if len(req.Entries) == 0 {
+		return status.Error(codes.InvalidArgument, "request must contain at least one log entry")
+	}
+	if size := proto.Size(req); size > defaultMaxRequestSize {
+		return status.Errorf(codes.InvalidArgument, "request size %d exceeds the limit of %d bytes", size, defaultMaxRequestSize)
+	}
+	for i, entry := range req.Entries {
+		logName := entry.LogName
+		if logName == "" {
+			logName = req.LogName
+		}
+		if !logNameRegex.MatchString(logName) {
+			return status.Errorf(codes.InvalidArgument, "entries[%d]: invalid log name %q", i, logName)
+		}
+		if entry.Resource == nil && req.Resource == nil {
+			return status.Errorf(codes.InvalidArgument, "entries[%d]: monitored resource is required", i)
+		}
+		if size := proto.Size(entry); size > defaultMaxEntrySize {
+			return status.Errorf(codes.InvalidArgument, "entries[%d]: log entry size %d exceeds the limit of %d bytes", i, size, defaultMaxEntrySize)
+		}
+	}
+	return nil
+}
+
+func validateBatchWriteSpansRequest(req *tracepb.BatchWriteSpansRequest) error {
+	if !projectNameRegex.MatchString(req.Name) {
+		return status.Errorf(codes.InvalidArgument, "invalid project name %q", req.Name)
+	}
+	for i, span := range req.Spans {
+		if !spanNameRegex.MatchString(span.Name) || !strings.HasPrefix(span.Name, req.Name+"/") {
+			return status.Errorf(codes.InvalidArgument, "spans[%d]: invalid span name %q", i, span.Name)
+		}
+		if len(span.GetDisplayName().GetValue()) > maxSpanDisplayNameLen {
+			return status.Errorf(codes.InvalidArgument, "spans[%d]: display name exceeds %d bytes", i, maxSpanDisplayNameLen)
+		}
+		if span.StartTime == nil || span.EndTime == nil {
+			return status.Errorf(codes.InvalidArgument, "spans[%d]: start and end time are required", i)
+		}
+		if len(span.GetAttributes().GetAttributeMap()) > maxSpanAttributes {
+			return status.Errorf(codes.InvalidArgument, "spans[%d]: at most %d attributes are allowed", i, maxSpanAttributes)
+		}
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	if len(labels) > defaultMaxLabels {
+		return fmt.Errorf("at most %d labels are allowed, got %d", defaultMaxLabels, len(labels))
+	}
+	for k, v := range labels {
+		if !labelKeyRegex.MatchString(k) || len(k) > defaultMaxLabelKeyLength {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if len(v) > defaultMaxLabelValueLen {
+			return fmt.Errorf("value of label %q exceeds %d bytes", k, defaultMaxLabelValueLen)
+		}
+	}
+	return nil
+}
@@ -28,16 +28,23 @@ import (
 	apitrace "go.opentelemetry.io/otel/trace"
 )
 
-func pdataResourceSpansToOTSpanData(rs ptrace.ResourceSpans) []sdktrace.ReadOnlySpan {
+// pdataResourceSpansToOTSpanData returns the spans grouped by the project
+// they are routed to. Spans which are dropped are removed.
+func pdataResourceSpansToOTSpanData(rs ptrace.ResourceSpans, router *projectRouter) map[string][]sdktrace.ReadOnlySpan {
 	resource := rs.Resource()
-	var sds []sdktrace.ReadOnlySpan
+	sds := make(map[string][]sdktrace.ReadOnlySpan)
 	ss := rs.ScopeSpans()
 	for i := 0; i < ss.Len(); i++ {
 		s := ss.At(i)
 		spans := s.Spans()
 		for j := 0; j < spans.Len(); j++ {
-			sd := pdataSpanToOTSpanData(spans.At(j), resource, s.Scope())
-			sds = append(sds, sd)
+			span := spans.At(j)
+			projectID, ok := router.route(resource.Attributes(), span.Attributes())
+			if !ok {
+				continue
+			}
+			sd := pdataSpanToOTSpanData(span, resource, s.Scope())
+			sds[projectID] = append(sds[projectID], sd)
 		}
 	}
 
@@ -83,7 +83,7 @@ func TestPDataResourceSpansToOTSpanData_endToEnd(t *testing.T) {
 	span.Attributes().InsertInt("ping_count", 25)
 	span.Attributes().InsertString("agent", "ocagent")
 
-	gotOTSpanData := pdataResourceSpansToOTSpanData(rs)
+	gotOTSpanData := pdataResourceSpansToOTSpanData(rs, newProjectRouter(Config{ProjectID: "my-project"}))["my-project"]
 
 	wantOTSpanData := &spanSnapshot{
 		spanContext: apitrace.NewSpanContext(apitrace.SpanContextConfig{
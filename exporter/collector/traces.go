@@ -25,16 +25,17 @@ import (
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	traceapi "cloud.google.com/go/trace/apiv2"
-	"go.opencensus.io/plugin/ocgrpc"
-	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.opentelemetry.io/otel/attribute"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	cloudtrace "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
 )
@@ -42,6 +43,8 @@ import (
 // TraceExporter is a wrapper struct of OT cloud trace exporter
 type TraceExporter struct {
 	texporter *cloudtrace.Exporter
+	obs       selfObservability
+	router    *projectRouter
 }
 
 func (te *TraceExporter) Shutdown(ctx context.Context) error {
@@ -66,31 +69,50 @@ func setProjectFromADC(ctx context.Context, cfg *Config, scopes []string) error
 	return nil
 }
 
-func generateClientOptions(ctx context.Context, cfg *ClientConfig, userAgent string, impersonateConfig ImpersonateConfig) ([]option.ClientOption, error) {
+func generateClientOptions(ctx context.Context, cfg *ClientConfig, userAgent string, impersonateConfig ImpersonateConfig, obs selfObservability) ([]option.ClientOption, error) {
 	var copts []option.ClientOption
+	// RPC metrics are recorded on every connection the clients use.
+	dialOpts := []grpc.DialOption{grpc.WithChainUnaryInterceptor(obs.unaryClientInterceptor)}
+	if cfg.Transport == ClientTransportFile {
+		// Requests are written to files by an interceptor, so the connection
+		// is never established and no credentials are needed.
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(newFileTransport(cfg.FilePath).unaryClientInterceptor),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		conn, err := grpc.Dial(fileTransportTarget, dialOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure file transport: %w", err)
+		}
+		copts = append(copts, option.WithGRPCConn(conn))
+		if cfg.GetClientOptions != nil {
+			copts = append(copts, cfg.GetClientOptions()...)
+		}
+		return copts, nil
+	}
 	// option.WithUserAgent is used by the Trace exporter, but not the Metric exporter (see comment below)
 	if userAgent != "" {
 		copts = append(copts, option.WithUserAgent(userAgent))
 	}
-	if cfg.Endpoint != "" {
-		if cfg.UseInsecure {
-			// option.WithGRPCConn option takes precedent over all other supplied options so the
-			// following user agent will be used by both exporters if we reach this branch
-			dialOpts := []grpc.DialOption{
-				grpc.WithStatsHandler(&ocgrpc.ClientHandler{}),
-				grpc.WithTransportCredentials(insecure.NewCredentials()),
-			}
-			if userAgent != "" {
-				dialOpts = append(dialOpts, grpc.WithUserAgent(userAgent))
-			}
-			conn, err := grpc.Dial(cfg.Endpoint, dialOpts...)
-			if err != nil {
-				return nil, fmt.Errorf("cannot configure grpc conn: %w", err)
-			}
-			copts = append(copts, option.WithGRPCConn(conn))
-		} else {
+	if cfg.Endpoint != "" && cfg.UseInsecure {
+		// option.WithGRPCConn option takes precedent over all other supplied options so the
+		// following user agent will be used by both exporters if we reach this branch
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if userAgent != "" {
+			dialOpts = append(dialOpts, grpc.WithUserAgent(userAgent))
+		}
+		conn, err := grpc.Dial(cfg.Endpoint, dialOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure grpc conn: %w", err)
+		}
+		copts = append(copts, option.WithGRPCConn(conn))
+	} else {
+		if cfg.Endpoint != "" {
 			copts = append(copts, option.WithEndpoint(cfg.Endpoint))
 		}
+		for _, opt := range dialOpts {
+			copts = append(copts, option.WithGRPCDialOption(opt))
+		}
 	}
 	if impersonateConfig.TargetPrincipal != "" {
 		tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
@@ -114,10 +136,14 @@ func generateClientOptions(ctx context.Context, cfg *ClientConfig, userAgent str
 }
 
 func NewGoogleCloudTracesExporter(ctx context.Context, cfg Config, version string, timeout time.Duration) (*TraceExporter, error) {
-	view.Register(ocgrpc.DefaultClientViews...)
 	setVersionInUserAgent(&cfg, version)
 	setProjectFromADC(ctx, &cfg, traceapi.DefaultAuthScopes())
 
+	obs, err := newSelfObservability(zap.NewNop(), cfg.MeterProvider)
+	if err != nil {
+		return nil, err
+	}
+
 	topts := []cloudtrace.Option{
 		cloudtrace.WithProjectID(cfg.ProjectID),
 		cloudtrace.WithTimeout(timeout),
@@ -126,7 +152,7 @@ func NewGoogleCloudTracesExporter(ctx context.Context, cfg Config, version strin
 		topts = append(topts, cloudtrace.WithAttributeMapping(mappingFuncFromAKM(cfg.TraceConfig.AttributeMappings)))
 	}
 
-	copts, err := generateClientOptions(ctx, &cfg.TraceConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig)
+	copts, err := generateClientOptions(ctx, &cfg.TraceConfig.ClientConfig, cfg.UserAgent, cfg.ImpersonateConfig, obs)
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +163,7 @@ func NewGoogleCloudTracesExporter(ctx context.Context, cfg Config, version strin
 		return nil, fmt.Errorf("error creating GoogleCloud Trace exporter: %w", err)
 	}
 
-	return &TraceExporter{texporter: exp}, nil
+	return &TraceExporter{texporter: exp, obs: obs, router: newProjectRouter(cfg)}, nil
 }
 
 func mappingFuncFromAKM(akm []AttributeMapping) func(attribute.Key) attribute.Key {
@@ -156,14 +182,22 @@ func mappingFuncFromAKM(akm []AttributeMapping) func(attribute.Key) attribute.Ke
 	}
 }
 
-// PushTraces calls texporter.ExportSpan for each span in the given traces
+// PushTraces calls texporter.ExportSpansToProject for the spans routed to
+// each project.
 func (te *TraceExporter) PushTraces(ctx context.Context, td ptrace.Traces) error {
 	resourceSpans := td.ResourceSpans()
-	spans := make([]sdktrace.ReadOnlySpan, 0, td.SpanCount())
+	spansByProject := make(map[string][]sdktrace.ReadOnlySpan)
 	for i := 0; i < resourceSpans.Len(); i++ {
-		sd := pdataResourceSpansToOTSpanData(resourceSpans.At(i))
-		spans = append(spans, sd...)
+		for projectID, sd := range pdataResourceSpansToOTSpanData(resourceSpans.At(i), te.router) {
+			spansByProject[projectID] = append(spansByProject[projectID], sd...)
+		}
 	}
 
-	return te.texporter.ExportSpans(ctx, spans)
+	var errs error
+	for projectID, spans := range spansByProject {
+		err := te.texporter.ExportSpansToProject(ctx, projectID, spans)
+		te.obs.recordSpans(ctx, len(spans), statusCodeToString(status.Convert(err)))
+		errs = multierr.Append(errs, err)
+	}
+	return errs
 }
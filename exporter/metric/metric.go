@@ -324,11 +324,16 @@ func (me *metricExporter) descToMetricType(desc *sdkapi.Descriptor) string {
 	return fmt.Sprintf(cloudMonitoringMetricDescriptorNameFormat, desc.Name())
 }
 
+// descToUnit translates the UCUM unit of the instrument into a unit
+// supported by Cloud Monitoring.
+func descToUnit(desc *sdkapi.Descriptor) string {
+	return translateUnit(string(desc.Unit()))
+}
+
 // recordToMdpb extracts data and converts them to googlemetricpb.MetricDescriptor.
 func (me *metricExporter) recordToMdpb(record *export.Record) *googlemetricpb.MetricDescriptor {
 	desc := record.Descriptor()
 	name := desc.Name()
-	unit := record.Descriptor().Unit()
 	kind, typ := recordToMdpbKindType(record)
 
 	// Detailed explanations on MetricDescriptor proto is not documented on
@@ -339,7 +344,7 @@ func (me *metricExporter) recordToMdpb(record *export.Record) *googlemetricpb.Me
 		Type:        me.descToMetricType(desc),
 		MetricKind:  kind,
 		ValueType:   typ,
-		Unit:        string(unit),
+		Unit:        descToUnit(desc),
 		Description: desc.Description(),
 	}
 }
@@ -634,7 +639,7 @@ func histogramToTypedValueAndTimestamp(hist aggregation.Histogram, r *export.Rec
 	}
 	return &monitoringpb.TimeSeries{
 		Resource:   mr,
-		Unit:       string(r.Descriptor().Unit()),
+		Unit:       descToUnit(r.Descriptor()),
 		MetricKind: googlemetricpb.MetricDescriptor_CUMULATIVE,
 		ValueType:  googlemetricpb.MetricDescriptor_DISTRIBUTION,
 		Points:     []*monitoringpb.Point{p},
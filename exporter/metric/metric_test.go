@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/unit"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
 	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	"go.opentelemetry.io/otel/sdk/metric/export"
@@ -191,6 +192,20 @@ func TestDescToMetricType(t *testing.T) {
 	}
 }
 
+func TestDescToUnit(t *testing.T) {
+	for u, want := range map[unit.Unit]string{
+		"":           "",
+		"By/s":       "By/s",
+		"{requests}": "{requests}",
+		"Cel":        "{Cel}",
+	} {
+		desc := sdkapi.NewDescriptor("testing", sdkapi.HistogramInstrumentKind, number.Float64Kind, "", u)
+		if out := descToUnit(&desc); out != want {
+			t.Errorf("descToUnit(%q) = %q, want %q", u, out, want)
+		}
+	}
+}
+
 func TestRecordToMpb(t *testing.T) {
 	ctx := context.Background()
 	cloudMock := cloudmock.NewCloudMock()
@@ -0,0 +1,187 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"strings"
+)
+
+// unitNames are the basic units of Cloud Monitoring. They share their names
+// with UCUM.
+var unitNames = map[string]struct{}{
+	"bit": {},
+	"By":  {},
+	"s":   {},
+	"min": {},
+	"h":   {},
+	"d":   {},
+}
+
+// unitPrefixes are the prefixes of Cloud Monitoring. The decimal prefixes
+// share their names with UCUM, and the binary prefixes are written the same
+// way in UCUM, e.g. KiBy.
+var unitPrefixes = []string{
+	// Binary prefixes come first, as Ki would otherwise not match.
+	"Ki", "Mi", "Gi", "Ti", "Pi",
+	"k", "M", "G", "T", "P", "E", "Z", "Y",
+	"m", "u", "n", "p", "f", "a", "z", "y",
+}
+
+// translateUnit returns the Cloud Monitoring unit of a UCUM unit. Units made
+// of supported units, prefixes and annotations are kept, with annotations
+// cleaned of characters Cloud Monitoring doesn't accept. Other units, such as
+// Cel, or units with exponents or factors, are translated into an
+// annotation, e.g. {Cel}, which Cloud Monitoring shows as is.
+//
+// It is a copy of the translation of the collector exporter, since this
+// module can't import the repository's internal packages.
+func translateUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if unit == "" {
+		return ""
+	}
+	if translated, ok := translateUnitExpression(unit); ok {
+		return translated
+	}
+	return "{" + annotationName(unit) + "}"
+}
+
+// translateUnitExpression translates an expression of components separated
+// by "." for multiplication and "/" for division. Cloud Monitoring only
+// allows multiplications before the first division, which is also how UCUM
+// evaluates them.
+func translateUnitExpression(unit string) (string, bool) {
+	var b strings.Builder
+	divided := false
+	for len(unit) > 0 {
+		end := componentEnd(unit)
+		if end < 0 {
+			return "", false
+		}
+		component := unit[:end]
+		if component == "" {
+			// UCUM allows a leading division, e.g. /s, which is 1/s in
+			// Cloud Monitoring.
+			if b.Len() > 0 || end == len(unit) || unit[end] != '/' {
+				return "", false
+			}
+			component = "1"
+		}
+		translated, ok := translateComponent(component)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(translated)
+		if end == len(unit) {
+			break
+		}
+		op := unit[end]
+		if op == '.' && divided {
+			return "", false
+		}
+		divided = divided || op == '/'
+		b.WriteByte(op)
+		unit = unit[end+1:]
+		if unit == "" {
+			// A trailing operator.
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// componentEnd returns the index of the operator following the first
+// component of unit, or len(unit) if it is the last component. It returns -1
+// if an annotation isn't closed.
+func componentEnd(unit string) int {
+	inAnnotation := false
+	for i := 0; i < len(unit); i++ {
+		switch c := unit[i]; {
+		case c == '{':
+			if inAnnotation {
+				return -1
+			}
+			inAnnotation = true
+		case c == '}':
+			if !inAnnotation {
+				return -1
+			}
+			inAnnotation = false
+		case (c == '.' || c == '/') && !inAnnotation:
+			return i
+		}
+	}
+	if inAnnotation {
+		return -1
+	}
+	return len(unit)
+}
+
+// translateComponent translates a unit with an optional prefix and
+// annotation, e.g. KiBy{transmitted}, or an annotation alone, e.g. {request}.
+func translateComponent(component string) (string, bool) {
+	unit, annotation := component, ""
+	if i := strings.IndexByte(component, '{'); i >= 0 {
+		// componentEnd ensures the annotation is closed, but it must also
+		// end the component.
+		if !strings.HasSuffix(component, "}") || strings.Count(component, "{") > 1 {
+			return "", false
+		}
+		unit, annotation = component[:i], "{"+annotationName(component[i+1:len(component)-1])+"}"
+	}
+	switch {
+	case unit == "":
+		if annotation == "{}" {
+			return "1", true
+		}
+		return annotation, true
+	case unit == "1" || unit == "%":
+		return unit + annotation, true
+	case isUnit(unit):
+		return unit + annotation, true
+	}
+	return "", false
+}
+
+func isUnit(unit string) bool {
+	if _, ok := unitNames[unit]; ok {
+		return true
+	}
+	for _, prefix := range unitPrefixes {
+		if !strings.HasPrefix(unit, prefix) {
+			continue
+		}
+		if _, ok := unitNames[unit[len(prefix):]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// annotationName replaces the characters Cloud Monitoring doesn't accept in
+// annotations, which must be printable ASCII without blanks or braces.
+func annotationName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '{' || r == '}':
+		case r <= ' ' || r > '~':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -189,6 +189,13 @@ func (e *Exporter) ExportSpans(ctx context.Context, spanData []sdktrace.ReadOnly
 	return e.traceExporter.ExportSpans(ctx, spanData)
 }
 
+// ExportSpansToProject exports ReadOnlySpans to Stackdriver Trace in the
+// given project, regardless of the gcp.project.id attribute of their
+// resources.
+func (e *Exporter) ExportSpansToProject(ctx context.Context, projectID string, spanData []sdktrace.ReadOnlySpan) error {
+	return e.traceExporter.exportSpans(ctx, projectID, spanData)
+}
+
 // Shutdown waits for exported data to be uploaded.
 //
 // For our purposes it closed down the client.
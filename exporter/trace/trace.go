@@ -55,10 +55,16 @@ func newTraceExporter(o *options) (*traceExporter, error) {
 }
 
 func (e *traceExporter) ExportSpans(ctx context.Context, spanData []sdktrace.ReadOnlySpan) error {
+	return e.exportSpans(ctx, "", spanData)
+}
+
+// exportSpans uploads the spans to the project, or to the project of each
+// span if projectID is empty.
+func (e *traceExporter) exportSpans(ctx context.Context, projectID string, spanData []sdktrace.ReadOnlySpan) error {
 	// Ship the whole bundle o data.
 	results := make(map[string][]*tracepb.Span)
 	for _, sd := range spanData {
-		span, project := e.protoFromReadOnlySpan(sd)
+		span, project := e.protoFromReadOnlySpan(sd, projectID)
 		results[project] = append(results[project], span)
 	}
 	var errs []error
@@ -80,7 +86,7 @@ func (e *traceExporter) ExportSpans(ctx context.Context, spanData []sdktrace.Rea
 
 // ConvertSpan converts a ReadOnlySpan to Stackdriver Trace.
 func (e *traceExporter) ConvertSpan(_ context.Context, sd sdktrace.ReadOnlySpan) *tracepb.Span {
-	span, _ := e.protoFromReadOnlySpan(sd)
+	span, _ := e.protoFromReadOnlySpan(sd, "")
 	return span
 }
 
@@ -118,7 +118,7 @@ func attributeWithLabelsFromResources(sd sdktrace.ReadOnlySpan) []attribute.KeyV
 	}
 
 	// Monitored resource attributes (`g.co/r/{resource_type}/{resource_label}`) come next.
-	gceResource := resourcemapping.ResourceAttributesToMonitoredResource(&attrs{
+	gceResource := resourcemapping.ResourceAttributesToLoggingMonitoredResource(&attrs{
 		Attrs: sd.Resource().Attributes(),
 	})
 	for key, value := range gceResource.Labels {
@@ -128,20 +128,25 @@ func attributeWithLabelsFromResources(sd sdktrace.ReadOnlySpan) []attribute.KeyV
 	return attributes
 }
 
-func (e *traceExporter) protoFromReadOnlySpan(s sdktrace.ReadOnlySpan) (*tracepb.Span, string) {
+// protoFromReadOnlySpan converts the span, and returns the project it is
+// written to. If projectID is empty, it is the span's gcp.project.id resource
+// attribute, or the exporter's project.
+func (e *traceExporter) protoFromReadOnlySpan(s sdktrace.ReadOnlySpan, projectID string) (*tracepb.Span, string) {
 	if s == nil {
 		return nil, ""
 	}
 
 	traceIDString := s.SpanContext().TraceID().String()
 	spanIDString := s.SpanContext().SpanID().String()
-	projectID := e.projectID
-	// override project ID with gcp.project.id, if present
-	attrs := s.Resource().Attributes()
-	for _, attr := range attrs {
-		if attr.Key == resourcemapping.ProjectIDAttributeKey {
-			projectID = attr.Value.AsString()
-			break
+	if projectID == "" {
+		projectID = e.projectID
+		// override project ID with gcp.project.id, if present
+		attrs := s.Resource().Attributes()
+		for _, attr := range attrs {
+			if attr.Key == resourcemapping.ProjectIDAttributeKey {
+				projectID = attr.Value.AsString()
+				break
+			}
 		}
 	}
 
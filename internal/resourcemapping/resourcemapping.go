@@ -23,106 +23,210 @@ const (
 
 	awsAccount     = "aws_account"
 	awsEc2Instance = "aws_ec2_instance"
+	cloudFunction  = "cloud_function"
+	cloudRunJob    = "cloud_run_job"
+	cloudRunRev    = "cloud_run_revision"
 	clusterName    = "cluster_name"
+	configName     = "configuration_name"
 	containerName  = "container_name"
+	functionName   = "function_name"
+	gaeApp         = "gae_app"
 	gceInstance    = "gce_instance"
 	genericNode    = "generic_node"
 	genericTask    = "generic_task"
 	instanceID     = "instance_id"
 	job            = "job"
+	jobName        = "job_name"
 	k8sCluster     = "k8s_cluster"
 	k8sContainer   = "k8s_container"
 	k8sNode        = "k8s_node"
 	k8sPod         = "k8s_pod"
 	location       = "location"
+	moduleID       = "module_id"
 	namespace      = "namespace"
 	namespaceName  = "namespace_name"
 	nodeID         = "node_id"
 	nodeName       = "node_name"
 	podName        = "pod_name"
 	region         = "region"
+	revisionName   = "revision_name"
+	serviceName    = "service_name"
 	taskID         = "task_id"
+	versionID      = "version_id"
 	zone           = "zone"
+
+	// cloudRunJobExecutionKey is reported by the GCP resource detector for
+	// Cloud Run jobs, which share their cloud.platform with services.
+	cloudRunJobExecutionKey = "gcp.cloud_run.job.execution"
 )
 
+// LabelMapping determines the value of a monitored resource label.
+type LabelMapping struct {
+	// OTelKeys are the OTel resource keys to try and populate the resource
+	// label from. The keys' values are coalesced in order until there is a
+	// non-empty value.
+	OTelKeys []string
+	// FallbackLiteral is the value of the label if none of the OTelKeys are
+	// present in the Resource.
+	FallbackLiteral string
+}
+
+// Predicate matches resources with an attribute.
+type Predicate struct {
+	// Key is the resource attribute key.
+	Key string
+	// Values are the values the attribute may have. If empty, the attribute
+	// only needs to be present.
+	Values []string
+}
+
+// Mapping maps resources matching all of its predicates to a monitored
+// resource type.
+type Mapping struct {
+	Type       string
+	Predicates []Predicate
+	// Labels maps monitored resource label keys onto the OTel resource
+	// attributes they are populated from. If nil, the labels of the built-in
+	// mapping of Type are used.
+	Labels map[string]LabelMapping
+}
+
 var (
+	// locationLabel populates the location label of most resource types.
+	locationLabel = LabelMapping{OTelKeys: []string{
+		string(semconv.CloudAvailabilityZoneKey),
+		string(semconv.CloudRegionKey),
+	}}
+
 	// monitoredResourceMappings contains mappings of GCM resource label keys onto mapping config from OTel
 	// resource for a given monitored resource type.
-	monitoredResourceMappings = map[string]map[string]struct {
-		// If none of the otelKeys are present in the Resource, fallback to this literal value
-		fallbackLiteral string
-		// OTel resource keys to try and populate the resource label from. For entries with
-		// multiple OTel resource keys, the keys' values will be coalesced in order until there
-		// is a non-empty value.
-		otelKeys []string
-	}{
+	monitoredResourceMappings = map[string]map[string]LabelMapping{
 		gceInstance: {
-			zone:       {otelKeys: []string{string(semconv.CloudAvailabilityZoneKey)}},
-			instanceID: {otelKeys: []string{string(semconv.HostIDKey)}},
+			zone:       {OTelKeys: []string{string(semconv.CloudAvailabilityZoneKey)}},
+			instanceID: {OTelKeys: []string{string(semconv.HostIDKey)}},
 		},
 		k8sContainer: {
-			location: {otelKeys: []string{
-				string(semconv.CloudAvailabilityZoneKey),
-				string(semconv.CloudRegionKey),
-			}},
-			clusterName:   {otelKeys: []string{string(semconv.K8SClusterNameKey)}},
-			namespaceName: {otelKeys: []string{string(semconv.K8SNamespaceNameKey)}},
-			podName:       {otelKeys: []string{string(semconv.K8SPodNameKey)}},
-			containerName: {otelKeys: []string{string(semconv.K8SContainerNameKey)}},
+			location:      locationLabel,
+			clusterName:   {OTelKeys: []string{string(semconv.K8SClusterNameKey)}},
+			namespaceName: {OTelKeys: []string{string(semconv.K8SNamespaceNameKey)}},
+			podName:       {OTelKeys: []string{string(semconv.K8SPodNameKey)}},
+			containerName: {OTelKeys: []string{string(semconv.K8SContainerNameKey)}},
 		},
 		k8sPod: {
-			location: {otelKeys: []string{
-				string(semconv.CloudAvailabilityZoneKey),
-				string(semconv.CloudRegionKey),
-			}},
-			clusterName:   {otelKeys: []string{string(semconv.K8SClusterNameKey)}},
-			namespaceName: {otelKeys: []string{string(semconv.K8SNamespaceNameKey)}},
-			podName:       {otelKeys: []string{string(semconv.K8SPodNameKey)}},
+			location:      locationLabel,
+			clusterName:   {OTelKeys: []string{string(semconv.K8SClusterNameKey)}},
+			namespaceName: {OTelKeys: []string{string(semconv.K8SNamespaceNameKey)}},
+			podName:       {OTelKeys: []string{string(semconv.K8SPodNameKey)}},
 		},
 		k8sNode: {
-			location: {otelKeys: []string{
-				string(semconv.CloudAvailabilityZoneKey),
-				string(semconv.CloudRegionKey),
-			}},
-			clusterName: {otelKeys: []string{string(semconv.K8SClusterNameKey)}},
-			nodeName:    {otelKeys: []string{string(semconv.K8SNodeNameKey)}},
+			location:    locationLabel,
+			clusterName: {OTelKeys: []string{string(semconv.K8SClusterNameKey)}},
+			nodeName:    {OTelKeys: []string{string(semconv.K8SNodeNameKey)}},
 		},
 		k8sCluster: {
-			location: {otelKeys: []string{
-				string(semconv.CloudAvailabilityZoneKey),
-				string(semconv.CloudRegionKey),
-			}},
-			clusterName: {otelKeys: []string{string(semconv.K8SClusterNameKey)}},
+			location:    locationLabel,
+			clusterName: {OTelKeys: []string{string(semconv.K8SClusterNameKey)}},
 		},
 		awsEc2Instance: {
-			instanceID: {otelKeys: []string{string(semconv.HostIDKey)}},
-			region:     {otelKeys: []string{string(semconv.CloudAvailabilityZoneKey)}},
-			awsAccount: {otelKeys: []string{string(semconv.CloudAccountIDKey)}},
+			instanceID: {OTelKeys: []string{string(semconv.HostIDKey)}},
+			region:     {OTelKeys: []string{string(semconv.CloudAvailabilityZoneKey)}},
+			awsAccount: {OTelKeys: []string{string(semconv.CloudAccountIDKey)}},
 		},
 		genericTask: {
 			location: {
-				otelKeys: []string{
-					string(semconv.CloudAvailabilityZoneKey),
-					string(semconv.CloudRegionKey),
-				},
-				fallbackLiteral: "global",
+				OTelKeys:        locationLabel.OTelKeys,
+				FallbackLiteral: "global",
 			},
-			namespace: {otelKeys: []string{string(semconv.ServiceNamespaceKey)}},
-			job:       {otelKeys: []string{string(semconv.ServiceNameKey)}},
-			taskID:    {otelKeys: []string{string(semconv.ServiceInstanceIDKey)}},
+			namespace: {OTelKeys: []string{string(semconv.ServiceNamespaceKey)}},
+			job:       {OTelKeys: []string{string(semconv.ServiceNameKey)}},
+			taskID:    {OTelKeys: []string{string(semconv.ServiceInstanceIDKey)}},
 		},
 		genericNode: {
 			location: {
-				otelKeys: []string{
-					string(semconv.CloudAvailabilityZoneKey),
-					string(semconv.CloudRegionKey),
-				},
-				fallbackLiteral: "global",
+				OTelKeys:        locationLabel.OTelKeys,
+				FallbackLiteral: "global",
 			},
-			namespace: {otelKeys: []string{string(semconv.ServiceNamespaceKey)}},
-			nodeID:    {otelKeys: []string{string(semconv.HostIDKey), string(semconv.HostNameKey)}},
+			namespace: {OTelKeys: []string{string(semconv.ServiceNamespaceKey)}},
+			nodeID:    {OTelKeys: []string{string(semconv.HostIDKey), string(semconv.HostNameKey)}},
+		},
+		cloudRunRev: {
+			location:     {OTelKeys: []string{string(semconv.CloudRegionKey)}},
+			serviceName:  {OTelKeys: []string{string(semconv.FaaSNameKey)}},
+			configName:   {OTelKeys: []string{string(semconv.FaaSNameKey)}},
+			revisionName: {OTelKeys: []string{string(semconv.FaaSVersionKey)}},
+		},
+		cloudRunJob: {
+			location: {OTelKeys: []string{string(semconv.CloudRegionKey)}},
+			jobName:  {OTelKeys: []string{string(semconv.FaaSNameKey)}},
+		},
+		cloudFunction: {
+			region:       {OTelKeys: []string{string(semconv.CloudRegionKey)}},
+			functionName: {OTelKeys: []string{string(semconv.FaaSNameKey)}},
 		},
+		gaeApp: {
+			zone:      {OTelKeys: []string{string(semconv.CloudAvailabilityZoneKey)}},
+			moduleID:  {OTelKeys: []string{string(semconv.FaaSNameKey)}},
+			versionID: {OTelKeys: []string{string(semconv.FaaSVersionKey)}},
+		},
+	}
+
+	gcePlatform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformGCPComputeEngine.Value.AsString()},
+	}
+	gkePlatform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformGCPKubernetesEngine.Value.AsString()},
+	}
+	ec2Platform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformAWSEC2.Value.AsString()},
+	}
+	cloudRunPlatform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformGCPCloudRun.Value.AsString()},
+	}
+	cloudFunctionsPlatform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformGCPCloudFunctions.Value.AsString()},
+	}
+	appEnginePlatform = Predicate{
+		Key:    string(semconv.CloudPlatformKey),
+		Values: []string{semconv.CloudPlatformGCPAppEngine.Value.AsString()},
+	}
+	faasName = Predicate{Key: string(semconv.FaaSNameKey)}
+
+	// defaultMappings are evaluated in order, and the first which matches
+	// determines the monitored resource type. Kubernetes resources are tried
+	// from most to least specific, and resources of unknown platforms fall
+	// back to generic_task, or generic_node if that isn't possible.
+	defaultMappings = []Mapping{
+		{Type: gceInstance, Predicates: []Predicate{gcePlatform}},
+		{Type: k8sContainer, Predicates: []Predicate{gkePlatform, {Key: string(semconv.K8SContainerNameKey)}}},
+		{Type: k8sPod, Predicates: []Predicate{gkePlatform, {Key: string(semconv.K8SPodNameKey)}}},
+		{Type: k8sNode, Predicates: []Predicate{gkePlatform, {Key: string(semconv.K8SNodeNameKey)}}},
+		{Type: k8sCluster, Predicates: []Predicate{gkePlatform}},
+		{Type: awsEc2Instance, Predicates: []Predicate{ec2Platform}},
+		{Type: genericTask, Predicates: []Predicate{
+			{Key: string(semconv.ServiceNameKey)},
+			{Key: string(semconv.ServiceInstanceIDKey)},
+		}},
+		{Type: genericNode},
 	}
+
+	// serverlessMappings are the serverless resource types, which Cloud
+	// Logging and Cloud Trace accept, but Cloud Monitoring doesn't accept for
+	// custom metrics.
+	serverlessMappings = []Mapping{
+		{Type: cloudRunJob, Predicates: []Predicate{cloudRunPlatform, faasName, {Key: cloudRunJobExecutionKey}}},
+		{Type: cloudRunRev, Predicates: []Predicate{cloudRunPlatform, faasName}},
+		{Type: cloudFunction, Predicates: []Predicate{cloudFunctionsPlatform, faasName}},
+		{Type: gaeApp, Predicates: []Predicate{appEnginePlatform, faasName}},
+	}
+
+	// defaultLoggingMappings are evaluated in place of defaultMappings for
+	// logs and traces.
+	defaultLoggingMappings = append(append([]Mapping(nil), serverlessMappings...), defaultMappings...)
 )
 
 type GceResource struct {
@@ -135,62 +239,95 @@ type ReadOnlyAttributes interface {
 	GetString(string) (string, bool)
 }
 
+// DefaultMappings returns the mappings used by
+// ResourceAttributesToMonitoredResource, in the order they are evaluated.
+func DefaultMappings() []Mapping {
+	return append([]Mapping(nil), defaultMappings...)
+}
+
+// DefaultLoggingMappings returns the mappings used by
+// ResourceAttributesToLoggingMonitoredResource, in the order they are
+// evaluated.
+func DefaultLoggingMappings() []Mapping {
+	return append([]Mapping(nil), defaultLoggingMappings...)
+}
+
 // ResourceAttributesToMonitoredResource converts from a set of OTEL resource attributes into a
 // GCP monitored resource type and label set.
 // E.g.
 // This may output `gce_instance` type with appropriate labels.
 func ResourceAttributesToMonitoredResource(attrs ReadOnlyAttributes) *GceResource {
-	cloudPlatform, _ := attrs.GetString(string(semconv.CloudPlatformKey))
-	var mr *GceResource
-	switch cloudPlatform {
-	case semconv.CloudPlatformGCPComputeEngine.Value.AsString():
-		mr = createMonitoredResource(gceInstance, attrs)
-	case semconv.CloudPlatformGCPKubernetesEngine.Value.AsString():
-		// Try for most to least specific k8s_container, k8s_pod, etc
-		if _, ok := attrs.GetString(string(semconv.K8SContainerNameKey)); ok {
-			mr = createMonitoredResource(k8sContainer, attrs)
-		} else if _, ok := attrs.GetString(string(semconv.K8SPodNameKey)); ok {
-			mr = createMonitoredResource(k8sPod, attrs)
-		} else if _, ok := attrs.GetString(string(semconv.K8SNodeNameKey)); ok {
-			mr = createMonitoredResource(k8sNode, attrs)
-		} else {
-			mr = createMonitoredResource(k8sCluster, attrs)
+	return MapResourceAttributes(attrs, defaultMappings)
+}
+
+// ResourceAttributesToLoggingMonitoredResource converts from a set of OTEL
+// resource attributes into a monitored resource for logs and traces. In
+// addition to the types supported by ResourceAttributesToMonitoredResource,
+// it supports `cloud_run_revision`, `cloud_run_job`, `cloud_function` and
+// `gae_app`.
+func ResourceAttributesToLoggingMonitoredResource(attrs ReadOnlyAttributes) *GceResource {
+	return MapResourceAttributes(attrs, defaultLoggingMappings)
+}
+
+// MapResourceAttributes converts from a set of OTEL resource attributes into
+// a monitored resource, using the first of the mappings whose predicates all
+// match. It falls back to generic_node if none match.
+func MapResourceAttributes(attrs ReadOnlyAttributes, mappings []Mapping) *GceResource {
+	for _, mapping := range mappings {
+		if !matches(attrs, mapping.Predicates) {
+			continue
+		}
+		labels := mapping.Labels
+		if labels == nil {
+			labels = monitoredResourceMappings[mapping.Type]
+		}
+		return createMonitoredResource(mapping.Type, labels, attrs)
+	}
+	return createMonitoredResource(genericNode, monitoredResourceMappings[genericNode], attrs)
+}
+
+func matches(attrs ReadOnlyAttributes, predicates []Predicate) bool {
+	for _, predicate := range predicates {
+		value, ok := attrs.GetString(predicate.Key)
+		if !ok {
+			return false
+		}
+		if len(predicate.Values) == 0 {
+			continue
+		}
+		found := false
+		for _, v := range predicate.Values {
+			if v == value {
+				found = true
+				break
+			}
 		}
-	case semconv.CloudPlatformAWSEC2.Value.AsString():
-		mr = createMonitoredResource(awsEc2Instance, attrs)
-	default:
-		// Fallback to generic_task
-		_, hasServiceName := attrs.GetString(string(semconv.ServiceNameKey))
-		_, hasServiceInstanceID := attrs.GetString(string(semconv.ServiceInstanceIDKey))
-		if hasServiceName && hasServiceInstanceID {
-			mr = createMonitoredResource(genericTask, attrs)
-		} else {
-			// If not possible, fallback to generic_node
-			mr = createMonitoredResource(genericNode, attrs)
+		if !found {
+			return false
 		}
 	}
-	return mr
+	return true
 }
 
 func createMonitoredResource(
 	monitoredResourceType string,
+	mappings map[string]LabelMapping,
 	resourceAttrs ReadOnlyAttributes,
 ) *GceResource {
-	mappings := monitoredResourceMappings[monitoredResourceType]
 	mrLabels := make(map[string]string, len(mappings))
 
 	for mrKey, mappingConfig := range mappings {
 		mrValue := ""
 		ok := false
 		// Coalesce the possible keys in order
-		for _, otelKey := range mappingConfig.otelKeys {
+		for _, otelKey := range mappingConfig.OTelKeys {
 			mrValue, ok = resourceAttrs.GetString(otelKey)
 			if mrValue != "" {
 				break
 			}
 		}
 		if !ok || mrValue == "" {
-			mrValue = mappingConfig.fallbackLiteral
+			mrValue = mappingConfig.FallbackLiteral
 		}
 		mrLabels[mrKey] = mrValue
 	}
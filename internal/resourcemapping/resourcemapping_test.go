@@ -0,0 +1,267 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourcemapping
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeAttributes map[string]string
+
+func (attrs fakeAttributes) GetString(key string) (string, bool) {
+	value, ok := attrs[key]
+	return value, ok
+}
+
+func TestResourceAttributesToMonitoredResource(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		attrs    fakeAttributes
+		expected *GceResource
+	}{
+		{
+			desc: "gce_instance",
+			attrs: fakeAttributes{
+				"cloud.platform":          "gcp_compute_engine",
+				"cloud.availability_zone": "us-central1-c",
+				"host.id":                 "1234",
+			},
+			expected: &GceResource{
+				Type:   gceInstance,
+				Labels: map[string]string{zone: "us-central1-c", instanceID: "1234"},
+			},
+		},
+		{
+			desc: "k8s_node is more specific than k8s_cluster",
+			attrs: fakeAttributes{
+				"cloud.platform":   "gcp_kubernetes_engine",
+				"cloud.region":     "us-central1",
+				"k8s.cluster.name": "cluster",
+				"k8s.node.name":    "node",
+			},
+			expected: &GceResource{
+				Type:   k8sNode,
+				Labels: map[string]string{location: "us-central1", clusterName: "cluster", nodeName: "node"},
+			},
+		},
+		{
+			desc: "k8s_cluster",
+			attrs: fakeAttributes{
+				"cloud.platform":      "gcp_kubernetes_engine",
+				"cloud.region":        "us-central1",
+				"k8s.cluster.name":    "cluster",
+				"service.name":        "service",
+				"service.instance.id": "instance",
+			},
+			expected: &GceResource{
+				Type:   k8sCluster,
+				Labels: map[string]string{location: "us-central1", clusterName: "cluster"},
+			},
+		},
+		{
+			desc: "generic_task requires service.instance.id",
+			attrs: fakeAttributes{
+				"service.name":        "service",
+				"service.instance.id": "instance",
+			},
+			expected: &GceResource{
+				Type:   genericTask,
+				Labels: map[string]string{location: "global", namespace: "", job: "service", taskID: "instance"},
+			},
+		},
+		{
+			desc: "generic_node falls back to host.name and the global location",
+			attrs: fakeAttributes{
+				"service.name": "service",
+				"host.id":      "",
+				"host.name":    "host",
+			},
+			expected: &GceResource{
+				Type:   genericNode,
+				Labels: map[string]string{location: "global", namespace: "", nodeID: "host"},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := ResourceAttributesToMonitoredResource(tc.attrs)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("ResourceAttributesToMonitoredResource() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestResourceAttributesToLoggingMonitoredResource(t *testing.T) {
+	for _, tc := range []struct {
+		desc           string
+		attrs          fakeAttributes
+		expected       *GceResource
+		expectedMetric string
+	}{
+		{
+			desc: "cloud_run_revision",
+			attrs: fakeAttributes{
+				"cloud.platform":      "gcp_cloud_run",
+				"cloud.region":        "us-central1",
+				"faas.name":           "service",
+				"faas.version":        "service-00001",
+				"service.name":        "service",
+				"service.instance.id": "instance",
+			},
+			expected: &GceResource{
+				Type: cloudRunRev,
+				Labels: map[string]string{
+					location:     "us-central1",
+					serviceName:  "service",
+					configName:   "service",
+					revisionName: "service-00001",
+				},
+			},
+			expectedMetric: genericTask,
+		},
+		{
+			desc: "cloud_run_job",
+			attrs: fakeAttributes{
+				"cloud.platform":              "gcp_cloud_run",
+				"cloud.region":                "us-central1",
+				"faas.name":                   "job",
+				"gcp.cloud_run.job.execution": "job-abcde",
+			},
+			expected: &GceResource{
+				Type:   cloudRunJob,
+				Labels: map[string]string{location: "us-central1", jobName: "job"},
+			},
+			expectedMetric: genericNode,
+		},
+		{
+			desc: "cloud_function",
+			attrs: fakeAttributes{
+				"cloud.platform": "gcp_cloud_functions",
+				"cloud.region":   "us-central1",
+				"faas.name":      "function",
+			},
+			expected: &GceResource{
+				Type:   cloudFunction,
+				Labels: map[string]string{region: "us-central1", functionName: "function"},
+			},
+			expectedMetric: genericNode,
+		},
+		{
+			desc: "gae_app",
+			attrs: fakeAttributes{
+				"cloud.platform":          "gcp_app_engine",
+				"cloud.availability_zone": "us-central1-c",
+				"faas.name":               "default",
+				"faas.version":            "20220101t000000",
+			},
+			expected: &GceResource{
+				Type:   gaeApp,
+				Labels: map[string]string{zone: "us-central1-c", moduleID: "default", versionID: "20220101t000000"},
+			},
+			expectedMetric: genericNode,
+		},
+		{
+			desc: "serverless platform without faas.name",
+			attrs: fakeAttributes{
+				"cloud.platform": "gcp_cloud_run",
+				"host.name":      "host",
+			},
+			expected: &GceResource{
+				Type:   genericNode,
+				Labels: map[string]string{location: "global", namespace: "", nodeID: "host"},
+			},
+			expectedMetric: genericNode,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := ResourceAttributesToLoggingMonitoredResource(tc.attrs)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("ResourceAttributesToLoggingMonitoredResource() = %v, want %v", got, tc.expected)
+			}
+			// Cloud Monitoring doesn't accept serverless types for custom
+			// metrics.
+			if got := ResourceAttributesToMonitoredResource(tc.attrs).Type; got != tc.expectedMetric {
+				t.Errorf("ResourceAttributesToMonitoredResource().Type = %v, want %v", got, tc.expectedMetric)
+			}
+		})
+	}
+}
+
+func TestMapResourceAttributes(t *testing.T) {
+	mappings := []Mapping{
+		{
+			Type: "custom",
+			Predicates: []Predicate{
+				{Key: "env", Values: []string{"staging", "prod"}},
+				{Key: "team"},
+			},
+			Labels: map[string]LabelMapping{
+				"team":   {OTelKeys: []string{"team"}},
+				"region": {OTelKeys: []string{"cloud.region", "cloud.availability_zone"}, FallbackLiteral: "global"},
+			},
+		},
+		{
+			// Uses the built-in labels of generic_task.
+			Type:       genericTask,
+			Predicates: []Predicate{{Key: "service.name"}},
+		},
+	}
+	for _, tc := range []struct {
+		desc     string
+		attrs    fakeAttributes
+		expected *GceResource
+	}{
+		{
+			desc:  "all predicates match",
+			attrs: fakeAttributes{"env": "prod", "team": "a", "cloud.availability_zone": "us-central1-c"},
+			expected: &GceResource{
+				Type:   "custom",
+				Labels: map[string]string{"team": "a", "region": "us-central1-c"},
+			},
+		},
+		{
+			desc:  "fallback literal",
+			attrs: fakeAttributes{"env": "staging", "team": "a", "cloud.region": ""},
+			expected: &GceResource{
+				Type:   "custom",
+				Labels: map[string]string{"team": "a", "region": "global"},
+			},
+		},
+		{
+			desc:  "value not in predicate values",
+			attrs: fakeAttributes{"env": "dev", "team": "a", "service.name": "service"},
+			expected: &GceResource{
+				Type:   genericTask,
+				Labels: map[string]string{location: "global", namespace: "", job: "service", taskID: ""},
+			},
+		},
+		{
+			desc:  "missing attribute",
+			attrs: fakeAttributes{"env": "prod", "host.name": "host"},
+			expected: &GceResource{
+				Type:   genericNode,
+				Labels: map[string]string{location: "global", namespace: "", nodeID: "host"},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := MapResourceAttributes(tc.attrs, mappings)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("MapResourceAttributes() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/rtbenfield/opentelemetry-operations-go/internal/unitmapping
+
+go 1.17
@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unitmapping
+
+import (
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	for _, tc := range []struct {
+		unit     string
+		expected string
+	}{
+		{unit: "", expected: ""},
+		{unit: " ", expected: ""},
+		{unit: "1", expected: "1"},
+		{unit: "%", expected: "%"},
+		{unit: "By", expected: "By"},
+		{unit: "ms", expected: "ms"},
+		{unit: "min", expected: "min"},
+		{unit: "KiBy", expected: "KiBy"},
+		{unit: "MBy", expected: "MBy"},
+		{unit: "By/s", expected: "By/s"},
+		{unit: "kBy.h", expected: "kBy.h"},
+		{unit: "/s", expected: "1/s"},
+		{unit: "{requests}", expected: "{requests}"},
+		{unit: "{requests}/s", expected: "{requests}/s"},
+		{unit: "By{transmitted}/s", expected: "By{transmitted}/s"},
+		{unit: "{}", expected: "1"},
+		{unit: "{dropped packets}", expected: "{dropped_packets}"},
+		{unit: "{m/s}", expected: "{m/s}"},
+		// Unsupported units are translated with the fallback.
+		{unit: "Cel", expected: "{Cel}"},
+		{unit: "Hz", expected: "{Hz}"},
+		{unit: "By/s.h", expected: "{By/s.h}"},
+		{unit: "s2", expected: "{s2}"},
+		{unit: "10*3{packets}", expected: "{10*3packets}"},
+		{unit: "By/", expected: "{By/}"},
+		{unit: "{requests", expected: "{requests}"},
+		{unit: "KBy", expected: "{KBy}"},
+	} {
+		t.Run(tc.unit, func(t *testing.T) {
+			if got := Translate(tc.unit, FallbackAnnotation); got != tc.expected {
+				t.Errorf("Translate(%q) = %q, want %q", tc.unit, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTranslateFallback(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		fallback Fallback
+		expected string
+	}{
+		{desc: "annotation", fallback: FallbackAnnotation, expected: "{deg_C}"},
+		{desc: "verbatim", fallback: FallbackVerbatim, expected: "deg C"},
+		{desc: "none", fallback: FallbackNone, expected: ""},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := Translate("deg C", tc.fallback); got != tc.expected {
+				t.Errorf("Translate() = %q, want %q", got, tc.expected)
+			}
+			// Supported units are kept regardless of the fallback.
+			if got := Translate("By/s", tc.fallback); got != "By/s" {
+				t.Errorf("Translate() = %q, want %q", got, "By/s")
+			}
+		})
+	}
+}